@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
+)
+
+// abModel is set by /ab: the next message is sent to both the session's model and abModel.
+var abModel string
+
+// heldWriter buffers output until it is released, then writes through. It shows the second of
+// two concurrent answers after the first one.
+type heldWriter struct {
+	mu       sync.Mutex
+	out      io.Writer
+	buf      bytes.Buffer
+	released bool
+}
+
+func (h *heldWriter) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.released {
+		return h.out.Write(p)
+	}
+	return h.buf.Write(p)
+}
+
+func (h *heldWriter) release() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.out.Write(h.buf.Bytes())
+	h.buf.Reset()
+	h.released = true
+}
+
+// abConfig returns the session settings adapted to model: values the model does not accept and
+// settings it lacks fall back to its defaults. Both answers are streamed.
+func abConfig(cfg map[string]string, model string) map[string]string {
+	c := map[string]string{}
+	for k, v := range cfg {
+		c[k] = v
+	}
+	c["MODEL"], c["STREAM"] = model, "true"
+	def := GetModelDefinition(model)
+	for name, param := range def.Parameters {
+		key := strings.ToUpper(name)
+		if c[key] == "" || validateParameter(name, c[key], def) != nil {
+			c[key] = defaultValueString(param)
+		}
+	}
+	return c
+}
+
+// abUnavailable returns why the next message of convFile cannot be compared, or "". A comparison
+// does not run tools, and queued messages have to be sent in order first.
+func abUnavailable(convFile string) string {
+	if len(registeredTools) > 0 {
+		return "the session has tools (see /tools), and a comparison cannot run them"
+	}
+	if cf, err := readConversation(convFile); err == nil {
+		if _, n := pendingRange(cf); n > 0 {
+			return fmt.Sprintf("%d queued message(s) have to be sent first", n)
+		}
+	}
+	return ""
+}
+
+// compareModels sends the conversation, whose last message is the user's prompt, to the session's
+// model and to modelB at the same time. The first answer streams live and the second follows once
+// the first is complete. The user then picks the answer to keep; it is saved with a record of the
+// comparison and the other answer, and given to the response hook. The budget is checked for both
+// requests together.
+func compareModels(convFile string, cfg map[string]string, sysPromptContent, modelB string) error {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		return fmt.Errorf("read conversation: %w", err)
+	}
+	models := []string{cfg["MODEL"], modelB}
+	var both []Message
+	for _, model := range models {
+		both = append(both, buildMessages(abConfig(cfg, model), sysPromptContent, cf)...)
+	}
+	if err := checkBudget(cf, both); err != nil {
+		return err
+	}
+	type answer struct {
+		text  string
+		usage tokenUsage
+		err   error
+	}
+	var answers [2]answer
+	held := &heldWriter{out: os.Stdout}
+	outs := [2]io.Writer{os.Stdout, held}
+	var done [2]chan struct{}
+	for i := range models {
+		done[i] = make(chan struct{})
+		go func(i int) {
+			defer close(done[i])
+			c := abConfig(cfg, models[i])
+			a := &answers[i]
+			a.text, a.usage, a.err = streamCompletion(c, buildMessages(c, sysPromptContent, cf), outs[i])
+		}(i)
+	}
+
+	for i, label := range []string{"A", "B"} {
+		fmt.Fprintf(os.Stderr, "\n%s[%s] %s%s\n", blue, label, models[i], normal)
+		if i == 1 {
+			held.release()
+		}
+		<-done[i]
+		if answers[i].err != nil {
+			fmt.Fprintf(os.Stderr, "%s%s%s\n", red, describeError(answers[i].err), normal)
+		}
+	}
+
+	kept := -1
+	switch {
+	case answers[0].err != nil && answers[1].err != nil:
+		requeueUnsent(convFile, answers[0].err)
+		return fmt.Errorf("both models failed")
+	case answers[1].err != nil:
+		kept = 0
+	case answers[0].err != nil:
+		kept = 1
+	default:
+		fmt.Fprintf(os.Stderr, "\nKeep which answer? [A] %s / [b] %s: ", models[0], models[1])
+		choice, _ := readSingleLine(nil, []string{"\n"}, true)
+		kept = 0
+		if strings.EqualFold(strings.TrimSpace(choice), "b") {
+			kept = 1
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%sKept the answer of %s%s\n", green, models[kept], normal)
+
+	cf, err = readConversation(convFile)
+	if err != nil {
+		return fmt.Errorf("append assistant message: %w", err)
+	}
+	cmp := &Comparison{Models: models, Kept: models[kept]}
+	if other := answers[1-kept]; other.err == nil {
+		cmp.Rejected = other.text
+	}
+	msg := nvidiachat.Reply(models[kept], answers[kept].text, answers[kept].usage)
+	msg.Comparison = cmp
+	cf.Messages = append(cf.Messages, msg)
+	cf.AddUsage(answers[0].usage)
+	cf.AddUsage(answers[1].usage)
+	if err := writeConversation(convFile, cf); err != nil {
+		return fmt.Errorf("append assistant message: %w", err)
+	}
+	runResponseHook(abConfig(cfg, models[kept]), convFile, answers[kept].text)
+	return nil
+}
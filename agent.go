@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// shellToolName is the tool offered to the model in agent mode.
+const shellToolName = "run_shell_command"
+
+// maxShellOutput caps the command output returned to the model.
+const maxShellOutput = 64 * 1024
+
+// setAgentMode offers or withdraws the shell command tool.
+func setAgentMode(enabled bool) {
+	if !enabled {
+		unregisterTool(shellToolName)
+		return
+	}
+	if findTool(shellToolName) != nil {
+		return
+	}
+	registerTool(&Tool{
+		Name:        shellToolName,
+		Description: "Run a shell command on the user's machine and return its output and exit status. The user is asked to confirm every command.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"command": map[string]interface{}{"type": "string", "description": "The command line to run."},
+			},
+			"required": []string{"command"},
+		},
+		Source: "agent",
+		Run:    runShellTool,
+	})
+}
+
+// runShellTool shows the proposed command, asks for confirmation and runs it in the current directory.
+func runShellTool(arguments string) (string, error) {
+	var args struct {
+		Command string `json:"command"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	if strings.TrimSpace(args.Command) == "" {
+		return "", errors.New("empty command")
+	}
+
+	fmt.Fprintf(os.Stderr, "%sThe assistant wants to run:%s\n  %s\n", bold, normal, args.Command)
+	fmt.Fprint(os.Stderr, "Run this command? [y/N] ")
+	answer, _ := readSingleLine(nil, nil, true)
+	if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+		return "The user declined to run this command.", nil
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", args.Command)
+	} else {
+		cmd = exec.Command("sh", "-c", args.Command)
+	}
+	output, err := cmd.CombinedOutput()
+	exitCode := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return "", err
+		}
+		exitCode = exitErr.ExitCode()
+	}
+	os.Stderr.Write(output)
+
+	text := string(output)
+	if len(text) > maxShellOutput {
+		text = text[:maxShellOutput] + "\n[output truncated]"
+	}
+	return fmt.Sprintf("Exit status: %d\n%s", exitCode, text), nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The API log records every request sent to the API as a JSON line, with its timing, status, model
+// and token counts. Headers and bodies are never logged, so neither are the keys nor the messages.
+
+const (
+	defaultLogMaxSize = 10 << 20 // bytes after which the log file is rotated
+	defaultLogBackups = 3        // rotated files kept, as FILE.1 (newest) to FILE.N
+	logTailSize       = 4096     // end of a response body searched for the token usage
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = [...]string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+func parseLogLevel(s string) (logLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return logLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid log level %q (debug|info|warn|error)", s)
+}
+
+// parseByteSize parses a size such as 500000, 512k or 10MB.
+func parseByteSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(v, u.suffix) {
+			v, unit = strings.TrimSpace(strings.TrimSuffix(v, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 10MB or 512k", s)
+	}
+	return n * unit, nil
+}
+
+// apiLogEntry is one line of the API log.
+type apiLogEntry struct {
+	Time             string `json:"time"`
+	Level            string `json:"level"`
+	Event            string `json:"event"` // "request" (debug only) or "response"
+	Method           string `json:"method"`
+	URL              string `json:"url"`
+	Model            string `json:"model,omitempty"`
+	Status           int    `json:"status,omitempty"`
+	FirstByteMS      int64  `json:"first_byte_ms,omitempty"`
+	DurationMS       int64  `json:"duration_ms,omitempty"`
+	PromptTokens     int    `json:"prompt_tokens,omitempty"`
+	CompletionTokens int    `json:"completion_tokens,omitempty"`
+	RequestBytes     int    `json:"request_bytes,omitempty"`
+	ResponseBytes    int64  `json:"response_bytes,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// apiLogger appends entries to the log file, moving it to FILE.1 once it exceeds maxSize.
+type apiLogger struct {
+	mu      sync.Mutex
+	path    string
+	level   logLevel
+	maxSize int64
+	backups int
+	f       *os.File
+	size    int64
+	failed  bool
+}
+
+// apiLog is the log set by --log-file or the [log] section of the config file, if any.
+var apiLog *apiLogger
+
+// openAPILog opens the API log. The flags, when given, override the [log] section of the config
+// file: file, level, max_size and backups. Without a file, nothing is logged.
+func openAPILog(file, level, maxSize string) error {
+	if file == "" {
+		file = userConfig["log.file"]
+	}
+	if file == "" {
+		return nil
+	}
+	l := &apiLogger{path: file, level: levelInfo, maxSize: defaultLogMaxSize, backups: defaultLogBackups}
+	if level == "" {
+		level = userConfig["log.level"]
+	}
+	if level != "" {
+		v, err := parseLogLevel(level)
+		if err != nil {
+			return err
+		}
+		l.level = v
+	}
+	if maxSize == "" {
+		maxSize = userConfig["log.max_size"]
+	}
+	if maxSize != "" {
+		v, err := parseByteSize(maxSize)
+		if err != nil {
+			return fmt.Errorf("log max size: %v", err)
+		}
+		l.maxSize = v
+	}
+	if v := userConfig["log.backups"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("Invalid log.backups (>= 0): %s", v)
+		}
+		l.backups = n
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	apiLog = l
+	return nil
+}
+
+func (l *apiLogger) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// rotate moves FILE.i to FILE.i+1, FILE to FILE.1 and starts a new FILE.
+func (l *apiLogger) rotate() error {
+	l.f.Close()
+	if l.backups == 0 {
+		os.Remove(l.path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", l.path, l.backups))
+		for i := l.backups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		}
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	}
+	return l.open()
+}
+
+// write appends e to the log if its level is enabled. A log that cannot be written is reported
+// once and then ignored, so that it never gets in the way of a request.
+func (l *apiLogger) write(level logLevel, e apiLogEntry) {
+	if level < l.level {
+		return
+	}
+	e.Time, e.Level = time.Now().Format(time.RFC3339Nano), level.String()
+	b, _ := json.Marshal(e)
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failed {
+		return
+	}
+	err := error(nil)
+	if l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		err = l.rotate()
+	}
+	if err == nil {
+		_, err = l.f.Write(b)
+	}
+	if err != nil {
+		l.failed = true
+		fmt.Fprintf(os.Stderr, "%sFailed to write the API log %s, logging stopped: %v%s\n", red, l.path, err, normal)
+		return
+	}
+	l.size += int64(len(b))
+}
+
+// loggingTransport logs each request it passes on, once its response has been read or closed.
+type loggingTransport struct {
+	next http.RoundTripper
+	log  *apiLogger
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, req, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	u := *req.URL
+	u.User, u.RawQuery = nil, "" // credentials are never logged
+	e := apiLogEntry{Method: req.Method, URL: u.String(), RequestBytes: len(body)}
+	var payload struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal(body, &payload) == nil {
+		e.Model = payload.Model
+	}
+	request := e
+	request.Event = "request"
+	t.log.write(levelDebug, request)
+
+	e.Event = "response"
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		e.DurationMS, e.Error = time.Since(start).Milliseconds(), err.Error()
+		t.log.write(levelError, e)
+		return nil, err
+	}
+	e.Status, e.FirstByteMS = resp.StatusCode, time.Since(start).Milliseconds()
+	resp.Body = &loggingBody{ReadCloser: resp.Body, entry: e, start: start, log: t.log}
+	return resp, nil
+}
+
+var (
+	usagePromptTokens     = regexp.MustCompile(`"prompt_tokens"\s*:\s*(\d+)`)
+	usageCompletionTokens = regexp.MustCompile(`"completion_tokens"\s*:\s*(\d+)`)
+)
+
+// loggingBody writes the log entry of a response when it has been read to the end, fails or is
+// closed, with the token usage found at the end of the body.
+type loggingBody struct {
+	io.ReadCloser
+	entry apiLogEntry
+	start time.Time
+	log   *apiLogger
+	tail  []byte
+	done  bool
+}
+
+func (b *loggingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.entry.ResponseBytes += int64(n)
+	if b.tail = append(b.tail, p[:n]...); len(b.tail) > 2*logTailSize {
+		b.tail = append(b.tail[:0], b.tail[len(b.tail)-logTailSize:]...)
+	}
+	if err == io.EOF {
+		b.finish("")
+	} else if err != nil {
+		b.finish(err.Error())
+	}
+	return n, err
+}
+
+func (b *loggingBody) Close() error {
+	b.finish("closed before the end of the response")
+	return b.ReadCloser.Close()
+}
+
+func (b *loggingBody) finish(errText string) {
+	if b.done {
+		return
+	}
+	b.done = true
+	e := b.entry
+	e.DurationMS, e.Error = time.Since(b.start).Milliseconds(), errText
+	if m := usagePromptTokens.FindAllSubmatch(b.tail, -1); len(m) > 0 {
+		e.PromptTokens, _ = strconv.Atoi(string(m[len(m)-1][1]))
+	}
+	if m := usageCompletionTokens.FindAllSubmatch(b.tail, -1); len(m) > 0 {
+		e.CompletionTokens, _ = strconv.Atoi(string(m[len(m)-1][1]))
+	}
+	level := levelInfo
+	switch {
+	case e.Error != "" || e.Status >= 400 && e.Status != http.StatusTooManyRequests && e.Status < 500:
+		level = levelError
+	case e.Status >= 400:
+		level = levelWarn
+	}
+	b.log.write(level, e)
+}
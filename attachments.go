@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxAttachmentSize is the largest single file that can be attached to a message.
+	maxAttachmentSize = 256 * 1024
+	// maxAttachmentsTotal caps the combined size of all files attached to one message.
+	maxAttachmentsTotal = 1024 * 1024
+)
+
+// pendingAttachments holds files queued with /attachfile (or --file in interactive mode)
+// that will be included in the next user message.
+var pendingAttachments []string
+
+// isBinary reports whether data looks like a binary file rather than text.
+func isBinary(data []byte) bool {
+	sniff := data
+	if len(sniff) > 8000 {
+		sniff = sniff[:8000]
+	}
+	if bytes.IndexByte(sniff, 0) != -1 {
+		return true
+	}
+	return !utf8.Valid(data)
+}
+
+// readAttachment reads a file and returns it wrapped in a fenced block labelled with its filename.
+func readAttachment(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() > maxAttachmentSize {
+		return "", fmt.Errorf("%s is too large (%d bytes, limit %d)", path, info.Size(), maxAttachmentSize)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if isBinary(data) {
+		return "", fmt.Errorf("%s looks like a binary file", path)
+	}
+
+	content := string(data)
+	lang := strings.TrimPrefix(filepath.Ext(path), ".")
+	// Use a fence longer than any backtick run inside the file so it cannot be closed early.
+	fence := "```"
+	for strings.Contains(content, fence) {
+		fence += "`"
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("File: %s\n", path))
+	builder.WriteString(fence + lang + "\n")
+	builder.WriteString(content)
+	if !strings.HasSuffix(content, "\n") {
+		builder.WriteString("\n")
+	}
+	builder.WriteString(fence + "\n")
+	return builder.String(), nil
+}
+
+// withAttachments appends the given files to the user input as fenced blocks.
+func withAttachments(userInput string, paths []string) (string, error) {
+	if len(paths) == 0 {
+		return userInput, nil
+	}
+	blocks := make([]string, 0, len(paths))
+	total := 0
+	for _, path := range paths {
+		block, err := readAttachment(path)
+		if err != nil {
+			return "", err
+		}
+		total += len(block)
+		if total > maxAttachmentsTotal {
+			return "", fmt.Errorf("attachments exceed the total size limit of %d bytes", maxAttachmentsTotal)
+		}
+		blocks = append(blocks, block)
+	}
+	if strings.TrimSpace(userInput) == "" {
+		return strings.Join(blocks, "\n"), nil
+	}
+	return strings.TrimRight(userInput, "\n") + "\n\n" + strings.Join(blocks, "\n"), nil
+}
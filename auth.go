@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
+)
+
+// keyringService is the service name under which API keys are stored in the OS keyring.
+const keyringService = "nvidia-chat"
+
+// defaultProfile is the keyring entry used when no --profile is given.
+// Each profile holds one or more newline-separated API keys.
+const defaultProfile = "default"
+
+var (
+	errKeyringNotFound    = errors.New("no API key stored in the OS keyring")
+	errKeyringUnavailable = errors.New("OS keyring unavailable")
+)
+
+func printAuthHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat auth <command> [--profile NAME]\n\n", bold, normal))
+	builder.WriteString("Commands:\n")
+	builder.WriteString("  login     Read an API key from stdin and store it in the OS keyring.\n")
+	builder.WriteString("            Options: --add (keep the profile's existing keys and add this one for failover)\n")
+	builder.WriteString("  logout    Remove the profile's stored API keys from the OS keyring.\n")
+	builder.WriteString("  check     Verify that the configured API keys are accepted by the API.\n")
+	builder.WriteString("            Options: -k KEY, --base-url URL\n")
+	fmt.Print(builder.String())
+}
+
+// authOptions holds the options accepted by the auth subcommands.
+type authOptions struct {
+	profile string
+	keys    []string
+	baseURL string
+	add     bool
+}
+
+func parseAuthOptions(args []string) (authOptions, error) {
+	opts := authOptions{profile: defaultProfile}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--add":
+			opts.add = true
+		case "--profile", "-k", "--access-token", "--base-url":
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("missing value for %s", args[i])
+			}
+			switch args[i] {
+			case "--profile":
+				opts.profile = args[i+1]
+			case "--base-url":
+				opts.baseURL = args[i+1]
+			default:
+				opts.keys = append(opts.keys, args[i+1])
+			}
+			i++
+		default:
+			return opts, fmt.Errorf("unknown option: %s", args[i])
+		}
+	}
+	return opts, nil
+}
+
+// runAuthCommand implements the `auth` subcommand and returns the process exit code.
+func runAuthCommand(args []string) int {
+	if len(args) == 0 {
+		printAuthHelp()
+		return exitUsage
+	}
+	if args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		printAuthHelp()
+		return exitOK
+	}
+	opts, err := parseAuthOptions(args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitUsage
+	}
+	switch args[0] {
+	case "login":
+		key, err := readAPIKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+			return exitUsage
+		}
+		keys := []string{key}
+		if opts.add {
+			keys = append(getAPIKeysFromKeyring(opts.profile), key)
+		}
+		if err := keyringSet(opts.profile, strings.Join(keys, "\n")); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to store API key: %v%s\n", red, err, normal)
+			return exitGeneral
+		}
+		fmt.Fprintf(os.Stderr, "%sAPI key stored in the OS keyring (profile %s, %d key(s)).%s\n", green, opts.profile, len(keys), normal)
+		return exitOK
+	case "logout":
+		if err := keyringDelete(opts.profile); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to remove API key: %v%s\n", red, err, normal)
+			return exitGeneral
+		}
+		fmt.Fprintf(os.Stderr, "%sAPI keys of profile %s removed from the OS keyring.%s\n", green, opts.profile, normal)
+		return exitOK
+	case "check":
+		return runAuthCheck(opts)
+	}
+	fmt.Fprintf(os.Stderr, "Unknown auth command: %s\n", args[0])
+	printAuthHelp()
+	return exitUsage
+}
+
+// readAPIKey prompts for an API key and reads it from stdin.
+func readAPIKey() (string, error) {
+	fmt.Fprint(os.Stderr, "Paste your NVIDIA API key and press Enter: ")
+	key, err := readSingleLine(os.Stdin, nil, true)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed reading API key: %w", err)
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", errors.New("no API key entered")
+	}
+	return key, nil
+}
+
+// checkAPIKey makes a cheap authenticated call (listing models) to verify the key.
+func checkAPIKey(cfg map[string]string, key string) error {
+	req, err := http.NewRequest("GET", resolveBaseURL(cfg)+"/models", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := newHTTPClient(cfg).Do(asAPIRequest(req))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return newAPIError(resp, body)
+	}
+	return nil
+}
+
+func runAuthCheck(opts authOptions) int {
+	cfg := map[string]string{"MODEL": defaultModel, "BASE_URL": opts.baseURL, "CONNECT_TIMEOUT": defaultConnectTimeout, "TIMEOUT": "30"}
+	keys, source := collectAPIKeys(opts.keys, opts.profile)
+	if len(keys) == 0 {
+		fmt.Fprintf(os.Stderr, "%sNo API key found.%s Run `nvidia-chat auth login` or set NVIDIA_BUILD_AI_ACCESS_TOKEN.\n", red, normal)
+		return exitAuth
+	}
+
+	code := exitOK
+	pool := newKeyPool(keys)
+	for range keys {
+		if err := checkAPIKey(cfg, pool.Current()); err != nil {
+			fmt.Fprintf(os.Stderr, "%s%s from %s: %s%s\n", red, pool.label(), source, describeError(err), normal)
+			code = exitCodeFor(err)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s%s from %s is valid.%s\n", green, pool.label(), source, normal)
+		}
+		pool.next()
+	}
+	return code
+}
+
+// handleInteractiveAPIError reports an API error response during a chat session and, when the key
+// was rejected, offers to enter a new one. The new key takes the place of the rejected one, in the
+// session's key pool and among the profile's keys in the keyring. It returns the key to use from
+// now on.
+func handleInteractiveAPIError(apiErr *apiError, accessToken, profile string) string {
+	fmt.Fprintf(os.Stderr, "%s%s%s\n", red, describeError(apiErr), normal)
+	if !errors.Is(apiErr, nvidiachat.ErrAuth) {
+		return accessToken
+	}
+	fmt.Fprint(os.Stderr, "Enter a new API key now? [y/N] ")
+	answer, _ := readSingleLine(nil, nil, true)
+	if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+		return accessToken
+	}
+	key, err := readAPIKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return accessToken
+	}
+	// After a failover the rejected key is the pool's current one, not necessarily accessToken.
+	failed := apiKeys.Current()
+	if failed == "" {
+		failed = accessToken
+	}
+	stored := replaceKey(getAPIKeysFromKeyring(profile), failed, key)
+	if err := keyringSet(profile, strings.Join(stored, "\n")); err != nil {
+		fmt.Fprintf(os.Stderr, "%sCould not store the key in the OS keyring (%v); using it for this session only.%s\n", red, err, normal)
+	} else {
+		fmt.Fprintf(os.Stderr, "%sAPI key stored in the OS keyring.%s\n", green, normal)
+	}
+	fmt.Fprintln(os.Stderr, "Send your message again to retry.")
+	apiKeys.replace(failed, key)
+	return key
+}
+
+// getAPIKeysFromKeyring returns the keys stored for profile with `auth login`.
+func getAPIKeysFromKeyring(profile string) []string {
+	secret, err := keyringGet(profile)
+	if err != nil {
+		return nil
+	}
+	return splitKeys(secret)
+}
+
+// nvidiaKeys is set when the API keys are build.nvidia.com keys, those of the default profile or
+// of the environment, which are only sent to the NVIDIA API: a self-hosted endpoint gets a key
+// given with -k or stored under its own profile.
+var nvidiaKeys bool
+
+// collectAPIKeys returns the API keys to use and where they came from. The first non-empty
+// source wins: -k flags, then the profile's keys in the OS keyring, then the environment.
+// Environment variables may hold several comma-separated keys. It sets nvidiaKeys.
+func collectAPIKeys(flagKeys []string, profile string) ([]string, string) {
+	nvidiaKeys = false
+	if len(flagKeys) > 0 {
+		return flagKeys, "command line"
+	}
+	if keys := getAPIKeysFromKeyring(profile); len(keys) > 0 {
+		nvidiaKeys = profile == defaultProfile
+		return keys, "OS keyring"
+	}
+	if keys := splitKeys(getAPIKeyFromEnv()); len(keys) > 0 {
+		nvidiaKeys = true
+		return keys, "environment"
+	}
+	return nil, ""
+}
+
+// sendsKey reports whether an API request to u carries the key: build.nvidia.com keys are not
+// sent to other hosts.
+func sendsKey(u *url.URL) bool {
+	if !nvidiaKeys {
+		return true
+	}
+	nvidia, _ := url.Parse(defaultBaseURL)
+	return strings.EqualFold(u.Host, nvidia.Host)
+}
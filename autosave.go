@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// autosaveSettings makes interactive changes to the model and its parameters persist to the
+// conversation file at once, as /persist-settings would. It starts from [settings] autosave.
+var autosaveSettings bool
+
+// autosaveAfterChange persists the settings after an interactive change when autosave is on.
+func autosaveAfterChange(convFile string, cfg map[string]string) {
+	if !autosaveSettings {
+		return
+	}
+	if err := persistSettingsToFile(convFile, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to save the settings: %v%s\n", red, err, normal)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Settings saved %s\n", savedTo(convFile))
+}
+
+// setAutosave implements /autosave [on|off].
+func setAutosave(args []string, convFile string, cfg map[string]string) {
+	if len(args) == 0 {
+		state := "off"
+		if autosaveSettings {
+			state = "on"
+		}
+		fmt.Fprintf(os.Stderr, "Autosave is %s. Usage: /autosave on|off\n", state)
+		return
+	}
+	switch strings.ToLower(args[0]) {
+	case "on":
+		autosaveSettings = true
+		fmt.Fprintf(os.Stderr, "%sAutosave enabled: setting changes are saved %s%s\n", green, savedTo(convFile), normal)
+		autosaveAfterChange(convFile, cfg)
+	case "off":
+		autosaveSettings = false
+		fmt.Fprintf(os.Stderr, "%sAutosave disabled: use /persist-settings to save setting changes%s\n", green, normal)
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: /autosave on|off")
+	}
+}
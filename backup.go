@@ -0,0 +1,740 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Conversations can be backed up to an S3-compatible bucket or a WebDAV directory with
+// `nvidia-chat backup push` and restored with `nvidia-chat backup pull`, following the [backup]
+// section of the config file:
+//
+//	[backup]
+//	url = "s3://my-bucket/chats"                 # or the https URL of a WebDAV directory
+//	endpoint = "https://s3.eu-west-1.amazonaws.com"
+//	region = "eu-west-1"
+//
+// The files are encrypted before they leave the machine, with a key derived from a passphrase
+// (NVIDIA_CHAT_BACKUP_PASSPHRASE, else asked for). The backup holds:
+//
+//	nvidia-chat-backup.json   the key derivation parameters, in clear
+//	manifest                  the encrypted list of the files with their SHA-256
+//	files/<id>                each encrypted version of a file, under an id that does not reveal
+//	                          its name or content
+//
+// A version's object is never overwritten: its id is derived from the file's name and SHA-256, so
+// a push racing another one cannot replace the content the other's manifest points to. Versions
+// no manifest lists any more are left in the backup.
+
+const (
+	backupHeaderName    = "nvidia-chat-backup.json"
+	backupManifestName  = "manifest"
+	backupKDFIterations = 600000
+	backupTimeout       = 2 * time.Minute
+	// mustNotExist is the condition of a put that creates an object.
+	mustNotExist = "-"
+)
+
+var (
+	errBackupNotFound = errors.New("not found")
+	errBackupChanged  = errors.New("changed meanwhile")
+)
+
+// backupStore is where the backup objects are kept.
+type backupStore interface {
+	// get returns an object and its ETag, or errBackupNotFound.
+	get(name string) ([]byte, string, error)
+	// put stores an object. cond is the ETag the object must still have, mustNotExist, or empty
+	// to replace it whatever it is; errBackupChanged reports a failed condition.
+	put(name string, data []byte, cond string) error
+}
+
+// httpBackupStore is an S3-compatible bucket (with path-style URLs) or a WebDAV directory.
+type httpBackupStore struct {
+	base   *url.URL // the directory, ending in /
+	client *http.Client
+	sign   func(req *http.Request, payload []byte)
+	dav    bool
+}
+
+// newBackupStore returns the store for the [backup] section of the config file.
+func newBackupStore(conf map[string]string) (*httpBackupStore, error) {
+	raw := conf["backup.url"]
+	if raw == "" {
+		return nil, fmt.Errorf("no backup configured: set url in the [backup] section of %s", userConfigPath())
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup url %q: %v", raw, err)
+	}
+	s := &httpBackupStore{client: &http.Client{Timeout: backupTimeout}}
+	switch u.Scheme {
+	case "s3":
+		region := firstNonEmpty(conf["backup.region"], os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+		endpoint := firstNonEmpty(conf["backup.endpoint"], "https://s3."+region+".amazonaws.com")
+		base, err := url.Parse(strings.TrimSuffix(endpoint, "/") + "/")
+		if err != nil || base.Host == "" {
+			return nil, fmt.Errorf("invalid backup endpoint %q", endpoint)
+		}
+		s.base = base.JoinPath(u.Host, u.Path)
+		accessKey := firstNonEmpty(os.Getenv("AWS_ACCESS_KEY_ID"), conf["backup.access_key"])
+		secretKey := firstNonEmpty(os.Getenv("AWS_SECRET_ACCESS_KEY"), conf["backup.secret_key"])
+		if accessKey == "" || secretKey == "" {
+			return nil, errors.New("no S3 credentials: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or access_key and secret_key in [backup]")
+		}
+		token := os.Getenv("AWS_SESSION_TOKEN")
+		s.sign = func(req *http.Request, payload []byte) {
+			signS3Request(req, payload, region, accessKey, secretKey, token, time.Now())
+		}
+	case "https", "http":
+		s.base, s.dav = u, true
+		user, password := conf["backup.username"], firstNonEmpty(os.Getenv("NVIDIA_CHAT_BACKUP_PASSWORD"), conf["backup.password"])
+		if u.User != nil {
+			user = u.User.Username()
+			if p, ok := u.User.Password(); ok {
+				password = p
+			}
+			s.base.User = nil
+		}
+		s.sign = func(req *http.Request, payload []byte) {
+			if user != "" {
+				req.SetBasicAuth(user, password)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported backup url %q: use s3://bucket/prefix or the https URL of a WebDAV directory", raw)
+	}
+	if !strings.HasSuffix(s.base.Path, "/") {
+		s.base.Path += "/"
+	}
+	return s, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s *httpBackupStore) do(method, name string, body []byte, header http.Header) (*http.Response, []byte, error) {
+	u := *s.base
+	u.Path += name
+	u.RawPath = ""
+	if !s.dav {
+		u.RawPath = s3EscapePath(u.Path)
+	}
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, body)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	return resp, data, err
+}
+
+func (s *httpBackupStore) get(name string) ([]byte, string, error) {
+	resp, data, err := s.do("GET", name, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", errBackupNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, "", backupStatusError("GET", name, resp, data)
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+func (s *httpBackupStore) put(name string, data []byte, cond string) error {
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	switch cond {
+	case "":
+	case mustNotExist:
+		header.Set("If-None-Match", "*")
+	default:
+		header.Set("If-Match", cond)
+	}
+	resp, body, err := s.do("PUT", name, data, header)
+	if err == nil && s.dav && resp.StatusCode == http.StatusConflict {
+		// the WebDAV collections are created on the first upload
+		if err = s.mkcol(name); err == nil {
+			resp, body, err = s.do("PUT", name, data, header)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return errBackupChanged
+	}
+	if resp.StatusCode/100 != 2 {
+		return backupStatusError("PUT", name, resp, body)
+	}
+	return nil
+}
+
+// mkcol creates the WebDAV collections leading to name, starting with the base directory.
+func (s *httpBackupStore) mkcol(name string) error {
+	dirs := []string{""}
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		dirs = append(dirs, strings.Join(parts[:i], "/")+"/")
+	}
+	for _, dir := range dirs {
+		resp, body, err := s.do("MKCOL", dir, nil, nil)
+		if err != nil {
+			return err
+		}
+		// 405 Method Not Allowed: the collection exists
+		if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusMethodNotAllowed {
+			return backupStatusError("MKCOL", dir, resp, body)
+		}
+	}
+	return nil
+}
+
+func backupStatusError(method, name string, resp *http.Response, body []byte) error {
+	msg := strings.TrimSpace(string(body))
+	if len(msg) > 200 {
+		msg = msg[:200] + "..."
+	}
+	if msg != "" {
+		return fmt.Errorf("%s %s: %s: %s", method, name, resp.Status, firstLine(msg))
+	}
+	return fmt.Errorf("%s %s: %s", method, name, resp.Status)
+}
+
+// s3EscapePath encodes a path the way S3 signatures expect: everything but the unreserved
+// characters and the slashes.
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signS3Request signs a request with AWS Signature Version 4.
+func signS3Request(req *http.Request, payload []byte, region, accessKey, secretKey, token string, now time.Time) {
+	sum := sha256.Sum256(payload)
+	payloadHash := hex.EncodeToString(sum[:])
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	names := []string{"host"}
+	for k := range req.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-amz-") || k == "content-type" || k == "if-match" || k == "if-none-match" || k == "range" {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, k := range names {
+		v := req.Header.Get(k)
+		if k == "host" {
+			v = req.URL.Host
+		}
+		headers.WriteString(k + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, headers.String(), signed, payloadHash}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	canonicalSum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalSum[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := mac(mac(mac(mac([]byte("AWS4"+secretKey), date), region), "s3"), "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signed, hex.EncodeToString(mac(key, toSign))))
+}
+
+// backupHeader is the clear part of a backup: how its key is derived from the passphrase, and a
+// sealed known text to check the passphrase with.
+type backupHeader struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Check      []byte `json:"check"`
+}
+
+const backupCheckText = "nvidia-chat backup"
+
+// backupCipher encrypts the objects of a backup with AES-256-GCM. The object name is authenticated
+// with its content, so that objects cannot be swapped.
+type backupCipher struct {
+	aead    cipher.AEAD
+	nameKey []byte // for the ids of the files
+}
+
+func newBackupCipher(passphrase string, h *backupHeader) (*backupCipher, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, h.Salt, h.Iterations, 64)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &backupCipher{aead: aead, nameKey: key[32:]}, nil
+}
+
+func (c *backupCipher) seal(name string, plain []byte) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	rand.Read(nonce)
+	return c.aead.Seal(nonce, nonce, plain, []byte(name))
+}
+
+func (c *backupCipher) open(name string, data []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(data) < n {
+		return nil, fmt.Errorf("%s: truncated", name)
+	}
+	plain, err := c.aead.Open(nil, data[:n], data[n:], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("%s: cannot be decrypted (wrong passphrase or damaged file)", name)
+	}
+	return plain, nil
+}
+
+// objectName returns the name of the object holding the version of a conversation file with the
+// given SHA-256. Backups made before versions had their own objects name them after the file only,
+// as an empty sum does.
+func (c *backupCipher) objectName(file, sum string) string {
+	h := hmac.New(sha256.New, c.nameKey)
+	h.Write([]byte(file))
+	if sum != "" {
+		h.Write([]byte{0})
+		h.Write([]byte(sum))
+	}
+	return "files/" + hex.EncodeToString(h.Sum(nil))
+}
+
+// openBackup reads the header of the backup, or creates it when create is set, and returns the
+// cipher for the passphrase.
+func openBackup(store backupStore, create bool) (*backupCipher, error) {
+	data, _, err := store.get(backupHeaderName)
+	var h backupHeader
+	switch {
+	case err == errBackupNotFound && create:
+		h = backupHeader{Version: 1, KDF: "pbkdf2-sha256", Iterations: backupKDFIterations, Salt: make([]byte, 16)}
+		rand.Read(h.Salt)
+	case err == errBackupNotFound:
+		return nil, errors.New("there is no backup yet: run nvidia-chat backup push first")
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &h); err != nil {
+			return nil, fmt.Errorf("%s: %v", backupHeaderName, err)
+		}
+		if h.Version != 1 || h.KDF != "pbkdf2-sha256" {
+			return nil, fmt.Errorf("%s: unsupported backup format %d (%s)", backupHeaderName, h.Version, h.KDF)
+		}
+	}
+	passphrase, err := backupPassphrase(h.Check == nil)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newBackupCipher(passphrase, &h)
+	if err != nil {
+		return nil, err
+	}
+	if h.Check != nil {
+		if _, err := c.open(backupCheckText, h.Check); err != nil {
+			return nil, errors.New("wrong passphrase")
+		}
+		return c, nil
+	}
+	h.Check = c.seal(backupCheckText, []byte(backupCheckText))
+	b, _ := json.MarshalIndent(h, "", "  ")
+	if err := store.put(backupHeaderName, b, mustNotExist); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// backupPassphrase returns NVIDIA_CHAT_BACKUP_PASSPHRASE, else asks for the passphrase, twice
+// when it is a new one.
+func backupPassphrase(isNew bool) (string, error) {
+	if p := os.Getenv("NVIDIA_CHAT_BACKUP_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+	read := func(prompt string) (string, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		p, err := readSingleLine(os.Stdin, nil, true)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		return strings.TrimRight(p, "\r\n"), nil
+	}
+	if isNew {
+		fmt.Fprintln(os.Stderr, "The backup is encrypted with a passphrase. It cannot be recovered if it is lost.")
+	}
+	p, err := read("Backup passphrase: ")
+	if err != nil || p == "" {
+		return "", errors.New("no passphrase entered")
+	}
+	if isNew {
+		if again, _ := read("Repeat the passphrase: "); again != p {
+			return "", errors.New("the passphrases differ")
+		}
+	}
+	return p, nil
+}
+
+// backupEntry describes a file of the backup.
+type backupEntry struct {
+	SHA256   string    `json:"sha256"`
+	Size     int       `json:"size"`
+	Modified time.Time `json:"modified"`
+	Host     string    `json:"host,omitempty"` // the machine that pushed it
+}
+
+// backupState records the SHA-256 of the files as they were when last pushed or pulled, so that
+// changes on both sides since then are told apart from changes on one side.
+type backupState struct {
+	URL   string            `json:"url"`
+	Files map[string]string `json:"files"`
+}
+
+func backupStatePath(dir string) string {
+	return filepath.Join(dir, "backup-state.json")
+}
+
+func loadBackupState(dir, url string) *backupState {
+	st := &backupState{URL: url, Files: map[string]string{}}
+	if b, err := ioutil.ReadFile(backupStatePath(dir)); err == nil {
+		var saved backupState
+		if json.Unmarshal(b, &saved) == nil && saved.URL == url && saved.Files != nil {
+			st.Files = saved.Files
+		}
+	}
+	return st
+}
+
+func (st *backupState) save(dir string) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(backupStatePath(dir), b, 0o600)
+}
+
+// backupFiles returns the names of the conversation files in dir, without their backups.
+func backupFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !(strings.HasSuffix(name, ".json") || isYAMLPath(name)) || strings.Contains(name, ".bak.") || strings.Contains(name, ".before-summary.") {
+			continue
+		}
+		if cf, err := readConversation(filepath.Join(dir, name)); err == nil && cf.Messages != nil && cf.Settings.Models != nil {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func printBackupHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat backup push|pull [--force] [--dry-run] [--dir DIR]\n\n", bold, normal))
+	builder.WriteString("Back up the conversations to an S3-compatible bucket or a WebDAV directory, encrypted with a\n")
+	builder.WriteString("passphrase (NVIDIA_CHAT_BACKUP_PASSPHRASE, else asked for), or restore them.\n\n")
+	builder.WriteString("  push                  Upload the conversations that changed since the last push or pull.\n")
+	builder.WriteString("  pull                  Download the conversations that changed in the backup.\n\n")
+	builder.WriteString("A conversation changed both locally and in the backup since it was last pushed or pulled is\n")
+	builder.WriteString("reported as a conflict and left alone.\n\n")
+	builder.WriteString("Options:\n")
+	builder.WriteString("  --force               Resolve the conflicts: push replaces the backup's copy, pull the local one.\n")
+	builder.WriteString("  -n, --dry-run         Only list what would be uploaded or downloaded.\n")
+	builder.WriteString("  --dir DIR             Directory of the conversations (default: " + conversationDir() + ").\n\n")
+	builder.WriteString("The [backup] section of the config file sets the destination:\n")
+	builder.WriteString("  url                   s3://bucket/prefix, or the https URL of a WebDAV directory.\n")
+	builder.WriteString("  endpoint, region      For S3: the endpoint (default: AWS) and region. The keys come from\n")
+	builder.WriteString("                        AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or access_key and secret_key.\n")
+	builder.WriteString("  username, password    For WebDAV (or NVIDIA_CHAT_BACKUP_PASSWORD).\n")
+	fmt.Print(builder.String())
+}
+
+// runBackupCommand implements the `backup` subcommand and returns the process exit code.
+func runBackupCommand(args []string) int {
+	if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+		printBackupHelp()
+		return exitOK
+	}
+	if len(args) == 0 || args[0] != "push" && args[0] != "pull" {
+		printBackupHelp()
+		return exitUsage
+	}
+	push := args[0] == "push"
+	dir, force, dryRun := conversationDir(), false, false
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printBackupHelp()
+			return exitOK
+		case "--force":
+			force = true
+		case "-n", "--dry-run":
+			dryRun = true
+		case "--dir":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%smissing value for %s%s\n", red, args[i], normal)
+				return exitUsage
+			}
+			dir = args[i+1]
+			i++
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown option: %s\n", args[i])
+			printBackupHelp()
+			return exitUsage
+		}
+	}
+	conf, err := loadConfigFile(userConfigPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to read config: %v%s\n", red, err, normal)
+		return exitUsage
+	}
+	store, err := newBackupStore(conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitUsage
+	}
+	failed := func(err error) int {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return exitNetwork
+		}
+		return exitGeneral
+	}
+	c, err := openBackup(store, push && !dryRun)
+	if err != nil {
+		return failed(err)
+	}
+	manifest := map[string]backupEntry{}
+	data, etag, err := store.get(backupManifestName)
+	switch {
+	case err == errBackupNotFound:
+		etag = mustNotExist
+	case err != nil:
+		return failed(err)
+	default:
+		plain, err := c.open(backupManifestName, data)
+		if err == nil {
+			err = json.Unmarshal(plain, &manifest)
+		}
+		if err != nil {
+			return failed(err)
+		}
+	}
+	state := loadBackupState(dir, store.base.String())
+	if push {
+		return backupPush(store, c, manifest, etag, state, dir, force, dryRun, failed)
+	}
+	return backupPull(store, c, manifest, state, dir, force, dryRun, failed)
+}
+
+func backupPush(store backupStore, c *backupCipher, manifest map[string]backupEntry, etag string, state *backupState, dir string, force, dryRun bool, failed func(error) int) int {
+	names, err := backupFiles(dir)
+	if err != nil {
+		return failed(err)
+	}
+	host, _ := os.Hostname()
+	uploaded, behind, conflicts := 0, 0, 0
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return failed(err)
+		}
+		local, remote, base := sha256Hex(data), manifest[name].SHA256, state.Files[name]
+		switch {
+		case local == remote:
+			state.Files[name] = local
+			continue
+		case remote == "" || remote == base:
+		case local == base:
+			fmt.Fprintf(os.Stderr, "%s changed in the backup: run nvidia-chat backup pull\n", name)
+			behind++
+			continue
+		case !force:
+			fmt.Fprintf(os.Stderr, "%sConflict: %s changed here and in the backup%s\n", red, name, normal)
+			conflicts++
+			continue
+		}
+		fmt.Println(path)
+		uploaded++
+		if dryRun {
+			continue
+		}
+		if err := store.put(c.objectName(name, local), c.seal(name, data), ""); err != nil {
+			return failed(err)
+		}
+		modified := time.Now()
+		if info, err := os.Stat(path); err == nil {
+			modified = info.ModTime()
+		}
+		manifest[name] = backupEntry{SHA256: local, Size: len(data), Modified: modified, Host: host}
+		state.Files[name] = local
+	}
+	if uploaded > 0 && !dryRun {
+		b, _ := json.Marshal(manifest)
+		if err := store.put(backupManifestName, c.seal(backupManifestName, b), etag); err != nil {
+			if err == errBackupChanged {
+				err = errors.New("the backup was changed by another push meanwhile: run nvidia-chat backup push again")
+			}
+			return failed(err)
+		}
+	}
+	if !dryRun {
+		if err := state.save(dir); err != nil {
+			return failed(err)
+		}
+	}
+	return backupSummary(map[bool]string{false: "Uploaded", true: "Would upload"}[dryRun], uploaded, behind, conflicts, "to pull")
+}
+
+func backupPull(store backupStore, c *backupCipher, manifest map[string]backupEntry, state *backupState, dir string, force, dryRun bool, failed func(error) int) int {
+	names := make([]string, 0, len(manifest))
+	for name := range manifest {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return failed(err)
+	}
+	downloaded, ahead, conflicts := 0, 0, 0
+	for _, name := range names {
+		entry := manifest[name]
+		if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			fmt.Fprintf(os.Stderr, "%sSkipping %q: not a file name%s\n", red, name, normal)
+			continue
+		}
+		path := filepath.Join(dir, name)
+		local := ""
+		if data, err := ioutil.ReadFile(path); err == nil {
+			local = sha256Hex(data)
+		}
+		remote, base := entry.SHA256, state.Files[name]
+		switch {
+		case local == remote:
+			state.Files[name] = local
+			continue
+		case local == "" || local == base:
+		case remote == base:
+			fmt.Fprintf(os.Stderr, "%s changed here: run nvidia-chat backup push\n", name)
+			ahead++
+			continue
+		case !force:
+			fmt.Fprintf(os.Stderr, "%sConflict: %s changed here and in the backup%s\n", red, name, normal)
+			conflicts++
+			continue
+		}
+		fmt.Println(path)
+		downloaded++
+		if dryRun {
+			continue
+		}
+		data, _, err := store.get(c.objectName(name, remote))
+		if err == errBackupNotFound {
+			data, _, err = store.get(c.objectName(name, ""))
+		}
+		if err == nil {
+			data, err = c.open(name, data)
+		}
+		if err == nil && sha256Hex(data) != remote {
+			err = fmt.Errorf("%s: the downloaded file does not match the manifest", name)
+		}
+		if err != nil {
+			return failed(err)
+		}
+		tmp := path + ".tmp"
+		if err := ioutil.WriteFile(tmp, data, 0o644); err != nil {
+			return failed(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return failed(err)
+		}
+		os.Chtimes(path, entry.Modified, entry.Modified)
+		state.Files[name] = remote
+	}
+	if !dryRun {
+		if err := state.save(dir); err != nil {
+			return failed(err)
+		}
+	}
+	return backupSummary(map[bool]string{false: "Downloaded", true: "Would download"}[dryRun], downloaded, ahead, conflicts, "to push")
+}
+
+// backupSummary prints the outcome of a push or pull and returns the exit code: 1 when conflicts
+// were left.
+func backupSummary(verb string, done, pending, conflicts int, pendingWhat string) int {
+	summary := fmt.Sprintf("%s %d conversation(s)", verb, done)
+	if pending > 0 {
+		summary += fmt.Sprintf(", %d %s", pending, pendingWhat)
+	}
+	if conflicts > 0 {
+		fmt.Fprintf(os.Stderr, "%s%s, %d conflict(s): use --force to resolve them.%s\n", red, summary, conflicts, normal)
+		return exitGeneral
+	}
+	fmt.Fprintf(os.Stderr, "%s%s.%s\n", green, summary, normal)
+	return exitOK
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
+)
+
+// benchRun is the measurement of one streamed completion.
+type benchRun struct {
+	ttft      time.Duration // until the first reasoning or content token
+	total     time.Duration
+	chars     int // characters of content and reasoning
+	tokens    int // completion tokens, from usage or estimated
+	estimated bool
+}
+
+// benchConfig returns the settings for a model: its defaults, with streaming on.
+func benchConfig(base map[string]string, model string) map[string]string {
+	cfg := map[string]string{}
+	for k, v := range base {
+		cfg[k] = v
+	}
+	cfg["MODEL"] = model
+	cfg["STREAM"] = "true"
+	for name, param := range GetModelDefinition(model).Parameters {
+		cfg[strings.ToUpper(name)] = defaultValueString(param)
+	}
+	return cfg
+}
+
+// runBenchOnce sends the prompt and times the streamed response.
+func runBenchOnce(cfg map[string]string, prompt string) (benchRun, error) {
+	var run benchRun
+	payloadBytes, err := buildPayload(cfg, []Message{{Role: "user", Content: prompt}})
+	if err != nil {
+		return run, err
+	}
+	req, err := newChatRequest(cfg, payloadBytes, apiKeys.Current())
+	if err != nil {
+		return run, err
+	}
+
+	start := time.Now()
+	resp, err := sendChatRequest(cfg, req)
+	if err != nil {
+		return run, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return run, newAPIError(resp, body)
+	}
+
+	stream := nvidiachat.NewStreamReader(resp.Body)
+	for {
+		d, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			run.total = time.Since(start)
+			return run, err
+		}
+		if d.Usage != nil {
+			run.tokens = d.Usage.CompletionTokens
+		}
+		n := len(d.Content) + len(d.Reasoning)
+		if n > 0 && run.chars == 0 {
+			run.ttft = time.Since(start)
+		}
+		run.chars += n
+	}
+	run.total = time.Since(start)
+	if run.tokens == 0 {
+		// roughly four characters per token when the server reports no usage
+		run.tokens, run.estimated = run.chars/4, true
+	}
+	return run, nil
+}
+
+func printBenchHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat bench --models a,b,c (--prompt-file FILE | --prompt TEXT) [options]\n\n", bold, normal))
+	builder.WriteString("Send the same prompt to each model and compare time to first token, total latency,\n")
+	builder.WriteString("tokens per second and output length. Each model uses its default settings.\n\n")
+	builder.WriteString("Options:\n")
+	builder.WriteString("  --models LIST           Comma-separated models to compare (default: " + defaultModel + ").\n")
+	builder.WriteString("  --prompt-file FILE      Read the prompt from FILE.\n")
+	builder.WriteString("  --prompt TEXT           Use TEXT as the prompt.\n")
+	builder.WriteString("  --runs N                Requests per model; the table shows averages (default: 3).\n")
+	builder.WriteString("  -k, --access-token KEY  API key (repeatable). Defaults to the keyring, then the environment.\n")
+	builder.WriteString("  --profile NAME          Keyring profile to read the key from.\n")
+	builder.WriteString("  --base-url URL          API base URL (default: the model's endpoint, else " + defaultBaseURL + ").\n")
+	fmt.Print(builder.String())
+}
+
+// runBenchCommand implements the `bench` subcommand and returns the process exit code.
+func runBenchCommand(args []string) int {
+	base := map[string]string{
+		"BASE_URL":        "",
+		"TIMEOUT":         "300",
+		"CONNECT_TIMEOUT": defaultConnectTimeout,
+		"IDLE_TIMEOUT":    defaultIdleTimeout,
+		"MAX_RETRIES":     "0",
+	}
+	models := []string{defaultModel}
+	prompt, profile := "", defaultProfile
+	runs := 3
+	var flagKeys []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printBenchHelp()
+			return exitOK
+		case "--models", "--prompt-file", "--prompt", "--runs", "-k", "--access-token", "--profile", "--base-url":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%smissing value for %s%s\n", red, args[i], normal)
+				return exitUsage
+			}
+			val := args[i+1]
+			switch args[i] {
+			case "--models":
+				models = nil
+				for _, m := range strings.Split(val, ",") {
+					if m = strings.TrimSpace(m); m != "" {
+						models = append(models, m)
+					}
+				}
+			case "--prompt-file":
+				b, err := ioutil.ReadFile(val)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%sFailed to read prompt file: %v%s\n", red, err, normal)
+					return exitUsage
+				}
+				prompt = string(b)
+			case "--prompt":
+				prompt = val
+			case "--runs":
+				n, err := strconv.Atoi(val)
+				if err != nil || n < 1 {
+					fmt.Fprintf(os.Stderr, "%sInvalid --runs (>= 1): %s%s\n", red, val, normal)
+					return exitUsage
+				}
+				runs = n
+			case "-k", "--access-token":
+				flagKeys = append(flagKeys, val)
+			case "--profile":
+				profile = val
+			case "--base-url":
+				base["BASE_URL"] = val
+			}
+			i++
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown option: %s\n", args[i])
+			printBenchHelp()
+			return exitUsage
+		}
+	}
+	if strings.TrimSpace(prompt) == "" || len(models) == 0 {
+		printBenchHelp()
+		return exitUsage
+	}
+
+	keys, _ := collectAPIKeys(flagKeys, profile)
+	if len(keys) == 0 {
+		fmt.Fprintf(os.Stderr, "%sNo API key found.%s Run `nvidia-chat auth login` or set NVIDIA_BUILD_AI_ACCESS_TOKEN.\n", red, normal)
+		return exitAuth
+	}
+	apiKeys = newKeyPool(keys)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tOK\tTTFT\tTOTAL\tTOK/S\tCHARS\t")
+	failed := 0
+	for _, model := range models {
+		cfg := benchConfig(base, model)
+		var sum benchRun
+		ok := 0
+		for r := 1; r <= runs; r++ {
+			fmt.Fprintf(os.Stderr, "%s run %d/%d...\n", model, r, runs)
+			run, err := runBenchOnce(cfg, prompt)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s%s: %s%s\n", red, model, firstLine(describeError(err)), normal)
+				continue
+			}
+			ok++
+			sum.ttft += run.ttft
+			sum.total += run.total
+			sum.chars += run.chars
+			sum.tokens += run.tokens
+			sum.estimated = sum.estimated || run.estimated
+		}
+		if ok == 0 {
+			failed++
+			fmt.Fprintf(tw, "%s\t0/%d\t-\t-\t-\t-\t\n", model, runs)
+			continue
+		}
+		tokPerSec := "-"
+		if gen := (sum.total - sum.ttft).Seconds(); gen > 0 {
+			tokPerSec = fmt.Sprintf("%.1f", float64(sum.tokens)/gen)
+			if sum.estimated {
+				tokPerSec = "~" + tokPerSec
+			}
+		}
+		n := time.Duration(ok)
+		fmt.Fprintf(tw, "%s\t%d/%d\t%s\t%s\t%s\t%d\t\n", model, ok, runs,
+			(sum.ttft / n).Round(time.Millisecond), (sum.total / n).Round(time.Millisecond), tokPerSec, sum.chars/ok)
+	}
+	tw.Flush()
+	if failed > 0 {
+		return exitGeneral
+	}
+	return exitOK
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// bookmarkMessage implements /bookmark [note]: it marks the last assistant message, or replaces the
+// note of its bookmark.
+func bookmarkMessage(args []string, convFile string) {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed reading conversation: %v%s\n", red, err, normal)
+		return
+	}
+	i := len(cf.Messages) - 1
+	for i >= 0 && cf.Messages[i].Role != "assistant" {
+		i--
+	}
+	if i < 0 {
+		fmt.Fprintf(os.Stderr, "%sNo assistant response to bookmark.%s\n", red, normal)
+		return
+	}
+	cf.Messages[i].Bookmark = &Bookmark{Note: strings.Join(args, " "), Time: time.Now()}
+	if err := writeConversation(convFile, cf); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to save the bookmark: %v%s\n", red, err, normal)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%sBookmarked response #%d%s\n", green, i+1, normal)
+}
+
+// bookmarkPreview returns the first line of a bookmarked answer, without its thinking, for lists.
+func bookmarkPreview(m Message) string {
+	text := strings.TrimSpace(filterThinkingBlock(m.Content))
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	if r := []rune(text); len(r) > 70 {
+		text = string(r[:67]) + "..."
+	}
+	return text
+}
+
+// listBookmarks implements /bookmarks: the bookmarked responses, numbered as in /exportrange, with
+// their notes.
+func listBookmarks(convFile string) {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed reading conversation: %v%s\n", red, err, normal)
+		return
+	}
+	found := false
+	for i, m := range cf.Messages {
+		if m.Bookmark == nil {
+			continue
+		}
+		found = true
+		note := m.Bookmark.Note
+		if note == "" {
+			note = "(no note)"
+		}
+		fmt.Fprintf(os.Stderr, "%s#%d%s %s  %s%s%s\n    %s\n", bold, i+1, normal, m.Bookmark.Time.Format("2006-01-02 15:04"), blue, note, normal, bookmarkPreview(m))
+	}
+	if !found {
+		fmt.Fprintln(os.Stderr, "No bookmarks. /bookmark [note] marks the last response.")
+	}
+}
+
+// exportBookmarks writes the bookmarked responses as markdown, each under a heading with its note.
+func exportBookmarks(convFile, targetFile string, filterThinking bool) error {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		return fmt.Errorf("reading conversation file: %w", err)
+	}
+	var builder strings.Builder
+	for i, m := range cf.Messages {
+		if m.Bookmark == nil {
+			continue
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\n")
+		}
+		heading := m.Bookmark.Note
+		if heading == "" {
+			heading = "Bookmark"
+		}
+		builder.WriteString(fmt.Sprintf("## %s (#%d)\n\n", heading, i+1))
+		content := m.Content
+		if filterThinking {
+			content = filterThinkingBlock(content)
+		}
+		builder.WriteString(strings.TrimRight(content, "\n") + "\n")
+	}
+	if builder.Len() == 0 {
+		return fmt.Errorf("no bookmarked responses")
+	}
+	return ioutil.WriteFile(targetFile, []byte(builder.String()), 0o644)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errBudgetExceeded is returned instead of sending a request that would exceed a budget of the
+// [budget] config section.
+var errBudgetExceeded = errors.New("budget exceeded")
+
+// budgetLimits are the budgets of the [budget] config section. Zero means unlimited. Prices are per
+// million tokens and are needed for the spend budgets.
+type budgetLimits struct {
+	monthlyTokens, conversationTokens int
+	monthlySpend, conversationSpend   float64
+	promptPrice, completionPrice      float64
+	warnOnly                          bool
+}
+
+// loadBudget reads and checks the [budget] config section.
+func loadBudget() (budgetLimits, error) {
+	var b budgetLimits
+	for key, dst := range map[string]*int{"monthly_tokens": &b.monthlyTokens, "conversation_tokens": &b.conversationTokens} {
+		if v := userConfig["budget."+key]; v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return b, fmt.Errorf("Invalid budget.%s (>= 0): %s", key, v)
+			}
+			*dst = n
+		}
+	}
+	for key, dst := range map[string]*float64{
+		"monthly_spend": &b.monthlySpend, "conversation_spend": &b.conversationSpend,
+		"prompt_price": &b.promptPrice, "completion_price": &b.completionPrice,
+	} {
+		if v := userConfig["budget."+key]; v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 {
+				return b, fmt.Errorf("Invalid budget.%s (>= 0): %s", key, v)
+			}
+			*dst = f
+		}
+	}
+	if (b.monthlySpend > 0 || b.conversationSpend > 0) && b.promptPrice == 0 && b.completionPrice == 0 {
+		return b, fmt.Errorf("Spend budgets need budget.prompt_price or budget.completion_price")
+	}
+	switch v := userConfig["budget.action"]; v {
+	case "", "refuse":
+	case "warn":
+		b.warnOnly = true
+	default:
+		return b, fmt.Errorf("Invalid budget.action (refuse|warn): %s", v)
+	}
+	return b, nil
+}
+
+func (b budgetLimits) enabled() bool {
+	return b.monthlyTokens > 0 || b.conversationTokens > 0 || b.monthlySpend > 0 || b.conversationSpend > 0
+}
+
+func (b budgetLimits) cost(u tokenUsage) float64 {
+	return (float64(u.PromptTokens)*b.promptPrice + float64(u.CompletionTokens)*b.completionPrice) / 1e6
+}
+
+// usageMu serializes updates of the usage file by concurrent requests.
+var usageMu sync.Mutex
+
+// usageFilePath returns the file holding the tokens used per month.
+func usageFilePath() string {
+	return filepath.Join(conversationDir(), "usage.json")
+}
+
+func currentMonth() string {
+	return time.Now().Format("2006-01")
+}
+
+// readMonthlyUsage returns the tokens used per month ("2006-01"). A missing file is not an error.
+func readMonthlyUsage() (map[string]tokenUsage, error) {
+	months := map[string]tokenUsage{}
+	b, err := ioutil.ReadFile(usageFilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return months, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &months); err != nil {
+		return nil, fmt.Errorf("%s: %w", usageFilePath(), err)
+	}
+	return months, nil
+}
+
+// recordUsage adds the tokens of a response to the current month.
+func recordUsage(u tokenUsage) {
+	if u.PromptTokens == 0 && u.CompletionTokens == 0 {
+		return
+	}
+	// an ephemeral session leaves no trace unless budgets need the count
+	if b, err := loadBudget(); ephemeralSession && (err != nil || !b.enabled()) {
+		return
+	}
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	err := func() error {
+		months, err := readMonthlyUsage()
+		if err != nil {
+			return err
+		}
+		m := months[currentMonth()]
+		m.PromptTokens += u.PromptTokens
+		m.CompletionTokens += u.CompletionTokens
+		months[currentMonth()] = m
+		b, err := json.MarshalIndent(months, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(usageFilePath()), 0o755); err != nil {
+			return err
+		}
+		tmp := usageFilePath() + ".tmp"
+		if err := ioutil.WriteFile(tmp, b, 0o644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, usageFilePath())
+	}()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to record token usage: %v%s\n", red, err, normal)
+	}
+}
+
+// checkBudget is called before sending messages. cf is the conversation they belong to, or nil
+// for requests outside a conversation. A request whose prompt, estimated from its length, would
+// go over a budget is refused with errBudgetExceeded, or only reported when budget.action is warn.
+func checkBudget(cf *ConversationFile, messages []Message) error {
+	b, err := loadBudget()
+	if err != nil || !b.enabled() {
+		return err
+	}
+	estimate := tokenUsage{}
+	for _, m := range messages {
+		estimate.PromptTokens += estimateTokens(m)
+	}
+	months, err := readMonthlyUsage()
+	if err != nil {
+		return err
+	}
+	month := months[currentMonth()]
+
+	var over []string
+	check := func(name string, used tokenUsage, tokenLimit int, spendLimit float64) {
+		if n := used.PromptTokens + used.CompletionTokens + estimate.PromptTokens; tokenLimit > 0 && n > tokenLimit {
+			over = append(over, fmt.Sprintf("%s token budget (%d of %d)", name, n, tokenLimit))
+		}
+		if cost := b.cost(used) + b.cost(estimate); spendLimit > 0 && cost > spendLimit {
+			over = append(over, fmt.Sprintf("%s spend budget (%.4f of %.4f)", name, cost, spendLimit))
+		}
+	}
+	check("monthly", month, b.monthlyTokens, b.monthlySpend)
+	if cf != nil {
+		check("conversation", conversationUsage(cf), b.conversationTokens, b.conversationSpend)
+	}
+	if len(over) == 0 {
+		return nil
+	}
+	msg := "this request would exceed the " + strings.Join(over, " and the ")
+	if b.warnOnly {
+		fmt.Fprintf(os.Stderr, "%sWarning: %s.%s\n", red, msg, normal)
+		return nil
+	}
+	return fmt.Errorf("%w: %s; raise the limits in the [budget] section of %s", errBudgetExceeded, msg, userConfigPath())
+}
+
+// conversationUsage returns the tokens used by a conversation so far.
+func conversationUsage(cf *ConversationFile) tokenUsage {
+	if cf.Usage == nil {
+		return tokenUsage{}
+	}
+	return *cf.Usage
+}
+
+// budgetReport describes the budgets and what remains of them, for /budget.
+func budgetReport(cf *ConversationFile) (string, error) {
+	b, err := loadBudget()
+	if err != nil {
+		return "", err
+	}
+	months, err := readMonthlyUsage()
+	if err != nil {
+		return "", err
+	}
+	month := months[currentMonth()]
+
+	var builder strings.Builder
+	line := func(name string, used tokenUsage, tokenLimit int, spendLimit float64) {
+		n := used.PromptTokens + used.CompletionTokens
+		builder.WriteString(fmt.Sprintf("%s%s:%s %d tokens (%d prompt, %d completion)", bold, name, normal, n, used.PromptTokens, used.CompletionTokens))
+		if tokenLimit > 0 {
+			builder.WriteString(fmt.Sprintf(", %d of %d left", max(tokenLimit-n, 0), tokenLimit))
+		}
+		builder.WriteString("\n")
+		if b.promptPrice > 0 || b.completionPrice > 0 {
+			cost := b.cost(used)
+			builder.WriteString(fmt.Sprintf("  spent %.4f", cost))
+			if spendLimit > 0 {
+				builder.WriteString(fmt.Sprintf(", %.4f of %.4f left", max(spendLimit-cost, 0), spendLimit))
+			}
+			builder.WriteString("\n")
+		}
+	}
+	line("This month ("+currentMonth()+")", month, b.monthlyTokens, b.monthlySpend)
+	line("This conversation", conversationUsage(cf), b.conversationTokens, b.conversationSpend)
+	if !b.enabled() {
+		builder.WriteString("No budget is set; add a [budget] section to " + userConfigPath() + " to set one.\n")
+	} else if b.warnOnly {
+		builder.WriteString("Requests over budget are sent with a warning (budget.action = warn).\n")
+	}
+	return builder.String(), nil
+}
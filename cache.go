@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defaultCacheTTL = "24h"
+
+// cachedResponse is a stored reply of a --prompt call.
+type cachedResponse struct {
+	Model   string    `json:"model"`
+	Created time.Time `json:"created"`
+	Text    string    `json:"text"`
+}
+
+// responseCacheDir returns the directory of cached --prompt responses.
+func responseCacheDir() string {
+	return filepath.Join(conversationDir(), "responses")
+}
+
+// responseCacheKey hashes the endpoint and the request payload, which includes the model, the
+// messages and every setting sent to the API.
+func responseCacheKey(cfg map[string]string, payload []byte) string {
+	h := sha256.New()
+	h.Write([]byte(resolveBaseURL(cfg) + "\n"))
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lookupCachedResponse returns the cached reply for key unless it is missing or older than ttl
+// (0 keeps entries forever). Expired entries are removed.
+func lookupCachedResponse(key string, ttl time.Duration) (*cachedResponse, bool) {
+	path := filepath.Join(responseCacheDir(), key+".json")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var c cachedResponse
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, false
+	}
+	if ttl > 0 && time.Since(c.Created) > ttl {
+		os.Remove(path)
+		return nil, false
+	}
+	return &c, true
+}
+
+// storeCachedResponse saves a reply under key.
+func storeCachedResponse(key, model, text string) error {
+	dir := responseCacheDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(cachedResponse{Model: model, Created: time.Now(), Text: text})
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, key+".json.tmp")
+	if err := ioutil.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, key+".json"))
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// catalogPath returns the cache of model metadata written by `models update`.
+func catalogPath() string {
+	return filepath.Join(conversationDir(), "models-catalog.json")
+}
+
+// catalogModel is an entry of the backend's model list. NVIDIA and other OpenAI-compatible
+// servers report limits under different names; absent fields are zero.
+type catalogModel struct {
+	ID                  string   `json:"id"`
+	ContextLength       int      `json:"context_length"`
+	MaxModelLen         int      `json:"max_model_len"`
+	ContextWindow       int      `json:"context_window"`
+	MaxOutputTokens     int      `json:"max_output_tokens"`
+	MaxCompletionTokens int      `json:"max_completion_tokens"`
+	SupportedParameters []string `json:"supported_parameters"`
+}
+
+func (m catalogModel) contextWindow() int {
+	for _, n := range []int{m.ContextLength, m.MaxModelLen, m.ContextWindow} {
+		if n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+func (m catalogModel) maxOutput() int {
+	if m.MaxOutputTokens > 0 {
+		return m.MaxOutputTokens
+	}
+	return m.MaxCompletionTokens
+}
+
+// isChatModel filters embedding and reranking models out of the catalog.
+func (m catalogModel) isChatModel() bool {
+	id := strings.ToLower(m.ID)
+	return !strings.Contains(id, "embed") && !strings.Contains(id, "rerank")
+}
+
+// catalogEntry builds the model definition stored in the catalog. Built-in models only get the
+// limits the catalog reports, so their curated parameters are kept; other models start from the
+// generic definition, restricted to the supported parameters when the catalog lists them. A
+// max_tokens default above the reported maximum is lowered to it, or every request with the
+// default would be rejected.
+func catalogEntry(m catalogModel) map[string]interface{} {
+	entry := map[string]interface{}{}
+	if n := m.contextWindow(); n > 0 {
+		entry["context_window"] = n
+	}
+
+	if def, builtin := ModelDefinitions[m.ID]; builtin {
+		if n := m.maxOutput(); n > 0 {
+			limit := map[string]interface{}{"max": n}
+			if defaultAbove(def.Parameters["max_tokens"], n) {
+				limit["default"] = n
+			}
+			entry["parameters"] = map[string]interface{}{"max_tokens": limit}
+		}
+		return entry
+	}
+
+	supported := map[string]bool{}
+	for _, p := range m.SupportedParameters {
+		supported[p] = true
+	}
+	params := map[string]ModelParameter{}
+	for name, p := range ModelDefinitions["others"].Parameters {
+		if len(supported) > 0 && !supported[p.APIKey] {
+			continue
+		}
+		if name == "max_tokens" && m.maxOutput() > 0 {
+			p.Max = float64(m.maxOutput())
+			if defaultAbove(p, m.maxOutput()) {
+				p.Default = m.maxOutput()
+			}
+		}
+		params[name] = p
+	}
+	entry["parameters"] = params
+	return entry
+}
+
+// defaultAbove reports whether the numeric default of param is above max.
+func defaultAbove(param ModelParameter, max int) bool {
+	d, err := strconv.ParseFloat(defaultValueString(param), 64)
+	return err == nil && d > float64(max)
+}
+
+// fetchCatalog downloads the model list of the backend.
+func fetchCatalog(cfg map[string]string) ([]catalogModel, error) {
+	req, err := http.NewRequest("GET", resolveBaseURL(cfg)+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	if apiKeys.Len() > 0 {
+		req.Header.Set("Authorization", "Bearer "+apiKeys.Current())
+	}
+	resp, err := sendChatRequest(cfg, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, newAPIError(resp, body)
+	}
+	var list struct {
+		Data []catalogModel `json:"data"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("parse model list: %w", err)
+	}
+	return list.Data, nil
+}
+
+func printModelsHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat models update [options]\n\n", bold, normal))
+	builder.WriteString("Fetch model metadata from the API and regenerate the local model catalog\n")
+	builder.WriteString("(" + catalogPath() + ").\n")
+	builder.WriteString("Models from the catalog appear in -l and can be selected with their settings validated;\n")
+	builder.WriteString("definitions in " + userModelsDir() + " take precedence over it.\n\n")
+	builder.WriteString("Options:\n")
+	builder.WriteString("  -k, --access-token KEY  API key (repeatable). Defaults to the keyring, then the environment.\n")
+	builder.WriteString("  --profile NAME          Keyring profile to read the key from.\n")
+	builder.WriteString("  --base-url URL          API base URL (default: " + defaultBaseURL + ").\n")
+	fmt.Print(builder.String())
+}
+
+// runModelsCommand implements the `models` subcommand and returns the process exit code.
+func runModelsCommand(args []string) int {
+	cfg := map[string]string{
+		"MODEL":           "",
+		"BASE_URL":        "",
+		"TIMEOUT":         "60",
+		"CONNECT_TIMEOUT": defaultConnectTimeout,
+		"IDLE_TIMEOUT":    defaultIdleTimeout,
+		"MAX_RETRIES":     "2",
+	}
+	profile := defaultProfile
+	var flagKeys, rest []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printModelsHelp()
+			return exitOK
+		case "-k", "--access-token", "--profile", "--base-url":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%smissing value for %s%s\n", red, args[i], normal)
+				return exitUsage
+			}
+			switch args[i] {
+			case "-k", "--access-token":
+				flagKeys = append(flagKeys, args[i+1])
+			case "--profile":
+				profile = args[i+1]
+			case "--base-url":
+				cfg["BASE_URL"] = args[i+1]
+			}
+			i++
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	if len(rest) != 1 || rest[0] != "update" {
+		printModelsHelp()
+		return exitUsage
+	}
+
+	// The model list is public, so a missing key is not an error.
+	keys, _ := collectAPIKeys(flagKeys, profile)
+	apiKeys = newKeyPool(keys)
+
+	models, err := fetchCatalog(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to fetch the model list: %s%s\n", red, describeError(err), normal)
+		return exitCodeFor(err)
+	}
+
+	catalog := map[string]interface{}{}
+	var added, updated int
+	for _, m := range models {
+		if m.ID == "" || !m.isChatModel() {
+			continue
+		}
+		if _, builtin := ModelDefinitions[m.ID]; builtin {
+			updated++
+		} else {
+			added++
+		}
+		catalog[m.ID] = catalogEntry(m)
+	}
+	b, err := json.MarshalIndent(catalog, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	path := catalogPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	if err := ioutil.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to write %s: %v%s\n", red, path, err, normal)
+		return exitGeneral
+	}
+	fmt.Fprintf(os.Stderr, "%sWrote %s: %d new model(s), %d built-in model(s) updated.%s\n", green, path, added, updated, normal)
+	return exitOK
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCatalogLowersMaxTokensDefault(t *testing.T) {
+	const id = "qwen/qwen3-coder-480b-a35b-instruct" // built-in max_tokens default: 4096
+	saved := ModelDefinitions[id]
+	t.Cleanup(func() { ModelDefinitions[id] = saved })
+
+	raw, err := json.Marshal(catalogEntry(catalogModel{ID: id, MaxOutputTokens: 1024}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mergeModelDefinition(id, raw); err != nil {
+		t.Fatal(err)
+	}
+	def := ModelDefinitions[id]
+	param := def.Parameters["max_tokens"]
+	if param.Max != 1024 || defaultValueString(param) != "1024" {
+		t.Errorf("max_tokens: max %g, default %s; want both 1024", param.Max, defaultValueString(param))
+	}
+	if err := validateParameter("max_tokens", defaultValueString(param), def); err != nil {
+		t.Errorf("the default is rejected: %v", err)
+	}
+	if param.Description != saved.Parameters["max_tokens"].Description || len(def.Parameters) != len(saved.Parameters) {
+		t.Error("the curated parameters of the built-in model were not kept")
+	}
+
+	// a maximum above the default leaves the default alone
+	ModelDefinitions[id] = saved
+	raw, _ = json.Marshal(catalogEntry(catalogModel{ID: id, MaxOutputTokens: 8192}))
+	if err := mergeModelDefinition(id, raw); err != nil {
+		t.Fatal(err)
+	}
+	if got := defaultValueString(ModelDefinitions[id].Parameters["max_tokens"]); got != "4096" {
+		t.Errorf("default = %s, want 4096 kept", got)
+	}
+}
+
+func TestCatalogGenericModelDefaultWithinMax(t *testing.T) {
+	entry := catalogEntry(catalogModel{ID: "example/small-model", MaxCompletionTokens: 256})
+	param := entry["parameters"].(map[string]ModelParameter)["max_tokens"]
+	if param.Max != 256 {
+		t.Fatalf("max = %g, want 256", param.Max)
+	}
+	if err := validateParameter("max_tokens", defaultValueString(param), ModelDefinition{Parameters: map[string]ModelParameter{"max_tokens": param}}); err != nil {
+		t.Errorf("the default %s is rejected: %v", defaultValueString(param), err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// codeBlock is a fenced code block of an assistant response.
+type codeBlock struct {
+	Lang string // language from the info string, if any
+	Path string // file path from the info string, if any
+	Code string
+}
+
+// codeExtensions maps info string languages to file extensions for blocks without a path.
+var codeExtensions = map[string]string{
+	"go": "go", "python": "py", "py": "py", "javascript": "js", "js": "js", "typescript": "ts", "ts": "ts",
+	"bash": "sh", "sh": "sh", "shell": "sh", "zsh": "sh", "rust": "rs", "c": "c", "cpp": "cpp", "c++": "cpp",
+	"java": "java", "kotlin": "kt", "ruby": "rb", "php": "php", "html": "html", "css": "css", "json": "json",
+	"yaml": "yaml", "yml": "yaml", "toml": "toml", "sql": "sql", "markdown": "md", "md": "md",
+	"dockerfile": "Dockerfile", "makefile": "mk", "xml": "xml", "lua": "lua", "swift": "swift",
+}
+
+var fileNameLike = regexp.MustCompile(`^[\w.\-/]*\w\.\w+$|/`)
+
+// parseInfoString splits a fence info string into a language and a file path. Accepted forms are
+// "go", "path/to/main.go", "go main.go", "go:main.go" and "go title=main.go".
+func parseInfoString(info string) (lang, path string) {
+	for _, field := range strings.Fields(info) {
+		if i := strings.Index(field, ":"); i > 0 && lang == "" && path == "" {
+			lang, field = field[:i], field[i+1:]
+		}
+		for _, prefix := range []string{"title=", "file=", "filename=", "path="} {
+			field = strings.TrimPrefix(field, prefix)
+		}
+		field = strings.Trim(field, `"'`)
+		if path == "" && fileNameLike.MatchString(field) {
+			path = field
+		} else if lang == "" {
+			lang = field
+		}
+	}
+	return strings.ToLower(lang), path
+}
+
+// extractCodeBlocks returns the fenced code blocks of a markdown text. Fences of three or more
+// backticks or tildes are recognized; a block only ends at a fence of the same kind and length.
+func extractCodeBlocks(text string) []codeBlock {
+	var blocks []codeBlock
+	var current *codeBlock
+	var fence string
+	var body []string
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if current == nil {
+			for _, ch := range []string{"`", "~"} {
+				if strings.HasPrefix(trimmed, strings.Repeat(ch, 3)) {
+					n := len(trimmed) - len(strings.TrimLeft(trimmed, ch))
+					fence = strings.Repeat(ch, n)
+					lang, path := parseInfoString(trimmed[n:])
+					current = &codeBlock{Lang: lang, Path: path}
+					body = nil
+					break
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			current.Code = strings.Join(body, "\n") + "\n"
+			blocks = append(blocks, *current)
+			current = nil
+			continue
+		}
+		body = append(body, line)
+	}
+	return blocks
+}
+
+// nthAssistantResponse returns the Nth-to-last assistant response (1 is the last one).
+func nthAssistantResponse(convFile string, n int) (string, error) {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		return "", fmt.Errorf("reading conversation file: %w", err)
+	}
+	for i := len(cf.Messages) - 1; i >= 0; i-- {
+		if cf.Messages[i].Role == "assistant" && cf.Messages[i].Content != "" {
+			if n--; n == 0 {
+				return cf.Messages[i].Content, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no such assistant response")
+}
+
+// safeJoin joins a block's path to dir, refusing paths that would escape it.
+func safeJoin(dir, path string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(path))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to write outside %s: %s", dir, path)
+	}
+	return filepath.Join(dir, clean), nil
+}
+
+// codeFile is a file written by /exportcode, with the code of the blocks that go to it.
+type codeFile struct {
+	name   string
+	code   string
+	blocks int
+}
+
+// groupCodeBlocks names the file of each block and joins the blocks that go to the same file, in
+// order. A block is named by its info string or else by a comment on its first line; the others
+// are numbered, with the extension of their language, detected when the info string gives none.
+func groupCodeBlocks(blocks []codeBlock) []*codeFile {
+	var files []*codeFile
+	byName := map[string]*codeFile{}
+	for i, b := range blocks {
+		name, code := b.Path, b.Code
+		if name == "" {
+			if name = inferredPath(b.Code); name != "" && byName[name] != nil {
+				code = strings.SplitN(code, "\n", 2)[1] // the file is already named
+			}
+		}
+		if name == "" {
+			lang := b.Lang
+			if _, ok := codeExtensions[lang]; !ok {
+				lang = detectLanguage(b.Code)
+			}
+			ext, ok := codeExtensions[lang]
+			if !ok {
+				ext = "txt"
+			}
+			name = fmt.Sprintf("snippet-%d.%s", i+1, ext)
+			if ext == "Dockerfile" {
+				name = fmt.Sprintf("Dockerfile.%d", i+1)
+			}
+		}
+		if f, ok := byName[name]; ok {
+			f.code += "\n" + code
+			f.blocks++
+			continue
+		}
+		f := &codeFile{name: name, code: b.Code, blocks: 1}
+		byName[name] = f
+		files = append(files, f)
+	}
+	return files
+}
+
+// exportCodeBlocks writes the code blocks of the Nth-to-last assistant response into dir, grouped
+// into files by groupCodeBlocks. Existing files are only overwritten after confirmation.
+func exportCodeBlocks(convFile string, n int, dir string) error {
+	content, err := nthAssistantResponse(convFile, n)
+	if err != nil {
+		return err
+	}
+	blocks := extractCodeBlocks(filterThinkingBlock(content))
+	if len(blocks) == 0 {
+		return fmt.Errorf("the response has no code blocks")
+	}
+
+	written := 0
+	for _, f := range groupCodeBlocks(blocks) {
+		target, err := safeJoin(dir, f.name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+			continue
+		}
+		if fileExists(target) {
+			fmt.Fprintf(os.Stderr, "%s exists. Overwrite? [y/N] ", target)
+			answer, _ := readSingleLine(nil, []string{"\n"}, true)
+			if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+				fmt.Fprintf(os.Stderr, "Skipped %s\n", target)
+				continue
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(target, []byte(f.code), 0o644); err != nil {
+			return err
+		}
+		joined := ""
+		if f.blocks > 1 {
+			joined = fmt.Sprintf(", %d blocks", f.blocks)
+		}
+		fmt.Fprintf(os.Stderr, "%sWrote %s (%d lines%s)%s\n", green, target, strings.Count(f.code, "\n"), joined, normal)
+		written += f.blocks
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d code block(s) written.\n", written, len(blocks))
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// languageSignal is a pattern typical of a language. A block scores weight for each match, up to
+// three matches per signal.
+type languageSignal struct {
+	lang    string
+	weight  int
+	pattern *regexp.Regexp
+}
+
+func langSignal(lang string, weight int, pattern string) languageSignal {
+	return languageSignal{lang, weight, regexp.MustCompile(pattern)}
+}
+
+// languageSignals are checked by detectLanguage; on a tie, the language listed first wins.
+var languageSignals = []languageSignal{
+	langSignal("go", 3, `(?m)^package \w+$`),
+	langSignal("go", 2, `(?m)^import \($|\bfunc (\(\w+ \*?\w+\) )?\w+\(|\bfmt\.\w+\(`),
+	langSignal("go", 1, `:= `),
+	langSignal("python", 3, `(?m)^[ \t]*def \w+\(.*\):\s*$|^[ \t]*class \w+(\(.*\))?:\s*$|^if __name__ == `),
+	langSignal("python", 2, `(?m)^[ \t]*elif\b|^[ \t]*(from [\w.]+ )?import \w[\w.]*(, \w+)*$`),
+	langSignal("python", 1, `\bprint\(|\bself\.|\bNone\b`),
+	langSignal("rust", 3, `(?m)\blet mut\b|^use \w+::|\w+!\(|\bimpl\b`),
+	langSignal("rust", 2, `\bfn \w+\(`),
+	langSignal("java", 3, `\bpublic (static |final )*(class|void|interface)\b|System\.out\.print|(?m)^import java\.`),
+	langSignal("kotlin", 3, `\bfun \w+\(|\bval \w+ = `),
+	langSignal("typescript", 3, `(?m)^[ \t]*(export )?(interface \w+ \{|type \w+ = )|:\s*(string|number|boolean)(\[\])?\b`),
+	langSignal("javascript", 2, `\bconsole\.log\(|\bfunction\s*\w*\(|\brequire\(|(?m)^[ \t]*export (default|const|function)\b|\bdocument\.`),
+	langSignal("javascript", 1, `(?m)^[ \t]*(const|let|var) \w+ = |=>`),
+	langSignal("cpp", 3, `std::|#include <(iostream|vector|string|memory)>|\bcout\b`),
+	langSignal("c", 3, `(?m)^#include\s*[<"]`),
+	langSignal("c", 2, `\bint main\(|\bprintf\(|\bmalloc\(`),
+	langSignal("php", 5, `<\?php`),
+	langSignal("ruby", 3, `(?m)^require ['"]|\bputs\b|\.each do\b`),
+	langSignal("ruby", 1, `(?m)^[ \t]*end$`),
+	langSignal("html", 5, `(?i)<!doctype html|<html\b`),
+	langSignal("html", 2, `</(div|p|body|head|span|a|ul|li)>`),
+	langSignal("xml", 5, `(?m)^<\?xml`),
+	langSignal("css", 2, `(?m)^[ \t]*(color|margin|padding|display|font-[\w-]+|background(-color)?|border|width|height):\s*[^;]+;`),
+	langSignal("sql", 3, `(?im)^[ \t]*(select\b.*\bfrom\b|insert into\b|create (table|index)\b|update \w+ set\b|delete from\b|alter table\b)`),
+	langSignal("dockerfile", 3, `(?m)^FROM \S+`),
+	langSignal("dockerfile", 2, `(?m)^(RUN|COPY|WORKDIR|CMD|ENTRYPOINT|ENV|EXPOSE) `),
+	langSignal("makefile", 5, `(?m)^\.PHONY:`),
+	langSignal("makefile", 3, "(?m)^[\\w.-]+:.*\n\t"),
+	langSignal("bash", 3, `(?m)^[ \t]*if \[|^[ \t]*fi$|^[ \t]*done$`),
+	langSignal("bash", 1, `(?m)^[ \t]*(\$ )?(sudo|apt|apt-get|brew|npm|pip3?|go|git|curl|wget|cd|ls|mkdir|echo|export|docker|kubectl|chmod|make|cargo) `),
+	langSignal("toml", 2, `(?m)^\[[\w.-]+\]$`),
+	langSignal("toml", 1, `(?m)^[\w.-]+ = ("|\d|\[|true|false)`),
+	langSignal("yaml", 2, `(?m)^---$`),
+	langSignal("yaml", 1, `(?m)^[ \t]*[\w.-]+:( [^;{\n]*)?$|^[ \t]*- [\w"']`),
+}
+
+// shebangLanguages maps the interpreter of a #! line to a language.
+var shebangLanguages = map[string]string{
+	"python": "python", "python3": "python", "bash": "bash", "sh": "bash", "zsh": "bash",
+	"node": "javascript", "ruby": "ruby", "php": "php",
+}
+
+var shebang = regexp.MustCompile(`^#!\s*\S*/(\w+)(?:[ \t]+(\w+))?`)
+
+// detectLanguage guesses the language of a code block without an info string, as a key of
+// codeExtensions, or returns "" when nothing stands out.
+func detectLanguage(code string) string {
+	trimmed := strings.TrimSpace(code)
+	if m := shebang.FindStringSubmatch(trimmed); m != nil {
+		interpreter := m[1]
+		if interpreter == "env" {
+			interpreter = m[2]
+		}
+		if lang, ok := shebangLanguages[interpreter]; ok {
+			return lang
+		}
+	}
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return "json"
+	}
+	scores := map[string]int{}
+	best := ""
+	for _, s := range languageSignals {
+		scores[s.lang] += s.weight * len(s.pattern.FindAllStringIndex(code, 3))
+		if scores[s.lang] > scores[best] {
+			best = s.lang
+		}
+	}
+	if scores[best] < 2 {
+		return ""
+	}
+	return best
+}
+
+// leadingFileComment matches a first line naming the file a block belongs to, such as
+// "// main.go", "# file: app.py" or "<!-- index.html -->".
+var leadingFileComment = regexp.MustCompile(`^[ \t]*(?://|#|--|/\*|<!--|;)\s*(?:(?i:file(?:name)?|path)\s*:\s*)?([\w.\-/]*\w\.(\w+))\s*(?:\*/|-->)?\s*$`)
+
+// inferredPath returns the file a block without a path in its info string names in its first
+// line, when that file has the extension of a known language.
+func inferredPath(code string) string {
+	first := strings.SplitN(code, "\n", 2)[0]
+	m := leadingFileComment.FindStringSubmatch(first)
+	if m == nil {
+		return ""
+	}
+	for _, ext := range codeExtensions {
+		if ext == m[2] {
+			return m[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// commitMsgTemplate is the prompt of `nvidia-chat commitmsg`. A commitmsg.tmpl file in the
+// template directory replaces it; {{.diff}} is the diff.
+const commitMsgTemplate = `Write a git commit message for the changes below, in the Conventional Commits style:
+a subject line "type(scope): summary" of at most 72 characters, where type is one of feat, fix,
+docs, style, refactor, perf, test, build, ci or chore and the scope is optional; then a blank
+line and a short body, wrapped at 72 columns, explaining what changed and why. Leave the body
+out for trivial changes. Reply with the commit message only, without code fences or comments.
+
+{{.diff}}`
+
+func printCommitMsgHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat commitmsg [--staged] [--commit] [options]\n\n", bold, normal))
+	builder.WriteString("Ask the model for a Conventional Commits style message describing the staged changes of the\n")
+	builder.WriteString("git repository in the current directory, and print it. When nothing is staged, the unstaged\n")
+	builder.WriteString("changes are described instead. The prompt can be replaced by a commitmsg.tmpl file in\n")
+	builder.WriteString(templateDir() + ", where {{.diff}} is the diff.\n\n")
+	builder.WriteString("Options:\n")
+	builder.WriteString("  --staged              Only describe the staged changes; fail when nothing is staged.\n")
+	builder.WriteString("  --commit              Run git commit -e -m MESSAGE to review the message in the editor and commit.\n")
+	builder.WriteString("  -m, --model NAME      Model to use (default: " + defaultModel + ").\n")
+	builder.WriteString("  -k, --access-token KEY\n                        API key (repeatable). Defaults to the OS keyring, then the environment.\n")
+	builder.WriteString("  --profile NAME        Use the API keys stored in the OS keyring under NAME.\n")
+	builder.WriteString("  --base-url URL        API base URL (default: the model's endpoint, else " + defaultBaseURL + ").\n")
+	fmt.Print(builder.String())
+}
+
+// commitMsgPrompt renders the commitmsg prompt for a formatted diff.
+func commitMsgPrompt(diff string) (string, error) {
+	if path := filepath.Join(templateDir(), "commitmsg.tmpl"); fileExists(path) {
+		return renderTemplate(path, map[string]string{"diff": diff})
+	}
+	return strings.Replace(commitMsgTemplate, "{{.diff}}", diff, 1), nil
+}
+
+// cleanCommitMessage removes the reasoning, and the code fence some models put around the message.
+func cleanCommitMessage(reply string) string {
+	msg := strings.TrimSpace(filterThinkingBlock(reply))
+	if strings.HasPrefix(msg, "```") && strings.HasSuffix(msg, "```") {
+		if i := strings.Index(msg, "\n"); i >= 0 {
+			msg = strings.TrimSpace(strings.TrimSuffix(msg[i+1:], "```"))
+		}
+	}
+	return msg
+}
+
+// runCommitMsgCommand implements the `commitmsg` subcommand and returns the process exit code.
+func runCommitMsgCommand(args []string) int {
+	base := map[string]string{
+		"BASE_URL":        "",
+		"TIMEOUT":         defaultTimeout,
+		"CONNECT_TIMEOUT": defaultConnectTimeout,
+		"IDLE_TIMEOUT":    defaultIdleTimeout,
+		"MAX_RETRIES":     defaultMaxRetries,
+		"HISTORY_LIMIT":   strconv.Itoa(defaultHistoryLimit),
+	}
+	model, profile := defaultModel, defaultProfile
+	stagedOnly, commit := false, false
+	var flagKeys []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printCommitMsgHelp()
+			return exitOK
+		case "--staged":
+			stagedOnly = true
+		case "--commit":
+			commit = true
+		case "-m", "--model", "-k", "--access-token", "--profile", "--base-url":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%smissing value for %s%s\n", red, args[i], normal)
+				return exitUsage
+			}
+			val := args[i+1]
+			switch args[i] {
+			case "-m", "--model":
+				model = val
+			case "-k", "--access-token":
+				flagKeys = append(flagKeys, val)
+			case "--profile":
+				profile = val
+			case "--base-url":
+				base["BASE_URL"] = val
+			}
+			i++
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown option: %s\n", args[i])
+			printCommitMsgHelp()
+			return exitUsage
+		}
+	}
+
+	staged := true
+	diff, err := runGitDiff(true)
+	if err == nil && strings.TrimSpace(diff) == "" && !stagedOnly && !commit {
+		fmt.Fprintln(os.Stderr, "Nothing is staged; describing the unstaged changes.")
+		staged = false
+		diff, err = runGitDiff(false)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sCannot read the changes: %v%s\n", red, err, normal)
+		return exitUsage
+	}
+	if strings.TrimSpace(diff) == "" {
+		fmt.Fprintf(os.Stderr, "%sThere are no changes to describe; stage them with git add.%s\n", red, normal)
+		return exitUsage
+	}
+	text, summary := formatGitDiff(diff, staged)
+	prompt, err := commitMsgPrompt(text)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitUsage
+	}
+
+	keys, _ := collectAPIKeys(flagKeys, profile)
+	if len(keys) == 0 {
+		fmt.Fprintf(os.Stderr, "%sNo API key found.%s Run `nvidia-chat auth login` or set NVIDIA_BUILD_AI_ACCESS_TOKEN.\n", red, normal)
+		return exitAuth
+	}
+	apiKeys = newKeyPool(keys)
+
+	fmt.Fprintf(os.Stderr, "Writing a commit message for %s with %s...\n", summary, model)
+	var reply bytes.Buffer
+	if err := processSinglePrompt(prompt, benchConfig(base, model), "", apiKeys.Current(), &reply); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%s%s\n", red, describeError(err), normal)
+		return exitCodeFor(err)
+	}
+	msg := cleanCommitMessage(reply.String())
+	if msg == "" {
+		fmt.Fprintf(os.Stderr, "%sThe model returned an empty message.%s\n", red, normal)
+		return exitGeneral
+	}
+	if !commit {
+		fmt.Println(msg)
+		return exitOK
+	}
+
+	cmd := exec.Command("git", "commit", "-e", "-m", msg)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%sgit commit failed: %v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	return exitOK
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// userConfig holds the settings read from the user configuration file. Keys are dotted,
+// e.g. "hooks.on_response".
+var userConfig = map[string]string{}
+
+// userConfigPath returns the user configuration file.
+func userConfigPath() string {
+	return filepath.Join(configDir(), "config.toml")
+}
+
+// loadConfigFile reads a small TOML subset: `key = value` lines, `[section]` headers that prefix
+// the following keys, `#` comments, also after a value, and optionally quoted string values. A
+// missing file is not an error.
+func loadConfigFile(path string) (map[string]string, error) {
+	values := map[string]string{}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return values, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, lineNo)
+		}
+		key := strings.TrimSpace(parts[0])
+		if section != "" {
+			key = section + "." + key
+		}
+		value := strings.TrimSpace(parts[1])
+		if strings.HasPrefix(value, "\"") {
+			// a comment may follow the closing quote
+			quoted, err := strconv.QuotedPrefix(value)
+			if rest := strings.TrimSpace(value[len(quoted):]); err == nil && rest != "" && !strings.HasPrefix(rest, "#") {
+				err = fmt.Errorf("unexpected %s", rest)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid string %s", path, lineNo, value)
+			}
+			value, _ = strconv.Unquote(quoted)
+		} else if strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") && len(value) >= 2 {
+			value = value[1 : len(value)-1]
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
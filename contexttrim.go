@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
+)
+
+// estimateTokens counts the tokens of a message with the model's tokenizer, if one is configured,
+// or roughly at four characters per token.
+func estimateTokens(m Message) int {
+	n := countTextTokens(m.Content)
+	for _, call := range m.ToolCalls {
+		n += countTextTokens(call.Function.Name + call.Function.Arguments)
+	}
+	return n + 4
+}
+
+// trimOldestMessages drops messages from the start of the history until about tokens tokens are
+// gone. The last message is always kept, and the kept history starts at a user message so tool
+// results never lose the call they answer.
+func trimOldestMessages(messages []Message, tokens int) (kept, dropped []Message) {
+	removed, cut := 0, 0
+	for cut < len(messages)-1 && removed < tokens {
+		removed += estimateTokens(messages[cut])
+		cut++
+	}
+	for cut < len(messages)-1 && messages[cut].Role != "user" {
+		cut++
+	}
+	return messages[cut:], messages[:cut]
+}
+
+// summarizeMessages asks the model for a summary of messages that are about to be dropped. With a
+// token budget, the oldest messages are left out until the rest fits in it.
+func summarizeMessages(cfg map[string]string, sysPromptContent string, messages []Message, accessToken string, budget int) (string, error) {
+	summary, err := askAboutMessages(cfg, sysPromptContent, messages, "Summarize the conversation above in a few paragraphs. Keep the facts, decisions, code names and open questions needed to continue it.", accessToken, budget, 1024)
+	if err == nil && summary == "" {
+		err = fmt.Errorf("the model returned an empty summary")
+	}
+	return summary, err
+}
+
+// askAboutMessages sends messages followed by instruction, without streaming and with at most
+// maxTokens tokens, and returns the model's answer. With a token budget, the oldest messages are
+// left out until the rest fits in it.
+func askAboutMessages(cfg map[string]string, sysPromptContent string, messages []Message, instruction, accessToken string, budget, maxTokens int) (string, error) {
+	total := 0
+	for _, m := range messages {
+		total += estimateTokens(m)
+	}
+	for budget > 0 && len(messages) > 1 && total > budget {
+		total -= estimateTokens(messages[0])
+		messages = messages[1:]
+	}
+	askCfg := map[string]string{}
+	for k, v := range cfg {
+		askCfg[k] = v
+	}
+	askCfg["STREAM"] = "false"
+	if mustAtoi(askCfg["MAX_TOKENS"], 0) > maxTokens {
+		askCfg["MAX_TOKENS"] = strconv.Itoa(maxTokens)
+	}
+	request := append(append([]Message{}, messages...), Message{Role: "user", Content: instruction})
+	payload, err := buildPayload(askCfg, buildMessages(askCfg, sysPromptContent, &ConversationFile{Messages: request}))
+	if err != nil {
+		return "", err
+	}
+	req, err := newChatRequest(askCfg, payload, accessToken)
+	if err != nil {
+		return "", err
+	}
+	resp, err := sendChatRequest(askCfg, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 400 {
+		return "", newAPIError(resp, body)
+	}
+	var r struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage tokenUsage `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &r); err != nil {
+		return "", err
+	}
+	recordUsage(r.Usage)
+	if len(r.Choices) == 0 {
+		return "", nil
+	}
+	return strings.TrimSpace(r.Choices[0].Message.Content), nil
+}
+
+// offerContextTrim offers to shorten a conversation that no longer fits the model's context window
+// by archiving its oldest messages, optionally behind a summary. It reports whether the
+// conversation was shortened, so the request can be retried.
+func offerContextTrim(err error, cfg map[string]string, convFile, sysPromptContent, accessToken string) bool {
+	var overflow *nvidiachat.ErrContextLength
+	if !errors.As(err, &overflow) {
+		return false
+	}
+	cf, err := readConversation(convFile)
+	if err != nil {
+		return false
+	}
+	sent, _ := splitPending(cf.Messages)
+	if len(sent) < 2 {
+		return false
+	}
+	need, limit := 0, 0
+	if overflow.Overflow > 0 {
+		limit = overflow.Limit
+		over := overflow.Overflow
+		if overflow.Messages > 0 && over >= overflow.Messages {
+			fmt.Fprintf(os.Stderr, "The completion alone exceeds the context window; lower max_tokens (-M) instead.\n")
+			return false
+		}
+		need = over + over/10 + 64 // estimates are rough, keep a margin
+	} else {
+		for _, m := range sent {
+			need += estimateTokens(m)
+		}
+		need /= 4 // unknown overflow: drop about a quarter of the history
+	}
+
+	fmt.Fprint(os.Stderr, "Drop the oldest messages [d], summarize them [s], or keep the conversation as is [N]? ")
+	answer, _ := readSingleLine(nil, []string{"\n"}, true)
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer != "d" && answer != "s" {
+		return false
+	}
+	_, dropped := trimOldestMessages(sent, need)
+	if len(dropped) == 0 {
+		fmt.Fprintf(os.Stderr, "%sNothing left to trim; start a new conversation.%s\n", red, normal)
+		return false
+	}
+	if answer == "s" {
+		fmt.Fprintf(os.Stderr, "Summarizing %d message(s)...\n", len(dropped))
+		summary, err := summarizeMessages(cfg, sysPromptContent, withSummary(cf, dropped), accessToken, limit/2)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to summarize: %s%s\n", red, firstLine(describeError(err)), normal)
+			return false
+		}
+		cf.Summary = summary
+	}
+	archiveOldest(cf, len(dropped))
+	if err := writeConversation(convFile, cf); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to write the conversation: %v%s\n", red, err, normal)
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%sArchived %d message(s); they stay in the file but are no longer sent. Retrying...%s\n", green, len(dropped), normal)
+	return true
+}
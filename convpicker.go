@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pickerPageSize is the number of conversations the picker lists at a time.
+const pickerPageSize = 15
+
+// conversationEntry describes a saved conversation in the picker.
+type conversationEntry struct {
+	path     string
+	title    string
+	preview  string // first line of the first user message
+	messages int
+	modified time.Time
+}
+
+// listConversations returns the conversations saved in dir, most recently modified first.
+// Backups and files that are not conversations are left out.
+func listConversations(dir string) []conversationEntry {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var entries []conversationEntry
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || filepath.Ext(name) != ".json" && !isYAMLPath(name) || strings.Contains(name, ".before-summary.") || strings.Contains(name, ".before-trim.") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		cf, err := readConversation(path)
+		if err != nil || cf.Settings.Models == nil {
+			continue
+		}
+		e := conversationEntry{path: path, title: conversationTitle(path, cf), messages: len(cf.Messages), modified: fi.ModTime()}
+		for _, m := range cf.Messages {
+			if m.Role == "user" {
+				e.preview = firstLine(strings.TrimSpace(m.Content))
+				break
+			}
+		}
+		if r := []rune(e.preview); len(r) > 70 {
+			e.preview = string(r[:69]) + "…"
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modified.After(entries[j].modified) })
+	return entries
+}
+
+// pickConversation lets the user choose a saved conversation of dir by typing part of its title,
+// first message or file name to narrow the list, or a number to select an entry. It returns "" for
+// a new conversation.
+func pickConversation(dir string) string {
+	all := listConversations(dir)
+	if len(all) == 0 {
+		return ""
+	}
+	keys := make([]string, len(all))
+	for i, e := range all {
+		keys[i] = e.title + " " + e.preview + " " + filepath.Base(e.path)
+	}
+	candidates := all
+	for {
+		fmt.Fprintf(os.Stderr, "%sConversations in %s:%s\n", bold, dir, normal)
+		fmt.Fprintf(os.Stderr, "  %3d) %sNew conversation%s\n", 0, green, normal)
+		for i, e := range candidates {
+			if i == pickerPageSize {
+				fmt.Fprintf(os.Stderr, "       ... %d more, type to search\n", len(candidates)-i)
+				break
+			}
+			fmt.Fprintf(os.Stderr, "  %3d) %s  %s%s%s (%d messages)\n", i+1, e.modified.Format("2006-01-02 15:04"), bold, e.title, normal, e.messages)
+			if e.preview != "" && e.preview != e.title {
+				fmt.Fprintf(os.Stderr, "       %s\n", e.preview)
+			}
+		}
+		fmt.Fprint(os.Stderr, "Type to search, a number to open, or Enter for a new conversation: ")
+		input, err := readSingleLine(nil, []string{"\n"}, true)
+		input = strings.TrimSpace(input)
+		if input == "" || err != nil || input == "0" {
+			return ""
+		}
+		if n, err := strconv.Atoi(input); err == nil {
+			if n >= 1 && n <= len(candidates) {
+				return candidates[n-1].path
+			}
+			fmt.Fprintf(os.Stderr, "%sNo entry %d.%s\n", red, n, normal)
+			continue
+		}
+		ranked := fuzzyRank(keys, input)
+		if len(ranked) == 0 {
+			fmt.Fprintf(os.Stderr, "%sNo conversation matches %q.%s\n", red, input, normal)
+			continue
+		}
+		candidates = nil
+		for _, i := range ranked {
+			candidates = append(candidates, all[i])
+		}
+	}
+}
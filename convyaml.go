@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// isYAMLPath reports whether a conversation file is stored as YAML, which its extension decides.
+func isYAMLPath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// encodeConversation returns the content of a conversation file at path: YAML or indented JSON.
+func encodeConversation(path string, cf *ConversationFile) ([]byte, error) {
+	if isYAMLPath(path) {
+		return conversationYAML(cf)
+	}
+	return json.MarshalIndent(cf, "", "  ")
+}
+
+// decodeConversation parses the content of a conversation file at path.
+func decodeConversation(path string, data []byte) (*ConversationFile, error) {
+	if isYAMLPath(path) {
+		v, err := parseYAML(path, data)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	var cf ConversationFile
+	if err := json.Unmarshal(data, &cf); err != nil {
+		if isYAMLPath(path) {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return nil, err
+	}
+	return &cf, nil
+}
+
+// saveConversationFile writes cf to the file at path, replacing it atomically like
+// ConversationFile.Save.
+func saveConversationFile(path string, cf *ConversationFile) error {
+	if !isYAMLPath(path) {
+		return cf.Save(path)
+	}
+	b, err := conversationYAML(cf)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// yamlField is a key of a YAML mapping, kept in the order of the JSON format.
+type yamlField struct {
+	key   string
+	value interface{}
+}
+
+// conversationYAML renders a conversation as YAML. Multi-line texts, such as messages and the
+// system prompt, become literal blocks so they can be edited as they read.
+func conversationYAML(cf *ConversationFile) ([]byte, error) {
+	b, err := json.Marshal(cf)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	v, err := readOrderedJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+	var sb strings.Builder
+	writeYAMLFields(&sb, v.([]yamlField), 0, false)
+	return []byte(sb.String()), nil
+}
+
+// readOrderedJSON decodes the next JSON value, with objects as []yamlField in their order.
+func readOrderedJSON(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		fields := []yamlField{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := readOrderedJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, yamlField{key.(string), value})
+		}
+		_, err = dec.Token()
+		return fields, err
+	case json.Delim('['):
+		items := []interface{}{}
+		for dec.More() {
+			item, err := readOrderedJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		_, err = dec.Token()
+		return items, err
+	}
+	return tok, nil
+}
+
+// writeYAMLFields writes the fields of a mapping at indent. In a sequence item, the first field
+// follows the "- " of the item.
+func writeYAMLFields(sb *strings.Builder, fields []yamlField, indent int, item bool) {
+	for i, f := range fields {
+		if i == 0 && item {
+			sb.WriteString(strings.Repeat(" ", indent-2) + "- ")
+		} else {
+			sb.WriteString(strings.Repeat(" ", indent))
+		}
+		sb.WriteString(yamlString(f.key) + ":")
+		writeYAMLValue(sb, f.value, indent)
+	}
+}
+
+// writeYAMLValue writes the value following a "key:" or "-" at indent, and ends the line.
+func writeYAMLValue(sb *strings.Builder, v interface{}, indent int) {
+	switch v := v.(type) {
+	case []yamlField:
+		if len(v) == 0 {
+			sb.WriteString(" {}\n")
+			return
+		}
+		sb.WriteString("\n")
+		writeYAMLFields(sb, v, indent+2, false)
+	case []interface{}:
+		if len(v) == 0 {
+			sb.WriteString(" []\n")
+			return
+		}
+		sb.WriteString("\n")
+		for _, item := range v {
+			if fields, ok := item.([]yamlField); ok && len(fields) > 0 {
+				writeYAMLFields(sb, fields, indent+4, true)
+				continue
+			}
+			sb.WriteString(strings.Repeat(" ", indent+2) + "-")
+			writeYAMLValue(sb, item, indent+2)
+		}
+	case string:
+		if block, ok := yamlBlock(v, indent+2); ok {
+			sb.WriteString(block)
+			return
+		}
+		sb.WriteString(" " + yamlString(v) + "\n")
+	case nil:
+		sb.WriteString(" null\n")
+	default: // json.Number or bool
+		sb.WriteString(fmt.Sprintf(" %v\n", v))
+	}
+}
+
+// yamlBlock renders a multi-line string as a literal block scalar indented by indent. Strings a
+// literal block cannot hold exactly are left to be quoted.
+func yamlBlock(s string, indent int) (string, bool) {
+	if !strings.Contains(strings.TrimRight(s, "\n"), "\n") || strings.HasPrefix(s, " ") || strings.HasPrefix(s, "\n") {
+		return "", false
+	}
+	for _, r := range s {
+		if r != '\n' && r != '\t' && (r < ' ' || r == 0x7f || r == 0xfeff) {
+			return "", false
+		}
+	}
+	for _, line := range strings.Split(s, "\n") {
+		// a line of spaces would read back as an empty line
+		if line != "" && strings.TrimLeft(line, " ") == "" {
+			return "", false
+		}
+	}
+	header := " |"
+	body := s
+	switch {
+	case !strings.HasSuffix(s, "\n"):
+		header += "-"
+	case strings.HasSuffix(s, "\n\n"):
+		header += "+"
+		body = strings.TrimSuffix(s, "\n")
+	default:
+		body = strings.TrimSuffix(s, "\n")
+	}
+	var sb strings.Builder
+	sb.WriteString(header + "\n")
+	pad := strings.Repeat(" ", indent)
+	for _, line := range strings.Split(body, "\n") {
+		if line != "" {
+			sb.WriteString(pad + line)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), true
+}
+
+// yamlParser reads the subset of YAML conversation files use: block mappings and sequences,
+// plain, quoted and block scalars, empty or single-line flow collections, and comments.
+type yamlParser struct {
+	path  string
+	lines []string
+	pos   int
+}
+
+var yamlNumber = regexp.MustCompile(`^[-+]?(\d+(\.\d*)?|\.\d+)([eE][-+]?\d+)?$`)
+
+// parseYAML parses a YAML document into maps, slices, strings, json.Numbers, bools and nils.
+func parseYAML(path string, data []byte) (interface{}, error) {
+	text := strings.TrimPrefix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\ufeff")
+	p := &yamlParser{path: path, lines: strings.Split(strings.TrimSuffix(text, "\n"), "\n")}
+	indent, text, ok := p.peek()
+	if ok && text == "---" {
+		p.pos++
+		indent, _, ok = p.peek()
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s: the document is empty", path)
+	}
+	v, err := p.parseBlock(indent)
+	if err != nil {
+		return nil, err
+	}
+	if _, text, ok := p.peek(); ok && text != "..." {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return v, nil
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.path, p.pos+1, fmt.Sprintf(format, args...))
+}
+
+// peek skips blank and comment lines and returns the indentation and text of the next line.
+func (p *yamlParser) peek() (int, string, bool) {
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		text := strings.TrimLeft(line, " ")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		return len(line) - len(text), strings.TrimRight(text, " \t"), true
+	}
+	return 0, "", false
+}
+
+// parseBlock parses the mapping or sequence starting at the current line, indented by indent.
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	_, text, _ := p.peek()
+	if text == "-" || strings.HasPrefix(text, "- ") {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for {
+		n, text, ok := p.peek()
+		if !ok || n < indent || text == "..." {
+			return m, nil
+		}
+		if n > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		if text == "-" || strings.HasPrefix(text, "- ") {
+			return m, nil
+		}
+		key, rest, ok := splitYAMLKey(text)
+		if !ok {
+			return nil, p.errorf("expected key: value")
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		p.pos++
+		v, err := p.parseValue(indent, rest, true)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for {
+		n, text, ok := p.peek()
+		if !ok || n < indent || text == "..." {
+			return items, nil
+		}
+		if n > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		if text != "-" && !strings.HasPrefix(text, "- ") {
+			return items, nil
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(text, "-"), " ")
+		if _, _, isKey := splitYAMLKey(rest); isKey {
+			// a mapping starting on the item's line: parse it as if "- " were indentation
+			offset := len(text) - len(rest)
+			p.lines[p.pos] = strings.Repeat(" ", n+offset) + rest
+			v, err := p.parseMapping(n + offset)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+			continue
+		}
+		p.pos++
+		v, err := p.parseValue(indent, rest, false)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+}
+
+// parseValue parses the value of a key or sequence item at indent, of which rest is the text on
+// the same line.
+func (p *yamlParser) parseValue(indent int, rest string, inMapping bool) (interface{}, error) {
+	if rest == "" || strings.HasPrefix(rest, "#") {
+		n, text, ok := p.peek()
+		switch {
+		case ok && n > indent:
+			return p.parseBlock(n)
+		case ok && n == indent && inMapping && (text == "-" || strings.HasPrefix(text, "- ")):
+			return p.parseSequence(n)
+		}
+		return nil, nil
+	}
+	if rest[0] == '|' || rest[0] == '>' {
+		return p.parseBlockScalar(indent, rest)
+	}
+	return p.parseScalar(rest)
+}
+
+// parseBlockScalar reads the lines of a literal (|) or folded (>) block scalar.
+func (p *yamlParser) parseBlockScalar(indent int, header string) (interface{}, error) {
+	if i := strings.Index(header, " #"); i >= 0 {
+		header = strings.TrimSpace(header[:i])
+	}
+	folded, chomp, contentIndent := header[0] == '>', byte(0), 0
+	for _, c := range header[1:] {
+		switch {
+		case c == '-' || c == '+':
+			chomp = byte(c)
+		case c >= '1' && c <= '9':
+			contentIndent = indent + int(c-'0')
+		default:
+			return nil, p.errorf("invalid block scalar header %q", header)
+		}
+	}
+	var lines []string
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		text := strings.TrimLeft(line, " ")
+		if text == "" {
+			lines = append(lines, "")
+			continue
+		}
+		n := len(line) - len(text)
+		if contentIndent == 0 {
+			if n <= indent {
+				break
+			}
+			contentIndent = n
+		}
+		if n < contentIndent {
+			break
+		}
+		lines = append(lines, line[contentIndent:])
+	}
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines, trailing = lines[:len(lines)-1], trailing+1
+	}
+	var s string
+	if folded {
+		var sb strings.Builder
+		for i, line := range lines {
+			// line breaks become spaces, except around blank and more-indented lines
+			switch {
+			case i == 0, line != "" && lines[i-1] == "":
+			case line == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(lines[i-1], " "):
+				sb.WriteString("\n")
+			default:
+				sb.WriteString(" ")
+			}
+			sb.WriteString(line)
+		}
+		s = sb.String()
+	} else {
+		s = strings.Join(lines, "\n")
+	}
+	switch {
+	case len(lines) == 0:
+		if chomp == '+' {
+			s = strings.Repeat("\n", trailing)
+		}
+	case chomp == '+':
+		s += strings.Repeat("\n", trailing+1)
+	case chomp == 0:
+		s += "\n"
+	}
+	return s, nil
+}
+
+// parseScalar parses a scalar or a one-line flow collection, followed by an optional comment.
+func (p *yamlParser) parseScalar(text string) (interface{}, error) {
+	var v interface{}
+	var rest string
+	switch text[0] {
+	case '"':
+		quoted, err := strconv.QuotedPrefix(text)
+		if err != nil {
+			return nil, p.errorf("invalid double-quoted string")
+		}
+		s, _ := strconv.Unquote(quoted)
+		v, rest = s, text[len(quoted):]
+	case '\'':
+		var sb strings.Builder
+		i := 1
+		for ; i < len(text); i++ {
+			if text[i] == '\'' {
+				if i+1 < len(text) && text[i+1] == '\'' {
+					sb.WriteByte('\'')
+					i++
+					continue
+				}
+				break
+			}
+			sb.WriteByte(text[i])
+		}
+		if i >= len(text) {
+			return nil, p.errorf("unterminated single-quoted string")
+		}
+		v, rest = sb.String(), text[i+1:]
+	case '[', '{':
+		end := strings.IndexByte(text, map[byte]byte{'[': ']', '{': '}'}[text[0]])
+		if end < 0 {
+			return nil, p.errorf("flow collections must end on the same line")
+		}
+		inner := strings.TrimSpace(text[1:end])
+		if text[0] == '{' {
+			if inner != "" {
+				return nil, p.errorf("only empty flow mappings are supported")
+			}
+			v = map[string]interface{}{}
+		} else {
+			items := []interface{}{}
+			if inner != "" {
+				for _, item := range strings.Split(inner, ",") {
+					iv, err := p.parseScalar(strings.TrimSpace(item))
+					if err != nil {
+						return nil, err
+					}
+					items = append(items, iv)
+				}
+			}
+			v = items
+		}
+		rest = text[end+1:]
+	default:
+		if i := strings.Index(text, " #"); i >= 0 {
+			text = text[:i]
+		}
+		return plainYAMLScalar(strings.TrimSpace(text)), nil
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return nil, p.errorf("unexpected text after value: %s", rest)
+	}
+	return v, nil
+}
+
+// plainYAMLScalar resolves an unquoted scalar to null, a bool, a number or a string.
+func plainYAMLScalar(s string) interface{} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if yamlNumber.MatchString(s) {
+		return json.Number(strings.TrimPrefix(s, "+"))
+	}
+	return s
+}
+
+// splitYAMLKey splits "key: value" into the key and the value text. It reports false for text
+// that is not a mapping entry.
+func splitYAMLKey(text string) (key, rest string, ok bool) {
+	if text == "" {
+		return "", "", false
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		end := -1
+		if text[0] == '"' {
+			if quoted, err := strconv.QuotedPrefix(text); err == nil {
+				end = len(quoted)
+				key, _ = strconv.Unquote(quoted)
+			}
+		} else if i := strings.Index(text[1:], "'"); i >= 0 {
+			end = i + 2
+			key = text[1 : i+1]
+		}
+		if end < 0 || !strings.HasPrefix(text[end:], ":") {
+			return "", "", false
+		}
+		rest = text[end+1:]
+		if rest != "" && rest[0] != ' ' {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest), true
+	}
+	if strings.ContainsRune("[{#|>", rune(text[0])) {
+		return "", "", false
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ' ' && i+1 < len(text) && text[i+1] == '#' {
+			return "", "", false
+		}
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
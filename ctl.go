@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sessionMu serializes turns and commands of the interactive session, whether they come from the
+// terminal or from the control socket.
+var sessionMu sync.Mutex
+
+// controlListener is the control socket of the running interactive session, if any.
+var controlListener net.Listener
+
+// ctlRequest and ctlResponse are the line-delimited JSON messages of the control protocol.
+type ctlRequest struct {
+	Cmd  string `json:"cmd"`
+	Text string `json:"text,omitempty"`
+}
+
+type ctlResponse struct {
+	OK           bool   `json:"ok"`
+	Reply        string `json:"reply,omitempty"`
+	Error        string `json:"error,omitempty"`
+	Conversation string `json:"conversation,omitempty"`
+	Model        string `json:"model,omitempty"`
+}
+
+// controlSocketDir returns the directory holding the control sockets of running sessions.
+func controlSocketDir() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "nvidia-chat-"+strconv.Itoa(os.Getuid()))
+}
+
+// startControlSocket listens for control connections for the interactive session. convFile points
+// to the session's conversation file, which /rename may change.
+func startControlSocket(path string, convFile *string, cfg map[string]string) error {
+	if path == "" {
+		path = filepath.Join(controlSocketDir(), strconv.Itoa(os.Getpid())+".sock")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	os.Remove(path) // stale socket from a crashed session
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	os.Chmod(path, 0o600)
+	controlListener = l
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveControlConn(conn, convFile, cfg)
+		}
+	}()
+	return nil
+}
+
+// closeControlSocket stops listening and removes the socket file.
+func closeControlSocket() {
+	if controlListener != nil {
+		controlListener.Close()
+	}
+}
+
+func serveControlConn(conn net.Conn, convFile *string, cfg map[string]string) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req ctlRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			enc.Encode(ctlResponse{Error: "invalid request: " + err.Error()})
+			continue
+		}
+		enc.Encode(handleControlRequest(req, convFile, cfg))
+	}
+}
+
+func handleControlRequest(req ctlRequest, session *string, cfg map[string]string) ctlResponse {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	convFile := *session
+	switch req.Cmd {
+	case "status":
+		return ctlResponse{OK: true, Conversation: convFile, Model: cfg["MODEL"]}
+	case "last":
+		reply, err := lastAssistantMessage(convFile)
+		if err != nil {
+			return ctlResponse{Error: err.Error()}
+		}
+		return ctlResponse{OK: true, Reply: reply}
+	case "send":
+		if strings.TrimSpace(req.Text) == "" {
+			return ctlResponse{Error: "empty message"}
+		}
+		// the session's system prompt as it is now, after any /system edit or clear
+		if err := sendControlMessage(req.Text, convFile, cfg, sessionSystem.content); err != nil {
+			return ctlResponse{Error: describeError(err)}
+		}
+		reply, err := lastAssistantMessage(convFile)
+		if err != nil {
+			return ctlResponse{Error: err.Error()}
+		}
+		return ctlResponse{OK: true, Reply: reply}
+	}
+	return ctlResponse{Error: fmt.Sprintf("unknown command %q", req.Cmd)}
+}
+
+// errAgentConfirmation refuses control messages in agent mode: the shell commands the model
+// proposes are confirmed on stdin, which the input loop is reading at the same time.
+var errAgentConfirmation = errors.New("agent mode is on and its commands must be confirmed at the terminal: send this message there, or turn agent mode off")
+
+// sendControlMessage runs a turn for a message injected through the control socket, echoing it
+// and the reply on the terminal like a typed message. Like typed messages, it is redacted before it
+// is saved and sent, and refused when the conversation has no room left under HISTORY_LIMIT. It is
+// refused in agent mode, whose tool calls need answers at the terminal.
+func sendControlMessage(text, convFile string, cfg map[string]string, sysPromptContent string) error {
+	if findTool(shellToolName) != nil {
+		return errAgentConfirmation
+	}
+	fmt.Fprintf(os.Stderr, "\n%s %s\n", blue+"You (ctl):"+normal, text)
+	count, err := messageCount(convFile)
+	if err != nil {
+		return err
+	}
+	if limit, _ := strconv.Atoi(cfg["HISTORY_LIMIT"]); count >= limit {
+		return fmt.Errorf("%w (%d)", errHistoryLimitExceeded, limit)
+	}
+	userInput, err := withRAGContext(cfg, text)
+	if err != nil {
+		return err
+	}
+	userInput = redactOutgoing(userInput, "")
+	if err := appendMessage(convFile, "user", userInput); err != nil {
+		return fmt.Errorf("append user message: %w", err)
+	}
+	return completeOrQueue(convFile, cfg, sysPromptContent, apiKeys.Current(), os.Stdout, func() {
+		fmt.Fprintf(os.Stderr, "\n%s\n", blue+"Assistant:"+normal)
+	})
+}
+
+func lastAssistantMessage(convFile string) (string, error) {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		return "", err
+	}
+	for i := len(cf.Messages) - 1; i >= 0; i-- {
+		if cf.Messages[i].Role == "assistant" && cf.Messages[i].Content != "" {
+			return cf.Messages[i].Content, nil
+		}
+	}
+	return "", errors.New("no assistant message yet")
+}
+
+func printCtlHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat ctl [--socket PATH] <command>\n\n", bold, normal))
+	builder.WriteString("Control a running interactive session through its control socket.\n\n")
+	builder.WriteString("Commands:\n")
+	builder.WriteString("  send TEXT|-   Send a message (- reads it from stdin) and print the reply.\n")
+	builder.WriteString("  last          Print the last assistant reply.\n")
+	builder.WriteString("  status        Print the session's conversation file and model.\n")
+	builder.WriteString("  list          List the control sockets of running sessions.\n\n")
+	builder.WriteString("Without --socket, the most recently started session is used.\n")
+	fmt.Print(builder.String())
+}
+
+// listControlSockets returns the sockets of running sessions, newest first.
+func listControlSockets() []string {
+	entries, err := ioutil.ReadDir(controlSocketDir())
+	if err != nil {
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ModTime().After(entries[j].ModTime()) })
+	var paths []string
+	for _, e := range entries {
+		if e.Mode()&os.ModeSocket != 0 {
+			paths = append(paths, filepath.Join(controlSocketDir(), e.Name()))
+		}
+	}
+	return paths
+}
+
+// runCtlCommand implements the `ctl` subcommand and returns the process exit code.
+func runCtlCommand(args []string) int {
+	socket := ""
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printCtlHelp()
+			return exitOK
+		case "--socket":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%smissing value for --socket%s\n", red, normal)
+				return exitUsage
+			}
+			socket = args[i+1]
+			i++
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	if len(rest) == 0 {
+		printCtlHelp()
+		return exitUsage
+	}
+
+	var req ctlRequest
+	switch rest[0] {
+	case "list":
+		for _, path := range listControlSockets() {
+			fmt.Println(path)
+		}
+		return exitOK
+	case "send":
+		if len(rest) < 2 {
+			fmt.Fprintln(os.Stderr, "Usage: nvidia-chat ctl send TEXT|-")
+			return exitUsage
+		}
+		text := strings.Join(rest[1:], " ")
+		if text == "-" {
+			b, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+				return exitGeneral
+			}
+			text = string(b)
+		}
+		req = ctlRequest{Cmd: "send", Text: text}
+	case "last", "status":
+		req = ctlRequest{Cmd: rest[0]}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown ctl command: %s\n", rest[0])
+		printCtlHelp()
+		return exitUsage
+	}
+
+	var conn net.Conn
+	var err error
+	if socket != "" {
+		conn, err = net.Dial("unix", socket)
+	} else {
+		err = errors.New("no running session found")
+		for _, path := range listControlSockets() {
+			if conn, err = net.Dial("unix", path); err == nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sCannot connect to a session: %v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	defer conn.Close()
+
+	b, _ := json.Marshal(req)
+	if _, err := conn.Write(append(b, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	var resp ctlResponse
+	if err := json.Unmarshal(bytes.TrimSpace(line), &resp); err != nil {
+		fmt.Fprintf(os.Stderr, "%sInvalid response: %v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "%s%s%s\n", red, resp.Error, normal)
+		return exitGeneral
+	}
+	if req.Cmd == "status" {
+		fmt.Printf("conversation: %s\nmodel: %s\n", resp.Conversation, resp.Model)
+	} else {
+		fmt.Println(strings.TrimRight(resp.Reply, "\n"))
+	}
+	return exitOK
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// defaultTranscriptionModel is the speech-to-text model used when [dictate] sets none.
+const defaultTranscriptionModel = "whisper-1"
+
+// recorderCommand returns the command recording the default microphone to file: the [dictate]
+// recorder setting, in which {file} stands for the file, else arecord, sox or ffmpeg.
+func recorderCommand(file string) ([]string, error) {
+	if custom := userConfig["dictate.recorder"]; custom != "" {
+		args := strings.Fields(custom)
+		found := false
+		for i, a := range args {
+			if strings.Contains(a, "{file}") {
+				args[i], found = strings.ReplaceAll(a, "{file}", file), true
+			}
+		}
+		if !found {
+			args = append(args, file)
+		}
+		return args, nil
+	}
+	if _, err := exec.LookPath("arecord"); err == nil {
+		return []string{"arecord", "-q", "-f", "S16_LE", "-r", "16000", "-c", "1", "-t", "wav", file}, nil
+	}
+	if _, err := exec.LookPath("rec"); err == nil {
+		return []string{"rec", "-q", "-r", "16000", "-c", "1", file}, nil
+	}
+	if _, err := exec.LookPath("ffmpeg"); err == nil {
+		switch runtime.GOOS {
+		case "linux":
+			return []string{"ffmpeg", "-loglevel", "error", "-y", "-f", "pulse", "-i", "default", "-ac", "1", "-ar", "16000", file}, nil
+		case "darwin":
+			return []string{"ffmpeg", "-loglevel", "error", "-y", "-f", "avfoundation", "-i", ":0", "-ac", "1", "-ar", "16000", file}, nil
+		}
+	}
+	return nil, fmt.Errorf("no audio recorder found; install arecord (alsa-utils), sox or ffmpeg, or set recorder in the [dictate] section of %s", userConfigPath())
+}
+
+// recordAudio records the microphone to file until the user presses Enter.
+func recordAudio(file string) error {
+	args, err := recorderCommand(file)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start %s: %w", args[0], err)
+	}
+	fmt.Fprintf(os.Stderr, "%sRecording...%s press Enter to stop.\n", red, normal)
+	readSingleLine(nil, []string{"\n"}, true)
+	// the recorders finish the file when interrupted; Windows cannot send the signal
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		cmd.Process.Kill()
+	}
+	err = cmd.Wait()
+	if info, statErr := os.Stat(file); statErr != nil || info.Size() <= 44 {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %s", args[0], firstLine(msg))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", args[0], err)
+		}
+		return fmt.Errorf("nothing was recorded")
+	}
+	return nil
+}
+
+// transcriptionURL returns the speech-to-text endpoint: the [dictate] url setting, else the
+// OpenAI-compatible /audio/transcriptions endpoint next to the chat API.
+func transcriptionURL(cfg map[string]string) string {
+	if url := userConfig["dictate.url"]; url != "" {
+		return url
+	}
+	return strings.TrimRight(resolveBaseURL(cfg), "/") + "/audio/transcriptions"
+}
+
+// transcribeAudio sends an audio file to the speech-to-text endpoint and returns the text.
+func transcribeAudio(cfg map[string]string, file string) (string, error) {
+	audio, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filepath.Base(file))
+	if err != nil {
+		return "", err
+	}
+	part.Write(audio)
+	model := userConfig["dictate.model"]
+	if model == "" {
+		model = defaultTranscriptionModel
+	}
+	form.WriteField("model", model)
+	if lang := userConfig["dictate.language"]; lang != "" {
+		form.WriteField("language", lang)
+	}
+	form.WriteField("response_format", "json")
+	form.Close()
+
+	req, err := http.NewRequest(http.MethodPost, transcriptionURL(cfg), &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	key := apiKeys.Current()
+	if env := userConfig["dictate.api_key_env"]; env != "" {
+		key = os.Getenv(env)
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := newHTTPClient(cfg).Do(asAPIRequest(req))
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return "", newAPIError(resp, data)
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", fmt.Errorf("unexpected transcription response: %v", err)
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// dictateMessage implements /dictate: it records the microphone, transcribes the recording and,
+// once the user confirms or corrects the text, queues it as the next message.
+func dictateMessage(cfg map[string]string) {
+	tmp, err := ioutil.TempFile("", "nvidia-chat-dictate-*.wav")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to create the audio file: %v%s\n", red, err, normal)
+		return
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := recordAudio(tmp.Name()); err != nil {
+		fmt.Fprintf(os.Stderr, "%sRecording failed: %v%s\n", red, err, normal)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Transcribing...")
+	text, err := transcribeAudio(cfg, tmp.Name())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sTranscription failed: %s%s\n", red, firstLine(describeError(err)), normal)
+		return
+	}
+	if text == "" {
+		fmt.Fprintln(os.Stderr, "No speech was recognized.")
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "\n%s\n%s\n\n", blue+"Transcription:"+normal, text)
+	fmt.Fprint(os.Stderr, "Send it [Y], edit it [e] or discard it [n]? ")
+	answer, _ := readSingleLine(nil, []string{"\n"}, true)
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "", "y", "yes":
+	case "e":
+		fmt.Fprint(os.Stderr, "Message: ")
+		edited, _ := readSingleLine(nil, []string{"\n"}, true)
+		if text = strings.TrimSpace(edited); text == "" {
+			fmt.Fprintln(os.Stderr, "Discarded.")
+			return
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "Discarded.")
+		return
+	}
+	queuedInput = text
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// lastUserMessage returns the text of the conversation's last user message, or "".
+func lastUserMessage(cf *ConversationFile) string {
+	for i := len(cf.Messages) - 1; i >= 0; i-- {
+		if cf.Messages[i].Role == "user" {
+			return cf.Messages[i].Content
+		}
+	}
+	return ""
+}
+
+// confirmResend guards against double submits, such as a second Ctrl+D or a terminal repeating
+// its input: when userInput repeats the last user message, it asks whether to send it again and
+// reports whether to go on.
+func confirmResend(convFile, userInput string) bool {
+	cf, err := readConversation(convFile)
+	if err != nil || strings.TrimSpace(lastUserMessage(cf)) != strings.TrimSpace(userInput) {
+		return true
+	}
+	fmt.Fprintf(os.Stderr, "%sThis is the same message as your last one.%s Send it again? [y/N] ", red, normal)
+	answer, _ := readSingleLine(nil, []string{"\n"}, true)
+	if strings.EqualFold(strings.TrimSpace(answer), "y") {
+		return true
+	}
+	fmt.Fprintln(os.Stderr, "Skipped.")
+	return false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
+)
+
+// Exit codes returned by the program so scripts can branch on the failure type.
+const (
+	exitOK           = 0
+	exitGeneral      = 1   // unexpected failure (I/O, corrupted files, ...)
+	exitUsage        = 2   // invalid flags, arguments or setting values
+	exitAuth         = 3   // missing or rejected API key
+	exitAPI          = 4   // the API returned an error response
+	exitRateLimit    = 5   // the API rejected the request with 429 Too Many Requests
+	exitContextLimit = 6   // conversation message limit or model context length reached
+	exitNetwork      = 7   // the API could not be reached
+	exitBudget       = 8   // a token or spend budget would be exceeded
+	exitUserAbort    = 130 // interrupted by the user (Ctrl+C)
+)
+
+// errHistoryLimitExceeded is returned when a conversation holds more messages than HISTORY_LIMIT.
+var errHistoryLimitExceeded = errors.New("conversation message limit exceeded")
+
+// apiError is returned when the API responds with an HTTP error status. It wraps the typed
+// reasons of the nvidiachat package: ErrAuth, ErrModelNotFound, *ErrRateLimited, *ErrContextLength.
+type apiError = nvidiachat.APIError
+
+// newAPIError returns the error for an HTTP error response whose body was read.
+var newAPIError = nvidiachat.NewAPIError
+
+// describeError returns a user-facing message for an error returned while calling the API.
+// Rejected keys get a targeted hint instead of the raw response body.
+func describeError(err error) string {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return err.Error()
+	}
+	var rateLimited *nvidiachat.ErrRateLimited
+	var contextLength *nvidiachat.ErrContextLength
+	switch {
+	case errors.Is(err, nvidiachat.ErrAuth):
+		reason := fmt.Sprintf("The API rejected your key (%s).", apiErr.Status)
+		if strings.Contains(strings.ToLower(apiErr.Body), "expired") {
+			reason = fmt.Sprintf("Your API key has expired (%s).", apiErr.Status)
+		}
+		return reason + " Run `nvidia-chat auth login` to store a new key, or pass one with -k."
+	case errors.As(err, &rateLimited):
+		if rateLimited.RetryAfter > 0 {
+			return fmt.Sprintf("The API is rate limiting your requests (%s); retry in %s, or set --max-retries to wait automatically.", apiErr.Status, rateLimited.RetryAfter)
+		}
+		return fmt.Sprintf("The API is rate limiting your requests (%s); retry later, or set --max-retries to wait automatically.", apiErr.Status)
+	case errors.As(err, &contextLength) && contextLength.Overflow > 0:
+		return fmt.Sprintf("The request is %d tokens over the model's context window (%d requested, %d allowed). Trim older messages or start a new conversation.", contextLength.Overflow, contextLength.Requested, contextLength.Limit)
+	case errors.Is(err, nvidiachat.ErrModelNotFound):
+		return fmt.Sprintf("The API does not serve this model (%s); it may be misspelled or retired. Run with -l to list models.", apiErr.Status)
+	}
+	return fmt.Sprintf("API error: %s\n%s", apiErr.Status, apiErr.Body)
+}
+
+// isUnknownModelError reports whether the API rejected the request because it does not serve
+// model: the error is ErrModelNotFound, or a 404 or 410 whose body names the model. Any other 404,
+// such as one for a wrong base URL, is not about the model.
+func isUnknownModelError(err error, model string) bool {
+	if errors.Is(err, nvidiachat.ErrModelNotFound) {
+		return true
+	}
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 404 && apiErr.StatusCode != 410 {
+		return false
+	}
+	return model != "" && strings.Contains(apiErr.Body, model)
+}
+
+// exitCodeFor maps an error returned while talking to the API to one of the exit codes above.
+func exitCodeFor(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if errors.Is(err, errHistoryLimitExceeded) {
+		return exitContextLimit
+	}
+	if errors.Is(err, errBudgetExceeded) {
+		return exitBudget
+	}
+	if errors.Is(err, errInterrupted) {
+		return exitUserAbort
+	}
+	var rateLimited *nvidiachat.ErrRateLimited
+	var contextLength *nvidiachat.ErrContextLength
+	var apiErr *apiError
+	switch {
+	case errors.Is(err, nvidiachat.ErrAuth):
+		return exitAuth
+	case errors.As(err, &rateLimited):
+		return exitRateLimit
+	case errors.As(err, &contextLength):
+		return exitContextLimit
+	case errors.As(err, &apiErr):
+		return exitAPI
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return exitNetwork
+	}
+	return exitGeneral
+}
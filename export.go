@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// parseMessageRange parses "from..to" with 1-based inclusive message numbers. Either end may be
+// omitted: "3.." runs to the last message and "..5" starts at the first.
+func parseMessageRange(spec string, total int) (int, int, error) {
+	parts := strings.SplitN(spec, "..", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid range %q, expected FROM..TO", spec)
+	}
+	from, to := 1, total
+	var err error
+	if parts[0] != "" {
+		if from, err = strconv.Atoi(parts[0]); err != nil {
+			return 0, 0, fmt.Errorf("invalid range start %q", parts[0])
+		}
+	}
+	if parts[1] != "" {
+		if to, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, fmt.Errorf("invalid range end %q", parts[1])
+		}
+	}
+	if from < 1 || to > total || from > to {
+		return 0, 0, fmt.Errorf("range %d..%d is outside the conversation's %d message(s)", from, to, total)
+	}
+	return from, to, nil
+}
+
+// roleHeading returns the markdown heading used for a message in exports.
+func roleHeading(m Message) string {
+	if m.Persona != "" {
+		return m.Persona
+	}
+	switch m.Role {
+	case "user":
+		return "User"
+	case "assistant":
+		return "Assistant"
+	case "tool":
+		return "Tool result"
+	}
+	return m.Role
+}
+
+// formatTranscript renders messages as markdown with a heading per message.
+func formatTranscript(messages []Message, firstNumber int, filterThinking bool) string {
+	var builder strings.Builder
+	for i, m := range messages {
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+		heading := roleHeading(m)
+		if m.Incomplete {
+			heading += ", incomplete"
+		}
+		if m.Comparison != nil {
+			heading += ", " + m.Comparison.Kept
+		}
+		builder.WriteString(fmt.Sprintf("## %s (#%d)\n\n", heading, firstNumber+i))
+		content := m.Content
+		if filterThinking && m.Role == "assistant" {
+			content = filterThinkingBlock(content)
+		}
+		if content != "" {
+			builder.WriteString(strings.TrimRight(content, "\n") + "\n")
+		}
+		for _, call := range m.ToolCalls {
+			builder.WriteString(fmt.Sprintf("\n*Tool call:* `%s %s`\n", call.Function.Name, call.Function.Arguments))
+		}
+	}
+	return builder.String()
+}
+
+// exportRange writes messages from..to (1-based, inclusive, both roles) as markdown.
+func exportRange(convFile, spec, targetFile string, filterThinking bool) error {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		return fmt.Errorf("reading conversation file: %w", err)
+	}
+	if len(cf.Messages) == 0 {
+		return fmt.Errorf("the conversation has no messages")
+	}
+	from, to, err := parseMessageRange(spec, len(cf.Messages))
+	if err != nil {
+		return err
+	}
+	content := formatTranscript(cf.Messages[from-1:to], from, filterThinking)
+	return ioutil.WriteFile(targetFile, []byte(content), 0o644)
+}
+
+// exportOptions are the flags shared by the export commands.
+type exportOptions struct {
+	filterThinking bool     // -t
+	frontMatter    bool     // -f, or implied by --tags
+	tags           []string // --tags a,b
+}
+
+// parseExportFlags removes -t, -f and --tags LIST from a command line and returns the rest.
+func parseExportFlags(parts []string) (exportOptions, []string) {
+	var opts exportOptions
+	newParts := []string{parts[0]}
+	for i := 1; i < len(parts); i++ {
+		switch p := parts[i]; {
+		case p == "-t":
+			opts.filterThinking = true
+		case p == "-f":
+			opts.frontMatter = true
+		case p == "--tags" && i+1 < len(parts):
+			i++
+			opts.tags = append(opts.tags, strings.Split(parts[i], ",")...)
+			opts.frontMatter = true
+		case strings.HasPrefix(p, "--tags="):
+			opts.tags = append(opts.tags, strings.Split(strings.TrimPrefix(p, "--tags="), ",")...)
+			opts.frontMatter = true
+		default:
+			newParts = append(newParts, p)
+		}
+	}
+	return opts, newParts
+}
+
+// yamlString quotes s when it would not survive as a plain YAML scalar.
+func yamlString(s string) string {
+	if s == "" || strings.ContainsAny(s, ":#{}[],&*!|>'\"%@`\n") || strings.TrimSpace(s) != s || strings.IndexFunc(s, unicode.IsControl) >= 0 {
+		return strconv.Quote(s)
+	}
+	if s == "-" || strings.HasPrefix(s, "- ") || strings.HasPrefix(s, "? ") {
+		return strconv.Quote(s)
+	}
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "null", "~":
+		return strconv.Quote(s)
+	}
+	// numbers would be read back as numbers
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// frontMatter builds a YAML front matter block describing the conversation: model, export date,
+// the model's settings, tags and the recorded token usage.
+func frontMatter(convFile string, cfg map[string]string, tags []string) (string, error) {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		return "", fmt.Errorf("reading conversation file: %w", err)
+	}
+	model := cfg["MODEL"]
+	if model == "" {
+		model = cf.Settings.Model
+	}
+
+	var builder strings.Builder
+	builder.WriteString("---\n")
+	builder.WriteString("title: " + yamlString(conversationTitle(convFile, cf)) + "\n")
+	builder.WriteString("model: " + yamlString(model) + "\n")
+	builder.WriteString("date: " + time.Now().Format(time.RFC3339) + "\n")
+	builder.WriteString("conversation: " + yamlString(convFile) + "\n")
+
+	var names []string
+	for name := range GetModelDefinition(model).Parameters {
+		if cfg[strings.ToUpper(name)] != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		builder.WriteString("settings:\n")
+		for _, name := range names {
+			builder.WriteString(fmt.Sprintf("  %s: %s\n", name, yamlString(cfg[strings.ToUpper(name)])))
+		}
+	}
+
+	builder.WriteString("tags:\n  - nvidia-chat\n")
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" && tag != "nvidia-chat" {
+			builder.WriteString("  - " + yamlString(tag) + "\n")
+		}
+	}
+
+	if cf.Usage != nil {
+		builder.WriteString("usage:\n")
+		builder.WriteString(fmt.Sprintf("  prompt_tokens: %d\n", cf.Usage.PromptTokens))
+		builder.WriteString(fmt.Sprintf("  completion_tokens: %d\n", cf.Usage.CompletionTokens))
+	}
+	builder.WriteString("---\n\n")
+	return builder.String(), nil
+}
+
+// prependFrontMatter adds the conversation's front matter to an exported file.
+func prependFrontMatter(targetFile, convFile string, cfg map[string]string, tags []string) error {
+	header, err := frontMatter(convFile, cfg, tags)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadFile(targetFile)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(targetFile, append([]byte(header), body...), 0o644)
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// exportCurlScript writes a shell script with one curl command per request of the conversation:
+// each assistant reply is reproduced by sending the messages that preceded it with the current
+// settings and system prompt: sysPromptContent, or else the conversation's. The API key is read
+// from NVIDIA_BUILD_AI_ACCESS_TOKEN when the script runs.
+func exportCurlScript(convFile, targetFile string, cfg map[string]string, sysPromptContent string) error {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		return fmt.Errorf("reading conversation file: %w", err)
+	}
+	var replies []int
+	for i, m := range cf.Messages {
+		if m.Role == "assistant" && i > 0 {
+			replies = append(replies, i)
+		}
+	}
+	if len(replies) == 0 {
+		return fmt.Errorf("no assistant responses found")
+	}
+
+	var builder strings.Builder
+	builder.WriteString("#!/bin/sh\n")
+	builder.WriteString(fmt.Sprintf("# Requests of %s, exported by nvidia-chat on %s.\n", convFile, time.Now().Format(time.RFC3339)))
+	builder.WriteString("# Each request uses the settings of the exporting session.\n")
+	builder.WriteString("set -e\n")
+	builder.WriteString(`: "${NVIDIA_BUILD_AI_ACCESS_TOKEN:?set NVIDIA_BUILD_AI_ACCESS_TOKEN to your API key}"` + "\n")
+	url := resolveBaseURL(cfg) + "/chat/completions"
+	for n, i := range replies {
+		history := *cf
+		history.Messages = cf.Messages[:i]
+		payload, err := buildPayload(cfg, buildMessages(cfg, sysPromptContent, &history))
+		if err != nil {
+			return fmt.Errorf("build payload: %w", err)
+		}
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, payload, "", "  "); err != nil {
+			return err
+		}
+		builder.WriteString(fmt.Sprintf("\n# Request %d of %d: reply #%d\n", n+1, len(replies), i+1))
+		builder.WriteString("curl -sS " + shellQuote(url))
+		if cfg["STREAM"] == "true" {
+			builder.WriteString(" -N")
+		}
+		builder.WriteString(" \\\n")
+		builder.WriteString("  -H \"Authorization: Bearer $NVIDIA_BUILD_AI_ACCESS_TOKEN\" \\\n")
+		builder.WriteString("  -H 'Content-Type: application/json' \\\n")
+		builder.WriteString("  --data-binary @- <<'PAYLOAD'\n")
+		pretty.WriteString("\nPAYLOAD\necho\n")
+		builder.Write(pretty.Bytes())
+	}
+	return ioutil.WriteFile(targetFile, []byte(builder.String()), 0o755)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// orgTimestamp formats t as an inactive Org timestamp, e.g. [2024-05-01 Wed 14:03].
+func orgTimestamp(t time.Time) string {
+	return t.Format("[2006-01-02 Mon 15:04]")
+}
+
+// orgTag turns a tag into one Org accepts: letters, digits, _, @, # and %.
+func orgTag(tag string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '@' || r == '#' || r == '%' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 127 {
+			return r
+		}
+		return '_'
+	}, tag)
+}
+
+var (
+	orgInlineCode = regexp.MustCompile("`([^`\n]+)`")
+	orgBold       = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+	orgHeading    = regexp.MustCompile(`^#{1,6}\s+(.*)$`)
+	orgBullet     = regexp.MustCompile(`^(\s*)[*+]\s`)
+	orgThinking   = regexp.MustCompile(`(?s)\[Begin of Assistant Reasoning\]\s*(.*?)\s*\[/End of Assistant Reasoning\]\s*`)
+)
+
+// markdownToOrg converts the markdown of a message to Org: fenced code blocks become BEGIN_SRC
+// blocks, headings bold lines, * bullets - bullets, and bold and inline code their Org markup.
+// Lines that Org would read as headlines or keywords are escaped with a comma.
+func markdownToOrg(text string) string {
+	var builder strings.Builder
+	inCode := false
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			if inCode {
+				builder.WriteString("#+END_SRC\n")
+			} else if lang := strings.Fields(strings.TrimPrefix(trimmed, "```")); len(lang) > 0 {
+				builder.WriteString("#+BEGIN_SRC " + lang[0] + "\n")
+			} else {
+				builder.WriteString("#+BEGIN_SRC\n")
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			if strings.HasPrefix(line, "*") || strings.HasPrefix(line, "#+") {
+				line = "," + line
+			}
+			builder.WriteString(line + "\n")
+			continue
+		}
+		if m := orgHeading.FindStringSubmatch(line); m != nil {
+			line = "*" + strings.Trim(m[1], "*") + "*"
+		} else {
+			line = orgBullet.ReplaceAllString(line, "$1- ")
+		}
+		line = orgBold.ReplaceAllString(line, "*$1*")
+		line = orgInlineCode.ReplaceAllString(line, "~$1~")
+		if strings.HasPrefix(line, "* ") || strings.HasPrefix(line, "#+") || strings.HasPrefix(line, ":") && strings.HasSuffix(line, ":") {
+			line = "," + line
+		}
+		builder.WriteString(line + "\n")
+	}
+	if inCode { // an unterminated fence, as in a cut-off response
+		builder.WriteString("#+END_SRC\n")
+	}
+	return builder.String()
+}
+
+// orgHeadline returns the headline of an exchange: the start of its first message, on one line and
+// without markup.
+func orgHeadline(m Message) string {
+	text := strings.Join(strings.Fields(filterThinkingBlock(m.Content)), " ")
+	if text == "" {
+		text = roleHeading(m)
+	}
+	if r := []rune(text); len(r) > 60 {
+		text = string(r[:57]) + "..."
+	}
+	return strings.NewReplacer("[", "(", "]", ")", "**", "", "`", "").Replace(text)
+}
+
+// formatOrgMessage renders one message as a second-level headline with its content. The reasoning
+// of an assistant message goes to a REASONING drawer, which Emacs folds, unless filterThinking.
+func formatOrgMessage(builder *strings.Builder, m Message, number int, filterThinking bool) {
+	heading := roleHeading(m)
+	if m.Incomplete {
+		heading += ", incomplete"
+	}
+	if m.Comparison != nil {
+		heading += ", " + m.Comparison.Kept
+	}
+	builder.WriteString(fmt.Sprintf("** %s (#%d)\n", heading, number))
+	var props []string
+	if m.Model != "" {
+		props = append(props, ":MODEL: "+m.Model)
+	}
+	if m.Time != nil {
+		props = append(props, ":TIME: "+orgTimestamp(m.Time.Local()))
+	}
+	if m.Usage != nil {
+		props = append(props, fmt.Sprintf(":PROMPT_TOKENS: %d", m.Usage.PromptTokens), fmt.Sprintf(":COMPLETION_TOKENS: %d", m.Usage.CompletionTokens))
+	}
+	if m.Archived {
+		props = append(props, ":ARCHIVED: t")
+	}
+	if m.Bookmark != nil {
+		props = append(props, strings.TrimSpace(":BOOKMARK: "+m.Bookmark.Note))
+	}
+	if len(props) > 0 {
+		builder.WriteString(":PROPERTIES:\n" + strings.Join(props, "\n") + "\n:END:\n")
+	}
+
+	content := m.Content
+	if m.Role == "assistant" {
+		if thinking := orgThinking.FindStringSubmatch(content); thinking != nil {
+			content = strings.Replace(content, thinking[0], "", 1)
+			if !filterThinking && thinking[1] != "" {
+				builder.WriteString(":REASONING:\n" + markdownToOrg(thinking[1]) + ":END:\n")
+			}
+		}
+	}
+	if strings.TrimSpace(content) != "" {
+		builder.WriteString(markdownToOrg(content))
+	}
+	for _, call := range m.ToolCalls {
+		builder.WriteString(fmt.Sprintf("Tool call: ~%s %s~\n", call.Function.Name, call.Function.Arguments))
+	}
+}
+
+// exportOrg writes the whole conversation as an Org-mode document: a properties drawer with the
+// conversation's metadata, the title and tags as file keywords, and a headline per exchange (a
+// user message and the replies to it) with a subheadline per message.
+func exportOrg(convFile, targetFile string, cfg map[string]string, filterThinking bool) error {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		return fmt.Errorf("reading conversation file: %w", err)
+	}
+	if len(cf.Messages) == 0 {
+		return fmt.Errorf("the conversation has no messages")
+	}
+	model := cfg["MODEL"]
+	if cf.Settings.Model != "" {
+		model = cf.Settings.Model
+	}
+
+	// The file's property drawer comes first, as Org only reads it there.
+	var builder strings.Builder
+	builder.WriteString(":PROPERTIES:\n")
+	builder.WriteString(":CONVERSATION: " + convFile + "\n")
+	builder.WriteString(":MODEL: " + model + "\n")
+	if cf.Usage != nil {
+		builder.WriteString(fmt.Sprintf(":PROMPT_TOKENS: %d\n:COMPLETION_TOKENS: %d\n", cf.Usage.PromptTokens, cf.Usage.CompletionTokens))
+	}
+	if cf.Previous != "" {
+		builder.WriteString(":PREVIOUS: " + cf.Previous + "\n")
+	}
+	builder.WriteString(":END:\n")
+	builder.WriteString("#+TITLE: " + conversationTitle(convFile, cf) + "\n")
+	builder.WriteString("#+DATE: " + orgTimestamp(time.Now()) + "\n")
+	tags := []string{"nvidia_chat"}
+	for _, tag := range cf.Tags {
+		tags = append(tags, orgTag(tag))
+	}
+	builder.WriteString("#+FILETAGS: :" + strings.Join(tags, ":") + ":\n")
+	if cf.Summary != "" {
+		builder.WriteString("\n* Summary of the earlier conversation\n" + markdownToOrg(cf.Summary))
+	}
+
+	for i, m := range cf.Messages {
+		if i == 0 || m.Role == "user" {
+			builder.WriteString("\n* " + orgHeadline(m) + "\n")
+		}
+		formatOrgMessage(&builder, m, i+1, filterThinking)
+	}
+	return ioutil.WriteFile(targetFile, []byte(builder.String()), 0o644)
+}
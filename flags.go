@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// flagSpec describes an option of the main command. The table drives argument normalization
+// and the General Options section of the help; options without help text are documented with
+// the model settings instead.
+type flagSpec struct {
+	short, long string // either may be empty
+	arg         string // value placeholder, empty for switches
+	help        string
+}
+
+// mainFlagSpecs returns the options of the main command in help order.
+func mainFlagSpecs() []flagSpec {
+	return []flagSpec{
+		{"-m", "--model", "NAME", fmt.Sprintf("Model ID to use (default: %s)", defaultModel)},
+		{"-s", "--sys-prompt-file", "PATH|URL", "Path or https URL of a system prompt text file (content used for this run). Repeatable."},
+		{"", "--system-text", "TEXT", "Add TEXT to the system prompt (repeatable)."},
+		{"", "--system-url", "URL", "Add the text downloaded from URL to the system prompt (repeatable).\n-s, --system-text and --system-url are joined in the order given."},
+		{"", "--dev-prompt-file", "PATH|URL", "Send the file's instructions as a developer message after the system prompt (repeatable).\nModels without the developer role receive them as a system message."},
+		{"", "--allow-http-system-url", "", "Allow system prompts to be downloaded over plain http."},
+		{"-S", "", "", "Persist the composed system prompt into the conversation file's 'system' field."},
+		{"", "--save-settings", "", "Persist current model settings into the conversation file."},
+		{"", "--pick", "", "Without CONVERSATION_FILE, choose a saved conversation to continue from a searchable list."},
+		{"", "--new", "", "Start a new conversation even if [conversations] picker is enabled in the config file."},
+		{"", "--ephemeral", "", "Keep a new conversation in memory only, without a file; /save FILE writes it."},
+		{"", "--stdio-json", "", "Read JSON requests on stdin and write JSON events on stdout, one per line, for programs driving the chat."},
+		{"", "--read-only", "", "Use CONVERSATION_FILE as context without saving new messages or changes to it."},
+		{"-k", "--access-token", "KEY", "Provide API key (overrides the OS keyring and environment variables).\nRepeat to configure several keys for automatic failover."},
+		{"", "--profile", "NAME", "Use the API keys stored in the OS keyring under NAME (default: default)."},
+		{"", "--prompt", "TEXT|FILE|-", "Non-interactive mode: provide a prompt and print the response."},
+		{"", "--file", "PATH", "Attach a text file to the prompt (repeatable)."},
+		{"", "--git-diff", "", "Attach the output of git diff in the current directory to the prompt (the first message in interactive mode)."},
+		{"", "--staged", "", "With --git-diff, attach the staged changes (git diff --staged)."},
+		{"", "--template", "NAME|PATH", "Render a Go text/template as the prompt (non-interactive)."},
+		{"", "--var", "KEY=VALUE", "Template variable (repeatable). Use KEY=@file to read the value from a file."},
+		{"", "--output", "FILE|-", "With --prompt, write the response to FILE instead of stdout."},
+		{"", "--append", "", "With --output, append to FILE instead of overwriting it."},
+		{"", "--raw", "", "With --prompt, print the API response exactly as received (server-sent events or JSON)."},
+		{"", "--json-stream", "", "With --prompt, write the response as JSON events on stdout, one per line: reasoning, delta, usage, then done or error."},
+		{"", "--tee", "FILE", "Append the streamed responses, without colors, to FILE as they arrive."},
+		{"", "--base-url", "URL", fmt.Sprintf("API base URL for all models (default: model endpoint override or %s).", defaultBaseURL)},
+		{"", "--timeout", "DURATION", "Overall timeout per API request, e.g. 90s or 2m (default: none)."},
+		{"", "--connect-timeout", "DURATION", fmt.Sprintf("Timeout for connecting to the API (default: %ss).", defaultConnectTimeout)},
+		{"", "--idle-timeout", "DURATION", "Abort a response when no data arrives for this long (default: none)."},
+		{"", "--max-retries", "N", "Retry failed requests (network errors, 429, 5xx) up to N times (default: 0)."},
+		{"", "--dry-run", "", "Print the request (URL, headers, payload) instead of sending it."},
+		{"", "--record", "DIR", "Save every API request and its raw response in DIR."},
+		{"", "--header", "\"NAME: VALUE\"", "Add a header to every API request, e.g. for a gateway (repeatable)."},
+		{"", "--organization", "ID", "Send ID as the OpenAI-Organization header, for gateways accounting usage per organization."},
+		{"", "--project", "ID", "Send ID as the OpenAI-Project header, for gateways accounting usage per project."},
+		{"", "--log-file", "FILE", "Log every API request, with its timing, status, model and token counts, to FILE as JSON lines."},
+		{"", "--log-level", "LEVEL", "Least level of the API log entries: debug, info (default), warn or error."},
+		{"", "--log-max-size", "SIZE", "Size after which the API log is rotated, e.g. 10MB (default)."},
+		{"", "--record-session", "FILE", "Record the interactive session, with its timing, to FILE in the asciinema format (.cast)."},
+		{"", "--replay", "DIR", "Answer API requests from the responses saved by --record in DIR, offline."},
+		{"", "--cache", "", "With --prompt and no conversation file, reuse the stored reply of an identical request."},
+		{"", "--tool-choice", "MODE", "With tools, auto (default), none, required, or the name of a tool the model must call. Recorded in the conversation file."},
+		{"", "--parallel-tool-calls", "", "With tools, allow (true, the default when given) or forbid (false) several tool calls in one response. Recorded in the conversation file."},
+		{"", "--extra-body", "JSON", "JSON object deep-merged into each request payload, or @file (e.g. '{\"nvext\":{\"top_k\":40}}')."},
+		{"", "--cache-ttl", "DURATION", "Age after which cached replies are ignored (default: " + defaultCacheTTL + ", 0 = never)."},
+		{"", "--no-cache", "", "Bypass the cache even if the config file enables it."},
+		{"-u", "--unbuffered", "", "Write each streamed token immediately instead of buffering output by line."},
+		{"", "--mcp-config", "FILE", "MCP servers whose tools the model may call (default: " + mcpConfigPath() + " if present)."},
+		{"", "--no-mcp", "", "Do not start any MCP servers."},
+		{"", "--no-status-line", "", "Do not keep a status line (model, temperature, tokens, state) at the bottom of the terminal."},
+		{"", "--no-project", "", "Ignore the .nvidia-chat.json or .nvidia-chat.yaml project configuration."},
+		{"", "--agent", "", "Let the model propose shell commands, which run after your confirmation."},
+		{"", "--control-socket", "PATH", "Control socket of the interactive session (default: one per session, see nvidia-chat ctl)."},
+		{"", "--no-control-socket", "", "Do not open a control socket."},
+		{"", "--rag", "INDEX", "Add the most relevant chunks of an index (see nvidia-chat index --help) to each prompt."},
+		{"", "--rag-top-k", "N", fmt.Sprintf("Number of chunks retrieved per prompt (default: %d).", defaultRAGTopK)},
+		{"-l", "--list", "", "List supported models and exit."},
+		{"", "--filter", "TAGS", "With -l, only list models with these capabilities (vision, tools, reasoning, code)\nand, given a number like 128k, at least that context window."},
+		{"", "--modelinfo", "NAME", "Show detailed settings for a specific model and exit."},
+		{"-h", "--help", "", "Show this help."},
+
+		// model settings, listed under Model Setting Options
+		{"-T", "--temperature", "VALUE", ""},
+		{"-P", "--top-p", "VALUE", ""},
+		{"-f", "--frequency-penalty", "VALUE", ""},
+		{"-r", "--presence-penalty", "VALUE", ""},
+		{"-M", "--max-tokens", "VALUE", ""},
+		{"-L", "--limit", "VALUE", ""},
+		{"", "--reasoning", "VALUE", ""},
+		{"", "--stop", "VALUE", ""},
+		{"", "--stream", "", ""}, // optionally followed by true or false
+		{"", "--no-stream", "", ""},
+	}
+}
+
+// modelSettingFlags returns the --<setting> options generated from the model definitions, such
+// as --seed or --reasoning-effort, plus --history-limit.
+func modelSettingFlags() map[string]bool {
+	flags := map[string]bool{"--history-limit": true}
+	for _, def := range ModelDefinitions {
+		for name := range def.Parameters {
+			flags["--"+strings.ReplaceAll(name, "_", "-")] = true
+		}
+	}
+	return flags
+}
+
+// isIntMapSetting reports whether a model defines the setting key (e.g. LOGIT_BIAS) as an IntMap,
+// whose flag may be repeated.
+func isIntMapSetting(key string) bool {
+	for _, def := range ModelDefinitions {
+		if p, ok := def.Parameters[strings.ToLower(key)]; ok && p.Type == IntMap {
+			return true
+		}
+	}
+	return false
+}
+
+// formatFlagHelp renders the options that have help text in the two-column layout of the help.
+func formatFlagHelp(specs []flagSpec) string {
+	var b strings.Builder
+	for _, s := range specs {
+		if s.help == "" {
+			continue
+		}
+		names := strings.Trim(s.short+", "+s.long, ", ")
+		if s.arg != "" {
+			names += " " + s.arg
+		}
+		lines := strings.Split(s.help, "\n")
+		if len(names) < 22 {
+			b.WriteString(fmt.Sprintf("  %-22s%s\n", names, lines[0]))
+		} else {
+			b.WriteString(fmt.Sprintf("  %s\n%24s%s\n", names, "", lines[0]))
+		}
+		for _, line := range lines[1:] {
+			b.WriteString(fmt.Sprintf("%24s%s\n", "", line))
+		}
+	}
+	return b.String()
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// unknownFlagError explains an unknown long option: abbreviations are not accepted, so a prefix
+// of known options lists them, and a near miss suggests the closest one.
+func unknownFlagError(name string, known []string) error {
+	var prefixed []string
+	for _, k := range known {
+		if strings.HasPrefix(k, name) {
+			prefixed = append(prefixed, k)
+		}
+	}
+	sort.Strings(prefixed)
+	switch {
+	case len(prefixed) == 1:
+		return fmt.Errorf("unknown option %s (options cannot be abbreviated); did you mean %s?", name, prefixed[0])
+	case len(prefixed) > 1:
+		return fmt.Errorf("unknown option %s (options cannot be abbreviated); it could be any of %s", name, strings.Join(prefixed, ", "))
+	}
+	best, bestDist := "", 3
+	for _, k := range known {
+		if d := editDistance(name, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	if best != "" {
+		return fmt.Errorf("unknown option %s; did you mean %s?", name, best)
+	}
+	return fmt.Errorf("unknown option %s", name)
+}
+
+// normalizeArgs rewrites the command line so every option stands alone and every value follows
+// its option as a separate argument: "-Sm model" becomes "-S -m model", "-T0.5" and
+// "--temperature=0.5" become "--temperature 0.5" style pairs, and values starting with "-"
+// (such as -0.5) are kept as values. --stream becomes --stream=true or --stream=false, and so does
+// --parallel-tool-calls.
+// Unknown options are reported with a suggestion.
+func normalizeArgs(args []string) ([]string, error) {
+	specs := mainFlagSpecs()
+	long, short := map[string]flagSpec{}, map[string]flagSpec{}
+	var known []string
+	for _, s := range specs {
+		if s.long != "" {
+			long[s.long] = s
+			known = append(known, s.long)
+		}
+		if s.short != "" {
+			short[s.short] = s
+		}
+	}
+	for name := range modelSettingFlags() {
+		if _, ok := long[name]; !ok {
+			long[name] = flagSpec{long: name, arg: "VALUE"}
+			known = append(known, name)
+		}
+	}
+
+	var out []string
+	value := func(i *int, name string) (string, error) {
+		if *i+1 >= len(args) {
+			return "", fmt.Errorf("missing value for %s", name)
+		}
+		*i++
+		return args[*i], nil
+	}
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--":
+			return append(out, args[i:]...), nil
+		case a == "-" || !strings.HasPrefix(a, "-"):
+			out = append(out, a)
+		case strings.HasPrefix(a, "--"):
+			name, val, hasVal := a, "", false
+			if eq := strings.Index(a, "="); eq >= 0 {
+				name, val, hasVal = a[:eq], a[eq+1:], true
+			}
+			spec, ok := long[name]
+			if !ok {
+				return nil, unknownFlagError(name, known)
+			}
+			switch {
+			case name == "--stream" || name == "--parallel-tool-calls":
+				if !hasVal {
+					val = "true"
+					if i+1 < len(args) && (args[i+1] == "true" || args[i+1] == "false") {
+						i++
+						val = args[i]
+					}
+				}
+				out = append(out, name+"="+val)
+			case spec.arg == "" && hasVal:
+				return nil, fmt.Errorf("option %s does not take a value", name)
+			case spec.arg == "":
+				out = append(out, name)
+			default:
+				if !hasVal {
+					v, err := value(&i, name)
+					if err != nil {
+						return nil, err
+					}
+					val = v
+				}
+				out = append(out, name, val)
+			}
+		default:
+			// a cluster of short options; a value option takes the rest of the cluster or the next argument
+			cluster := a[1:]
+			for j := 0; j < len(cluster); j++ {
+				name := "-" + cluster[j:j+1]
+				spec, ok := short[name]
+				if !ok {
+					if len(cluster) == 1 {
+						return nil, fmt.Errorf("unknown option %s", a)
+					}
+					return nil, fmt.Errorf("unknown option %s in %s", name, a)
+				}
+				if spec.arg == "" {
+					out = append(out, name)
+					continue
+				}
+				rest := cluster[j+1:]
+				if strings.HasPrefix(rest, "=") {
+					out = append(out, name, rest[1:])
+				} else if rest != "" {
+					out = append(out, name, rest)
+				} else {
+					v, err := value(&i, name)
+					if err != nil {
+						return nil, err
+					}
+					out = append(out, name, v)
+				}
+				break
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Conversations that have not changed for a while can be archived or deleted with `nvidia-chat gc`,
+// following the [gc] section of the config file:
+//
+//	[gc]
+//	older_than = "90d"
+//	keep_tagged = true
+//	action = "archive"   # or "delete"
+//	archive_dir = "~/conversation-archive"
+
+const defaultGCAge = "90d"
+
+// parseAge parses an age such as 90d, 12w or a Go duration such as 36h.
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, suffix)); err == nil && strings.HasSuffix(s, suffix) && n >= 0 {
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q, expected e.g. 90d, 12w or 36h", s)
+	}
+	return d, nil
+}
+
+// gcFile is a file removed by gc: a conversation or one of its backups.
+type gcFile struct {
+	path string
+	size int64
+}
+
+// gcCandidates returns the files of the conversations in dir that did not change since cutoff, with
+// their backups (.bak.<time> copies of malformed files and .before-summary copies), and the
+// backups whose conversation is gone once they are old enough themselves. Tagged conversations are
+// skipped when keepTagged is set.
+func gcCandidates(dir string, cutoff time.Time, keepTagged bool) (conversations, backups []gcFile, err error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	isBackup := func(name string) bool {
+		return strings.Contains(name, ".bak.") || strings.Contains(name, ".before-summary.")
+	}
+	// backupOf returns the conversation file a backup belongs to
+	backupOf := func(name string) string {
+		if i := strings.Index(name, ".bak."); i >= 0 {
+			return name[:i]
+		}
+		i := strings.Index(name, ".before-summary.")
+		return name[:i] + name[i+len(".before-summary"):]
+	}
+	files := map[string]os.FileInfo{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			files[e.Name()] = e
+		}
+	}
+	removed := map[string]bool{}
+	for name, info := range files {
+		if isBackup(name) || !(strings.HasSuffix(name, ".json") || isYAMLPath(name)) || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		cf, err := readConversation(path)
+		if err != nil || cf.Messages == nil || cf.Settings.Models == nil {
+			continue // not a conversation, e.g. usage.json
+		}
+		if keepTagged && len(cf.Tags) > 0 {
+			continue
+		}
+		conversations = append(conversations, gcFile{path, info.Size()})
+		removed[name] = true
+	}
+	for name, info := range files {
+		if !isBackup(name) {
+			continue
+		}
+		owner := backupOf(name)
+		if _, exists := files[owner]; removed[owner] || !exists && info.ModTime().Before(cutoff) {
+			backups = append(backups, gcFile{filepath.Join(dir, name), info.Size()})
+		}
+	}
+	sort.Slice(conversations, func(i, j int) bool { return conversations[i].path < conversations[j].path })
+	sort.Slice(backups, func(i, j int) bool { return backups[i].path < backups[j].path })
+	return conversations, backups, nil
+}
+
+func printGCHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat gc [--older-than AGE] [--keep-tagged] [--delete|--archive DIR] [--dry-run]\n\n", bold, normal))
+	builder.WriteString("Move the conversations that have not changed for a while, and their backups, to an archive\n")
+	builder.WriteString("directory, or delete them. The defaults come from the [gc] section of the config file:\n")
+	builder.WriteString("older_than, keep_tagged, action (archive or delete) and archive_dir.\n\n")
+	builder.WriteString("Options:\n")
+	builder.WriteString("  --older-than AGE      Only remove conversations unchanged for AGE, e.g. 90d (default), 12w or 36h.\n")
+	builder.WriteString("  --keep-tagged         Keep the conversations that have tags (see /tag).\n")
+	builder.WriteString("  --archive DIR         Move the files to DIR (default: the archive directory in the history directory).\n")
+	builder.WriteString("  --delete              Delete the files instead of archiving them.\n")
+	builder.WriteString("  -n, --dry-run         Only list what would be archived or deleted.\n")
+	builder.WriteString("  --dir DIR             Directory of the conversations (default: " + conversationDir() + ").\n")
+	fmt.Print(builder.String())
+}
+
+// runGCCommand implements the `gc` subcommand and returns the process exit code.
+func runGCCommand(args []string) int {
+	conf, err := loadConfigFile(userConfigPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to read config: %v%s\n", red, err, normal)
+		return exitUsage
+	}
+	dir := conversationDir()
+	age, keepTagged, action, archiveDir := defaultGCAge, conf["gc.keep_tagged"] == "true", "archive", conf["gc.archive_dir"]
+	if v := conf["gc.older_than"]; v != "" {
+		age = v
+	}
+	if v := conf["gc.action"]; v != "" {
+		if v != "archive" && v != "delete" {
+			fmt.Fprintf(os.Stderr, "%sInvalid gc.action (archive|delete): %s%s\n", red, v, normal)
+			return exitUsage
+		}
+		action = v
+	}
+	dryRun := false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printGCHelp()
+			return exitOK
+		case "--keep-tagged":
+			keepTagged = true
+		case "--delete":
+			action = "delete"
+		case "-n", "--dry-run":
+			dryRun = true
+		case "--older-than", "--archive", "--dir":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%smissing value for %s%s\n", red, args[i], normal)
+				return exitUsage
+			}
+			val := args[i+1]
+			switch args[i] {
+			case "--older-than":
+				age = val
+			case "--archive":
+				action, archiveDir = "archive", val
+			case "--dir":
+				dir = val
+			}
+			i++
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown option: %s\n", args[i])
+			printGCHelp()
+			return exitUsage
+		}
+	}
+	maxAge, err := parseAge(age)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitUsage
+	}
+	if archiveDir == "" {
+		archiveDir = filepath.Join(dir, "archive")
+	} else if strings.HasPrefix(archiveDir, "~/") {
+		archiveDir = filepath.Join(os.Getenv("HOME"), archiveDir[2:])
+	}
+
+	conversations, backups, err := gcCandidates(dir, time.Now().Add(-maxAge), keepTagged)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to read the conversations: %v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	if len(conversations)+len(backups) == 0 {
+		fmt.Fprintf(os.Stderr, "No conversation unchanged for %s.\n", age)
+		return exitOK
+	}
+	verb := map[string]string{"archive": "Archived", "delete": "Deleted"}[action]
+	if dryRun {
+		verb = map[string]string{"archive": "Would archive", "delete": "Would delete"}[action]
+	} else if action == "archive" {
+		if err := os.MkdirAll(archiveDir, 0o755); err != nil {
+			fmt.Fprintf(os.Stderr, "%sCannot create %s: %v%s\n", red, archiveDir, err, normal)
+			return exitGeneral
+		}
+	}
+	var size int64
+	failed := 0
+	for _, f := range append(conversations, backups...) {
+		if !dryRun {
+			var err error
+			if action == "delete" {
+				err = os.Remove(f.path)
+			} else {
+				err = moveFile(f.path, filepath.Join(archiveDir, filepath.Base(f.path)))
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%sFailed to %s %s: %v%s\n", red, action, f.path, err, normal)
+				failed++
+				continue
+			}
+		}
+		size += f.size
+		fmt.Println(f.path)
+	}
+	summary := fmt.Sprintf("%s %d conversation(s) and %d backup(s), %d bytes", verb, len(conversations), len(backups), size)
+	if action == "archive" {
+		summary += " to " + archiveDir
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%s%s; %d file(s) failed.%s\n", red, summary, failed, normal)
+		return exitGeneral
+	}
+	fmt.Fprintf(os.Stderr, "%s%s.%s\n", green, summary, normal)
+	return exitOK
+}
+
+// moveFile moves a file, copying it when it goes to another file system. An existing target is
+// never replaced.
+func moveFile(from, to string) error {
+	if fileExists(to) {
+		return fmt.Errorf("%s already exists", to)
+	}
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	b, err := ioutil.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(to, b, 0o644); err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
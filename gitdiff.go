@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// maxGitDiffSize caps the diff attached to one message.
+	maxGitDiffSize = 96 * 1024
+	// maxGitDiffFileSize caps the part of the diff of a single file, so that one large file does
+	// not crowd out the others.
+	maxGitDiffFileSize = 24 * 1024
+)
+
+// pendingGitDiff holds the diff queued with /gitdiff (or --git-diff in interactive mode) that
+// will be included in the next user message.
+var pendingGitDiff string
+
+// runGitDiff returns the output of `git diff`, or of `git diff --staged`, in the current directory.
+// It is empty when there are no changes.
+func runGitDiff(staged bool) (string, error) {
+	args := []string{"diff", "--no-color", "--no-ext-diff"}
+	if staged {
+		args = append(args, "--staged")
+	}
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git diff: %s", firstLine(msg))
+		}
+		return "", fmt.Errorf("git diff: %v", err)
+	}
+	return string(out), nil
+}
+
+// diffFile is the part of a diff about one file.
+type diffFile struct {
+	name           string
+	text           string
+	added, removed int
+}
+
+// splitDiff splits a diff into its files.
+func splitDiff(diff string) []diffFile {
+	var files []diffFile
+	start, pos := 0, 0
+	inHunk := false
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		if strings.HasPrefix(line, "diff --git ") || len(files) == 0 {
+			if len(files) > 0 {
+				files[len(files)-1].text = diff[start:pos]
+			}
+			start, inHunk = pos, false
+			name := strings.TrimSpace(strings.TrimPrefix(line, "diff --git "))
+			if i := strings.Index(name, " b/"); i >= 0 {
+				name = name[i+3:]
+			}
+			files = append(files, diffFile{name: name})
+		}
+		pos += len(line)
+		f := &files[len(files)-1]
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			inHunk = true
+		case !inHunk:
+		case strings.HasPrefix(line, "+"):
+			f.added++
+		case strings.HasPrefix(line, "-"):
+			f.removed++
+		}
+	}
+	if len(files) > 0 {
+		files[len(files)-1].text = diff[start:]
+	}
+	return files
+}
+
+// truncateDiffFile keeps the header and the first hunks of a file's diff that fit in limit bytes,
+// and as many whole lines of the next hunk as there is room for.
+func truncateDiffFile(f diffFile, limit int) string {
+	if len(f.text) <= limit {
+		return f.text
+	}
+	hunks := strings.Split(f.text, "\n@@")
+	var builder strings.Builder
+	builder.WriteString(hunks[0])
+	kept := 1
+	for ; kept < len(hunks) && builder.Len()+len(hunks[kept])+3 <= limit; kept++ {
+		builder.WriteString("\n@@" + hunks[kept])
+	}
+	if room := limit - builder.Len() - 3; room > 0 {
+		part := hunks[kept][:room]
+		if i := strings.LastIndex(part, "\n"); i > 0 {
+			builder.WriteString("\n@@" + part[:i])
+		}
+	}
+	text := builder.String()
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return text + fmt.Sprintf("[... the rest of the diff of %s is omitted]\n", f.name)
+}
+
+// formatGitDiff wraps a diff in a fenced block for a message. Files over maxGitDiffFileSize are
+// cut at a hunk boundary, and files beyond maxGitDiffSize are only listed with their line counts.
+func formatGitDiff(diff string, staged bool) (text, summary string) {
+	files := splitDiff(diff)
+	var body strings.Builder
+	var omitted []string
+	added, removed := 0, 0
+	for _, f := range files {
+		added, removed = added+f.added, removed+f.removed
+		part := truncateDiffFile(f, maxGitDiffFileSize)
+		if body.Len()+len(part) > maxGitDiffSize {
+			omitted = append(omitted, fmt.Sprintf("%s (+%d -%d)", f.name, f.added, f.removed))
+			continue
+		}
+		body.WriteString(part)
+	}
+
+	content := body.String()
+	fence := "```"
+	for strings.Contains(content, fence) {
+		fence += "`"
+	}
+	var builder strings.Builder
+	if staged {
+		builder.WriteString("Git diff (staged changes):\n")
+	} else {
+		builder.WriteString("Git diff (unstaged changes):\n")
+	}
+	builder.WriteString(fence + "diff\n")
+	builder.WriteString(content)
+	if !strings.HasSuffix(content, "\n") {
+		builder.WriteString("\n")
+	}
+	builder.WriteString(fence + "\n")
+	if len(omitted) > 0 {
+		builder.WriteString("Omitted to keep the diff short: " + strings.Join(omitted, ", ") + "\n")
+	}
+	summary = fmt.Sprintf("%d file(s), +%d -%d", len(files), added, removed)
+	if len(omitted) > 0 {
+		summary += fmt.Sprintf(", %d file(s) listed only", len(omitted))
+	}
+	return builder.String(), summary
+}
+
+// gitDiffAttachment runs git diff and returns it formatted for a message, with a short summary.
+func gitDiffAttachment(staged bool) (string, string, error) {
+	diff, err := runGitDiff(staged)
+	if err != nil {
+		return "", "", err
+	}
+	if strings.TrimSpace(diff) == "" {
+		if staged {
+			return "", "", fmt.Errorf("there are no staged changes")
+		}
+		return "", "", fmt.Errorf("there are no unstaged changes (use --staged for the staged ones)")
+	}
+	text, summary := formatGitDiff(diff, staged)
+	return text, summary, nil
+}
+
+// withGitDiff appends a formatted diff to the user input.
+func withGitDiff(userInput, diff string) string {
+	if diff == "" {
+		return userInput
+	}
+	if strings.TrimSpace(userInput) == "" {
+		return diff
+	}
+	return strings.TrimRight(userInput, "\n") + "\n\n" + diff
+}
+
+// queueGitDiff implements /gitdiff [--staged] and /gitdiff off.
+func queueGitDiff(args []string) {
+	staged := false
+	for _, a := range args {
+		switch a {
+		case "--staged", "--cached":
+			staged = true
+		case "off":
+			pendingGitDiff = ""
+			fmt.Fprintln(os.Stderr, "The git diff will not be attached.")
+			return
+		default:
+			fmt.Fprintln(os.Stderr, "Usage: /gitdiff [--staged], /gitdiff off")
+			return
+		}
+	}
+	text, summary, err := gitDiffAttachment(staged)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sCannot attach the git diff: %v%s\n", red, err, normal)
+		return
+	}
+	pendingGitDiff = text
+	fmt.Fprintf(os.Stderr, "%sWill attach the git diff (%s) to the next message%s\n", green, summary, normal)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	grepSnippetWidth = 100 // bytes of a matching line shown around the first match
+	grepMaxLines     = 3   // matching lines shown per message
+)
+
+// grepSnippet returns the part of line around its matches, with the matches highlighted.
+func grepSnippet(line string, matches [][]int) string {
+	start, end := 0, len(line)
+	if end > grepSnippetWidth {
+		start = matches[0][0] - grepSnippetWidth/3
+		if start < 0 {
+			start = 0
+		}
+		if end = start + grepSnippetWidth; end > len(line) {
+			end = len(line)
+		}
+		for start > 0 && !utf8.RuneStart(line[start]) {
+			start--
+		}
+		for end < len(line) && !utf8.RuneStart(line[end]) {
+			end++
+		}
+	}
+	var sb strings.Builder
+	if start > 0 {
+		sb.WriteString("…")
+	}
+	pos := start
+	for _, m := range matches {
+		from, to := m[0], m[1]
+		if to <= pos || from >= end || from == to {
+			continue
+		}
+		if from < pos {
+			from = pos
+		}
+		if to > end {
+			to = end
+		}
+		sb.WriteString(line[pos:from] + bold + red + line[from:to] + normal)
+		pos = to
+	}
+	sb.WriteString(line[pos:end])
+	if end < len(line) {
+		sb.WriteString("…")
+	}
+	return sb.String()
+}
+
+// grepConversation implements /grep [-i] <pattern>: it lists the messages whose content matches
+// the regular expression, numbered from 1 as for /exportrange, with their matching lines.
+func grepConversation(convFile, args string) {
+	ignoreCase := false
+	if args == "-i" || strings.HasPrefix(args, "-i ") {
+		ignoreCase, args = true, strings.TrimSpace(args[2:])
+	}
+	if args == "" {
+		fmt.Fprintln(os.Stderr, "Usage: /grep [-i] <pattern>")
+		return
+	}
+	if ignoreCase {
+		args = "(?i)" + args
+	}
+	re, err := regexp.Compile(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sInvalid pattern: %v%s\n", red, err, normal)
+		return
+	}
+	cf, err := readConversation(convFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed reading conversation: %v%s\n", red, err, normal)
+		return
+	}
+
+	found := 0
+	for i, m := range cf.Messages {
+		if !re.MatchString(m.Content) {
+			continue
+		}
+		found++
+		fmt.Fprintf(os.Stderr, "%s#%d %s%s\n", bold, i+1, m.Role, normal)
+		shown, more := 0, 0
+		for _, line := range strings.Split(m.Content, "\n") {
+			matches := re.FindAllStringIndex(line, -1)
+			if len(matches) == 0 || matches[0][0] == matches[0][1] && len(matches) == 1 {
+				continue
+			}
+			if shown == grepMaxLines {
+				more++
+				continue
+			}
+			trimmed := strings.TrimLeft(line, " \t")
+			offset := len(line) - len(trimmed)
+			for _, mt := range matches {
+				mt[0], mt[1] = mt[0]-offset, mt[1]-offset
+				if mt[0] < 0 {
+					mt[0] = 0
+				}
+			}
+			fmt.Fprintf(os.Stderr, "    %s\n", grepSnippet(trimmed, matches))
+			shown++
+		}
+		if shown == 0 {
+			// the match spans lines
+			fmt.Fprintf(os.Stderr, "    %s\n", grepSnippet(firstLine(strings.TrimSpace(m.Content)), [][]int{{0, 0}}))
+		}
+		if more > 0 {
+			fmt.Fprintf(os.Stderr, "    ... %d more matching line(s)\n", more)
+		}
+	}
+	if found == 0 {
+		fmt.Fprintf(os.Stderr, "No message matches %s\n", strings.TrimPrefix(args, "(?i)"))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d message(s) match\n", found, len(cf.Messages))
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// commandDoc is the detailed help of an interactive command, shown by /help <command>.
+type commandDoc struct {
+	usage    string
+	text     string
+	examples []string
+	related  string // settings, options and commands that go with it
+}
+
+// commandAliases maps the other names of a command to the name of its help.
+var commandAliases = map[string]string{"quit": "exit", "models": "list"}
+
+var commandDocs = map[string]commandDoc{
+	"help": {
+		usage:    "/help [command]",
+		text:     "Without a command, list all the commands. With one, show its usage, examples and related settings. A model parameter, such as temperature, shows its range for the current model.",
+		examples: []string{"/help tee", "/help temperature"},
+	},
+	"exit": {
+		usage: "/exit, /quit",
+		text:  "Leave the session. The conversation is already saved after every message. Ctrl+C exits too, with status 130.",
+	},
+	"history": {
+		usage:   "/history",
+		text:    "Print the conversation file as JSON: system prompt, settings and messages.",
+		related: "/grep to search the messages, /last to print a response again.",
+	},
+	"tee": {
+		usage:    "/tee [on <file>|off]",
+		text:     "Append the streamed responses to a file as they arrive, or stop. Without arguments, tell where they go.",
+		examples: []string{"/tee on answers.md", "/tee off"},
+		related:  "--tee FILE starts the session with it on.",
+	},
+	"tokens": {
+		usage:   "/tokens",
+		text:    "Count the tokens the next request would send, per message, with the model's tokenizer when one is configured and an estimate otherwise.",
+		related: "[tokenizers] in the config file; /compact to save tokens.",
+	},
+	"stats": {
+		usage:   "/stats",
+		text:    "Show the requests and tokens of the conversation and of this month, and the quota left as reported by the rate-limit headers of the last API response, with when it resets.",
+		related: "the stats subcommand for all conversations; /tokens.",
+	},
+	"grep": {
+		usage:    "/grep [-i] <pattern>",
+		text:     "List the messages matching a regular expression, by their number (from 1, as for /exportrange), with up to three matching lines. -i ignores case.",
+		examples: []string{"/grep -i docker", `/grep func \w+Handler`},
+	},
+	"last": {
+		usage:    "/last [-t] [--md] [--pager] [n]",
+		text:     "Print the last (or Nth-to-last) response again. -t leaves out the thinking, --md (-m) formats the markdown for the terminal, --pager (-p) shows it through $PAGER (less -R when unset).",
+		examples: []string{"/last", "/last -t --md 2", "/last --pager"},
+	},
+	"clear": {
+		usage: "/clear",
+		text:  "Remove all the messages of the conversation. The system prompt and the settings are kept.",
+	},
+	"save": {
+		usage:    "/save <file>",
+		text:     "Write the conversation to a new file, as YAML when its name ends in .yaml or .yml. The session goes on in the current file; in an --ephemeral session, this is the way to keep the conversation.",
+		examples: []string{"/save ideas.json", "/save ideas.yaml"},
+		related:  "/rename to move the current file instead.",
+	},
+	"rename": {
+		usage:    "/rename <path>",
+		text:     "Move the conversation file to <path>, or into <path> when it is a directory, and keep chatting in it. An existing file is never replaced.",
+		examples: []string{"/rename ~/notes/design-review.json", "/rename ~/notes/"},
+	},
+	"title": {
+		usage:    "/title [text|auto]",
+		text:     "Show the conversation's title, set it, or let the model suggest one from the conversation (auto). The title heads exports instead of the file name.",
+		examples: []string{"/title Release checklist", "/title auto"},
+	},
+	"tag": {
+		usage:    "/tag [name|-name]...",
+		text:     "List the conversation's tags, add tags, or remove them with a leading -.",
+		examples: []string{"/tag keep work", "/tag -draft"},
+		related:  "nvidia-chat gc --keep-tagged never removes a tagged conversation.",
+	},
+	"bookmark": {
+		usage:    "/bookmark [note]",
+		text:     "Bookmark the last response, with an optional note; bookmarking it again replaces the note. The bookmark is stored with the message in the conversation file.",
+		examples: []string{"/bookmark", "/bookmark retry loop with backoff"},
+		related:  "/bookmarks; /exportbookmarks.",
+	},
+	"bookmarks": {
+		usage:   "/bookmarks",
+		text:    "List the bookmarked responses with their number, date, note and first line.",
+		related: "/bookmark; /exportbookmarks.",
+	},
+	"exportbookmarks": {
+		usage:    "/exportbookmarks [-t] <file>",
+		text:     "Write only the bookmarked responses to a markdown file, each under a heading with its note. -t leaves out the thinking.",
+		examples: []string{"/exportbookmarks answers.md"},
+		related:  "/bookmark; /exportrange.",
+	},
+	"list": {
+		usage:    "/list, /models [filter]",
+		text:     "List the supported models with their capabilities and context window. A filter keeps the models with all the given capabilities.",
+		examples: []string{"/models code", "/models tools,128k"},
+		related:  "--filter; /model to switch.",
+	},
+	"model": {
+		usage:    "/model [model_name]",
+		text:     "Switch the session to another model. Without a name, pick one from the list: type part of a name to narrow it (fuzzy matching), then a number. Settings out of range for the new model are reported, with an offer to reset them.",
+		examples: []string{"/model meta/llama-3.3-70b-instruct", "/model"},
+		related:  "-m; /modelinfo; /autosave to record the change in the conversation file.",
+	},
+	"modelinfo": {
+		usage:    "/modelinfo [name]",
+		text:     "List the parameters of a model (the current one by default) with their description, default, range and options.",
+		examples: []string{"/modelinfo", "/modelinfo deepseek-ai/deepseek-r1-0528"},
+	},
+	"askfor_model_setting": {
+		usage: "/askfor_model_setting",
+		text:  "Go through the parameters of the current model and enter a new value for each, or keep the current one with Enter.",
+	},
+	"persist-settings": {
+		usage:   "/persist-settings",
+		text:    "Save the session's model, parameters, stream and history_limit to the conversation file, so they are restored when it is reopened.",
+		related: "--save-settings; /autosave; /diff-settings to see the changes first.",
+	},
+	"diff-settings": {
+		usage:   "/diff-settings",
+		text:    "Compare the session's settings with those saved in the conversation file and show what /persist-settings would change: the saved values (-) and the session's (+).",
+		related: "/persist-settings; /settings sources for where each session value came from.",
+	},
+	"autosave": {
+		usage:   "/autosave on|off",
+		text:    "Save the settings to the conversation file whenever the model or a parameter changes, as /persist-settings does.",
+		related: "autosave = true in the [settings] section of the config file turns it on for every session.",
+	},
+	"settings": {
+		usage:    "/settings [sources]",
+		text:     "Show the session's settings; with sources, also where each value came from: default, config file, environment, conversation file, flag or command.",
+		examples: []string{"/settings sources"},
+	},
+	"system": {
+		usage:    "/system [edit|clear] [--model]",
+		text:     "Show the system prompt in effect and where it comes from (-s, --system-text, --system-url or the conversation file). edit opens it in $VISUAL or $EDITOR and saves the result as the conversation's system prompt; clear removes it. Both replace a prompt given on the command line for the rest of the session. With --model, they act on the current model's own system prompt, saved with its settings, which replaces the conversation's while that model is used; once a model has one, edit and clear act on it by default.",
+		examples: []string{"/system", "/system edit", "/system edit --model"},
+		related:  "-s and -S on the command line; /persist-system <file>.",
+	},
+	"persist-system": {
+		usage:    "/persist-system <file>",
+		text:     "Store the text of a file as the conversation's system prompt, in effect from the next message on.",
+		examples: []string{"/persist-system prompts/reviewer.txt"},
+		related:  "-s and -S on the command line; /system edit.",
+	},
+	"exportlast": {
+		usage:    "/exportlast [-t] [-f] [--tags a,b] <file>",
+		text:     "Write the last response to a markdown file. -t leaves out the thinking; -f prepends a YAML front matter block (title, model, date, settings, tags, usage); --tags adds tags and implies -f.",
+		examples: []string{"/exportlast answer.md", "/exportlast -t --tags go,review answer.md"},
+		related:  "/exportlastn, /exportn, /exportrange, /export pdf, /export org.",
+	},
+	"exportlastn": {
+		usage:    "/exportlastn [-t] [-f] <n> <file>",
+		text:     "Write the last n responses to a markdown file, oldest first. -t and -f work as for /exportlast.",
+		examples: []string{"/exportlastn 3 answers.md"},
+	},
+	"exportn": {
+		usage:    "/exportn [-t] [-f] <n> <file>",
+		text:     "Write the Nth-to-last response to a markdown file. -t and -f work as for /exportlast.",
+		examples: []string{"/exportn 2 previous.md"},
+	},
+	"exportrange": {
+		usage:    "/exportrange [-t] [-f] <from>..<to> <file>",
+		text:     "Write messages from..to, of both roles, numbered from 1, to a markdown file with a heading per message. Either end may be left out.",
+		examples: []string{"/exportrange 3..8 part.md", "/exportrange 10.. rest.md"},
+	},
+	"export": {
+		usage:    "/export pdf|org [-t] <file>",
+		text:     "Render the whole conversation as an A4 PDF with formatted markdown and highlighted code blocks, or as an Org-mode document with a headline per exchange, BEGIN_SRC blocks for code and properties drawers with the model, times and tokens. -t leaves out the thinking. A conversation with characters outside Latin-1 cannot be exported as PDF.",
+		examples: []string{"/export pdf chat.pdf", "/export org chat.org"},
+	},
+	"exportcurl": {
+		usage:    "/exportcurl <file>",
+		text:     "Write a shell script with one curl command per request of the conversation. The key is read from $NVIDIA_BUILD_AI_ACCESS_TOKEN when it runs, never written.",
+		examples: []string{"/exportcurl repro.sh"},
+	},
+	"share": {
+		usage:    "/share [-t] [from..to]",
+		text:     "Render the conversation (or messages from..to) as markdown, masked with the [redact] rules, show it with its destination, and upload it after confirmation. Prints the link. -t leaves out the thinking.",
+		examples: []string{"/share", "/share -t 3..6"},
+		related:  "The [share] section of the config file: service (gist or paste), token (else $GITHUB_TOKEN), public, url.",
+	},
+	"exportcode": {
+		usage:    "/exportcode [n] [dir]",
+		text:     "Write the code blocks of the last (or Nth-to-last) response to files in dir (default: the current directory). Blocks naming a file (```go cmd/main.go, or a first line such as // main.go) go to that path, and blocks naming the same file are joined into it; the others go to snippet-<i>.<ext>, with the language detected from the code when the fence gives none. Existing files are only replaced after confirmation.",
+		examples: []string{"/exportcode", "/exportcode 2 ./scratch"},
+	},
+	"apply": {
+		usage:   "/apply [n]",
+		text:    "Preview the unified diffs of the last (or Nth-to-last) response and apply them to the working tree after confirmation. Hunks are placed where their context matches; the changed files are backed up first.",
+		related: "/gitdiff to send your changes for review.",
+	},
+	"attachfile": {
+		usage:    "/attachfile <path>",
+		text:     "Attach a text file to the next message.",
+		examples: []string{"/attachfile main.go"},
+		related:  "--file on the command line.",
+	},
+	"gitdiff": {
+		usage:    "/gitdiff [--staged|off]",
+		text:     "Attach the output of git diff (or git diff --staged) in the current directory to the next message. /gitdiff off drops it.",
+		examples: []string{"/gitdiff --staged"},
+		related:  "--git-diff and --staged on the command line.",
+	},
+	"dictate": {
+		usage:   "/dictate",
+		text:    "Record a message from the microphone until you press Enter, transcribe it, and send it once you confirm or correct the text.",
+		related: "The [dictate] section of the config file.",
+	},
+	"ab": {
+		usage:    "/ab <model>|off",
+		text:     "Send the next message to the current model and to <model>, stream both answers, then keep the one you choose. /ab off cancels. Not available in a session with tools.",
+		examples: []string{"/ab meta/llama-3.1-8b-instruct"},
+	},
+	"summarize": {
+		usage:    "/summarize [n] [--compact]",
+		text:     "Print a summary of the last n exchanges, or of the whole conversation. With --compact, everything but the last n exchanges is replaced by a summary; the replaced messages stay in the file, archived, and no longer count against the message limit.",
+		examples: []string{"/summarize", "/summarize 4 --compact"},
+		related:  "/compact.",
+	},
+	"compact": {
+		usage:    "/compact [n]",
+		text:     "Replace all but the last n exchanges (default 2) with a summary, and report the tokens saved per request.",
+		examples: []string{"/compact", "/compact 5"},
+		related:  "/tokens; /summarize --compact.",
+	},
+	"budget": {
+		usage:   "/budget",
+		text:    "Show the tokens used this month and in this conversation, their cost when prices are configured, and what is left of the budgets.",
+		related: "The [budget] section of the config file.",
+	},
+	"send-raw": {
+		usage:    "/send-raw <message>",
+		text:     "Send a message without masking the secrets and personal data matched by [redact]. The message may go on over several lines.",
+		examples: []string{"/send-raw my test key is sk-123"},
+	},
+	"template": {
+		usage:    "/template <name> [key=value...]",
+		text:     "Render a prompt template with the given variables and send it as your message.",
+		examples: []string{"/template review lang=go"},
+		related:  "--template and --var on the command line.",
+	},
+	"dryrun": {
+		usage:   "/dryrun [on|off]",
+		text:    "Toggle dry-run mode: each message prints the request it would send instead of sending it.",
+		related: "--dry-run.",
+	},
+	"tools": {
+		usage:   "/tools",
+		text:    "List the tools of the MCP servers that the model may call.",
+		related: "The [mcp] section of the config file; --no-mcp.",
+	},
+	"agent": {
+		usage:   "/agent [on|off]",
+		text:    "Toggle agent mode, in which the model may propose shell commands that run after your confirmation.",
+		related: "--agent.",
+	},
+	"randomodel": {
+		usage: "/randomodel",
+		text:  "Switch to a random supported model.",
+	},
+}
+
+// printCommandHelp implements /help <command>.
+func printCommandHelp(name string, cfg map[string]string) {
+	name = strings.TrimPrefix(name, "/")
+	if alias, ok := commandAliases[name]; ok {
+		name = alias
+	}
+	if isSessionParameter(cfg, name) {
+		fmt.Print(parameterHelp(name, cfg))
+		return
+	}
+	doc, ok := commandDocs[name]
+	if !ok {
+		fmt.Printf("Unknown command /%s. /help lists the commands.\n", name)
+		return
+	}
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s %s\n\n", bold, normal, doc.usage))
+	builder.WriteString(wrapText(doc.text, 96) + "\n")
+	if len(doc.examples) > 0 {
+		builder.WriteString(fmt.Sprintf("\n%sExamples:%s\n", bold, normal))
+		for _, e := range doc.examples {
+			builder.WriteString("  " + e + "\n")
+		}
+	}
+	if doc.related != "" {
+		builder.WriteString(fmt.Sprintf("\n%sSee also:%s %s\n", bold, normal, doc.related))
+	}
+	fmt.Print(builder.String())
+}
+
+// parameterHelp describes the /<parameter> command of a model parameter, stream or history_limit,
+// with its current value and, for a parameter, its range for the current model.
+func parameterHelp(name string, cfg map[string]string) string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s /%s <value>, /%s unset\n\n", bold, normal, name, name))
+	key := strings.ToUpper(name)
+	switch name {
+	case "stream":
+		builder.WriteString("Stream the responses as they are written (true), or print them once complete (false).\n")
+	case "history_limit":
+		builder.WriteString("The number of messages a conversation file may hold.\n")
+	default:
+		builder.WriteString(fmt.Sprintf("A parameter of %s:\n", cfg["MODEL"]))
+		builder.WriteString(formatParameter(cfg["MODEL"], name, GetModelDefinition(cfg["MODEL"]).Parameters[name]))
+	}
+	current := cfg[key]
+	if current == "" {
+		current = "(unset)"
+	}
+	builder.WriteString(fmt.Sprintf("\nCurrent value: %s", current))
+	if o, ok := settingOrigins[key]; ok {
+		builder.WriteString(" (" + o.source.String() + ")")
+	}
+	builder.WriteString("\nunset reverts it to the default.\n")
+	flag := "--" + strings.ReplaceAll(name, "_", "-")
+	if name == "history_limit" {
+		flag = "-L"
+	}
+	builder.WriteString(fmt.Sprintf("\n%sSee also:%s %s on the command line, %s = ... in the [settings] section of the config file,\n", bold, normal, flag, name))
+	builder.WriteString(fmt.Sprintf("%s%s, /persist-settings and /autosave to keep it in the conversation file.\n", envSettingPrefix, key))
+	return builder.String()
+}
+
+// wrapText breaks text into lines of at most width characters, at spaces.
+func wrapText(text string, width int) string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	return strings.Join(append(lines, line), "\n")
+}
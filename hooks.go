@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// runResponseHook runs the hooks.on_response command after an assistant reply. The command gets
+// the reply on stdin and the model and conversation file in the environment. Failures are
+// reported but never abort the chat.
+func runResponseHook(cfg map[string]string, convFile, reply string) {
+	command := userConfig["hooks.on_response"]
+	if command == "" || strings.TrimSpace(reply) == "" {
+		return
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdin = strings.NewReader(reply)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"NVIDIA_CHAT_MODEL="+cfg["MODEL"],
+		"NVIDIA_CHAT_CONVERSATION="+convFile,
+		"NVIDIA_CHAT_EVENT=response",
+	)
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%sResponse hook failed: %v%s\n", red, err, normal)
+	}
+}
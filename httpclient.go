@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
+)
+
+// parseDurationSetting parses a timeout setting given either as a Go duration ("90s", "2m")
+// or as a plain number of seconds. Empty or zero means no timeout.
+func parseDurationSetting(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		if secs < 0 {
+			return 0, fmt.Errorf("negative duration: %s", value)
+		}
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration: %s", value)
+	}
+	return d, nil
+}
+
+// validateHTTPSettings checks the timeout and retry settings.
+func validateHTTPSettings(cfg map[string]string) error {
+	for _, key := range []string{"TIMEOUT", "CONNECT_TIMEOUT", "IDLE_TIMEOUT"} {
+		if _, err := parseDurationSetting(cfg[key]); err != nil {
+			return fmt.Errorf("Invalid %s: %v", strings.ToLower(key), err)
+		}
+	}
+	if r, err := strconv.Atoi(cfg["MAX_RETRIES"]); err != nil || r < 0 {
+		return fmt.Errorf("Invalid max_retries (>= 0): %s", cfg["MAX_RETRIES"])
+	}
+	if v := userConfig["http.max_idle_conns"]; v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n < 0 {
+			return fmt.Errorf("Invalid http.max_idle_conns (>= 0): %s", v)
+		}
+	}
+	if _, err := parseDurationSetting(userConfig["http.idle_conn_timeout"]); err != nil {
+		return fmt.Errorf("Invalid http.idle_conn_timeout: %v", err)
+	}
+	if v := userConfig["http.http2"]; v != "" {
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("Invalid http.http2 (true|false): %s", v)
+		}
+	}
+	return nil
+}
+
+var (
+	transportsMu sync.Mutex
+	transports   = map[time.Duration]*http.Transport{}
+)
+
+// sharedTransport returns the transport for a connect timeout, creating it on first use. The
+// transport keeps idle connections open (over HTTP/2 when the server supports it), so later
+// requests to the same host skip the TCP and TLS handshakes. The [http] section of the config
+// file tunes the pool: max_idle_conns, idle_conn_timeout and http2.
+func sharedTransport(connectTimeout time.Duration) *http.Transport {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	if t, ok := transports[connectTimeout]; ok {
+		return t
+	}
+	maxIdle := mustAtoi(userConfig["http.max_idle_conns"], 16)
+	idleConnTimeout := 90 * time.Second
+	if d, err := parseDurationSetting(userConfig["http.idle_conn_timeout"]); err == nil && userConfig["http.idle_conn_timeout"] != "" {
+		idleConnTimeout = d
+	}
+	http2 := true
+	if v, err := strconv.ParseBool(userConfig["http.http2"]); err == nil {
+		http2 = v
+	}
+
+	dialer := &net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}
+	t := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: connectTimeout,
+		ForceAttemptHTTP2:   http2,
+		MaxIdleConns:        maxIdle,
+		MaxIdleConnsPerHost: maxIdle,
+		IdleConnTimeout:     idleConnTimeout,
+	}
+	if !http2 {
+		// a non-nil empty map disables the automatic HTTP/2 upgrade
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	transports[connectTimeout] = t
+	return t
+}
+
+// newHTTPClient returns a client for API calls with the TIMEOUT setting, on the shared transport
+// for the CONNECT_TIMEOUT setting. With --record or --replay, exchanges are saved or served from disk.
+func newHTTPClient(cfg map[string]string) *http.Client {
+	timeout, _ := parseDurationSetting(cfg["TIMEOUT"])
+	connectTimeout, _ := parseDurationSetting(cfg["CONNECT_TIMEOUT"])
+	var transport http.RoundTripper = sharedTransport(connectTimeout)
+	if len(extraHeaders) > 0 {
+		transport = &headerTransport{next: transport}
+	}
+	switch {
+	case replaying != nil:
+		transport = &replayTransport{log: replaying}
+	case recording != nil:
+		transport = &recordingTransport{next: transport, log: recording}
+	}
+	if apiLog != nil {
+		transport = &loggingTransport{next: transport, log: apiLog}
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// extraHeaders are the headers of --header and the [headers] section of the config file, added to
+// every API request, e.g. for a corporate gateway.
+var extraHeaders = http.Header{}
+
+// scopeHeaders name the headers carrying the ORGANIZATION and PROJECT settings, which gateways
+// enforcing per-organization accounting expect.
+var scopeHeaders = [][2]string{{"ORGANIZATION", "OpenAI-Organization"}, {"PROJECT", "OpenAI-Project"}}
+
+// loadExtraHeaders sets extraHeaders from the config file, the organization and project settings,
+// and the --header flags, which take precedence for the same name.
+func loadExtraHeaders(cfg map[string]string, flags []string) error {
+	for key, value := range userConfig {
+		if strings.HasPrefix(key, "headers.") {
+			extraHeaders.Set(strings.Trim(strings.TrimPrefix(key, "headers."), `"'`), value)
+		}
+	}
+	for _, h := range scopeHeaders {
+		if value := cfg[h[0]]; value != "" {
+			extraHeaders.Set(h[1], value)
+		}
+	}
+	for _, h := range flags {
+		parts := strings.SplitN(h, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" || strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("Invalid header %q, expected \"Name: value\"", h)
+		}
+		extraHeaders.Set(name, strings.TrimSpace(parts[1]))
+	}
+	return nil
+}
+
+// addExtraHeaders sets the extra headers on req, replacing any header of the same name.
+func addExtraHeaders(req *http.Request) {
+	for name, values := range extraHeaders {
+		req.Header[name] = values
+	}
+}
+
+// apiRequestKey marks the context of the API requests.
+type apiRequestKey struct{}
+
+// asAPIRequest returns req marked as an API request, which gets the extra headers whether or not
+// it carries a key.
+func asAPIRequest(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), apiRequestKey{}, true))
+}
+
+// headerTransport adds the extra headers to the API requests: other downloads, such as system
+// prompts, do not get them.
+type headerTransport struct {
+	next http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Context().Value(apiRequestKey{}) != nil {
+		req = req.Clone(req.Context())
+		addExtraHeaders(req)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// errInterrupted is the error of a request cancelled with Ctrl+C.
+var errInterrupted = errors.New("interrupted")
+
+// inFlight holds the cancel functions of the requests being sent or read, for Ctrl+C.
+var inFlight = struct {
+	sync.Mutex
+	next    int
+	cancels map[int]context.CancelCauseFunc
+}{cancels: map[int]context.CancelCauseFunc{}}
+
+// trackRequest registers the cancel function of a request and returns the function removing it.
+func trackRequest(cancel context.CancelCauseFunc) func() {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+	id := inFlight.next
+	inFlight.next++
+	inFlight.cancels[id] = cancel
+	return func() {
+		inFlight.Lock()
+		defer inFlight.Unlock()
+		delete(inFlight.cancels, id)
+	}
+}
+
+// interruptRequests cancels the requests in flight with errInterrupted and reports whether there
+// were any.
+func interruptRequests() bool {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+	n := len(inFlight.cancels)
+	for id, cancel := range inFlight.cancels {
+		cancel(errInterrupted)
+		delete(inFlight.cancels, id)
+	}
+	return n > 0
+}
+
+// sendChatRequest sends req, retrying up to MAX_RETRIES times with exponential backoff on network
+// errors, 429 and 5xx responses, waiting longer when the server asks to with Retry-After. The
+// rate-limit headers of the responses are recorded: when the quota is spent, the next request
+// waits for it to reset, and a 429 telling when to come back is retried then even without
+// MAX_RETRIES, provided the wait is under maxRateLimitWait. The returned body is aborted if no data
+// arrives for IDLE_TIMEOUT. When several API keys are configured, a key that is rejected or rate
+// limited is replaced by the next one without consuming a retry. Ctrl+C cancels the request, which
+// then fails with errInterrupted.
+func sendChatRequest(cfg map[string]string, req *http.Request) (*http.Response, error) {
+	client := newHTTPClient(cfg)
+	req = asAPIRequest(req)
+	retries := mustAtoi(cfg["MAX_RETRIES"], 0)
+	idle, _ := parseDurationSetting(cfg["IDLE_TIMEOUT"])
+	withKey := sendsKey(req.URL)
+	if !withKey {
+		req = req.Clone(req.Context())
+		req.Header.Del("Authorization")
+	} else if apiKeys.Len() > 1 {
+		req.Header.Set("Authorization", "Bearer "+apiKeys.Current())
+	}
+
+	attempt, failovers, rateLimited := 0, 0, 0
+	for sent := 0; ; sent++ {
+		if wait := rateLimitWait(); wait > 0 && wait <= maxRateLimitWait {
+			fmt.Fprintf(os.Stderr, "%sRate limit reached, waiting %s for the quota to reset...%s\n", red, wait, normal)
+			time.Sleep(wait)
+		}
+		attemptReq := req
+		if sent > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+		ctx, cancelCause := context.WithCancelCause(req.Context())
+		untrack := trackRequest(cancelCause)
+		cancel := func() {
+			untrack()
+			cancelCause(nil)
+		}
+		resp, err := client.Do(attemptReq.WithContext(ctx))
+		if err != nil && context.Cause(ctx) == errInterrupted {
+			cancel()
+			return nil, errInterrupted
+		}
+		if err == nil {
+			recordRateLimit(resp.Header)
+		}
+
+		if err == nil && withKey && isKeyFailoverStatus(resp.StatusCode) && failovers < apiKeys.Len()-1 {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			cancel()
+			failed := apiKeys.label()
+			apiKeys.next()
+			fmt.Fprintf(os.Stderr, "%s%s failed (%s), switching to %s%s\n", red, failed, resp.Status, apiKeys.label(), normal)
+			req.Header.Set("Authorization", "Bearer "+apiKeys.Current())
+			failovers++
+			continue
+		}
+
+		retryable := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		var serverDelay time.Duration
+		if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+			serverDelay = retryDelay(resp)
+		}
+		waitQuota := serverDelay > 0 && serverDelay <= maxRateLimitWait && rateLimited < rateLimitRetries
+		if !retryable || attempt >= retries && !waitQuota {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			if apiKeys.Len() > 1 && resp.StatusCode < 400 {
+				fmt.Fprintf(os.Stderr, "Request served by %s\n", apiKeys.label())
+			}
+			resp.Body = newIdleTimeoutBody(resp.Body, idle, ctx, cancel)
+			return resp, nil
+		}
+
+		reason := ""
+		delay := time.Duration(1<<uint(attempt)) * time.Second
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			if after := nvidiachat.RetryAfter(resp.Header); after > delay {
+				delay = after
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		cancel()
+		if attempt >= retries {
+			// only the quota's reset is awaited, without consuming a retry
+			fmt.Fprintf(os.Stderr, "%sRate limited (%s), retrying in %s when the quota resets...%s\n", red, reason, serverDelay, normal)
+			time.Sleep(serverDelay)
+			rateLimited++
+			continue
+		}
+		if serverDelay > delay {
+			delay = serverDelay
+		}
+		fmt.Fprintf(os.Stderr, "%sRequest failed (%s), retrying in %s (%d/%d)...%s\n", red, reason, delay, attempt+1, retries, normal)
+		time.Sleep(delay)
+		attempt++
+	}
+}
+
+// idleTimeoutBody cancels the request when no data has been read for the idle duration.
+type idleTimeoutBody struct {
+	body   io.ReadCloser
+	idle   time.Duration
+	ctx    context.Context
+	cancel func()
+	timer  *time.Timer
+	once   sync.Once
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, idle time.Duration, ctx context.Context, cancel func()) io.ReadCloser {
+	b := &idleTimeoutBody{body: body, idle: idle, ctx: ctx, cancel: cancel}
+	if idle > 0 {
+		b.timer = time.AfterFunc(idle, cancel)
+	}
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if b.timer != nil && n > 0 {
+		b.timer.Reset(b.idle)
+	}
+	if err != nil && context.Cause(b.ctx) == errInterrupted {
+		return n, errInterrupted
+	}
+	if err != nil && err != io.EOF && b.timer != nil && !b.timer.Stop() {
+		// the timer already fired: report the idle timeout rather than a bare cancellation
+		return n, fmt.Errorf("no data received for %s: %w", b.idle, err)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	err := b.body.Close()
+	b.once.Do(func() {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		b.cancel()
+	})
+	return err
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestExtraHeadersWithoutKey(t *testing.T) {
+	t.Cleanup(func() { extraHeaders = http.Header{} })
+	api := newMockAPI(t, mockResponse{status: http.StatusOK, body: `{"choices":[{"message":{"content":"ok"}}]}`})
+	cfg := mockConfig(api.URL)
+	cfg["ORGANIZATION"], cfg["PROJECT"] = "org-1", "proj-1"
+	if err := loadExtraHeaders(cfg, []string{"X-Gateway: team-a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a self-hosted endpoint without authentication: the request carries no key
+	req, err := newChatRequest(cfg, []byte(`{"model":"m","messages":[]}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := sendChatRequest(cfg, req)
+	if err != nil {
+		t.Fatalf("sendChatRequest: %v", err)
+	}
+	resp.Body.Close()
+
+	headers := api.requestHeaders()
+	if len(headers) != 1 {
+		t.Fatalf("the server got %d requests, want 1", len(headers))
+	}
+	h := headers[0]
+	if h.Get("Authorization") != "" {
+		t.Errorf("Authorization = %q, want none", h.Get("Authorization"))
+	}
+	for name, want := range map[string]string{"OpenAI-Organization": "org-1", "OpenAI-Project": "proj-1", "X-Gateway": "team-a"} {
+		if got := h.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// jsonStream writes the response of --prompt as --json-stream events on stdout, one JSON object
+// per line, instead of the decorated text: "reasoning" and "delta" events as the chunks arrive,
+// "usage" with the token counts, then "done" or "error". It is nil when the option is off. The
+// --stdio-json protocol sets it while answering a message request, whose id is jsonStreamID.
+var jsonStream *stdioEmitter
+
+// jsonStreamID is the id repeated in the chunk and usage events.
+var jsonStreamID string
+
+// startJSONStream turns --json-stream on.
+func startJSONStream() {
+	jsonStream = &stdioEmitter{enc: json.NewEncoder(os.Stdout)}
+	jsonStream.enc.SetEscapeHTML(false)
+}
+
+// emitChunk emits the reasoning and the content of a response chunk, if --json-stream is on.
+func emitChunk(reasoning, content string) {
+	if jsonStream == nil {
+		return
+	}
+	if reasoning != "" {
+		jsonStream.emit(stdioEvent{ID: jsonStreamID, Type: "reasoning", Content: reasoning})
+	}
+	if content != "" {
+		jsonStream.emit(stdioEvent{ID: jsonStreamID, Type: "delta", Content: content})
+	}
+}
+
+// emitUsage emits the token counts of a response, if --json-stream is on.
+func emitUsage(u tokenUsage) {
+	if jsonStream == nil || u == (tokenUsage{}) {
+		return
+	}
+	jsonStream.emit(stdioEvent{ID: jsonStreamID, Type: "usage", PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens})
+}
+
+// emitDone ends a --json-stream response with a done event, or with an error event when err is not
+// nil.
+func emitDone(err error, model, convFile string) {
+	if jsonStream == nil {
+		return
+	}
+	if err != nil {
+		jsonStream.fail("", err)
+		return
+	}
+	jsonStream.emit(stdioEvent{Type: "done", Model: model, Conversation: convFile})
+}
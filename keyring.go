@@ -0,0 +1,68 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// keyringSet stores secret in the OS keyring: the login Keychain on macOS (via `security`),
+// the Secret Service (libsecret, via `secret-tool`) elsewhere. Both tools read the secret from
+// stdin, so it never shows up in the process list.
+func keyringSet(account, secret string) error {
+	if runtime.GOOS == "darwin" {
+		// A trailing -w without a value makes security prompt for the password, then for it again.
+		return runKeyringTool(secret+"\n"+secret+"\n", "security", "add-generic-password", "-U", "-s", keyringService, "-a", account, "-w")
+	}
+	return runKeyringTool(secret, "secret-tool", "store", "--label=nvidia-chat API key", "service", keyringService, "account", account)
+}
+
+// keyringGet returns the secret stored for account, or errKeyringNotFound.
+func keyringGet(account string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", account, "-w")
+	} else {
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", account)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", errKeyringNotFound
+		}
+		return "", fmt.Errorf("%w: %v", errKeyringUnavailable, err)
+	}
+	secret := strings.TrimRight(string(out), "\r\n")
+	if secret == "" {
+		return "", errKeyringNotFound
+	}
+	return secret, nil
+}
+
+// keyringDelete removes the secret stored for account.
+func keyringDelete(account string) error {
+	if runtime.GOOS == "darwin" {
+		return runKeyringTool("", "security", "delete-generic-password", "-s", keyringService, "-a", account)
+	}
+	return runKeyringTool("", "secret-tool", "clear", "service", keyringService, "account", account)
+}
+
+func runKeyringTool(stdin, name string, args ...string) error {
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("%w: %s not found", errKeyringUnavailable, name)
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
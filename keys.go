@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// keyPool holds the API keys configured for the session. Requests use the current key and
+// fail over to the next one when a key is rejected or rate limited. It is safe for concurrent use.
+type keyPool struct {
+	mu      sync.Mutex
+	keys    []string
+	current int
+}
+
+// apiKeys is the key pool of the running session.
+var apiKeys = &keyPool{}
+
+func newKeyPool(keys []string) *keyPool {
+	return &keyPool{keys: keys}
+}
+
+// Len returns the number of configured keys.
+func (p *keyPool) Len() int {
+	return len(p.keys)
+}
+
+// Current returns the key in use, or "" if no key is configured.
+func (p *keyPool) Current() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.currentLocked()
+}
+
+func (p *keyPool) currentLocked() string {
+	if len(p.keys) == 0 {
+		return ""
+	}
+	return p.keys[p.current]
+}
+
+// next switches to the following key, wrapping around after the last one.
+func (p *keyPool) next() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.keys) > 0 {
+		p.current = (p.current + 1) % len(p.keys)
+	}
+}
+
+// replace swaps the key old for key and makes it the current one; the other keys stay. A key
+// that is not in the pool is added.
+func (p *keyPool) replace(old, key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = replaceKey(p.keys, old, key)
+	for i, k := range p.keys {
+		if k == key {
+			p.current = i
+		}
+	}
+}
+
+// label identifies the current key without revealing it, e.g. "key 2/3 (…wxyz)".
+func (p *keyPool) label() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return fmt.Sprintf("key %d/%d (%s)", p.current+1, len(p.keys), keyFingerprint(p.currentLocked()))
+}
+
+// keyFingerprint returns the last characters of a key, enough to tell keys apart in logs.
+func keyFingerprint(key string) string {
+	if len(key) <= 8 {
+		return "…"
+	}
+	return "…" + key[len(key)-4:]
+}
+
+// isKeyFailoverStatus reports whether a response status means the key itself is unusable
+// (rejected or out of quota), so another key may succeed.
+func isKeyFailoverStatus(code int) bool {
+	return code == 401 || code == 403 || code == 429
+}
+
+// replaceKey returns a copy of keys with old replaced by key, or with key appended when old is
+// not among them.
+func replaceKey(keys []string, old, key string) []string {
+	replaced := make([]string, 0, len(keys)+1)
+	found := false
+	for _, k := range keys {
+		if k == old {
+			k, found = key, true
+		}
+		replaced = append(replaced, k)
+	}
+	if !found {
+		replaced = append(replaced, key)
+	}
+	return replaced
+}
+
+// splitKeys splits a comma- or newline-separated list of keys, dropping blanks.
+func splitKeys(s string) []string {
+	var keys []string
+	for _, k := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
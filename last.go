@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	markdownBold       = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+	markdownInlineCode = regexp.MustCompile("`([^`\n]+)`")
+	markdownBullet     = regexp.MustCompile(`^(\s*)[-*+]\s+`)
+)
+
+// renderMarkdown formats markdown for the terminal: headings and bold text in bold, code in blue,
+// bullets as dots and the reasoning markers in green.
+func renderMarkdown(text string) string {
+	var sb strings.Builder
+	inCode := false
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			inCode = !inCode
+			sb.WriteString(blue + line + normal)
+		case inCode:
+			sb.WriteString(blue + line + normal)
+		case strings.HasPrefix(trimmed, "[Begin of Assistant Reasoning]"), strings.HasPrefix(trimmed, "[/End of Assistant Reasoning]"), strings.HasPrefix(trimmed, "[End of Assistant Reasoning]"):
+			sb.WriteString(green + line + normal)
+		case strings.HasPrefix(trimmed, "#"):
+			sb.WriteString(bold + strings.TrimSpace(strings.TrimLeft(trimmed, "#")) + normal)
+		default:
+			line = markdownBullet.ReplaceAllString(line, "$1• ")
+			line = markdownBold.ReplaceAllString(line, bold+"$1"+normal)
+			line = markdownInlineCode.ReplaceAllString(line, blue+"$1"+normal)
+			sb.WriteString(line)
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// showInPager writes text through $PAGER, or less -R when it is unset. It reports false when no
+// pager could be run, for the caller to print the text itself.
+func showInPager(text string) bool {
+	args := strings.Fields(os.Getenv("PAGER"))
+	if len(args) == 0 {
+		if _, err := exec.LookPath("less"); err != nil {
+			return false
+		}
+		args = []string{"less", "-R"}
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text + "\n")
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%sPager %s failed: %v%s\n", red, args[0], err, normal)
+		return false
+	}
+	return true
+}
+
+// printLastResponse implements /last [-t] [--md] [--pager] [n]: it prints the last (or Nth-to-last)
+// assistant response again, without its thinking with -t, formatted with --md, or through the pager.
+func printLastResponse(args []string, convFile string, out io.Writer) {
+	n, filterThinking, markdown, pager := 1, false, false, false
+	for _, arg := range args {
+		switch arg {
+		case "-t":
+			filterThinking = true
+		case "-m", "--md":
+			markdown = true
+		case "-p", "--pager":
+			pager = true
+		default:
+			v, err := strconv.Atoi(arg)
+			if err != nil || v < 1 {
+				fmt.Fprintln(os.Stderr, "Usage: /last [-t] [--md] [--pager] [n]")
+				return
+			}
+			n = v
+		}
+	}
+	text, err := nthAssistantResponse(convFile, n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sCannot show response %d: %v%s\n", red, n, err, normal)
+		return
+	}
+	if filterThinking {
+		text = filterThinkingBlock(text)
+	}
+	if markdown {
+		text = renderMarkdown(text)
+	}
+	if pager && showInPager(text) {
+		return
+	}
+	fmt.Fprintln(out, text)
+}
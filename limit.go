@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// linkedFilePath returns a free path next to convFile for the conversation continuing it:
+// chat.json is continued in chat-2.json, chat-2.json in chat-3.json, and so on.
+func linkedFilePath(convFile string) string {
+	ext := filepath.Ext(convFile)
+	stem := strings.TrimSuffix(convFile, ext)
+	n := 2
+	if i := strings.LastIndex(stem, "-"); i >= 0 {
+		if v, err := strconv.Atoi(stem[i+1:]); err == nil && v > 0 {
+			stem, n = stem[:i], v+1
+		}
+	}
+	for ; ; n++ {
+		if path := fmt.Sprintf("%s-%d%s", stem, n, ext); !conversationExists(path) {
+			return path
+		}
+	}
+}
+
+// startLinkedConversation creates the file continuing convFile, with the same title, system
+// prompt and settings and a link back to it, and returns its path.
+func startLinkedConversation(convFile string, cfg map[string]string) (string, error) {
+	old, err := readConversation(convFile)
+	if err != nil {
+		return "", err
+	}
+	path := linkedFilePath(convFile)
+	if err := ensureHistoryFileStructure(path, cfg); err != nil {
+		return "", err
+	}
+	cf, err := readConversation(path)
+	if err != nil {
+		return "", err
+	}
+	cf.Title, cf.System, cf.Settings, cf.Previous = old.Title, old.System, old.Settings, convFile
+	return path, writeConversation(path, cf)
+}
+
+// offerHistoryLimitRecovery is called when the conversation holds count messages and has no room
+// for another one under HISTORY_LIMIT. It lets the user raise the limit, continue in a new linked
+// file or compact the conversation, and reports whether there is room again; convFile is updated
+// when the session moves to a new file.
+func offerHistoryLimitRecovery(convFile *string, cfg map[string]string, count int) bool {
+	limit := mustAtoi(cfg["HISTORY_LIMIT"], defaultHistoryLimit)
+	fmt.Fprintf(os.Stderr, "%sConversation message limit reached.%s\nFile: %s\nMessages in the conversation (archived ones excluded): %d\nConfigured limit: %d\n\n", red, normal, *convFile, count, limit)
+	for count >= limit {
+		fmt.Fprint(os.Stderr, "Raise the limit [r], continue in a new linked file [n], compact the conversation [c], or quit [Q]? ")
+		answer, err := readSingleLine(nil, []string{"\n"}, true)
+		if err != nil && strings.TrimSpace(answer) == "" {
+			fmt.Fprintln(os.Stderr)
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "r":
+			suggested := count + defaultHistoryLimit
+			fmt.Fprintf(os.Stderr, "New limit [%d]: ", suggested)
+			line, _ := readSingleLine(nil, []string{"\n"}, true)
+			n := suggested
+			if line = strings.TrimSpace(line); line != "" {
+				if v, err := strconv.Atoi(line); err == nil {
+					n = v
+				}
+			}
+			if n <= count {
+				fmt.Fprintf(os.Stderr, "%sThe limit must be above %d.%s\n", red, count, normal)
+				continue
+			}
+			cf, err := readConversation(*convFile)
+			if err == nil {
+				cf.Settings.HistoryLimit = n
+				err = writeConversation(*convFile, cf)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%sFailed to save the limit: %v%s\n", red, err, normal)
+				continue
+			}
+			cfg["HISTORY_LIMIT"], limit = strconv.Itoa(n), n
+			fmt.Fprintf(os.Stderr, "%sLimit raised to %d and saved %s%s\n", green, n, savedTo(*convFile), normal)
+		case "n":
+			path, err := startLinkedConversation(*convFile, cfg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%sFailed to create the new file: %v%s\n", red, err, normal)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "%sContinuing in %s%s (linked to %s)\n", green, path, normal, *convFile)
+			*convFile, count = path, 0
+		case "c":
+			compactConversation(*convFile, cfg, defaultCompactKeep, false)
+			if n, err := messageCount(*convFile); err == nil {
+				count = n
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,29 +12,36 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
 )
 
 var (
 	// defaults (same as your zsh script)
-	defaultBaseURL       = "https://integrate.api.nvidia.com/v1"
-	defaultModel         = "openai/gpt-oss-120b"
-	defaultTemperature   = "1"
-	defaultTopP          = "1"
-	defaultFrequency     = "0"
-	defaultPresence      = "0"
-	defaultMaxTokens     = "4096"
-	defaultStream        = "true"
-	defaultReasoning     = "low"
-	defaultStop          = ""
-	defaultHistorySubdir = ".cache/nvidia-chat"
-	defaultHistoryLimit  = 40
-	modelsList           = []string{
+	defaultBaseURL        = "https://integrate.api.nvidia.com/v1"
+	defaultModel          = "openai/gpt-oss-120b"
+	defaultTemperature    = "1"
+	defaultTopP           = "1"
+	defaultFrequency      = "0"
+	defaultPresence       = "0"
+	defaultMaxTokens      = "4096"
+	defaultStream         = "true"
+	defaultReasoning      = "low"
+	defaultStop           = ""
+	defaultHistorySubdir  = ".cache/nvidia-chat"
+	defaultHistoryLimit   = 40
+	defaultTimeout        = "0"  // whole request, 0 = no limit
+	defaultConnectTimeout = "30" // dial + TLS handshake
+	defaultIdleTimeout    = "0"  // max silence while reading the response, 0 = no limit
+	defaultMaxRetries     = "0"
+	modelsList            = []string{
 		"openai/gpt-oss-120b",
 		"bytedance/seed-oss-36b-instruct",
 		"qwen/qwen3-coder-480b-a35b-instruct",
@@ -55,29 +63,15 @@ var (
 	apiEnvNames = []string{"NVIDIA_BUILD_AI_ACCESS_TOKEN", "NVIDIA_ACCESS_TOKEN", "ACCESS_TOKEN", "NVIDIA_API_KEY", "API_KEY"}
 )
 
-// ModelSettings represents the settings for a single model or the default settings.
-// It's a map to flexibly accommodate various parameters across different models.
-type ModelSettings map[string]interface{}
-
-// TopLevelSettings holds the overall settings in the conversation file.
-type TopLevelSettings struct {
-	Stream       bool                     `json:"stream"`
-	HistoryLimit int                      `json:"history_limit"`
-	Default      ModelSettings            `json:"default"`
-	Models       map[string]ModelSettings `json:"models"`
-}
-
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-// ConversationFile is the top-level structure for the conversation JSON file.
-type ConversationFile struct {
-	System   string           `json:"system"`
-	Settings TopLevelSettings `json:"settings"`
-	Messages []Message        `json:"messages"`
-}
+// The conversation file format and API types are those of the nvidiachat package.
+type (
+	ModelSettings    = nvidiachat.ModelSettings
+	TopLevelSettings = nvidiachat.Settings
+	Message          = nvidiachat.Message
+	ConversationFile = nvidiachat.Conversation
+	Comparison       = nvidiachat.Comparison
+	Bookmark         = nvidiachat.Bookmark
+)
 
 func tput(name string) string {
 	return ""
@@ -94,20 +88,55 @@ var (
 func printInteractiveHelp() {
 	var builder strings.Builder
 	builder.WriteString(fmt.Sprintf("%sInteractive Commands:%s\n", bold, normal))
-	builder.WriteString("  /help                 Show this help message.\n")
+	builder.WriteString("  /help [command]       Show this help message, or the details of a command (e.g. /help tee).\n")
 	builder.WriteString("  /exit, /quit          Exit the program.\n")
 	builder.WriteString("  /history              Print full conversation JSON.\n")
+	builder.WriteString("  /tee [on <file>|off]  Append the streamed responses to a file as they arrive, or stop.\n")
+	builder.WriteString("  /tokens               Count the tokens of the next request (see [tokenizers] in the config file).\n")
+	builder.WriteString("  /stats                Show the tokens used by the conversation and this month, and the API quota left.\n")
+	builder.WriteString("  /grep [-i] <pattern>  List the messages matching a regular expression (-i ignores case).\n")
+	builder.WriteString("  /last [-t] [--md] [--pager] [n]\n                        Print the last (or Nth-to-last) AI response again (-t filters thinking;\n                        --md formats the markdown; --pager shows it through $PAGER).\n")
 	builder.WriteString("  /clear                Clear conversation messages.\n")
 	builder.WriteString("  /save <file>          Save conversation to a new file.\n")
-	builder.WriteString("  /list                 List supported models.\n")
-	builder.WriteString("  /model <model_name>   Switch model for the session.\n")
+	builder.WriteString("  /rename <path>        Move the conversation file to <path> (a file or a directory) and continue there.\n")
+	builder.WriteString("  /title [text|auto]    Show or set the conversation's title; auto asks the model for one.\n")
+	builder.WriteString("  /tag [name|-name]...  List the conversation's tags, add a tag or remove one (-name).\n")
+	builder.WriteString("  /bookmark [note]      Bookmark the last AI response, with an optional note.\n")
+	builder.WriteString("  /bookmarks            List the bookmarked responses.\n")
+	builder.WriteString("  /exportbookmarks [-t] <file>\n                        Export only the bookmarked responses, as markdown.\n")
+	builder.WriteString("  /list, /models [filter]\n                        List supported models, e.g. /models code or /models tools,128k.\n")
+	builder.WriteString("  /model [model_name]   Switch model for the session; without a name, search and pick from the list.\n")
 	builder.WriteString("  /modelinfo [name]     List settings for a model (defaults to current).\n")
 	builder.WriteString("  /askfor_model_setting Interactively set model parameters.\n")
 	builder.WriteString("  /persist-settings     Save the current session's settings to the conversation file.\n")
+	builder.WriteString("  /diff-settings        Show what /persist-settings would change in the conversation file.\n")
+	builder.WriteString("  /autosave on|off      Save the settings to the conversation file after every change of model or parameter.\n")
+	builder.WriteString("  /settings [sources]   Show the session settings and, with sources, where each value came from.\n")
+	builder.WriteString("  /system [edit|clear] [--model]  Show the system prompt and its source, edit it in $EDITOR, or\n")
+	builder.WriteString("                        remove it; --model: the current model's own system prompt.\n")
 	builder.WriteString("  /persist-system <file>\n                        Persist a system prompt from a file.\n")
-	builder.WriteString("  /exportlast [-t] <file>\n                        Export last AI response to a markdown file (-t filters thinking).\n")
-	builder.WriteString("  /exportlastn [-t] <n> <file>\n                        Export last n AI responses.\n")
-	builder.WriteString("  /exportn [-t] <n> <file>\n                        Export the Nth-to-last AI response.\n")
+	builder.WriteString("  /exportlast [-t] [-f] [--tags a,b] <file>\n                        Export last AI response to a markdown file (-t filters thinking;\n                        -f or --tags prepend YAML front matter with model, date, settings, tags, usage).\n")
+	builder.WriteString("  /exportlastn [-t] [-f] <n> <file>\n                        Export last n AI responses.\n")
+	builder.WriteString("  /exportn [-t] [-f] <n> <file>\n                        Export the Nth-to-last AI response.\n")
+	builder.WriteString("  /exportrange [-t] [-f] <from>..<to> <file>\n                        Export messages from..to (both roles, numbered from 1) as markdown.\n")
+	builder.WriteString("  /export pdf [-t] <file>\n                        Render the whole conversation as a PDF with highlighted code blocks.\n")
+	builder.WriteString("  /export org [-t] <file>\n                        Export the whole conversation as an Org-mode document, a headline per exchange.\n")
+	builder.WriteString("  /exportcurl <file>    Write a shell script of curl commands reproducing each request (key from $NVIDIA_BUILD_AI_ACCESS_TOKEN).\n")
+	builder.WriteString("  /share [-t] [from..to]\n                        Upload the conversation as markdown to a gist or paste service ([share] in the config file).\n")
+	builder.WriteString("  /exportcode [n] [dir] Write the code blocks of the last (or Nth-to-last) AI response to files in dir.\n")
+	builder.WriteString("  /apply [n]            Preview the unified diffs of the last (or Nth-to-last) AI response and apply them\n                        to the working tree after confirmation, backing up the changed files.\n")
+	builder.WriteString("  /attachfile <path>    Attach a text file to the next message.\n")
+	builder.WriteString("  /gitdiff [--staged]   Attach the output of git diff (or git diff --staged) to the next message. /gitdiff off drops it.\n")
+	builder.WriteString("  /dictate              Record a message from the microphone, transcribe it, and send it after confirmation.\n")
+	builder.WriteString("  /ab <model>|off       Send the next message to the current model and <model>, then keep one answer.\n")
+	builder.WriteString("  /summarize [n] [--compact]\n                        Print a summary of the last n exchanges (default: all). --compact replaces all\n                        but the last n exchanges with the summary, to save context.\n")
+	builder.WriteString("  /compact [n]          Replace all but the last n exchanges (default: 2) with a summary and report the tokens saved.\n")
+	builder.WriteString("  /budget               Show the tokens used this month and in this conversation, and what is left of the budgets.\n")
+	builder.WriteString("  /send-raw <message>   Send a message without masking the secrets and personal data matched by [redact].\n")
+	builder.WriteString("  /template <name> [key=value...]\n                        Render a prompt template and send it.\n")
+	builder.WriteString("  /dryrun [on|off]      Toggle printing requests instead of sending them.\n")
+	builder.WriteString("  /tools                List the tools the model may call.\n")
+	builder.WriteString("  /agent [on|off]       Toggle agent mode (model-proposed shell commands).\n")
 	builder.WriteString("  /randomodel           Switch to a random supported model.\n\n")
 	builder.WriteString("For any model setting, you can use `/setting_name <value>` or `/setting_name unset`.\n")
 	builder.WriteString("For example: `/temperature 0.8`, `/stop unset`\n\n")
@@ -119,20 +148,30 @@ func printHelp(cfg map[string]string) {
 
 	// --- Usage ---
 	builder.WriteString(fmt.Sprintf("%snvidia-chat (go)%s\n", bold, normal))
-	builder.WriteString("Usage: nvidia-chat [OPTIONS] [CONVERSATION_FILE]\n\n")
+	builder.WriteString("Usage: nvidia-chat [OPTIONS] [CONVERSATION_FILE]\n")
+	builder.WriteString("       nvidia-chat auth login|logout|check\n")
+	builder.WriteString("       nvidia-chat serve [--port PORT] (see nvidia-chat serve --help)\n")
+	builder.WriteString("       nvidia-chat index <dir> [--name NAME] (see nvidia-chat index --help)\n")
+	builder.WriteString("       nvidia-chat ctl send|last|status|list (see nvidia-chat ctl --help)\n")
+	builder.WriteString("       nvidia-chat models update (see nvidia-chat models --help)\n")
+	builder.WriteString("       nvidia-chat nim add http://host:8000 (register a self-hosted NIM; see nvidia-chat nim --help)\n")
+	builder.WriteString("       nvidia-chat bench --models a,b --prompt-file FILE (see nvidia-chat bench --help)\n")
+	builder.WriteString("       nvidia-chat roundtable --persona A --persona B [--rounds N] (see nvidia-chat roundtable --help)\n")
+	builder.WriteString("       nvidia-chat flush CONVERSATION_FILE... (send the messages queued while offline)\n")
+	builder.WriteString("       nvidia-chat replay old.json --model NAME [-o new.json] (see nvidia-chat replay --help)\n")
+	builder.WriteString("       nvidia-chat commitmsg [--staged] [--commit] (see nvidia-chat commitmsg --help)\n")
+	builder.WriteString("       nvidia-chat pipeline steps.yaml [--var key=value] (see nvidia-chat pipeline --help)\n")
+	builder.WriteString("       nvidia-chat stats [--by day|week|month] [--csv FILE] (see nvidia-chat stats --help)\n")
+	builder.WriteString("       nvidia-chat gc [--older-than 90d] [--keep-tagged] [--dry-run] (see nvidia-chat gc --help)\n")
+	builder.WriteString("       nvidia-chat sync [--remote URL] (commit the conversations to git, pull and push; see nvidia-chat sync --help)\n")
+	builder.WriteString("       nvidia-chat backup push|pull (encrypted backup to S3 or WebDAV; see nvidia-chat backup --help)\n\n")
 	builder.WriteString(fmt.Sprintf("If CONVERSATION_FILE is omitted, one will be created at:\n  %s/conversation-<timestamp>.json\nand its path will be printed.\n\n", cfg["HISTORY_DIR"]))
 
 	// --- General Options ---
 	builder.WriteString(fmt.Sprintf("%sGeneral Options:%s\n", bold, normal))
-	builder.WriteString(fmt.Sprintf("  -m, --model NAME      Model ID to use (default: %s)\n", defaultModel))
-	builder.WriteString("  -s, --sys-prompt-file PATH\n                        Path to system prompt text file (content used for this run).\n")
-	builder.WriteString("  -S                    Persist the -s content into the conversation file's 'system' field.\n")
-	builder.WriteString("  --save-settings       Persist current model settings into the conversation file.\n")
-	builder.WriteString("  -k, --access-token KEY\n                        Provide API key (overrides environment variables).\n")
-	builder.WriteString("  --prompt TEXT|FILE|-\n                        Non-interactive mode: provide a prompt and print the response.\n")
-	builder.WriteString("  -l, --list            List supported models and exit.\n")
-	builder.WriteString("  --modelinfo NAME      Show detailed settings for a specific model and exit.\n")
-	builder.WriteString("  -h, --help            Show this help.\n\n")
+	builder.WriteString(formatFlagHelp(mainFlagSpecs()))
+	builder.WriteString("\nShort options can be combined (-Sm NAME) and values may follow an '=' (--temperature=-0.5).\n")
+	builder.WriteString("Long options cannot be abbreviated.\n\n")
 
 	// --- Model Setting Options (Dynamic) ---
 	builder.WriteString(fmt.Sprintf("%sModel Setting Options:%s\n", bold, normal))
@@ -156,32 +195,86 @@ func printHelp(cfg map[string]string) {
 	allParams["stream"] = ModelParameter{Type: Bool, Default: true, Description: "Enable or disable streaming responses."}
 	allParams["history_limit"] = ModelParameter{Type: Int, Default: defaultHistoryLimit, Description: "Maximum number of messages in conversation history."}
 
+	aliases := map[string]string{"--history-limit": "-L, --limit, ", "--reasoning-effort": "--reasoning, "}
+	for _, spec := range mainFlagSpecs() {
+		if spec.short != "" && spec.long != "" {
+			aliases[spec.long] = spec.short + ", "
+		}
+	}
 	for _, name := range paramOrder {
 		param := allParams[name]
-		flagName := strings.ReplaceAll(name, "_", "-")
-		builder.WriteString(fmt.Sprintf("  --%s VALUE\n", flagName))
+		flagName := "--" + strings.ReplaceAll(name, "_", "-")
+		builder.WriteString(fmt.Sprintf("  %s%s VALUE\n", aliases[flagName], flagName))
 		builder.WriteString(fmt.Sprintf("      %s\n", param.Description))
 		builder.WriteString(fmt.Sprintf("      To unset, use the interactive command: /%s unset\n\n", name))
 	}
 
 	// --- Interactive Commands ---
 	builder.WriteString(fmt.Sprintf("%sInteractive Commands:%s\n", bold, normal))
-	builder.WriteString("  /help                 Show this help message.\n")
+	builder.WriteString("  /help [command]       Show this help message, or the details of a command (e.g. /help tee).\n")
 	builder.WriteString("  /exit, /quit          Exit the program.\n")
 	builder.WriteString("  /history              Print full conversation JSON.\n")
+	builder.WriteString("  /tee [on <file>|off]  Append the streamed responses to a file as they arrive, or stop.\n")
+	builder.WriteString("  /tokens               Count the tokens of the next request (see [tokenizers] in the config file).\n")
+	builder.WriteString("  /stats                Show the tokens used by the conversation and this month, and the API quota left.\n")
+	builder.WriteString("  /grep [-i] <pattern>  List the messages matching a regular expression (-i ignores case).\n")
+	builder.WriteString("  /last [-t] [--md] [--pager] [n]\n                        Print the last (or Nth-to-last) AI response again (-t filters thinking;\n                        --md formats the markdown; --pager shows it through $PAGER).\n")
 	builder.WriteString("  /clear                Clear conversation messages.\n")
 	builder.WriteString("  /save <file>          Save conversation to a new file.\n")
-	builder.WriteString("  /model <model_name>   Switch model for the session.\n")
+	builder.WriteString("  /rename <path>        Move the conversation file to <path> (a file or a directory) and continue there.\n")
+	builder.WriteString("  /title [text|auto]    Show or set the conversation's title; auto asks the model for one.\n")
+	builder.WriteString("  /tag [name|-name]...  List the conversation's tags, add a tag or remove one (-name).\n")
+	builder.WriteString("  /bookmark [note]      Bookmark the last AI response, with an optional note.\n")
+	builder.WriteString("  /bookmarks            List the bookmarked responses.\n")
+	builder.WriteString("  /exportbookmarks [-t] <file>\n                        Export only the bookmarked responses, as markdown.\n")
+	builder.WriteString("  /model [model_name]   Switch model for the session; without a name, search and pick from the list.\n")
 	builder.WriteString("  /modelinfo <name>     List settings for a specific model.\n")
 	builder.WriteString("  /persist-settings     Save the current session's settings to the conversation file.\n")
+	builder.WriteString("  /diff-settings        Show what /persist-settings would change in the conversation file.\n")
+	builder.WriteString("  /autosave on|off      Save the settings to the conversation file after every change of model or parameter.\n")
+	builder.WriteString("  /settings [sources]   Show the session settings and, with sources, where each value came from.\n")
+	builder.WriteString("  /system [edit|clear] [--model]  Show the system prompt and its source, edit it in $EDITOR, or\n")
+	builder.WriteString("                        remove it; --model: the current model's own system prompt.\n")
 	builder.WriteString("  /persist-system <file>\n                        Persist a system prompt from a file.\n")
-	builder.WriteString("  /exportlast [-t] <file>\n                        Export last AI response to a markdown file (-t filters thinking).\n")
-	builder.WriteString("  /exportlastn [-t] <n> <file>\n                        Export last n AI responses.\n")
-	builder.WriteString("  /exportn [-t] <n> <file>\n                        Export the Nth-to-last AI response.\n")
+	builder.WriteString("  /exportlast [-t] [-f] [--tags a,b] <file>\n                        Export last AI response to a markdown file (-t filters thinking;\n                        -f or --tags prepend YAML front matter with model, date, settings, tags, usage).\n")
+	builder.WriteString("  /exportlastn [-t] [-f] <n> <file>\n                        Export last n AI responses.\n")
+	builder.WriteString("  /exportn [-t] [-f] <n> <file>\n                        Export the Nth-to-last AI response.\n")
+	builder.WriteString("  /exportrange [-t] [-f] <from>..<to> <file>\n                        Export messages from..to (both roles, numbered from 1) as markdown.\n")
+	builder.WriteString("  /export pdf [-t] <file>\n                        Render the whole conversation as a PDF with highlighted code blocks.\n")
+	builder.WriteString("  /export org [-t] <file>\n                        Export the whole conversation as an Org-mode document, a headline per exchange.\n")
+	builder.WriteString("  /exportcurl <file>    Write a shell script of curl commands reproducing each request (key from $NVIDIA_BUILD_AI_ACCESS_TOKEN).\n")
+	builder.WriteString("  /share [-t] [from..to]\n                        Upload the conversation as markdown to a gist or paste service ([share] in the config file).\n")
+	builder.WriteString("  /exportcode [n] [dir] Write the code blocks of the last (or Nth-to-last) AI response to files in dir.\n")
+	builder.WriteString("  /apply [n]            Preview the unified diffs of the last (or Nth-to-last) AI response and apply them\n                        to the working tree after confirmation, backing up the changed files.\n")
+	builder.WriteString("  /attachfile <path>    Attach a text file to the next message.\n")
+	builder.WriteString("  /gitdiff [--staged]   Attach the output of git diff (or git diff --staged) to the next message. /gitdiff off drops it.\n")
+	builder.WriteString("  /dictate              Record a message from the microphone, transcribe it, and send it after confirmation.\n")
+	builder.WriteString("  /ab <model>|off       Send the next message to the current model and <model>, then keep one answer.\n")
+	builder.WriteString("  /summarize [n] [--compact]\n                        Print a summary of the last n exchanges (default: all). --compact replaces all\n                        but the last n exchanges with the summary, to save context.\n")
+	builder.WriteString("  /compact [n]          Replace all but the last n exchanges (default: 2) with a summary and report the tokens saved.\n")
+	builder.WriteString("  /budget               Show the tokens used this month and in this conversation, and what is left of the budgets.\n")
+	builder.WriteString("  /send-raw <message>   Send a message without masking the secrets and personal data matched by [redact].\n")
+	builder.WriteString("  /template <name> [key=value...]\n                        Render a prompt template and send it.\n")
+	builder.WriteString("  /dryrun [on|off]      Toggle printing requests instead of sending them.\n")
+	builder.WriteString("  /tools                List the tools the model may call.\n")
+	builder.WriteString("  /agent [on|off]       Toggle agent mode (model-proposed shell commands).\n")
 	builder.WriteString("  /randomodel           Switch to a random supported model.\n\n")
 	builder.WriteString("For any model setting, you can use `/setting_name <value>` or `/setting_name unset`.\n")
 	builder.WriteString("For example: `/temperature 0.8`, `/stop unset`\n\n")
 
+	// --- Exit Codes ---
+	builder.WriteString(fmt.Sprintf("%sExit Codes:%s\n", bold, normal))
+	builder.WriteString("  0    Success.\n")
+	builder.WriteString("  1    General error.\n")
+	builder.WriteString("  2    Invalid options, arguments or setting values.\n")
+	builder.WriteString("  3    Missing or rejected API key.\n")
+	builder.WriteString("  4    API error response.\n")
+	builder.WriteString("  5    Rate limited by the API.\n")
+	builder.WriteString("  6    Conversation message limit or model context length reached.\n")
+	builder.WriteString("  7    Network error (API unreachable).\n")
+	builder.WriteString("  8    A token or spend budget would be exceeded.\n")
+	builder.WriteString("  130  Interrupted by the user (Ctrl+C).\n\n")
+
 	fmt.Print(builder.String())
 }
 
@@ -192,63 +285,47 @@ func mustAtoi(s string, def int) int {
 	}
 	return def
 }
-func mustParseFloat(s string, def float64) float64 {
-	if v, err := strconv.ParseFloat(s, 64); err == nil {
-		return v
+
+// newFileSettings returns the settings of a new conversation file, from the session's.
+func newFileSettings(cfg map[string]string) TopLevelSettings {
+	limit, _ := strconv.Atoi(cfg["HISTORY_LIMIT"])
+	// Model parameters are only recorded once persisted, so until then they keep coming from the
+	// defaults, the config file, the environment and the flags.
+	return TopLevelSettings{
+		Model:        cfg["MODEL"],
+		Stream:       cfg["STREAM"] == "true",
+		HistoryLimit: limit,
+		Default:      make(ModelSettings),
+		Models:       make(map[string]ModelSettings),
 	}
-	return def
 }
 
 func ensureHistoryFileStructure(path string, cfg map[string]string) error {
 	// if file doesn't exist, create it with defaults
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		dir := filepath.Dir(path)
-		if err := os.MkdirAll(dir, 0o755); err != nil {
-			return err
-		}
-		// build default file
-		stream := cfg["STREAM"] == "true"
-		limit, _ := strconv.Atoi(cfg["HISTORY_LIMIT"])
-
-		// Create default settings based on the generic model definition
-		defaultSettings := make(ModelSettings)
-		genericDef := GetModelDefinition("others")
-		for name, param := range genericDef.Parameters {
-			defaultSettings[name] = param.Default
-		}
-
-		s := TopLevelSettings{
-			Stream:       stream,
-			HistoryLimit: limit,
-			Default:      defaultSettings,
-			Models:       make(map[string]ModelSettings),
-		}
-		// Add the specific default model to the models map
-		s.Models[defaultModel] = ModelSettings{
-			"temperature":       mustParseFloat(defaultTemperature, 1.0),
-			"top_p":             mustParseFloat(defaultTopP, 1.0),
-			"frequency_penalty": mustParseFloat(defaultFrequency, 0),
-			"presence_penalty":  mustParseFloat(defaultPresence, 0),
-			"max_tokens":        mustAtoi(defaultMaxTokens, 4096),
-			"reasoning_effort":  defaultReasoning,
-		}
-
+	if !conversationExists(path) {
 		cf := ConversationFile{
 			System:   "",
-			Settings: s,
+			Settings: newFileSettings(cfg),
 			Messages: []Message{},
 		}
-		b, _ := json.MarshalIndent(cf, "", "  ")
-		return ioutil.WriteFile(path, b, 0o644)
+		if !conversationsInMemory {
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+		}
+		return writeConversation(path, &cf)
 	}
 
 	// file exists: verify shape; if not, back up and recreate
-	data, err := ioutil.ReadFile(path)
+	data, err := conversationData(path)
 	if err != nil {
 		return err
 	}
-	var cf ConversationFile
-	if err := json.Unmarshal(data, &cf); err != nil {
+	cf, err := decodeConversation(path, data)
+	if err != nil {
+		if conversationsInMemory {
+			return fmt.Errorf("%s is malformed: %v", path, err)
+		}
 		// back up and recreate
 		backup := path + ".bak." + strconv.FormatInt(time.Now().Unix(), 10)
 		_ = os.Rename(path, backup)
@@ -256,39 +333,53 @@ func ensureHistoryFileStructure(path string, cfg map[string]string) error {
 		return ensureHistoryFileStructure(path, cfg)
 	}
 
+	// Files of the first versions have messages but no settings: they get those of a new file
+	if cf.Messages != nil && cf.Settings.Default == nil && cf.Settings.Models == nil {
+		cf.Settings = newFileSettings(cfg)
+		if err := writeConversation(path, cf); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Added the default settings to %s, which had none.\n", path)
+		return nil
+	}
+
 	// Basic validation of structure
 	if cf.Messages == nil || cf.Settings.Default == nil || cf.Settings.Models == nil {
+		if conversationsInMemory {
+			return fmt.Errorf("%s is missing required fields", path)
+		}
 		backup := path + ".bak." + strconv.FormatInt(time.Now().Unix(), 10)
 		_ = os.Rename(path, backup)
 		fmt.Fprintf(os.Stderr, "Warning: Conversation file at %s was missing required fields. Backed up to %s and creating a new one.\n", path, backup)
 		return ensureHistoryFileStructure(path, cfg)
 	}
 
+	// Files of older versions are read in the current format; save them that way
+	if cf.Settings.Migrated {
+		if err := writeConversation(path, cf); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Converted %s to the per-model settings format.\n", path)
+	}
+
 	return nil
 }
 
+// readConversation reads a conversation file, JSON or, for .yaml and .yml files, YAML.
 func readConversation(path string) (*ConversationFile, error) {
-	data, err := ioutil.ReadFile(path)
+	data, err := conversationData(path)
 	if err != nil {
 		return nil, err
 	}
-	var cf ConversationFile
-	if err := json.Unmarshal(data, &cf); err != nil {
-		return nil, err
-	}
-	return &cf, nil
+	return decodeConversation(path, data)
 }
 
+// writeConversation saves a conversation file in the format its extension selects.
 func writeConversation(path string, cf *ConversationFile) error {
-	b, err := json.MarshalIndent(cf, "", "  ")
-	if err != nil {
-		return err
-	}
-	tmp := path + ".tmp"
-	if err := ioutil.WriteFile(tmp, b, 0o644); err != nil {
-		return err
+	if conversationsInMemory {
+		return keepConversationInMemory(path, cf)
 	}
-	return os.Rename(tmp, path)
+	return saveConversationFile(path, cf)
 }
 
 func appendMessage(path, role, content string) error {
@@ -296,16 +387,19 @@ func appendMessage(path, role, content string) error {
 	if err != nil {
 		return err
 	}
-	cf.Messages = append(cf.Messages, Message{Role: role, Content: content})
+	cf.Append(role, content)
 	return writeConversation(path, cf)
 }
 
+// messageCount returns the number of messages of a conversation that count against HISTORY_LIMIT:
+// archived messages, which are no longer sent, and queued ones, not sent yet, are left out.
 func messageCount(path string) (int, error) {
 	cf, err := readConversation(path)
 	if err != nil {
 		return 0, err
 	}
-	return len(cf.Messages), nil
+	sent, _ := splitPending(cf.Messages)
+	return len(sent), nil
 }
 
 func persistSystemToFile(path, content string) error {
@@ -317,12 +411,48 @@ func persistSystemToFile(path, content string) error {
 	return writeConversation(path, cf)
 }
 
+// persistModelSystemToFile saves content as the system text of model's settings, or removes it
+// when content is empty.
+func persistModelSystemToFile(path, model, content string) error {
+	cf, err := readConversation(path)
+	if err != nil {
+		return err
+	}
+	settings, ok := cf.Settings.Models[model]
+	if !ok {
+		settings = ModelSettings{}
+		cf.Settings.Models[model] = settings
+	}
+	if content == "" {
+		delete(settings, "system")
+	} else {
+		settings["system"] = content
+	}
+	return writeConversation(path, cf)
+}
+
+// persistModelToFile records the conversation's model so it is restored on reopen.
+func persistModelToFile(path, model string) error {
+	cf, err := readConversation(path)
+	if err != nil {
+		return err
+	}
+	cf.Settings.Model = model
+	return writeConversation(path, cf)
+}
+
 func persistSettingsToFile(path string, cfg map[string]string) error {
 	cf, err := readConversation(path)
 	if err != nil {
 		return err
 	}
+	storeSessionSettings(cf, cfg)
+	return writeConversation(path, cf)
+}
 
+// storeSessionSettings records the session's settings in cf: those of the current model, which
+// becomes the conversation's, stream, the history limit and the tool settings.
+func storeSessionSettings(cf *ConversationFile, cfg map[string]string) {
 	modelName := cfg["MODEL"]
 	modelDef := GetModelDefinition(modelName)
 
@@ -354,26 +484,49 @@ func persistSettingsToFile(path string, cfg map[string]string) error {
 				if err == nil {
 					modelSettings[key] = val
 				}
+			case IntMap:
+				if val, err := parseIntMap(valStr); err == nil {
+					modelSettings[key] = val
+				}
 			}
 		}
 	}
 
+	// Parameters without a setting of their own, sent as they are
+	if extra, err := parseExtraBody(cfg["EXTRA_BODY"]); err == nil && extra != nil {
+		modelSettings["extra"] = extra
+	} else {
+		delete(modelSettings, "extra")
+	}
+
 	// Save the updated model-specific settings
 	cf.Settings.Models[modelName] = modelSettings
+	cf.Settings.Model = modelName
 
 	// Also save global settings
 	cf.Settings.Stream = cfg["STREAM"] == "true"
 	cf.Settings.HistoryLimit = mustAtoi(cfg["HISTORY_LIMIT"], defaultHistoryLimit)
-
-	return writeConversation(path, cf)
+	recordToolSettings(cf, cfg)
 }
 
-func applyFileSettingsAsDefaults(path string, cfg map[string]string, provided map[string]bool) error {
+// applyFileSettingsAsDefaults applies the settings saved in the conversation file where no
+// environment variable or flag overrides them. Values equal to the current ones keep their origin,
+// as a new conversation file records the settings it was created with.
+func applyFileSettingsAsDefaults(path string, cfg map[string]string) error {
 	cf, err := readConversation(path)
 	if err != nil {
 		return err
 	}
+	apply := func(key, value string) {
+		if cfg[key] != value {
+			applySetting(cfg, key, value, sourceConversation, path)
+		}
+	}
 
+	// Keep talking to the conversation's model unless -m picked another one
+	if cf.Settings.Model != "" {
+		apply("MODEL", cf.Settings.Model)
+	}
 	modelName := cfg["MODEL"]
 
 	// Get the settings for the current model, falling back to default settings.
@@ -382,73 +535,96 @@ func applyFileSettingsAsDefaults(path string, cfg map[string]string, provided ma
 		settings = cf.Settings.Default
 	}
 
-	// Apply model-specific settings if they were not provided via CLI flags.
+	// Apply the model-specific settings
 	modelDef := GetModelDefinition(modelName)
 	for key, paramDef := range modelDef.Parameters {
 		configKey := strings.ToUpper(key)
-		if !provided[configKey] {
-			if value, exists := settings[key]; exists {
-				// Convert the loaded value to a string for the cfg map
-				switch paramDef.Type {
-				case Float:
-					if v, ok := value.(float64); ok {
-						cfg[configKey] = fmt.Sprintf("%g", v)
-					}
-				case Int:
-					// JSON unmarshals numbers into float64 by default
-					if v, ok := value.(float64); ok {
-						cfg[configKey] = fmt.Sprintf("%d", int(v))
-					} else if v, ok := value.(int); ok {
-						cfg[configKey] = fmt.Sprintf("%d", v)
-					}
-				case String, StringA:
-					if v, ok := value.(string); ok {
-						cfg[configKey] = v
-					}
-				case Bool:
-					if v, ok := value.(bool); ok {
-						cfg[configKey] = strconv.FormatBool(v)
+		if value, exists := settings[key]; exists {
+			// Convert the loaded value to a string for the cfg map
+			switch paramDef.Type {
+			case Float:
+				if v, ok := value.(float64); ok {
+					apply(configKey, fmt.Sprintf("%g", v))
+				}
+			case Int:
+				// JSON unmarshals numbers into float64 by default
+				if v, ok := value.(float64); ok {
+					apply(configKey, fmt.Sprintf("%d", int(v)))
+				} else if v, ok := value.(int); ok {
+					apply(configKey, fmt.Sprintf("%d", v))
+				}
+			case String, StringA:
+				if v, ok := value.(string); ok {
+					apply(configKey, v)
+				}
+			case Bool:
+				if v, ok := value.(bool); ok {
+					apply(configKey, strconv.FormatBool(v))
+				}
+			case IntMap:
+				if v, ok := value.(map[string]interface{}); ok {
+					m := map[string]int{}
+					for k, w := range v {
+						if f, ok := w.(float64); ok {
+							m[k] = int(f)
+						}
 					}
+					apply(configKey, formatIntMap(m))
 				}
 			}
 		}
 	}
 
+	if extra, ok := settings["extra"].(map[string]interface{}); ok {
+		if b, err := json.Marshal(extra); err == nil {
+			apply("EXTRA_BODY", string(b))
+		}
+	}
+
 	// Apply global settings
-	if !provided["STREAM"] {
-		cfg["STREAM"] = strconv.FormatBool(cf.Settings.Stream)
+	apply("STREAM", strconv.FormatBool(cf.Settings.Stream))
+	if cf.Settings.ToolChoice != "" {
+		apply("TOOL_CHOICE", cf.Settings.ToolChoice)
+	}
+	if cf.Settings.ParallelToolCalls != nil {
+		apply("PARALLEL_TOOL_CALLS", strconv.FormatBool(*cf.Settings.ParallelToolCalls))
 	}
-	if !provided["HISTORY_LIMIT"] && cf.Settings.HistoryLimit != 0 {
-		cfg["HISTORY_LIMIT"] = fmt.Sprintf("%d", cf.Settings.HistoryLimit)
+	if cf.Settings.HistoryLimit != 0 {
+		apply("HISTORY_LIMIT", fmt.Sprintf("%d", cf.Settings.HistoryLimit))
 	}
 
 	return nil
 }
 
-func validateNumericRanges(cfg map[string]string) error {
-	// temperature 0..1
-	t, err := strconv.ParseFloat(cfg["TEMPERATURE"], 64)
-	if err != nil || t < 0 || t > 1 {
-		return fmt.Errorf("Invalid temperature (0..1): %s", cfg["TEMPERATURE"])
-	}
-	tp, err := strconv.ParseFloat(cfg["TOP_P"], 64)
-	if err != nil || tp < 0.01 || tp > 1 {
-		return fmt.Errorf("Invalid top_p (0.01..1): %s", cfg["TOP_P"])
-	}
-	freq, err := strconv.ParseFloat(cfg["FREQUENCY_PENALTY"], 64)
-	if err != nil || freq < -2 || freq > 2 {
-		return fmt.Errorf("Invalid frequency_penalty (-2..2): %s", cfg["FREQUENCY_PENALTY"])
-	}
-	pres, err := strconv.ParseFloat(cfg["PRESENCE_PENALTY"], 64)
-	if err != nil || pres < -2 || pres > 2 {
-		return fmt.Errorf("Invalid presence_penalty (-2..2): %s", cfg["PRESENCE_PENALTY"])
+// validateSettings checks the settings against the active model's definition. A value the user
+// did not set explicitly that the model does not accept, such as the global max_tokens default on a
+// model with a lower cap, falls back to the model's default instead.
+func validateSettings(cfg map[string]string, provided map[string]bool) error {
+	selectTokenizer(cfg["MODEL"])
+	modelDef := GetModelDefinition(cfg["MODEL"])
+	names := make([]string, 0, len(modelDef.Parameters))
+	for name := range modelDef.Parameters {
+		names = append(names, name)
 	}
-	mt, err := strconv.Atoi(cfg["MAX_TOKENS"])
-	if err != nil || mt < 1 || mt > 4096 {
-		return fmt.Errorf("Invalid max_tokens (1..4096): %s", cfg["MAX_TOKENS"])
+	sort.Strings(names)
+	for _, name := range names {
+		key := strings.ToUpper(name)
+		value := cfg[key]
+		if value == "" {
+			continue
+		}
+		if err := validateParameter(name, value, modelDef); err != nil {
+			if provided[key] {
+				return fmt.Errorf("Invalid %s for %s: %v", name, cfg["MODEL"], err)
+			}
+			cfg[key] = defaultValueString(modelDef.Parameters[name])
+			settingOrigins[key] = settingOrigin{sourceDefault, "the model's, replacing " + value}
+		}
 	}
-	if cfg["REASONING_EFFORT"] != "low" && cfg["REASONING_EFFORT"] != "medium" && cfg["REASONING_EFFORT"] != "high" {
-		return fmt.Errorf("Invalid reasoning effort (low|medium|high): %s", cfg["REASONING_EFFORT"])
+	if _, ok := modelDef.Parameters["min_tokens"]; ok {
+		if min, max := mustAtoi(cfg["MIN_TOKENS"], 0), mustAtoi(cfg["MAX_TOKENS"], 0); max > 0 && min > max {
+			return fmt.Errorf("Invalid min_tokens for %s: %d is more than max_tokens (%d)", cfg["MODEL"], min, max)
+		}
 	}
 	if cfg["STREAM"] != "true" && cfg["STREAM"] != "false" {
 		return fmt.Errorf("Invalid stream flag (true|false): %s", cfg["STREAM"])
@@ -461,11 +637,7 @@ func buildPayload(cfg map[string]string, messages []Message) ([]byte, error) {
 	modelName := cfg["MODEL"]
 	modelDef := GetModelDefinition(modelName)
 
-	payload := map[string]interface{}{
-		"model":    modelName,
-		"messages": messages,
-		"stream":   cfg["STREAM"] == "true",
-	}
+	payload := map[string]interface{}{}
 
 	for key, paramDef := range modelDef.Parameters {
 		// Skip parameters that are not part of the API payload (e.g., internal 'thinking' flag)
@@ -483,6 +655,10 @@ func buildPayload(cfg map[string]string, messages []Message) ([]byte, error) {
 		switch paramDef.Type {
 		case Float:
 			if val, err := strconv.ParseFloat(valStr, 64); err == nil {
+				// A neutral length_penalty is omitted, for deployments that do not accept it
+				if key == "length_penalty" && val == 1 {
+					continue
+				}
 				payload[paramDef.APIKey] = val
 			}
 		case Int:
@@ -493,6 +669,9 @@ func buildPayload(cfg map[string]string, messages []Message) ([]byte, error) {
 						continue // Omit for other models
 					}
 				}
+				if key == "min_tokens" && val == 0 {
+					continue
+				}
 				payload[paramDef.APIKey] = val
 			}
 		case String, StringA:
@@ -505,6 +684,11 @@ func buildPayload(cfg map[string]string, messages []Message) ([]byte, error) {
 			if val, err := strconv.ParseBool(valStr); err == nil {
 				payload[paramDef.APIKey] = val
 			}
+		case IntMap:
+			// Don't send empty maps
+			if val, err := parseIntMap(valStr); err == nil && len(val) > 0 {
+				payload[paramDef.APIKey] = val
+			}
 		}
 	}
 
@@ -522,171 +706,310 @@ func buildPayload(cfg map[string]string, messages []Message) ([]byte, error) {
 		}
 	}
 
-	return json.Marshal(payload)
+	request := nvidiachat.Request{Model: modelName, Messages: messages, Stream: cfg["STREAM"] == "true", Params: payload, RoleMap: modelDef.RoleMap}
+	if request.RoleMap == nil {
+		request.RoleMap = defaultRoleMap
+	}
+	if len(registeredTools) > 0 {
+		request.Tools = toolsPayload()
+		if c := cfg["TOOL_CHOICE"]; c != "" {
+			choice, err := toolChoicePayload(c)
+			if err != nil {
+				return nil, err
+			}
+			payload["tool_choice"] = choice
+		}
+		if p := cfg["PARALLEL_TOOL_CALLS"]; p != "" {
+			parallel, err := strconv.ParseBool(p)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid parallel_tool_calls %q: use true or false", p)
+			}
+			payload["parallel_tool_calls"] = parallel
+		}
+	}
+	extra, err := parseExtraBody(cfg["EXTRA_BODY"])
+	if err != nil {
+		return nil, err
+	}
+	request.Extra = extra
+	return request.Payload()
 }
 
-// streaming JSON chunk structures (we only extract needed bits)
-type ChoiceDelta struct {
-	Content          *string `json:"content,omitempty"`
-	ReasoningContent *string `json:"reasoning_content,omitempty"`
-}
-type ChoiceStream struct {
-	Delta   *ChoiceDelta           `json:"delta,omitempty"`
-	Message map[string]interface{} `json:"message,omitempty"` // fallback
+// buildMessages assembles the messages sent to the API: model-specific thinking control,
+// the effective system prompt (precedence -s content > the model's persisted system text >
+// persisted .system in file > none),
+// the --dev-prompt-file instructions, then the conversation history.
+func buildMessages(cfg map[string]string, sysPromptContent string, cf *ConversationFile) []Message {
+	var messages []Message
+
+	// Handle special thinking-related system messages
+	modelDef := GetModelDefinition(cfg["MODEL"])
+	if modelDef.PrependedSystemMessageOnThinking != "" {
+		thinkingEnabled, _ := strconv.ParseBool(cfg["THINKING"])
+		if thinkingEnabled {
+			messages = append(messages, Message{Role: "system", Content: modelDef.PrependedSystemMessageOnThinking})
+		} else if cfg["MODEL"] == "nvidia/llama-3.3-nemotron-super-49b-v1.5" { // Special case for disabling
+			messages = append(messages, Message{Role: "system", Content: "/no_think"})
+		}
+	}
+
+	effectiveSystem := sysPromptContent
+	if effectiveSystem == "" {
+		effectiveSystem = cf.SystemFor(cfg["MODEL"])
+	}
+	if effectiveSystem != "" {
+		messages = append(messages, Message{Role: "system", Content: effectiveSystem})
+	}
+	if developerPrompt != "" {
+		messages = append(messages, Message{Role: "developer", Content: developerPrompt})
+	}
+	history := *cf
+	history.System = ""
+	return append(messages, history.APIMessages()...)
 }
-type StreamChunk struct {
-	Choices []ChoiceStream `json:"choices"`
+
+// resolveBaseURL returns the API base URL for the active model: --base-url wins,
+// then the model definition's endpoint override, then the default NVIDIA endpoint.
+func resolveBaseURL(cfg map[string]string) string {
+	baseURL := cfg["BASE_URL"]
+	if baseURL == "" {
+		baseURL = GetModelDefinition(cfg["MODEL"]).BaseURL
+	}
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+	return strings.TrimSuffix(baseURL, "/")
 }
 
-func handleStream(respBody io.Reader, convFile string) (string, error) {
-	scanner := bufio.NewScanner(respBody)
-	assistantTextBuf := &bytes.Buffer{}
-	inReasoning := false
+// newChatRequest prepares the chat completions request for the given payload.
+func newChatRequest(cfg map[string]string, payload []byte, accessToken string) (*http.Request, error) {
+	client := nvidiachat.New(nvidiachat.WithBaseURL(resolveBaseURL(cfg)), nvidiachat.WithAPIKey(accessToken))
+	return client.NewHTTPRequest(context.Background(), payload)
+}
 
-	// Ensure scanner can read very long lines if needed
-	const maxCapacity = 1024 * 1024
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, maxCapacity)
+// printDryRun writes the request that would be sent, with the API key redacted.
+func printDryRun(w io.Writer, req *http.Request, payload []byte) {
+	req = req.Clone(req.Context())
+	addExtraHeaders(req)
+	if !sendsKey(req.URL) {
+		req.Header.Del("Authorization")
+	}
+	fmt.Fprintf(w, "%s %s\n", req.Method, req.URL)
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "Authorization" {
+			value = "Bearer [REDACTED]"
+		}
+		fmt.Fprintf(w, "%s: %s\n", name, value)
+	}
+	fmt.Fprintln(w)
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, payload, "", "  "); err != nil {
+		w.Write(payload)
+	} else {
+		pretty.WriteTo(w)
+	}
+	fmt.Fprintln(w)
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		// SSE style: lines may start with "data: "
-		if strings.HasPrefix(line, "data: ") {
-			line = strings.TrimPrefix(line, "data: ")
-		}
-		line = strings.TrimSpace(line)
-		if line == "" {
-			// skip event separators
-			continue
-		}
-		if line == "[DONE]" {
-			continue
+// dryRun builds the request that sending userInput would produce and prints it instead of calling the API.
+// The conversation file, if any, is only read.
+func dryRun(userInput, convFile string, cfg map[string]string, sysPromptContent, accessToken string, out io.Writer) error {
+	cf := &ConversationFile{}
+	if convFile != "" && conversationExists(convFile) {
+		var err error
+		if cf, err = readConversation(convFile); err != nil {
+			return fmt.Errorf("read conversation: %w", err)
 		}
+	}
+	messages := buildMessages(cfg, sysPromptContent, cf)
+	messages = append(messages, Message{Role: "user", Content: userInput})
+	payloadBytes, err := buildPayload(cfg, messages)
+	if err != nil {
+		return fmt.Errorf("build payload: %w", err)
+	}
+	req, err := newChatRequest(cfg, payloadBytes, accessToken)
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	printDryRun(out, req, payloadBytes)
+	return nil
+}
 
-		// Try to parse JSON chunk
-		var chunk StreamChunk
-		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
-			// Not parsable -> skip
-			continue
-		}
-		if len(chunk.Choices) == 0 {
-			continue
-		}
-		choice := chunk.Choices[0]
-		// Try delta.reasoning_content and delta.content
-		var reasoning, content string
-		if choice.Delta != nil {
-			if choice.Delta.ReasoningContent != nil {
-				reasoning = *choice.Delta.ReasoningContent
-			}
-			if choice.Delta.Content != nil {
-				content = *choice.Delta.Content
-			}
-		} else {
-			// fallback: some servers may put content under message
-			if msg := choice.Message; msg != nil {
-				if v, ok := msg["reasoning_content"].(string); ok {
-					reasoning = v
-				}
-				if v, ok := msg["content"].(string); ok {
-					content = v
-				}
+// streamCheckpointInterval is how often a streaming response is saved to the conversation file.
+const streamCheckpointInterval = 2 * time.Second
+
+// checkpointResponse saves the text streamed so far as an incomplete assistant message, replacing
+// the previous checkpoint, so a crash or kill mid-stream keeps the partial answer.
+func checkpointResponse(convFile, text string) error {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		return err
+	}
+	cf.Messages = dropIncomplete(cf.Messages)
+	cf.Messages = append(cf.Messages, Message{Role: "assistant", Content: text, Incomplete: true})
+	return writeConversation(convFile, cf)
+}
+
+// dropIncomplete removes a trailing checkpoint left by checkpointResponse.
+func dropIncomplete(messages []Message) []Message {
+	if n := len(messages); n > 0 && messages[n-1].Incomplete {
+		return messages[:n-1]
+	}
+	return messages
+}
+
+// reasoningMarker returns a marker around the reasoning of a response, in green when out is a
+// terminal. Files and pipes, such as --output, get the plain marker.
+func reasoningMarker(out io.Writer, marker string) string {
+	if isTerminal(out) {
+		return green + marker + normal
+	}
+	return marker
+}
+
+// handleStream prints a streamed response and returns the assistant text, any tool calls and the
+// reported token usage. The response is checkpointed to convFile while it streams.
+func handleStream(respBody io.Reader, convFile string, out io.Writer) (string, []ToolCall, tokenUsage, error) {
+	stream := nvidiachat.NewStreamReader(respBody)
+	assistantTextBuf := &bytes.Buffer{}
+	inReasoning := false
+	var toolCalls []ToolCall
+	var usage tokenUsage
+	lastCheckpoint := time.Now()
+
+	var streamErr error
+	for {
+		d, err := stream.Next()
+		if err != nil {
+			if err != io.EOF {
+				streamErr = err
 			}
+			break
+		}
+		if d.Usage != nil {
+			usage = *d.Usage
 		}
+		toolCalls = nvidiachat.MergeToolCallDeltas(toolCalls, d.ToolCalls)
+		emitChunk(d.Reasoning, d.Content)
 
-		if reasoning != "" {
+		if d.Reasoning != "" {
 			if !inReasoning {
-				fmt.Printf("\n%s\n", green+"[Begin of Assistant Reasoning]"+normal)
+				fmt.Fprintf(out, "\n%s\n", reasoningMarker(out, "[Begin of Assistant Reasoning]"))
 				assistantTextBuf.WriteString("[Begin of Assistant Reasoning]\n")
 				inReasoning = true
 			}
-			// JSON unmarshal already unescaped sequences; print directly
-			fmt.Print(reasoning)
-			assistantTextBuf.WriteString(reasoning)
+			fmt.Fprint(out, d.Reasoning)
+			assistantTextBuf.WriteString(d.Reasoning)
 		}
-		if content != "" {
+		if d.Content != "" {
 			if inReasoning {
-				fmt.Printf("\n%s\n\n", green+"[/End of Assistant Reasoning]"+normal)
+				fmt.Fprintf(out, "\n%s\n\n", reasoningMarker(out, "[/End of Assistant Reasoning]"))
 				assistantTextBuf.WriteString("\n[/End of Assistant Reasoning]\n\n")
 				inReasoning = false
 			}
-			fmt.Print(content)
-			assistantTextBuf.WriteString(content)
+			fmt.Fprint(out, d.Content)
+			assistantTextBuf.WriteString(d.Content)
+		}
+		if convFile != "" && assistantTextBuf.Len() > 0 && time.Since(lastCheckpoint) >= streamCheckpointInterval {
+			// best effort: a failed checkpoint only loses the crash protection
+			checkpointResponse(convFile, assistantTextBuf.String())
+			lastCheckpoint = time.Now()
 		}
 	}
 
 	if inReasoning {
-		fmt.Printf("\n%s\n\n", green+"[/End of Assistant Reasoning]"+normal)
+		fmt.Fprintf(out, "\n%s\n\n", reasoningMarker(out, "[/End of Assistant Reasoning]"))
 		assistantTextBuf.WriteString("\n[/End of Assistant Reasoning]\n\n")
 		inReasoning = false
 	}
+	emitUsage(usage)
 
-	if err := scanner.Err(); err != nil {
+	if streamErr != nil {
 		// Non-fatal; return what we have
-		return assistantTextBuf.String(), err
+		return assistantTextBuf.String(), toolCalls, usage, streamErr
 	}
 
-	fmt.Println()
-	return assistantTextBuf.String(), nil
+	fmt.Fprintln(out)
+	return assistantTextBuf.String(), toolCalls, usage, nil
 }
 
-func handleNonStream(body []byte) (string, error) {
-	// try to extract .choices[0].delta.reasoning_content or .choices[0].message.reasoning_content and content fields
-	var j map[string]interface{}
-	if err := json.Unmarshal(body, &j); err != nil {
-		return "", err
-	}
-	var reasoning string
-	var content string
-
-	if choices, ok := j["choices"].([]interface{}); ok && len(choices) > 0 {
-		if first, ok := choices[0].(map[string]interface{}); ok {
-			// delta.reasoning_content
-			if delta, ok := first["delta"].(map[string]interface{}); ok {
-				if rc, ok := delta["reasoning_content"].(string); ok {
-					reasoning = rc
-				}
-				if c, ok := delta["content"].(string); ok {
-					content = c
-				}
-			}
-			// fallback: message.reasoning_content
-			if msg, ok := first["message"].(map[string]interface{}); ok {
-				if rc, ok := msg["reasoning_content"].(string); ok && reasoning == "" {
-					reasoning = rc
-				}
-				if c, ok := msg["content"].(string); ok && content == "" {
-					content = c
-				}
-			}
-		}
+// handleNonStream prints a complete response and returns the assistant text, any tool calls and
+// the reported token usage.
+func handleNonStream(body []byte, out io.Writer) (string, []ToolCall, tokenUsage, error) {
+	reply, err := nvidiachat.ParseResponse(body)
+	if err != nil {
+		return "", nil, tokenUsage{}, err
 	}
+	reasoning, content, toolCalls, usage := reply.Reasoning, reply.Content, reply.ToolCalls, reply.Usage
+	emitChunk(reasoning, content)
+	emitUsage(usage)
 
 	outBuf := &bytes.Buffer{}
 	if reasoning != "" {
-		fmt.Printf("\n%s\n", green+"[Begin of Assistant Reasoning]"+normal)
-		fmt.Print(reasoning)
-		fmt.Printf("\n%s\n\n", green+"[/End of Assistant Reasoning]"+normal)
+		fmt.Fprintf(out, "\n%s\n", reasoningMarker(out, "[Begin of Assistant Reasoning]"))
+		fmt.Fprint(out, reasoning)
+		fmt.Fprintf(out, "\n%s\n\n", reasoningMarker(out, "[/End of Assistant Reasoning]"))
 		outBuf.WriteString("[Begin of Assistant Reasoning]\n")
 		outBuf.WriteString(reasoning)
 		outBuf.WriteString("\n[End of Assistant Reasoning]\n\n")
 	}
 	if content != "" {
-		fmt.Print(content)
+		fmt.Fprint(out, content)
 		outBuf.WriteString(content)
 	}
-	if outBuf.Len() == 0 {
+	if outBuf.Len() == 0 && len(toolCalls) == 0 {
 		// no assistant content parsed; print raw
-		fmt.Printf("%s\n", string(body))
-		return "", errors.New("no assistant content parsed from response")
+		fmt.Fprintf(out, "%s\n", string(body))
+		return "", nil, usage, errors.New("no assistant content parsed from response")
+	}
+	return outBuf.String(), toolCalls, usage, nil
+}
+
+// streamCompletion sends messages with the settings in cfg, streams the reply to out and returns
+// its text and token usage. It is used by the commands that drive conversations themselves.
+func streamCompletion(cfg map[string]string, messages []Message, out io.Writer) (string, tokenUsage, error) {
+	if err := checkBudget(nil, messages); err != nil {
+		return "", tokenUsage{}, err
+	}
+	payloadBytes, err := buildPayload(cfg, messages)
+	if err != nil {
+		return "", tokenUsage{}, fmt.Errorf("build payload: %w", err)
+	}
+	req, err := newChatRequest(cfg, payloadBytes, apiKeys.Current())
+	if err != nil {
+		return "", tokenUsage{}, err
+	}
+	resp, err := sendChatRequest(cfg, req)
+	if err != nil {
+		return "", tokenUsage{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", tokenUsage{}, newAPIError(resp, body)
 	}
-	return outBuf.String(), nil
+	w := newStreamWriter(out, cfg)
+	text, _, usage, err := handleStream(resp.Body, "", w)
+	flushOutput(w)
+	recordUsage(usage)
+	return text, usage, err
 }
 
 // processMessage sends the given userInput as a user message, calls the API (stream or non-stream),
-// prints the assistant output and persists the assistant message to convFile.
-func processMessage(userInput, convFile string, cfg map[string]string, sysPromptContent, accessToken string) error {
+// writes the assistant output to out and persists the assistant message to convFile.
+func processMessage(userInput, convFile string, cfg map[string]string, sysPromptContent, accessToken string, out io.Writer) (err error) {
+	span := startSpan("chat.message")
+	span.set("model", cfg["MODEL"])
+	defer func() { span.finish(err) }()
+
 	// append user message
+	persist := startSpan("conversation.persist")
 	if err := appendMessage(convFile, "user", userInput); err != nil {
 		return fmt.Errorf("append user message: %w", err)
 	}
@@ -698,110 +1021,53 @@ func processMessage(userInput, convFile string, cfg map[string]string, sysPrompt
 	}
 	limit, _ := strconv.Atoi(cfg["HISTORY_LIMIT"])
 	if count > limit {
-		return fmt.Errorf("after adding your message, the conversation file exceeded the limit (%d)", limit)
+		return fmt.Errorf("%w: after adding your message, the conversation file exceeded the limit (%d)", errHistoryLimitExceeded, limit)
 	}
+	persist.finish(nil)
 
-	// Determine effective system prompt: precedence -s content > persisted .system in file > none
-	effectiveSystem := sysPromptContent
-	if effectiveSystem == "" {
-		cf, err := readConversation(convFile)
-		if err == nil {
-			effectiveSystem = cf.System
+	return completeOrQueue(convFile, cfg, sysPromptContent, accessToken, out, nil)
+}
+
+// conversationDir returns the directory where new conversation files are created: dir in the
+// [conversations] section of the config file, or nvidia-chat in the cache directory.
+func conversationDir() string {
+	if dir := userConfig["conversations.dir"]; dir != "" {
+		if strings.HasPrefix(dir, "~/") {
+			dir = filepath.Join(os.Getenv("HOME"), dir[2:])
 		}
+		return dir
+	}
+	hdir := os.Getenv("XDG_CACHE_HOME")
+	if hdir == "" {
+		hdir = filepath.Join(os.Getenv("HOME"), ".cache")
 	}
+	return filepath.Join(hdir, "nvidia-chat")
+}
 
-	// Build messages: prepend system prompt if non-empty, then .messages
-	cf2, err := readConversation(convFile)
-	if err != nil {
-		return fmt.Errorf("read conversation: %w", err)
+// configDir returns the user configuration directory for nvidia-chat.
+func configDir() string {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		base = filepath.Join(os.Getenv("HOME"), ".config")
 	}
-	var messages []Message
+	return filepath.Join(base, "nvidia-chat")
+}
 
-	// Handle special thinking-related system messages
-	modelDef := GetModelDefinition(cfg["MODEL"])
-	if modelDef.PrependedSystemMessageOnThinking != "" {
-		thinkingEnabled, _ := strconv.ParseBool(cfg["THINKING"])
-		if thinkingEnabled {
-			messages = append(messages, Message{Role: "system", Content: modelDef.PrependedSystemMessageOnThinking})
-		} else if cfg["MODEL"] == "nvidia/llama-3.3-nemotron-super-49b-v1.5" { // Special case for disabling
-			messages = append(messages, Message{Role: "system", Content: "/no_think"})
+func getAPIKeyFromEnv() string {
+	for _, n := range apiEnvNames {
+		if v := os.Getenv(n); v != "" {
+			return v
 		}
 	}
+	return ""
+}
 
-	if effectiveSystem != "" {
-		messages = append(messages, Message{Role: "system", Content: effectiveSystem})
+func readSingleLine(reader io.Reader, delimiters []string, trimDelimiter bool) (string, error) {
+	if reader == nil {
+		reader = os.Stdin
 	}
-	messages = append(messages, cf2.Messages...)
-
-	// Build payload
-	payloadBytes, err := buildPayload(cfg, messages)
-	if err != nil {
-		return fmt.Errorf("build payload: %w", err)
-	}
-
-	// Prepare HTTP request
-	url := cfg["BASE_URL"] + "/chat/completions"
-	req, _ := http.NewRequest("POST", url, bytes.NewReader(payloadBytes))
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: 0}
-	if cfg["STREAM"] == "true" {
-		// streaming mode
-		resp, err := client.Do(req)
-		if err != nil {
-			return fmt.Errorf("request failed: %w", err)
-		}
-		if resp.StatusCode >= 400 {
-			body, _ := ioutil.ReadAll(resp.Body)
-			resp.Body.Close()
-			return fmt.Errorf("api error: %s\n%s", resp.Status, string(body))
-		}
-		assistantText, err := handleStream(resp.Body, convFile)
-		resp.Body.Close()
-		if assistantText != "" {
-			if err2 := appendMessage(convFile, "assistant", assistantText); err2 != nil {
-				// non-fatal append error, but surface it
-				return fmt.Errorf("append assistant message: %w", err2)
-			}
-		}
-		return err
-	} else {
-		// non-streaming mode
-		resp, err := client.Do(req)
-		if err != nil {
-			return fmt.Errorf("request failed: %w", err)
-		}
-		body, _ := ioutil.ReadAll(resp.Body)
-		resp.Body.Close()
-		if resp.StatusCode >= 400 {
-			return fmt.Errorf("api error: %s\n%s", resp.Status, string(body))
-		}
-		assistantText, _ := handleNonStream(body)
-		if assistantText != "" {
-			if err := appendMessage(convFile, "assistant", assistantText); err != nil {
-				return fmt.Errorf("append assistant message: %w", err)
-			}
-		}
-		return nil
-	}
-}
-
-func getAPIKeyFromEnv() string {
-	for _, n := range apiEnvNames {
-		if v := os.Getenv(n); v != "" {
-			return v
-		}
-	}
-	return ""
-}
-
-func readSingleLine(reader io.Reader, delimiters []string, trimDelimiter bool) (string, error) {
-	if reader == nil {
-		reader = os.Stdin
-	}
-	if len(delimiters) == 0 {
-		delimiters = []string{"\r\n", "\r", "\n"}
+	if len(delimiters) == 0 {
+		delimiters = []string{"\r\n", "\r", "\n"}
 	}
 	br := bufio.NewReader(reader)
 	var line bytes.Buffer
@@ -814,6 +1080,9 @@ func readSingleLine(reader io.Reader, delimiters []string, trimDelimiter bool) (
 					return "", io.EOF
 				}
 				// Return last partial line along with EOF
+				if reader == os.Stdin {
+					recordSessionInput(line.String())
+				}
 				return line.String(), io.EOF
 			}
 			return "", err
@@ -822,6 +1091,9 @@ func readSingleLine(reader io.Reader, delimiters []string, trimDelimiter bool) (
 		for _, delim := range delimiters {
 			delimBytes := []byte(delim)
 			if bytes.HasSuffix(line.Bytes(), delimBytes) {
+				if reader == os.Stdin {
+					recordSessionInput(line.String())
+				}
 				resultBytes := line.Bytes()
 				if trimDelimiter {
 					resultBytes = bytes.TrimSuffix(resultBytes, delimBytes)
@@ -866,36 +1138,166 @@ func readLines(reader io.Reader, delimiters []string, trimDelimiter bool) ([]str
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
+	// read early for the conversation directory; errors are reported once the subcommands are handled
+	if conf, err := loadConfigFile(userConfigPath()); err == nil {
+		userConfig = conf
+	}
+
+	// `models update` regenerates the catalog from the built-in definitions alone, and `nim add`
+	// registers models on top of them
+	if len(os.Args) > 1 && os.Args[1] == "models" {
+		os.Exit(runModelsCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "nim" {
+		os.Exit(runNIMCommand(os.Args[2:]))
+	}
+	if _, err := os.Stat(catalogPath()); err == nil {
+		if err := loadModelDefinitionsFile(catalogPath()); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to load the model catalog: %v%s\n", red, err, normal)
+			fmt.Fprintln(os.Stderr, "Run `nvidia-chat models update` to regenerate it.")
+			os.Exit(exitUsage)
+		}
+	}
+	if err := loadUserModelDefinitions(userModelsDir()); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to load model definitions: %v%s\n", red, err, normal)
+		os.Exit(exitUsage)
+	}
+
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "auth":
+			os.Exit(runAuthCommand(os.Args[2:]))
+		case "serve":
+			os.Exit(runServeCommand(os.Args[2:]))
+		case "index":
+			os.Exit(runIndexCommand(os.Args[2:]))
+		case "ctl":
+			os.Exit(runCtlCommand(os.Args[2:]))
+		case "bench":
+			os.Exit(runBenchCommand(os.Args[2:]))
+		case "roundtable":
+			os.Exit(runRoundTableCommand(os.Args[2:]))
+		case "flush":
+			os.Exit(runFlushCommand(os.Args[2:]))
+		case "replay":
+			os.Exit(runReplayCommand(os.Args[2:]))
+		case "commitmsg":
+			os.Exit(runCommitMsgCommand(os.Args[2:]))
+		case "pipeline":
+			os.Exit(runPipelineCommand(os.Args[2:]))
+		case "stats":
+			os.Exit(runStatsCommand(os.Args[2:]))
+		case "gc":
+			os.Exit(runGCCommand(os.Args[2:]))
+		case "sync":
+			os.Exit(runSyncCommand(os.Args[2:]))
+		case "backup":
+			os.Exit(runBackupCommand(os.Args[2:]))
+		}
+	}
+
+	if conf, err := loadConfigFile(userConfigPath()); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to read config: %v%s\n", red, err, normal)
+		os.Exit(exitUsage)
+	} else {
+		userConfig = conf
+	}
+
+	// Ctrl+C cancels the requests in flight, which then return through the normal path: the partial
+	// response is saved and flushed, and --prompt mode exits with exitUserAbort. With no request
+	// in flight it ends the program with that exit code.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		for range interrupts {
+			fmt.Fprintln(os.Stderr)
+			if interruptRequests() {
+				continue
+			}
+			closeControlSocket()
+			stopStatusLine()
+			stopSessionRecording()
+			os.Exit(exitUserAbort)
+		}
+	}()
 	// Default cfg map
 	cfg := map[string]string{
-		"BASE_URL":          defaultBaseURL,
-		"MODEL":             defaultModel,
-		"TEMPERATURE":       defaultTemperature,
-		"TOP_P":             defaultTopP,
-		"FREQUENCY_PENALTY": defaultFrequency,
-		"PRESENCE_PENALTY":  defaultPresence,
-		"MAX_TOKENS":        defaultMaxTokens,
-		"STREAM":            defaultStream,
-		"REASONING_EFFORT":  defaultReasoning,
-		"STOP":              defaultStop,
-		"HISTORY_DIR":       filepath.Join(os.Getenv("HOME"), defaultHistorySubdir),
-		"HISTORY_LIMIT":     fmt.Sprintf("%d", defaultHistoryLimit),
-	}
+		"BASE_URL":            "", // empty: the model's endpoint override, else defaultBaseURL
+		"MODEL":               defaultModel,
+		"TEMPERATURE":         defaultTemperature,
+		"TOP_P":               defaultTopP,
+		"FREQUENCY_PENALTY":   defaultFrequency,
+		"PRESENCE_PENALTY":    defaultPresence,
+		"MAX_TOKENS":          defaultMaxTokens,
+		"STREAM":              defaultStream,
+		"REASONING_EFFORT":    defaultReasoning,
+		"STOP":                defaultStop,
+		"HISTORY_DIR":         filepath.Join(os.Getenv("HOME"), defaultHistorySubdir),
+		"HISTORY_LIMIT":       fmt.Sprintf("%d", defaultHistoryLimit),
+		"TIMEOUT":             defaultTimeout,
+		"CONNECT_TIMEOUT":     defaultConnectTimeout,
+		"IDLE_TIMEOUT":        defaultIdleTimeout,
+		"MAX_RETRIES":         defaultMaxRetries,
+		"CACHE":               "false",
+		"CACHE_TTL":           defaultCacheTTL,
+		"EXTRA_BODY":          "",
+		"TOOL_CHOICE":         "", // empty: the backend's default (auto)
+		"PARALLEL_TOOL_CALLS": "",
+		"STATUS_LINE":         "true",
+		"ORGANIZATION":        "",
+		"PROJECT":             "",
+		"EMBEDDINGS_URL":      "", // empty: the NVIDIA endpoint, whatever BASE_URL is
+	}
+	autosaveSettings = userConfig["settings.autosave"] == "true"
 
 	// -----------------------
 	// Parse options (robust)
 	// -----------------------
 	provided := map[string]bool{}
-	rawArgs := os.Args[1:]
+	rawArgs, err := normalizeArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+		fmt.Fprintln(os.Stderr, "Run nvidia-chat --help for the list of options.")
+		os.Exit(exitUsage)
+	}
 	var positionalArgs []string
 
 	ACCESS_TOKEN := ""
-	SYS_PROMPT_FILE := ""
+	var ACCESS_TOKENS []string // -k may be repeated for key failover
+	PROFILE := defaultProfile
+	MCP_CONFIG := ""
+	NO_MCP := false
+	NO_PROJECT := false
+	RAG_INDEX := ""
+	CONTROL_SOCKET := ""
+	NO_CONTROL_SOCKET := false
+	var SYSTEM_SOURCES []systemSource
+	var DEV_SOURCES []systemSource // --dev-prompt-file
 	PERSIST_SYSTEM := false
 	SAVE_SETTINGS := false
 	LIST_ONLY := false
+	MODEL_FILTER := ""
 	PROMPT_MODE := ""     // for --prompt
 	MODEL_INFO_FLAG := "" // for --modelinfo
+	var ATTACH_FILES []string
+	GIT_DIFF := false
+	RECORD_SESSION := ""
+	PICK_CONVERSATION := false
+	NEW_CONVERSATION := false
+	READ_ONLY := false
+	EPHEMERAL := false
+	STDIO_JSON := false
+	JSON_STREAM := false
+	RAW_OUTPUT := false
+	GIT_DIFF_STAGED := false
+	OUTPUT_FILE := ""   // for --output
+	TEE_FILE := ""      // for --tee
+	TEMPLATE_NAME := "" // for --template
+	var TEMPLATE_VARS []string
+	var HEADERS []string // for --header
+	LOG_FILE, LOG_LEVEL, LOG_MAX_SIZE := "", "", ""
+	APPEND_OUTPUT := false
 
 	// helper to get next argument (used when flag and its value are separate tokens)
 	nextArg := func(i *int) (string, error) {
@@ -916,16 +1318,15 @@ func main() {
 			break
 		}
 
-		if !strings.HasPrefix(a, "-") {
+		if a == "-" || !strings.HasPrefix(a, "-") {
 			positionalArgs = append(positionalArgs, a)
 			i++
 			continue
 		}
 
-		// at this point, 'a' is a flag
+		// at this point, 'a' is a flag; normalizeArgs has split off values except for --stream=BOOL
 		key := a
 		val := ""
-		// handle --flag=value and -f=value
 		if strings.Contains(a, "=") {
 			parts := strings.SplitN(a, "=", 2)
 			key = parts[0]
@@ -939,7 +1340,7 @@ func main() {
 				v, err := nextArg(&i)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
-					os.Exit(1)
+					os.Exit(exitUsage)
 				}
 				val = v
 			}
@@ -950,7 +1351,7 @@ func main() {
 				v, err := nextArg(&i)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
-					os.Exit(1)
+					os.Exit(exitUsage)
 				}
 				val = v
 			}
@@ -961,7 +1362,7 @@ func main() {
 				v, err := nextArg(&i)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
-					os.Exit(1)
+					os.Exit(exitUsage)
 				}
 				val = v
 			}
@@ -972,7 +1373,7 @@ func main() {
 				v, err := nextArg(&i)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
-					os.Exit(1)
+					os.Exit(exitUsage)
 				}
 				val = v
 			}
@@ -983,7 +1384,7 @@ func main() {
 				v, err := nextArg(&i)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
-					os.Exit(1)
+					os.Exit(exitUsage)
 				}
 				val = v
 			}
@@ -994,7 +1395,7 @@ func main() {
 				v, err := nextArg(&i)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
-					os.Exit(1)
+					os.Exit(exitUsage)
 				}
 				val = v
 			}
@@ -1005,7 +1406,7 @@ func main() {
 				v, err := nextArg(&i)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
-					os.Exit(1)
+					os.Exit(exitUsage)
 				}
 				val = v
 			}
@@ -1016,28 +1417,57 @@ func main() {
 				v, err := nextArg(&i)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
-					os.Exit(1)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			SYSTEM_SOURCES = append(SYSTEM_SOURCES, systemFileSource(val))
+		case "--dev-prompt-file":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			DEV_SOURCES = append(DEV_SOURCES, systemFileSource(val))
+		case "--system-text", "--system-url":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
 				}
 				val = v
 			}
-			SYS_PROMPT_FILE = val
-			provided["SYS_PROMPT_FILE"] = true
+			SYSTEM_SOURCES = append(SYSTEM_SOURCES, systemSource{strings.TrimPrefix(key, "--system-"), val})
 		case "-k", "--access-token":
 			if val == "" {
 				v, err := nextArg(&i)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
-					os.Exit(1)
+					os.Exit(exitUsage)
 				}
 				val = v
 			}
-			ACCESS_TOKEN = val
+			ACCESS_TOKENS = append(ACCESS_TOKENS, val)
+		case "--profile":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			PROFILE = val
 		case "--reasoning":
 			if val == "" {
 				v, err := nextArg(&i)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
-					os.Exit(1)
+					os.Exit(exitUsage)
 				}
 				val = v
 			}
@@ -1048,7 +1478,7 @@ func main() {
 				v, err := nextArg(&i)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
-					os.Exit(1)
+					os.Exit(exitUsage)
 				}
 				val = v
 			}
@@ -1059,7 +1489,7 @@ func main() {
 				v, err := nextArg(&i)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
-					os.Exit(1)
+					os.Exit(exitUsage)
 				}
 				val = v
 			}
@@ -1069,11 +1499,285 @@ func main() {
 				v, err := nextArg(&i)
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
-					os.Exit(1)
+					os.Exit(exitUsage)
 				}
 				val = v
 			}
 			MODEL_INFO_FLAG = val
+		case "--file":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			ATTACH_FILES = append(ATTACH_FILES, val)
+		case "--output":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			OUTPUT_FILE = val
+		case "--tee":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			TEE_FILE = val
+		case "--header":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			HEADERS = append(HEADERS, val)
+		case "--log-file", "--log-level", "--log-max-size":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			switch key {
+			case "--log-file":
+				LOG_FILE = val
+			case "--log-level":
+				LOG_LEVEL = val
+			default:
+				LOG_MAX_SIZE = val
+			}
+		case "--template":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			TEMPLATE_NAME = val
+		case "--var":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			TEMPLATE_VARS = append(TEMPLATE_VARS, val)
+		case "--base-url":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			cfg["BASE_URL"] = val
+			provided["BASE_URL"] = true
+		case "--timeout":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			cfg["TIMEOUT"] = val
+			provided["TIMEOUT"] = true
+		case "--cache-ttl":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			cfg["CACHE_TTL"] = val
+			provided["CACHE_TTL"] = true
+		case "--extra-body":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			if strings.HasPrefix(val, "@") {
+				b, err := ioutil.ReadFile(val[1:])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%sCannot read %s: %v%s\n", red, val[1:], err, normal)
+					os.Exit(exitUsage)
+				}
+				val = string(b)
+			}
+			cfg["EXTRA_BODY"] = val
+			provided["EXTRA_BODY"] = true
+		case "--connect-timeout":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			cfg["CONNECT_TIMEOUT"] = val
+			provided["CONNECT_TIMEOUT"] = true
+		case "--idle-timeout":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			cfg["IDLE_TIMEOUT"] = val
+			provided["IDLE_TIMEOUT"] = true
+		case "--max-retries":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			cfg["MAX_RETRIES"] = val
+			provided["MAX_RETRIES"] = true
+		case "--mcp-config":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			MCP_CONFIG = val
+		case "--control-socket":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			CONTROL_SOCKET = val
+		case "--record-session":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			RECORD_SESSION = val
+		case "--record", "--replay":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			if key == "--record" {
+				recording = newExchangeLog(val)
+			} else {
+				replaying = newExchangeLog(val)
+			}
+		case "--filter":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			MODEL_FILTER = val
+		case "--rag":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			RAG_INDEX = val
+		case "--rag-top-k":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				fmt.Fprintf(os.Stderr, "%sInvalid --rag-top-k (>= 1): %s%s\n", red, val, normal)
+				os.Exit(exitUsage)
+			}
+			ragTopK = n
+		case "--organization", "--project":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			name := strings.ToUpper(strings.TrimPrefix(key, "--"))
+			cfg[name] = val
+			provided[name] = true
+		case "--tool-choice":
+			if val == "" {
+				v, err := nextArg(&i)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+					os.Exit(exitUsage)
+				}
+				val = v
+			}
+			cfg["TOOL_CHOICE"] = val
+			provided["TOOL_CHOICE"] = true
+		case "--parallel-tool-calls":
+			if val == "" {
+				val = "true"
+			}
+			if _, err := strconv.ParseBool(val); err != nil {
+				fmt.Fprintf(os.Stderr, "%sInvalid value for --parallel-tool-calls: %s. Use true or false.%s\n", red, val, normal)
+				os.Exit(exitUsage)
+			}
+			cfg["PARALLEL_TOOL_CALLS"] = val
+			provided["PARALLEL_TOOL_CALLS"] = true
 		case "--stream":
 			if val == "true" {
 				cfg["STREAM"] = "true"
@@ -1081,7 +1785,7 @@ func main() {
 				cfg["STREAM"] = "false"
 			} else {
 				fmt.Fprintf(os.Stderr, "%sInvalid value for --stream: %s. Use true or false.%s\n", red, val, normal)
-				os.Exit(1)
+				os.Exit(exitUsage)
 			}
 			provided["STREAM"] = true
 
@@ -1093,26 +1797,207 @@ func main() {
 			provided["STREAM"] = true
 		case "--save-settings":
 			SAVE_SETTINGS = true
+		case "--append":
+			APPEND_OUTPUT = true
+		case "--dry-run":
+			cfg["DRY_RUN"] = "true"
+		case "-u", "--unbuffered":
+			cfg["UNBUFFERED"] = "true"
+		case "--cache":
+			cfg["CACHE"] = "true"
+			provided["CACHE"] = true
+		case "--no-cache":
+			cfg["CACHE"] = "false"
+			provided["CACHE"] = true
+		case "--no-mcp":
+			NO_MCP = true
+		case "--no-status-line":
+			cfg["STATUS_LINE"] = "false"
+			provided["STATUS_LINE"] = true
+		case "--no-project":
+			NO_PROJECT = true
+		case "--pick":
+			PICK_CONVERSATION = true
+		case "--new":
+			NEW_CONVERSATION = true
+		case "--read-only":
+			READ_ONLY = true
+		case "--ephemeral":
+			EPHEMERAL = true
+		case "--allow-http-system-url":
+			allowHTTPSystemURL = true
+		case "--stdio-json":
+			STDIO_JSON = true
+		case "--json-stream":
+			JSON_STREAM = true
+		case "--raw":
+			RAW_OUTPUT = true
+		case "--git-diff":
+			GIT_DIFF = true
+		case "--staged":
+			GIT_DIFF_STAGED = true
+		case "--agent":
+			setAgentMode(true)
+		case "--no-control-socket":
+			NO_CONTROL_SOCKET = true
 		case "-l", "--list":
 			LIST_ONLY = true
 		case "-h", "--help":
 			printHelp(cfg)
 			return
 		default:
-			fmt.Fprintf(os.Stderr, "Unknown option: %s\n", a)
-			printHelp(cfg)
-			os.Exit(1)
+			// --<setting> for any model parameter, e.g. --seed 42; validated with the model's settings
+			v, err := nextArg(&i)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+				os.Exit(exitUsage)
+			}
+			name := strings.ToUpper(strings.ReplaceAll(strings.TrimPrefix(key, "--"), "-", "_"))
+			if provided[name] && isIntMapSetting(name) {
+				v = cfg[name] + "," + v // repeated pairs, e.g. --logit-bias 1:-100 --logit-bias 2:5
+			}
+			cfg[name] = v
+			provided[name] = true
 		}
 		i++
 	}
 	args := positionalArgs
 
+	// settings precedence: defaults < config file < conversation file < environment < flags
+	recordSettingSource(provided, sourceFlag)
+	if err := resolveSettings(cfg, provided); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		os.Exit(exitUsage)
+	}
+
+	// project configuration: settings not given on the command line
+	var project *projectConfig
+	if wd, err := os.Getwd(); err == nil && !NO_PROJECT {
+		if path := findProjectConfig(wd); path != "" {
+			if project, err = loadProjectConfig(path); err != nil {
+				fmt.Fprintf(os.Stderr, "%sFailed to read project config: %v%s\n", red, err, normal)
+				os.Exit(exitUsage)
+			}
+			if project.Model != "" {
+				applySetting(cfg, "MODEL", project.Model, sourceConfigFile, project.Path)
+			}
+			if project.SystemPromptFile != "" && len(SYSTEM_SOURCES) == 0 {
+				SYSTEM_SOURCES = []systemSource{{"file", project.SystemPromptFile}}
+			}
+			if RAG_INDEX == "" {
+				RAG_INDEX = project.RAGIndex
+			}
+			if project.ConversationFile != "" && len(args) == 0 && !EPHEMERAL {
+				args = []string{project.ConversationFile}
+			}
+		}
+	}
+
+	if err := validateHTTPSettings(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+		os.Exit(exitUsage)
+	}
+	if _, err := loadBudget(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+		os.Exit(exitUsage)
+	}
+	if _, err := loadRedactionRules(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+		os.Exit(exitUsage)
+	}
+	if _, err := parseDurationSetting(cfg["CACHE_TTL"]); err != nil {
+		fmt.Fprintf(os.Stderr, "%sInvalid cache ttl: %v%s\n", red, err, normal)
+		os.Exit(exitUsage)
+	}
+	if _, err := parseExtraBody(cfg["EXTRA_BODY"]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		os.Exit(exitUsage)
+	}
+	if recording != nil && replaying != nil {
+		fmt.Fprintf(os.Stderr, "%s--record and --replay cannot be combined.%s\n", red, normal)
+		os.Exit(exitUsage)
+	}
+	if replaying != nil && !fileExists(replaying.dir) {
+		fmt.Fprintf(os.Stderr, "%sReplay directory not found: %s%s\n", red, replaying.dir, normal)
+		os.Exit(exitUsage)
+	}
+	if GIT_DIFF_STAGED && !GIT_DIFF {
+		fmt.Fprintf(os.Stderr, "%s--staged requires --git-diff.%s\n", red, normal)
+		os.Exit(exitUsage)
+	}
+	gitDiff := ""
+	if GIT_DIFF {
+		text, summary, err := gitDiffAttachment(GIT_DIFF_STAGED)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sCannot attach the git diff: %v%s\n", red, err, normal)
+			os.Exit(exitUsage)
+		}
+		gitDiff = text
+		fmt.Fprintf(os.Stderr, "Attaching the git diff (%s)\n", summary)
+	}
+	if len(TEMPLATE_VARS) > 0 && TEMPLATE_NAME == "" {
+		fmt.Fprintf(os.Stderr, "%s--var requires --template.%s\n", red, normal)
+		os.Exit(exitUsage)
+	}
+	// A template alone is enough to run in non-interactive mode
+	promptRequested := PROMPT_MODE != "" || TEMPLATE_NAME != ""
+
+	if EPHEMERAL && (promptRequested || READ_ONLY || PICK_CONVERSATION || len(args) > 0) {
+		fmt.Fprintf(os.Stderr, "%s--ephemeral starts a new interactive conversation and cannot be used with a conversation file, --prompt, --pick or --read-only.%s\n", red, normal)
+		os.Exit(exitUsage)
+	}
+	if STDIO_JSON && (promptRequested || PICK_CONVERSATION || RECORD_SESSION != "" || len(ATTACH_FILES) > 0 || GIT_DIFF || findTool(shellToolName) != nil) {
+		fmt.Fprintf(os.Stderr, "%s--stdio-json reads its requests on stdin and cannot be used with --prompt, --pick, --record-session, --file, --git-diff or --agent.%s\n", red, normal)
+		os.Exit(exitUsage)
+	}
+	if PICK_CONVERSATION && NEW_CONVERSATION {
+		fmt.Fprintf(os.Stderr, "%s--pick and --new cannot be combined.%s\n", red, normal)
+		os.Exit(exitUsage)
+	}
+	if RECORD_SESSION != "" && promptRequested {
+		fmt.Fprintf(os.Stderr, "%s--record-session records interactive sessions and cannot be used with --prompt.%s\n", red, normal)
+		os.Exit(exitUsage)
+	}
+	if (OUTPUT_FILE != "" || APPEND_OUTPUT) && !promptRequested {
+		fmt.Fprintf(os.Stderr, "%s--output and --append can only be used with --prompt.%s\n", red, normal)
+		os.Exit(exitUsage)
+	}
+	if JSON_STREAM && (!promptRequested || OUTPUT_FILE != "" && OUTPUT_FILE != "-") {
+		fmt.Fprintf(os.Stderr, "%s--json-stream writes the response of --prompt as JSON events on stdout and cannot be used without --prompt or with --output FILE.%s\n", red, normal)
+		os.Exit(exitUsage)
+	}
+	if RAW_OUTPUT && (!promptRequested || JSON_STREAM) {
+		fmt.Fprintf(os.Stderr, "%s--raw prints the API responses of --prompt and cannot be used without --prompt or with --json-stream.%s\n", red, normal)
+		os.Exit(exitUsage)
+	}
+	if APPEND_OUTPUT && (OUTPUT_FILE == "" || OUTPUT_FILE == "-") {
+		fmt.Fprintf(os.Stderr, "%s--append requires --output FILE.%s\n", red, normal)
+		os.Exit(exitUsage)
+	}
+	if err := loadExtraHeaders(cfg, HEADERS); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		os.Exit(exitUsage)
+	}
+	if err := openAPILog(LOG_FILE, LOG_LEVEL, LOG_MAX_SIZE); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to open the API log: %v%s\n", red, err, normal)
+		os.Exit(exitUsage)
+	}
+	if TEE_FILE != "" {
+		if err := startTee(TEE_FILE); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to open the --tee file: %v%s\n", red, err, normal)
+			os.Exit(exitGeneral)
+		}
+	}
+
 	// If list requested
 	if LIST_ONLY {
-		fmt.Printf("%sSupported models (built-in subset):%s\n", bold, normal)
-		for _, m := range modelsList {
-			fmt.Printf("  %s\n", m)
+		filter, err := parseModelFilter(MODEL_FILTER)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sInvalid --filter: %v%s\n", red, err, normal)
+			os.Exit(exitUsage)
 		}
+		fmt.Printf("%sSupported models (built-in subset):%s\n", bold, normal)
+		fmt.Print(formatModelList(filter))
 		fmt.Println()
 		fmt.Println("View the full models list and details at: https://build.nvidia.com/")
 		return
@@ -1124,13 +2009,38 @@ func main() {
 		return
 	}
 
-	// API key selection from env if not provided
-	if ACCESS_TOKEN == "" {
-		ACCESS_TOKEN = getAPIKeyFromEnv()
+	// API key selection: -k flags, then the profile's keys in the OS keyring (auth login), then env
+	keys, _ := collectAPIKeys(ACCESS_TOKENS, PROFILE)
+	// the wizard sets up a build.nvidia.com key, which self-hosted endpoints do not need
+	if replaying == nil && cfg["DRY_RUN"] != "true" && resolveBaseURL(cfg) == defaultBaseURL && setupNeeded(keys) {
+		key, model, err := runSetupWizard(cfg, PROFILE)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sSetup stopped: %v%s\n", red, err, normal)
+			os.Exit(exitAuth)
+		}
+		keys = []string{key}
+		applySetting(cfg, "MODEL", model, sourceConfigFile, "[settings] model")
+	}
+	apiKeys = newKeyPool(keys)
+	ACCESS_TOKEN = apiKeys.Current()
+	if ACCESS_TOKEN == "" && replaying != nil {
+		// recorded responses are served without the API, so no key is needed
+		apiKeys = newKeyPool([]string{"replay"})
+		ACCESS_TOKEN = apiKeys.Current()
 	}
-	if ACCESS_TOKEN == "" {
-		fmt.Fprintf(os.Stderr, "%sNo API key provided.%s Set NVIDIA_BUILD_AI_ACCESS_TOKEN or pass -k ACCESS_TOKEN\n", red, normal)
-		os.Exit(1)
+	// self-hosted endpoints, such as a NIM registered with `nim add`, usually need no key
+	if ACCESS_TOKEN == "" && cfg["DRY_RUN"] != "true" && resolveBaseURL(cfg) == defaultBaseURL {
+		fmt.Fprintf(os.Stderr, "%sNo API key provided.%s Run `nvidia-chat auth login`, set NVIDIA_BUILD_AI_ACCESS_TOKEN or pass -k ACCESS_TOKEN\n", red, normal)
+		os.Exit(exitAuth)
+	}
+
+	if RAG_INDEX != "" {
+		idx, err := loadIndex(RAG_INDEX)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to load index %s: %v%s\n", red, RAG_INDEX, err, normal)
+			os.Exit(exitUsage)
+		}
+		ragIndex = idx
 	}
 
 	// conversation file
@@ -1143,20 +2053,29 @@ func main() {
 			convFile = home + convFile[1:]
 		}
 	}
-
-	// read system prompt file
-	sysPromptContent := ""
-	if SYS_PROMPT_FILE != "" {
-		if _, err := os.Stat(SYS_PROMPT_FILE); os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "%sSystem prompt file not found: %s%s\n", red, SYS_PROMPT_FILE, normal)
-			os.Exit(1)
+	if READ_ONLY {
+		if convFile == "" || !fileExists(convFile) {
+			fmt.Fprintf(os.Stderr, "%s--read-only requires an existing conversation file.%s\n", red, normal)
+			os.Exit(exitUsage)
 		}
-		b, _ := ioutil.ReadFile(SYS_PROMPT_FILE)
-		sysPromptContent = string(b)
+		conversationsInMemory = true
+	}
+
+	// compose the system prompt from -s, --system-text and --system-url
+	ephemeralSession = EPHEMERAL // nothing is cached for an ephemeral session
+	sysPromptContent, err := composeSystemPrompt(cfg, SYSTEM_SOURCES)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		os.Exit(exitUsage)
+	}
+	sessionSystem.content, sessionSystem.sources = sysPromptContent, SYSTEM_SOURCES
+	if developerPrompt, err = composeSystemPrompt(cfg, DEV_SOURCES); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		os.Exit(exitUsage)
 	}
 
 	// Non-interactive prompt mode
-	if PROMPT_MODE != "" {
+	if promptRequested {
 		var promptText string
 		var err error
 		if PROMPT_MODE == "-" {
@@ -1164,7 +2083,7 @@ func main() {
 			b, e := ioutil.ReadAll(os.Stdin)
 			if e != nil {
 				fmt.Fprintf(os.Stderr, "%sFailed to read from stdin: %v%s\n", red, e, normal)
-				os.Exit(1)
+				os.Exit(exitGeneral)
 			}
 			promptText = string(b)
 		} else if fileExists(PROMPT_MODE) {
@@ -1172,7 +2091,7 @@ func main() {
 			b, e := ioutil.ReadFile(PROMPT_MODE)
 			if e != nil {
 				fmt.Fprintf(os.Stderr, "%sFailed to read prompt file: %v%s\n", red, e, normal)
-				os.Exit(1)
+				os.Exit(exitGeneral)
 			}
 			promptText = string(b)
 		} else {
@@ -1180,265 +2099,451 @@ func main() {
 			promptText = PROMPT_MODE
 		}
 
-		if convFile != "" {
+		if TEMPLATE_NAME != "" {
+			vars, err := parseTemplateVars(TEMPLATE_VARS)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+				os.Exit(exitUsage)
+			}
+			// Any --prompt text is available to the template as {{.input}}
+			if _, ok := vars["input"]; !ok {
+				vars["input"] = promptText
+			}
+			promptText, err = renderTemplate(TEMPLATE_NAME, vars)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+				os.Exit(exitUsage)
+			}
+		}
+
+		promptText, err = withAttachments(promptText, ATTACH_FILES)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+			os.Exit(exitUsage)
+		}
+		promptText = withGitDiff(promptText, gitDiff)
+		promptText, err = withRAGContext(cfg, promptText)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sError: %s%s\n", red, describeError(err), normal)
+			os.Exit(exitCodeFor(err))
+		}
+		promptText = redactOutgoing(promptText, "")
+
+		// Response destination: stdout by default (or with --output -), otherwise a file
+		var out io.Writer = os.Stdout
+		var outFile *os.File
+		var fileOut *lastByteWriter
+		if OUTPUT_FILE != "" && OUTPUT_FILE != "-" {
+			flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+			if APPEND_OUTPUT {
+				flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+			}
+			outFile, err = os.OpenFile(OUTPUT_FILE, flags, 0o644)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%sFailed to open output file: %v%s\n", red, err, normal)
+				os.Exit(exitGeneral)
+			}
+			fileOut = &lastByteWriter{w: outFile}
+			out = fileOut
+		}
+		if JSON_STREAM && cfg["DRY_RUN"] != "true" {
+			startJSONStream()
+			out = ioutil.Discard
+		}
+		if RAW_OUTPUT && cfg["DRY_RUN"] != "true" {
+			// the response as received, parsed only to update the conversation; cached replies
+			// have no response to show
+			rawOutput, out = out, ioutil.Discard
+			cfg["CACHE"] = "false"
+		}
+
+		if cfg["DRY_RUN"] == "true" {
+			if convFile != "" && conversationExists(convFile) {
+				if err := applyFileSettingsAsDefaults(convFile, cfg); err != nil {
+					fmt.Fprintf(os.Stderr, "%sWarning applying file settings: %v%s\n", red, err, normal)
+				}
+			}
+			if err := validateSettings(cfg, provided); err != nil {
+				fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+				os.Exit(exitUsage)
+			}
+			if err := dryRun(promptText, convFile, cfg, sysPromptContent, ACCESS_TOKEN, out); err != nil {
+				fmt.Fprintf(os.Stderr, "%sError: %s%s\n", red, describeError(err), normal)
+				os.Exit(exitCodeFor(err))
+			}
+		} else if convFile != "" {
 			// Non-interactive with a conversation file
 			if err := ensureHistoryFileStructure(convFile, cfg); err != nil {
 				fmt.Fprintf(os.Stderr, "%sFailed to setup conversation file: %v%s\n", red, err, normal)
-				os.Exit(1)
+				os.Exit(exitGeneral)
 			}
-			if err := applyFileSettingsAsDefaults(convFile, cfg, provided); err != nil {
+			if err := applyFileSettingsAsDefaults(convFile, cfg); err != nil {
 				fmt.Fprintf(os.Stderr, "%sWarning applying file settings: %v%s\n", red, err, normal)
 			}
-			if err := validateNumericRanges(cfg); err != nil {
+			if err := validateSettings(cfg, provided); err != nil {
 				fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
-				os.Exit(1)
+				os.Exit(exitUsage)
 			}
 			if SAVE_SETTINGS {
 				if err := persistSettingsToFile(convFile, cfg); err != nil {
 					fmt.Fprintf(os.Stderr, "%sFailed to persist settings: %v%s\n", red, err, normal)
-					os.Exit(1)
+					os.Exit(exitGeneral)
 				}
-				fmt.Fprintf(os.Stderr, "%sPersisted current settings into %s%s\n", green, convFile, normal)
+				fmt.Fprintf(os.Stderr, "%sPersisted current settings %s%s\n", green, savedTo(convFile), normal)
 			}
-			err = processMessage(promptText, convFile, cfg, sysPromptContent, ACCESS_TOKEN)
+			if !NO_MCP {
+				if _, err := loadMCPServers(MCP_CONFIG); err != nil {
+					fmt.Fprintf(os.Stderr, "%sFailed to load MCP servers: %v%s\n", red, err, normal)
+					os.Exit(exitUsage)
+				}
+			}
+			err = processMessage(promptText, convFile, cfg, sysPromptContent, ACCESS_TOKEN, out)
+			emitDone(err, cfg["MODEL"], convFile)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "%sError: %v%s\n", red, err, normal)
-				os.Exit(1)
+				fmt.Fprintf(os.Stderr, "%sError: %s%s\n", red, describeError(err), normal)
+				offerModelReplacement(err, cfg, convFile, false)
+				os.Exit(exitCodeFor(err))
 			}
 		} else {
 			// Non-interactive, no conversation file
-			err = processSinglePrompt(promptText, cfg, sysPromptContent, ACCESS_TOKEN)
+			if err := validateSettings(cfg, provided); err != nil {
+				fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
+				os.Exit(exitUsage)
+			}
+			err = processSinglePrompt(promptText, cfg, sysPromptContent, ACCESS_TOKEN, out)
+			emitDone(err, cfg["MODEL"], "")
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "%sError: %v%s\n", red, err, normal)
-				os.Exit(1)
+				fmt.Fprintf(os.Stderr, "%sError: %s%s\n", red, describeError(err), normal)
+				offerModelReplacement(err, cfg, "", false)
+				os.Exit(exitCodeFor(err))
+			}
+		}
+		if outFile != nil {
+			// Keep appended runs on separate lines
+			if fileOut.last != '\n' {
+				fmt.Fprintln(outFile)
+			}
+			if err := outFile.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "%sFailed to write output file: %v%s\n", red, err, normal)
+				os.Exit(exitGeneral)
 			}
+			fmt.Fprintf(os.Stderr, "%sResponse written to %s%s\n", green, OUTPUT_FILE, normal)
 		}
 		return
 	}
 
 	// Interactive mode
+	if EPHEMERAL {
+		conversationsInMemory, ephemeralSession = true, true
+		convFile = ephemeralConversation
+	}
+	if convFile == "" && (PICK_CONVERSATION || userConfig["conversations.picker"] == "true" && !NEW_CONVERSATION) {
+		convFile = pickConversation(conversationDir())
+	}
 	if convFile == "" {
 		// create new default path
-		hdir := os.Getenv("XDG_CACHE_HOME")
-		if hdir == "" {
-			hdir = filepath.Join(os.Getenv("HOME"), ".cache")
-		}
-		cfg["HISTORY_DIR"] = filepath.Join(hdir, "nvidia-chat")
+		cfg["HISTORY_DIR"] = conversationDir()
 		ts := time.Now().Format("20060102-150405")
-		convFile = filepath.Join(cfg["HISTORY_DIR"], "conversation-"+ts+".json")
+		ext := ".json"
+		if userConfig["conversations.format"] == "yaml" {
+			ext = ".yaml"
+		}
+		convFile = filepath.Join(cfg["HISTORY_DIR"], "conversation-"+ts+ext)
 		fmt.Fprintf(os.Stderr, "Creating conversation file: %s\n", convFile)
 	}
 
 	// ensure conversation file exists and has structure
 	if err := ensureHistoryFileStructure(convFile, cfg); err != nil {
 		fmt.Fprintf(os.Stderr, "%sFailed to setup conversation file: %v%s\n", red, err, normal)
-		os.Exit(1)
+		os.Exit(exitGeneral)
 	}
 	fmt.Fprintf(os.Stderr, "%sConversation file:%s %s\n", green, normal, convFile)
+	if project != nil {
+		fmt.Fprintf(os.Stderr, "%sProject settings:%s %s\n", green, normal, project.Path)
+	}
+
+	if !NO_MCP {
+		if _, err := loadMCPServers(MCP_CONFIG); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to load MCP servers: %v%s\n", red, err, normal)
+			os.Exit(exitUsage)
+		}
+	}
+	if !NO_CONTROL_SOCKET {
+		if err := startControlSocket(CONTROL_SOCKET, &convFile, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "%sControl socket unavailable: %v%s\n", red, err, normal)
+		}
+	}
 
 	// Apply persisted settings as defaults if user did not provide those options explicitly
-	if err := applyFileSettingsAsDefaults(convFile, cfg, provided); err != nil {
+	if err := applyFileSettingsAsDefaults(convFile, cfg); err != nil {
 		// non-fatal: warn
 		fmt.Fprintf(os.Stderr, "%sWarning applying file settings: %v%s\n", red, err, normal)
 	}
 
-	// Validate numeric ranges
-	if err := validateNumericRanges(cfg); err != nil {
+	// Validate settings against the model
+	if err := validateSettings(cfg, provided); err != nil {
 		fmt.Fprintf(os.Stderr, "%s%s%s\n", red, err.Error(), normal)
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	// If persist system requested but no -s provided -> exit
 	if PERSIST_SYSTEM && sysPromptContent == "" {
-		fmt.Fprintf(os.Stderr, "%sPersist system requested (-S) but no -s SYS_PROMPT_FILE provided.%s Provide -s path and -S together to persist system prompt into the conversation file.\n", red, normal)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "%sPersist system requested (-S) but no system prompt provided.%s Provide -s, --system-text or --system-url together with -S to persist the system prompt into the conversation file.\n", red, normal)
+		os.Exit(exitUsage)
 	}
 
 	// Check message count vs limit
 	count, err := messageCount(convFile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%sFailed reading conversation file: %v%s\n", red, err, normal)
-		os.Exit(1)
+		os.Exit(exitGeneral)
 	}
 	limit, _ := strconv.Atoi(cfg["HISTORY_LIMIT"])
 	if limit <= 0 {
 		fmt.Fprintf(os.Stderr, "%sInvalid limit (-L): %s%s\n", red, cfg["HISTORY_LIMIT"], normal)
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
-	if count >= limit {
-		fmt.Fprintf(os.Stderr, "%sConversation message limit reached.%s\nFile: %s\nMessages in file: %d\nConfigured limit: %d\n\nThis program will NOT remove or rotate messages automatically.\nOptions:\n  - Increase limit via -L option and re-run\n  - Use a different conversation file (pass new filename)\n  - Manually edit the file to remove old messages\n\nExiting.\n", red, normal, convFile, count, limit)
-		os.Exit(1)
+	if count >= limit && (STDIO_JSON || !offerHistoryLimitRecovery(&convFile, cfg, count)) {
+		fmt.Fprintln(os.Stderr, "Exiting.")
+		os.Exit(exitContextLimit)
 	}
 
 	// Persist settings or system if requested before interactive loop
 	if SAVE_SETTINGS {
 		if err := persistSettingsToFile(convFile, cfg); err != nil {
 			fmt.Fprintf(os.Stderr, "%sFailed to persist settings: %v%s\n", red, err, normal)
-			os.Exit(1)
+			os.Exit(exitGeneral)
 		}
-		fmt.Fprintf(os.Stderr, "%sPersisted current settings into conversation file's .settings%s\n", green, normal)
+		fmt.Fprintf(os.Stderr, "%sPersisted current settings %s%s\n", green, savedTo(convFile), normal)
 	}
 	if PERSIST_SYSTEM {
 		if err := persistSystemToFile(convFile, sysPromptContent); err != nil {
 			fmt.Fprintf(os.Stderr, "%sFailed to persist system prompt: %v%s\n", red, err, normal)
-			os.Exit(1)
+			os.Exit(exitGeneral)
+		}
+		fmt.Fprintf(os.Stderr, "%sPersisted system prompt %s%s\n", green, savedTo(convFile), normal)
+	}
+
+	// Files passed with --file are attached to the first message of the session
+	for _, path := range ATTACH_FILES {
+		if _, err := readAttachment(path); err != nil {
+			fmt.Fprintf(os.Stderr, "%sCannot attach %s: %v%s\n", red, path, err, normal)
+			os.Exit(exitUsage)
 		}
-		fmt.Fprintf(os.Stderr, "%sPersisted system prompt into conversation file's .system%s\n", green, normal)
+	}
+	pendingAttachments = append(pendingAttachments, ATTACH_FILES...)
+	pendingGitDiff = gitDiff
+
+	if STDIO_JSON {
+		code := runStdioJSON(convFile, cfg, sysPromptContent)
+		closeControlSocket()
+		os.Exit(code)
+	}
+
+	if RECORD_SESSION != "" {
+		if err := startSessionRecording(RECORD_SESSION, "nvidia-chat "+filepath.Base(convFile)); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to start the session recording: %v%s\n", red, err, normal)
+			os.Exit(exitGeneral)
+		}
+		defer stopSessionRecording()
 	}
 
 	// Interactive banner
 	fmt.Fprint(os.Stderr, "\n")
-	fmt.Fprintln(os.Stderr, `AI models generate responses and outputs based on complex algorithms and
+	fmt.Fprint(os.Stderr, `AI models generate responses and outputs based on complex algorithms and
 machine learning techniques, and those responses or outputs may be
 inaccurate, harmful, biased or indecent. By testing this model, you assume
 the risk of any harm caused by any response or output of the model. Please
 do not upload any confidential information or personal data unless
 expressly permitted. Your use is logged for security purposes.
+
 `)
-	fmt.Fprintf(os.Stderr, "%sNVIDIA chat (go)%s model=%s temperature=%s top_p=%s max_tokens=%s stream=%s freq_penalty=%s pres_penalty=%s reasoning=%s stop=%q\n\n", bold, normal, cfg["MODEL"], cfg["TEMPERATURE"], cfg["TOP_P"], cfg["MAX_TOKENS"], cfg["STREAM"], cfg["FREQUENCY_PENALTY"], cfg["PRESENCE_PENALTY"], cfg["REASONING_EFFORT"], cfg["STOP"])
-	fmt.Fprintf(os.Stderr, "Conversation file: %s\n\n", convFile)
+	startStatusLine(cfg)
+	defer stopStatusLine()
+	if status != nil {
+		// the model and its temperature stay in view at the bottom; /settings lists the rest
+		fmt.Fprintf(os.Stderr, "%sNVIDIA chat (go)%s\n\n", bold, normal)
+	} else {
+		fmt.Fprintf(os.Stderr, "%sNVIDIA chat (go)%s model=%s temperature=%s top_p=%s max_tokens=%s stream=%s freq_penalty=%s pres_penalty=%s reasoning=%s stop=%q\n\n", bold, normal, cfg["MODEL"], cfg["TEMPERATURE"], cfg["TOP_P"], cfg["MAX_TOKENS"], cfg["STREAM"], cfg["FREQUENCY_PENALTY"], cfg["PRESENCE_PENALTY"], cfg["REASONING_EFFORT"], cfg["STOP"])
+	}
+	fmt.Fprintf(os.Stderr, "Conversation file: %s\n", convFile)
+	if cf, err := readConversation(convFile); err == nil && cf.Title != "" {
+		fmt.Fprintf(os.Stderr, "Title: %s\n", cf.Title)
+	}
+	if READ_ONLY {
+		fmt.Fprintln(os.Stderr, "Read-only: new messages and changes are not saved to the conversation file")
+	}
+	if EPHEMERAL {
+		fmt.Fprintln(os.Stderr, "Ephemeral: the conversation is kept in memory only; /save FILE writes it to a file")
+	}
+	fmt.Fprintln(os.Stderr)
+	if cf, err := readConversation(convFile); err == nil && len(cf.Messages) > 0 && cf.Messages[len(cf.Messages)-1].Incomplete {
+		fmt.Fprintf(os.Stderr, "%sThe last response was interrupted; its partial text is kept in the conversation.%s\n\n", red, normal)
+	}
+	if cf, err := readConversation(convFile); err == nil {
+		if _, n := pendingRange(cf); n > 0 {
+			fmt.Fprintf(os.Stderr, "%d message(s) queued while offline will be sent before your next message.\n\n", n)
+		}
+	}
 	fmt.Fprintln(os.Stderr, "Type your message and end it by Ctrl+D. See /help for commands")
 
-	// trap SIGINT handled by default (Ctrl+C ends program)
-
-	lines := make([]string, 0)
-
 	// interactive loop
 	for {
+		status.ready(convFile)
 		fmt.Fprintf(os.Stderr, "\n%s: ", blue+"You"+normal)
 
-		// read first line
-		firstLine, err := readSingleLine(nil, []string{"\r\n", "\r", "\n"}, true)
-		if err != nil && err != io.EOF {
-			fmt.Fprintf(os.Stderr, "%sFailed reading input: %v%s\n", red, err, normal)
-			return
+		var userInput string
+		sendRaw, typed := false, queuedInput == ""
+		if queuedInput != "" {
+			// input prepared by a command such as /template
+			userInput = queuedInput
+			queuedInput = ""
+			fmt.Fprintln(os.Stderr, userInput)
+		} else {
+			// read first line
+			firstLine, err := readSingleLine(nil, []string{"\r\n", "\r", "\n"}, true)
+			if err != nil && err != io.EOF {
+				fmt.Fprintf(os.Stderr, "%sFailed reading input: %v%s\n", red, err, normal)
+				return
+			}
+			if firstLine == "" {
+				// EOF with no input -> restart loop
+				continue
+			}
+
+			firstLineTrimmed := strings.TrimSpace(firstLine)
+			if firstLineTrimmed == "/send-raw" || strings.HasPrefix(firstLineTrimmed, "/send-raw ") {
+				// the message follows the command and is sent without redaction
+				sendRaw = true
+				firstLine = strings.TrimPrefix(firstLineTrimmed, "/send-raw")
+			} else if strings.HasPrefix(firstLineTrimmed, "/") {
+				// Check if it's a command
+				sessionMu.Lock()
+				handled := handleInteractiveInput(firstLineTrimmed, convFile, cfg)
+				if renamedConvFile != "" {
+					convFile, renamedConvFile = renamedConvFile, ""
+				}
+				sysPromptContent = sessionSystem.content // /system edit and clear drop the -s prompt
+				sessionMu.Unlock()
+				if handled {
+					continue
+				}
+			}
+
+			// If it wasn't a command, read the rest of the multi-line input until EOF
+			lines := []string{firstLine}
+			if err == nil { // only if we didn't get an EOF on the first read
+				remainingLines, err := readLines(nil, []string{"\r\n", "\r", "\n"}, true)
+				if err != nil && err != io.EOF {
+					fmt.Fprintf(os.Stderr, "%sFailed reading multi-line input: %v%s\n", red, err, normal)
+					continue
+				}
+				lines = append(lines, remainingLines...)
+			}
+			userInput = strings.Join(lines, "\n")
 		}
-		if firstLine == "" {
-			// EOF with no input -> restart loop
+		userInput = strings.TrimSpace(userInput)
+
+		if userInput == "" {
 			continue
 		}
 
-		firstLineTrimmed := strings.TrimSpace(firstLine)
-		if strings.HasPrefix(firstLineTrimmed, "/") {
-			// Check if it's a command
-			if handled := handleInteractiveInput(firstLineTrimmed, convFile, cfg); handled {
+		if len(pendingAttachments) > 0 {
+			withFiles, err := withAttachments(userInput, pendingAttachments)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%sFailed attaching files: %v%s\n", red, err, normal)
 				continue
 			}
+			fmt.Fprintf(os.Stderr, "%sAttached %d file(s)%s\n", green, len(pendingAttachments), normal)
+			userInput = withFiles
+		}
+		if pendingGitDiff != "" {
+			fmt.Fprintf(os.Stderr, "%sAttached the git diff%s\n", green, normal)
+			userInput = withGitDiff(userInput, pendingGitDiff)
+		}
+		withContext, err := withRAGContext(cfg, userInput)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%s%s\n", red, describeError(err), normal)
+			continue
+		}
+		userInput = withContext
+		if !sendRaw {
+			userInput = redactOutgoing(userInput, "Use /send-raw to send a message unchanged.")
 		}
 
-		// If it wasn't a command, read the rest of the multi-line input until EOF
-		if err == nil { // only if we didn't get an EOF on the first read
-			remainingLines, err := readLines(nil, []string{"\r\n", "\r", "\n"}, true)
-			if err != nil && err != io.EOF {
-				fmt.Fprintf(os.Stderr, "%sFailed reading multi-line input: %v%s\n", red, err, normal)
-				continue
+		if cfg["DRY_RUN"] == "true" {
+			// Show the request without sending it or touching the conversation file
+			if err := dryRun(userInput, convFile, cfg, sysPromptContent, ACCESS_TOKEN, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "%sDry run failed: %v%s\n", red, err, normal)
 			}
-			lines = append([]string{firstLine}, remainingLines...)
+			continue
 		}
-
-		userInput := strings.Join(lines, "\n")
-		userInput = strings.TrimSpace(userInput)
-
-		if userInput == "" {
+		if typed && !confirmResend(convFile, userInput) {
 			continue
 		}
 
 		// append user message
+		sessionMu.Lock()
+		if count, _ := messageCount(convFile); count >= mustAtoi(cfg["HISTORY_LIMIT"], defaultHistoryLimit) && !offerHistoryLimitRecovery(&convFile, cfg, count) {
+			sessionMu.Unlock()
+			fmt.Fprintln(os.Stderr, "Exiting.")
+			closeControlSocket()
+			stopStatusLine()
+			stopSessionRecording()
+			os.Exit(exitContextLimit)
+		}
 		if err := appendMessage(convFile, "user", userInput); err != nil {
+			sessionMu.Unlock()
 			fmt.Fprintf(os.Stderr, "%sFailed appending message: %v%s\n", red, err, normal)
 			continue
 		}
+		// the attachments went out with this message; they stay pending when it is not sent
+		pendingAttachments, pendingGitDiff = nil, ""
 		// re-check limit
 		count, _ := messageCount(convFile)
 		limit, _ := strconv.Atoi(cfg["HISTORY_LIMIT"])
 		if count > limit {
 			fmt.Fprintf(os.Stderr, "%sAfter adding your message, the conversation file exceeded the limit (%d).%s\nI did not remove messages. Increase limit with -L or use another file.\n", red, limit, normal)
-			os.Exit(1)
-		}
-
-		// Determine effective system prompt: precedence -s content > persisted .system in file > none
-		effectiveSystem := ""
-		if sysPromptContent != "" {
-			effectiveSystem = sysPromptContent
-		} else {
-			cf, _ := readConversation(convFile)
-			effectiveSystem = cf.System
-		}
-
-		// Build messages: prepend system prompt if non-empty, then .messages
-		var messages []Message
-		cf2, err := readConversation(convFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%sFailed reading conversation to build payload: %v%s\n", red, err, normal)
-			continue
-		}
-		if effectiveSystem != "" {
-			messages = append(messages, Message{Role: "system", Content: effectiveSystem})
+			closeControlSocket()
+			stopStatusLine()
+			stopSessionRecording()
+			os.Exit(exitContextLimit)
 		}
-		messages = append(messages, cf2.Messages...)
 
-		// Build payload
-		payloadBytes, err := buildPayload(cfg, messages)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%sFailed building payload: %v%s\n", red, err, normal)
-			continue
-		}
-
-		// Prepare HTTP request
-		url := cfg["BASE_URL"] + "/chat/completions"
-		req, _ := http.NewRequest("POST", url, bytes.NewReader(payloadBytes))
-		req.Header.Set("Authorization", "Bearer "+ACCESS_TOKEN)
-		req.Header.Set("Content-Type", "application/json")
-
-		client := &http.Client{}
-		if cfg["STREAM"] == "true" {
-			// streaming mode
-			resp, err := client.Do(req)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "%sRequest failed: %v%s\n", red, err, normal)
-				continue
-			}
-			if resp.StatusCode >= 400 {
-				body, _ := ioutil.ReadAll(resp.Body)
-				fmt.Fprintf(os.Stderr, "%sAPI error: %s%s\n%s\n", red, resp.Status, normal, string(body))
-				resp.Body.Close()
-				continue
-			}
+		announce := func() {
+			status.set("streaming")
 			fmt.Fprintf(os.Stderr, "\n%s\n", blue+"Assistant:"+normal)
-			assistantText, err := handleStream(resp.Body, convFile)
-			resp.Body.Close()
-			if err != nil {
-				// print error but continue
-			}
-			if strings.TrimSpace(assistantText) != "" {
-				if err := appendMessage(convFile, "assistant", assistantText); err != nil {
-					fmt.Fprintf(os.Stderr, "%sFailed appending assistant message: %v%s\n", red, err, normal)
-				}
+		}
+		out := status.writer(os.Stdout)
+		status.set("waiting")
+		if abModel != "" {
+			if reason := abUnavailable(convFile); reason != "" {
+				fmt.Fprintf(os.Stderr, "%sNot comparing with %s: %s.%s\n", red, abModel, reason, normal)
+				abModel = ""
 			}
+		}
+		if abModel != "" {
+			err = compareModels(convFile, cfg, sysPromptContent, abModel)
+			abModel = ""
 		} else {
-			// non-streaming mode
-			resp, err := client.Do(req)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "%sRequest failed: %v%s\n", red, err, normal)
-				continue
-			}
-			body, _ := ioutil.ReadAll(resp.Body)
-			resp.Body.Close()
-			if resp.StatusCode >= 400 {
-				fmt.Fprintf(os.Stderr, "%sAPI error: %s%s\n%s\n", red, resp.Status, normal, string(body))
-				continue
-			}
-			fmt.Fprintf(os.Stderr, "\n%s\n", blue+"Assistant:"+normal)
-			assistantText, err := handleNonStream(body)
-			if err != nil {
-				// we printed raw body already; don't treat as fatal
-			}
-			if strings.TrimSpace(assistantText) != "" {
-				if err := appendMessage(convFile, "assistant", assistantText); err != nil {
-					fmt.Fprintf(os.Stderr, "%sFailed appending assistant message: %v%s\n", red, err, normal)
+			err = completeOrQueue(convFile, cfg, sysPromptContent, ACCESS_TOKEN, out, announce)
+		}
+		// The session stays locked until the error is dealt with: the context trim rewrites the
+		// conversation file, and a control socket message must not slip in before the retry.
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			ACCESS_TOKEN = handleInteractiveAPIError(apiErr, ACCESS_TOKEN, PROFILE)
+			offerModelReplacement(err, cfg, convFile, true)
+			if offerContextTrim(err, cfg, convFile, sysPromptContent, ACCESS_TOKEN) {
+				err = completeConversation(convFile, cfg, sysPromptContent, ACCESS_TOKEN, out, announce)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%s%s\n", red, describeError(err), normal)
 				}
 			}
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
 		}
+		sessionMu.Unlock()
 	}
 }
 
@@ -1446,7 +2551,7 @@ expressly permitted. Your use is logged for security purposes.
 // handleInteractiveInput returns true if the input was a special command that was handled here.
 // Otherwise returns false so the caller will continue normal message processing.
 func filterThinkingBlock(content string) string {
-	re := regexp.MustCompile(`(?s)\[Begin of Assistant Reasoning\].*?\[/End of Assistant Reasoning\]\s*\n?`)
+	re := regexp.MustCompile(`(?s)\[Begin of Assistant Reasoning\].*?\[/?End of Assistant Reasoning\]\s*\n?`)
 	return re.ReplaceAllString(content, "")
 }
 
@@ -1515,73 +2620,37 @@ func exportNth(n int, convFile, targetFile string, filterThinking bool) error {
 	return ioutil.WriteFile(targetFile, []byte(content), 0o644)
 }
 
-func parseTFlag(parts []string) (bool, []string) {
-	filterThinking := false
-	var newParts []string
-	newParts = append(newParts, parts[0])
-	for _, p := range parts[1:] {
-		if p == "-t" {
-			filterThinking = true
-		} else {
-			newParts = append(newParts, p)
-		}
-	}
-	return filterThinking, newParts
-}
-
 func getModelInfoString(modelName string, modelDef ModelDefinition) string {
 	var builder strings.Builder
 
 	builder.WriteString(fmt.Sprintf("%sModel: %s%s\n\n", bold, modelName, normal))
+	if len(modelDef.Capabilities) > 0 {
+		builder.WriteString(fmt.Sprintf("%sCapabilities:%s %s\n", bold, normal, strings.Join(modelDef.Capabilities, ", ")))
+	}
+	if modelDef.ContextWindow > 0 {
+		builder.WriteString(fmt.Sprintf("%sContext window:%s %d tokens\n", bold, normal, modelDef.ContextWindow))
+	}
+	if len(modelDef.Capabilities) > 0 || modelDef.ContextWindow > 0 {
+		builder.WriteString("\n")
+	}
 	builder.WriteString(fmt.Sprintf("%sParameters:%s\n", bold, normal))
 
 	paramNames := make([]string, 0, len(modelDef.Parameters))
 	for name := range modelDef.Parameters {
-		paramNames = append(paramNames, name)
-	}
-	sort.Strings(paramNames)
-
-	for _, name := range paramNames {
-		param := modelDef.Parameters[name]
-		builder.WriteString(fmt.Sprintf("  %s%s%s\n", blue, name, normal))
-		builder.WriteString(fmt.Sprintf("    Description: %s\n", param.Description))
-		builder.WriteString(fmt.Sprintf("    Type: %s\n", param.Type))
-
-		defaultStr := "Not set"
-		if param.Default != nil {
-			// Handle float formatting
-			if f, ok := param.Default.(float64); ok {
-				defaultStr = fmt.Sprintf("%g", f)
-			} else {
-				defaultStr = fmt.Sprintf("%v", param.Default)
-			}
-		} else {
-			// Special case for deepseek seed
-			if modelName == "deepseek-ai/deepseek-v3.1" && name == "seed" {
-				defaultStr = "null (omitted)"
-			}
-		}
-
-		builder.WriteString(fmt.Sprintf("    Default: %s\n", defaultStr))
-
-		if param.Type == Float || param.Type == Int {
-			hasMin := param.Min != 0 || (param.Type == Float && param.Min == 0.0)
-			hasMax := param.Max != 0
-			if hasMin && hasMax {
-				builder.WriteString(fmt.Sprintf("    Range: %g to %g\n", param.Min, param.Max))
-			} else if hasMin {
-				builder.WriteString(fmt.Sprintf("    Range: >= %g\n", param.Min))
-			} else if hasMax {
-				builder.WriteString(fmt.Sprintf("    Range: <= %g\n", param.Max))
-			}
-		}
+		paramNames = append(paramNames, name)
+	}
+	sort.Strings(paramNames)
 
-		if len(param.Options) > 0 {
-			builder.WriteString(fmt.Sprintf("    Options: %s\n", strings.Join(param.Options, ", ")))
-		}
+	for _, name := range paramNames {
+		builder.WriteString(fmt.Sprintf("  %s%s%s\n", blue, name, normal))
+		builder.WriteString(formatParameter(modelName, name, modelDef.Parameters[name]))
 		builder.WriteString("\n")
 	}
 
+	if modelDef.BaseURL != "" {
+		builder.WriteString(fmt.Sprintf("%sEndpoint:%s %s\n\n", bold, normal, modelDef.BaseURL))
+	}
+
 	if modelDef.PrependedSystemMessageOnThinking != "" || modelDef.ChatTemplateKwargsThinking {
 		builder.WriteString(fmt.Sprintf("%sSpecial Behavior:%s\n", bold, normal))
 		if modelDef.PrependedSystemMessageOnThinking != "" {
@@ -1594,12 +2663,54 @@ func getModelInfoString(modelName string, modelDef ModelDefinition) string {
 	return builder.String()
 }
 
+// formatParameter describes a model parameter for /modelinfo and /help <parameter>: its
+// description, type, default, range and options.
+func formatParameter(modelName, name string, param ModelParameter) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("    Description: %s\n", param.Description))
+	b.WriteString(fmt.Sprintf("    Type: %s\n", param.Type))
+
+	defaultStr := "Not set"
+	if param.Default != nil {
+		// Handle float formatting
+		if f, ok := param.Default.(float64); ok {
+			defaultStr = fmt.Sprintf("%g", f)
+		} else {
+			defaultStr = fmt.Sprintf("%v", param.Default)
+		}
+	} else {
+		// Special case for deepseek seed
+		if modelName == "deepseek-ai/deepseek-v3.1" && name == "seed" {
+			defaultStr = "null (omitted)"
+		}
+	}
+
+	b.WriteString(fmt.Sprintf("    Default: %s\n", defaultStr))
+
+	if param.Type == Float || param.Type == Int || param.Type == IntMap {
+		hasMin := param.Min != 0 || (param.Type == Float && param.Min == 0.0)
+		hasMax := param.Max != 0
+		if hasMin && hasMax {
+			b.WriteString(fmt.Sprintf("    Range: %g to %g\n", param.Min, param.Max))
+		} else if hasMin {
+			b.WriteString(fmt.Sprintf("    Range: >= %g\n", param.Min))
+		} else if hasMax {
+			b.WriteString(fmt.Sprintf("    Range: <= %g\n", param.Max))
+		}
+	}
+
+	if len(param.Options) > 0 {
+		b.WriteString(fmt.Sprintf("    Options: %s\n", strings.Join(param.Options, ", ")))
+	}
+	return b.String()
+}
+
 func printModelInfo(modelName string) {
 	modelDef, exists := ModelDefinitions[modelName]
 	if !exists {
 		fmt.Fprintf(os.Stderr, "%sError: Model '%s' not found.%s\n", red, modelName, normal)
 		fmt.Fprintf(os.Stderr, "Use the -l flag to list all supported models.\n")
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	info := getModelInfoString(modelName, modelDef)
@@ -1634,16 +2745,16 @@ func validateParameter(paramName, value string, modelDef ModelDefinition) error
 		if err != nil {
 			return fmt.Errorf("invalid float value: %s", value)
 		}
-		if (param.Min != 0 || param.Max != 0) && (v < param.Min || v > param.Max) {
-			return fmt.Errorf("value out of range [%g, %g]: %g", param.Min, param.Max, v)
+		if err := checkRange(param, v); err != nil {
+			return err
 		}
 	case Int:
 		v, err := strconv.Atoi(value)
 		if err != nil {
 			return fmt.Errorf("invalid integer value: %s", value)
 		}
-		if (param.Min != 0 || param.Max != 0) && (float64(v) < param.Min || float64(v) > param.Max) {
-			return fmt.Errorf("value out of range [%d, %d]: %d", int(param.Min), int(param.Max), v)
+		if err := checkRange(param, float64(v)); err != nil {
+			return err
 		}
 	case String:
 		if len(param.Options) > 0 {
@@ -1664,10 +2775,130 @@ func validateParameter(paramName, value string, modelDef ModelDefinition) error
 		}
 	case StringA:
 		// No specific validation for string arrays, any string is fine.
+	case IntMap:
+		m, err := parseIntMap(value)
+		if err != nil {
+			return err
+		}
+		for k, v := range m {
+			if err := checkRange(param, float64(v)); err != nil {
+				return fmt.Errorf("%s: %v", k, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkRange checks a numeric value against the parameter's bounds. A zero Max means no upper
+// bound, as for max_tokens on models without a documented limit.
+func checkRange(param ModelParameter, v float64) error {
+	if param.Min == 0 && param.Max == 0 {
+		return nil
+	}
+	if v < param.Min || (param.Max != 0 && v > param.Max) {
+		if param.Max == 0 {
+			return fmt.Errorf("value out of range [%g, ...): %g", param.Min, v)
+		}
+		return fmt.Errorf("value out of range [%g, %g]: %g", param.Min, param.Max, v)
 	}
 	return nil
 }
 
+// defaultValueString formats a parameter's default value for the cfg map.
+func defaultValueString(param ModelParameter) string {
+	switch v := param.Default.(type) {
+	case nil:
+		return ""
+	case float64:
+		return fmt.Sprintf("%g", v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// revalidateSettings checks the session settings against a newly selected model. Conflicting
+// values are reported and, if the user agrees, reset to the model's defaults.
+func revalidateSettings(cfg map[string]string, modelDef ModelDefinition) {
+	conflicts := settingConflicts(cfg, modelDef)
+	if len(conflicts) == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Reset %s to the model's defaults? [Y/n] ", strings.Join(conflicts, ", "))
+	answer, _ := readSingleLine(nil, []string{"\n"}, true)
+	if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "" && answer != "y" && answer != "yes" {
+		fmt.Fprintln(os.Stderr, "Settings kept; requests may be rejected by the API.")
+		return
+	}
+	values := resetSettings(cfg, modelDef, conflicts)
+	for _, name := range conflicts {
+		fmt.Fprintf(os.Stderr, "%s%s reset to %s%s\n", green, name, values[name], normal)
+	}
+}
+
+// settingConflicts returns the sorted names of the session settings that are not valid for the
+// model, reporting each on stderr.
+func settingConflicts(cfg map[string]string, modelDef ModelDefinition) []string {
+	selectTokenizer(cfg["MODEL"])
+	names := make([]string, 0, len(modelDef.Parameters))
+	for name := range modelDef.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var conflicts []string
+	for _, name := range names {
+		value := cfg[strings.ToUpper(name)]
+		if value == "" {
+			continue
+		}
+		if err := validateParameter(name, value, modelDef); err != nil {
+			fmt.Fprintf(os.Stderr, "%sWarning: %s=%s is not valid for this model: %v%s\n", red, name, value, err, normal)
+			conflicts = append(conflicts, name)
+		}
+	}
+	return conflicts
+}
+
+// resetSettings sets the named settings to the model's defaults and returns their new values.
+func resetSettings(cfg map[string]string, modelDef ModelDefinition, names []string) map[string]string {
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		applySetting(cfg, strings.ToUpper(name), defaultValueString(modelDef.Parameters[name]), sourceInteractive, "reset for "+cfg["MODEL"])
+		values[name] = cfg[strings.ToUpper(name)]
+	}
+	return values
+}
+
+// offerModelReplacement suggests the successor of a model the API rejected as unknown. In an
+// interactive session the user may switch the conversation to it right away.
+func offerModelReplacement(err error, cfg map[string]string, convFile string, interactive bool) {
+	if !isUnknownModelError(err, cfg["MODEL"]) {
+		return
+	}
+	successor, ok := ModelReplacements[cfg["MODEL"]]
+	if !ok {
+		return
+	}
+	if !interactive {
+		fmt.Fprintf(os.Stderr, "%s has been replaced by %s; use -m %s.\n", cfg["MODEL"], successor, successor)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s has been replaced by %s. Switch this conversation to it? [Y/n] ", cfg["MODEL"], successor)
+	answer, _ := readSingleLine(nil, []string{"\n"}, true)
+	if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "" && answer != "y" && answer != "yes" {
+		return
+	}
+	applySetting(cfg, "MODEL", successor, sourceInteractive, "replacement of "+cfg["MODEL"])
+	fmt.Fprintf(os.Stderr, "%sModel set to %s%s\n", green, successor, normal)
+	revalidateSettings(cfg, GetModelDefinition(successor))
+	if err := persistModelToFile(convFile, successor); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to save the model: %v%s\n", red, err, normal)
+	}
+	autosaveAfterChange(convFile, cfg)
+	fmt.Fprintln(os.Stderr, "Send your message again to retry.")
+}
+
 func handleInteractiveInput(userInput, convFile string, cfg map[string]string) bool {
 	trimmed := strings.TrimSpace(userInput)
 	parts := strings.Fields(trimmed)
@@ -1684,10 +2915,31 @@ func handleInteractiveInput(userInput, convFile string, cfg map[string]string) b
 	switch commandName {
 	case "exit", "quit":
 		fmt.Fprint(os.Stderr, "Bye.\n")
-		os.Exit(0)
+		closeControlSocket()
+		stopStatusLine()
+		stopSessionRecording()
+		os.Exit(exitOK)
+		return true
+	case "tools":
+		printTools()
+		return true
+	case "grep":
+		grepConversation(convFile, strings.TrimSpace(strings.TrimPrefix(trimmed, command)))
+		return true
+	case "last":
+		printLastResponse(parts[1:], convFile, os.Stdout)
+		return true
+	case "tee":
+		teeCommand(parts[1:])
+		return true
+	case "tokens":
+		printTokenCount(convFile)
+		return true
+	case "stats":
+		printSessionStats(convFile)
 		return true
 	case "history":
-		b, err := ioutil.ReadFile(convFile)
+		b, err := conversationData(convFile)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%sFailed reading conversation: %v%s\n", red, err, normal)
 		} else {
@@ -1712,12 +2964,17 @@ func handleInteractiveInput(userInput, convFile string, cfg map[string]string) b
 			fmt.Fprintln(os.Stderr, "Usage: /save <path>")
 			return true
 		}
-		if err := copyFile(convFile, parts[1]); err != nil {
+		if err := saveConversationCopy(convFile, parts[1]); err != nil {
 			fmt.Fprintf(os.Stderr, "%sFailed to save: %v%s\n", red, err, normal)
+		} else if ephemeralSession {
+			fmt.Fprintf(os.Stderr, "Saved to %s; this session stays in memory, continue it later with nvidia-chat %s\n", parts[1], parts[1])
 		} else {
 			fmt.Fprintf(os.Stderr, "Saved to %s\n", parts[1])
 		}
 		return true
+	case "system":
+		systemCommand(parts[1:], convFile, cfg)
+		return true
 	case "persist-system":
 		if len(parts) < 2 {
 			fmt.Fprintln(os.Stderr, "Usage: /persist-system <file>")
@@ -1732,40 +2989,64 @@ func handleInteractiveInput(userInput, convFile string, cfg map[string]string) b
 		if err := persistSystemToFile(convFile, string(content)); err != nil {
 			fmt.Fprintf(os.Stderr, "%sFailed to persist system prompt: %v%s\n", red, err, normal)
 		} else {
-			fmt.Fprintf(os.Stderr, "%sPersisted system prompt from %s%s\n", green, path, normal)
+			sessionSystem.content, sessionSystem.sources = "", nil
+			fmt.Fprintf(os.Stderr, "%sPersisted system prompt from %s %s%s\n", green, path, savedTo(convFile), normal)
 		}
 		return true
+	case "autosave":
+		setAutosave(parts[1:], convFile, cfg)
+		return true
+	case "settings":
+		printSettings(parts[1:], cfg)
+		return true
+	case "diff-settings":
+		diffSettings(convFile, cfg)
+		return true
 	case "persist-settings":
 		if err := persistSettingsToFile(convFile, cfg); err != nil {
 			fmt.Fprintf(os.Stderr, "%sFailed to persist settings: %v%s\n", red, err, normal)
 		} else {
-			fmt.Fprintf(os.Stderr, "%sPersisted current settings to %s%s\n", green, convFile, normal)
+			fmt.Fprintf(os.Stderr, "%sPersisted current settings %s%s\n", green, savedTo(convFile), normal)
 		}
 		return true
-	case "exportlast", "exportn", "exportlastn":
-		filterThinking, newParts := parseTFlag(parts)
+	case "exportlast", "exportn", "exportlastn", "exportrange":
+		opts, newParts := parseExportFlags(parts)
+		filterThinking := opts.filterThinking
 		var err error
+		target := ""
+		if len(newParts) > 1 {
+			target = newParts[len(newParts)-1]
+		}
 		switch commandName {
 		case "exportlast":
 			if len(newParts) < 2 {
-				fmt.Fprintln(os.Stderr, "Usage: /exportlast [-t] <file>")
+				fmt.Fprintln(os.Stderr, "Usage: /exportlast [-t] [-f] [--tags a,b] <file>")
 				return true
 			}
 			err = exportLastN(1, convFile, newParts[1], filterThinking)
 		case "exportn":
 			if len(newParts) < 3 {
-				fmt.Fprintln(os.Stderr, "Usage: /exportn [-t] <n> <file>")
+				fmt.Fprintln(os.Stderr, "Usage: /exportn [-t] [-f] <n> <file>")
 				return true
 			}
 			n, _ := strconv.Atoi(newParts[1])
 			err = exportNth(n, convFile, newParts[2], filterThinking)
 		case "exportlastn":
 			if len(newParts) < 3 {
-				fmt.Fprintln(os.Stderr, "Usage: /exportlastn [-t] <n> <file>")
+				fmt.Fprintln(os.Stderr, "Usage: /exportlastn [-t] [-f] <n> <file>")
 				return true
 			}
 			n, _ := strconv.Atoi(newParts[1])
 			err = exportLastN(n, convFile, newParts[2], filterThinking)
+		case "exportrange":
+			if len(newParts) < 3 {
+				fmt.Fprintln(os.Stderr, "Usage: /exportrange [-t] [-f] <from>..<to> <file>")
+				return true
+			}
+			err = exportRange(convFile, newParts[1], newParts[2], filterThinking)
+		}
+		if err == nil && opts.frontMatter {
+			err = prependFrontMatter(target, convFile, cfg, opts.tags)
 		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%sFailed to export: %v%s\n", red, err, normal)
@@ -1773,26 +3054,283 @@ func handleInteractiveInput(userInput, convFile string, cfg map[string]string) b
 			fmt.Fprintf(os.Stderr, "%sExport successful%s\n", green, normal)
 		}
 		return true
+	case "export":
+		filterThinking, args := false, []string{}
+		for _, p := range parts[1:] {
+			if p == "-t" {
+				filterThinking = true
+			} else {
+				args = append(args, p)
+			}
+		}
+		if len(args) != 2 || args[0] != "pdf" && args[0] != "org" {
+			fmt.Fprintln(os.Stderr, "Usage: /export pdf|org [-t] <file>")
+			return true
+		}
+		export := exportPDF
+		if args[0] == "org" {
+			export = exportOrg
+		}
+		if err := export(convFile, args[1], cfg, filterThinking); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to export: %v%s\n", red, err, normal)
+		} else {
+			fmt.Fprintf(os.Stderr, "%sWrote %s%s\n", green, args[1], normal)
+		}
+		return true
+	case "exportcurl":
+		if len(parts) < 2 {
+			fmt.Fprintln(os.Stderr, "Usage: /exportcurl <file>")
+			return true
+		}
+		if err := exportCurlScript(convFile, parts[1], cfg, sessionSystem.content); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to export: %v%s\n", red, err, normal)
+		} else {
+			fmt.Fprintf(os.Stderr, "%sWrote %s%s\n", green, parts[1], normal)
+		}
+		return true
+	case "share":
+		shareConversation(parts[1:], convFile)
+		return true
+	case "apply":
+		n := 1
+		if len(parts) > 1 {
+			v, err := strconv.Atoi(parts[1])
+			if err != nil || v < 1 {
+				fmt.Fprintln(os.Stderr, "Usage: /apply [n]")
+				return true
+			}
+			n = v
+		}
+		if err := applyResponsePatches(convFile, n); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to apply the patch: %v%s\n", red, err, normal)
+		}
+		return true
+	case "exportcode":
+		n, dir := 1, "."
+		args := parts[1:]
+		if len(args) > 0 {
+			if v, err := strconv.Atoi(args[0]); err == nil {
+				if v < 1 {
+					fmt.Fprintln(os.Stderr, "Usage: /exportcode [n] [dir]")
+					return true
+				}
+				n, args = v, args[1:]
+			}
+		}
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		if err := exportCodeBlocks(convFile, n, dir); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to export code: %v%s\n", red, err, normal)
+		}
+		return true
+	case "ab":
+		switch {
+		case len(parts) < 2 && abModel == "":
+			fmt.Fprintln(os.Stderr, "Usage: /ab <model> (send the next message to the current model and <model>), /ab off")
+		case len(parts) < 2:
+			fmt.Fprintf(os.Stderr, "The next message will be compared with %s\n", abModel)
+		case parts[1] == "off":
+			abModel = ""
+			fmt.Fprintf(os.Stderr, "%sA/B comparison cancelled%s\n", green, normal)
+		case parts[1] == cfg["MODEL"]:
+			fmt.Fprintf(os.Stderr, "%s%s is already the current model.%s\n", red, parts[1], normal)
+		case len(registeredTools) > 0:
+			fmt.Fprintf(os.Stderr, "%sCannot compare: %s.%s\n", red, abUnavailable(convFile), normal)
+		default:
+			abModel = parts[1]
+			fmt.Fprintf(os.Stderr, "%sThe next message will be sent to %s and %s%s\n", green, cfg["MODEL"], abModel, normal)
+		}
+		return true
+	case "summarize":
+		summarizeConversation(parts[1:], convFile, cfg)
+		return true
+	case "compact":
+		runCompactCommand(parts[1:], convFile, cfg)
+		return true
+	case "title":
+		setTitle(parts[1:], convFile, cfg)
+		return true
+	case "tag":
+		setTags(parts[1:], convFile)
+		return true
+	case "bookmark":
+		bookmarkMessage(parts[1:], convFile)
+		return true
+	case "bookmarks":
+		listBookmarks(convFile)
+		return true
+	case "exportbookmarks":
+		filterThinking, args := false, []string{}
+		for _, p := range parts[1:] {
+			if p == "-t" {
+				filterThinking = true
+			} else {
+				args = append(args, p)
+			}
+		}
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, "Usage: /exportbookmarks [-t] <file>")
+			return true
+		}
+		if err := exportBookmarks(convFile, args[0], filterThinking); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to export: %v%s\n", red, err, normal)
+		} else {
+			fmt.Fprintf(os.Stderr, "%sWrote %s%s\n", green, args[0], normal)
+		}
+		return true
+	case "rename":
+		if len(parts) != 2 {
+			fmt.Fprintln(os.Stderr, "Usage: /rename <new path>")
+			return true
+		}
+		target, err := renameConversation(convFile, parts[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to rename: %v%s\n", red, err, normal)
+			return true
+		}
+		renamedConvFile = target
+		fmt.Fprintf(os.Stderr, "%sConversation file: %s%s\n", green, target, normal)
+		return true
+	case "budget":
+		cf, err := readConversation(convFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed reading conversation: %v%s\n", red, err, normal)
+			return true
+		}
+		report, err := budgetReport(cf)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+			return true
+		}
+		fmt.Fprint(os.Stderr, report)
+		return true
+	case "gitdiff":
+		queueGitDiff(parts[1:])
+		return true
+	case "dictate":
+		dictateMessage(cfg)
+		return true
+	case "attachfile":
+		if len(parts) < 2 {
+			if len(pendingAttachments) == 0 {
+				fmt.Fprintln(os.Stderr, "Usage: /attachfile <path>... (no files pending)")
+			} else {
+				fmt.Fprintf(os.Stderr, "Files pending for the next message:\n")
+				for _, path := range pendingAttachments {
+					fmt.Fprintf(os.Stderr, "  %s\n", path)
+				}
+			}
+			return true
+		}
+		for _, path := range parts[1:] {
+			if _, err := readAttachment(path); err != nil {
+				fmt.Fprintf(os.Stderr, "%sCannot attach %s: %v%s\n", red, path, err, normal)
+				continue
+			}
+			pendingAttachments = append(pendingAttachments, path)
+			fmt.Fprintf(os.Stderr, "%sWill attach %s to the next message%s\n", green, path, normal)
+		}
+		return true
+	case "template":
+		if len(parts) < 2 {
+			names := listTemplates()
+			if len(names) == 0 {
+				fmt.Fprintf(os.Stderr, "Usage: /template <name> [key=value...]\nNo templates found in %s\n", templateDir())
+				return true
+			}
+			fmt.Fprintf(os.Stderr, "%sTemplates in %s:%s\n", bold, templateDir(), normal)
+			for _, name := range names {
+				fmt.Fprintf(os.Stderr, "  %s\n", name)
+			}
+			return true
+		}
+		vars, err := parseTemplateVars(parts[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+			return true
+		}
+		rendered, err := renderTemplate(parts[1], vars)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+			return true
+		}
+		queuedInput = rendered
+		return true
+	case "dryrun":
+		enabled := cfg["DRY_RUN"] != "true"
+		if len(parts) > 1 {
+			switch parts[1] {
+			case "on":
+				enabled = true
+			case "off":
+				enabled = false
+			default:
+				fmt.Fprintln(os.Stderr, "Usage: /dryrun [on|off]")
+				return true
+			}
+		}
+		cfg["DRY_RUN"] = strconv.FormatBool(enabled)
+		if enabled {
+			fmt.Fprintf(os.Stderr, "%sDry run enabled: requests will be printed instead of sent%s\n", green, normal)
+		} else {
+			fmt.Fprintf(os.Stderr, "%sDry run disabled%s\n", green, normal)
+		}
+		return true
+	case "agent":
+		enabled := findTool(shellToolName) == nil
+		if len(parts) > 1 {
+			switch parts[1] {
+			case "on":
+				enabled = true
+			case "off":
+				enabled = false
+			default:
+				fmt.Fprintln(os.Stderr, "Usage: /agent [on|off]")
+				return true
+			}
+		}
+		setAgentMode(enabled)
+		if enabled {
+			fmt.Fprintf(os.Stderr, "%sAgent mode enabled: the model may propose shell commands, which run after your confirmation%s\n", green, normal)
+		} else {
+			fmt.Fprintf(os.Stderr, "%sAgent mode disabled%s\n", green, normal)
+		}
+		return true
 	case "randomodel":
 		newModel := modelsList[rand.Intn(len(modelsList))]
-		cfg["MODEL"] = newModel
+		applySetting(cfg, "MODEL", newModel, sourceInteractive, "/randomodel")
 		fmt.Fprintf(os.Stderr, "%sSwitched model to %s%s\n", green, newModel, normal)
+		autosaveAfterChange(convFile, cfg)
 		return true
-	case "list":
-		fmt.Fprintf(os.Stderr, "%sSupported models:%s\n", bold, normal)
-		for _, m := range modelsList {
-			fmt.Fprintf(os.Stderr, "  %s\n", m)
+	case "list", "models":
+		filter, err := parseModelFilter(strings.Join(parts[1:], ","))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+			return true
 		}
+		fmt.Fprintf(os.Stderr, "%sSupported models:%s\n", bold, normal)
+		fmt.Fprint(os.Stderr, formatModelList(filter))
 		return true
 	case "help":
-		printInteractiveHelp()
+		if len(parts) > 1 {
+			printCommandHelp(parts[1], cfg)
+		} else {
+			printInteractiveHelp()
+		}
 		return true
 	case "model":
+		var modelName string
 		if len(parts) < 2 {
-			fmt.Fprintln(os.Stderr, "Usage: /model <model_name>")
-			return true
+			picked, ok := pickModel(cfg["MODEL"])
+			if !ok {
+				fmt.Fprintln(os.Stderr, "Model unchanged.")
+				return true
+			}
+			modelName = picked
+		} else {
+			modelName = parts[1]
 		}
-		modelName := parts[1]
 		if _, exists := ModelDefinitions[modelName]; !exists {
 			// Check if it's in the master list even if not in our detailed defs
 			found := false
@@ -1807,8 +3345,13 @@ func handleInteractiveInput(userInput, convFile string, cfg map[string]string) b
 				return true
 			}
 		}
-		cfg["MODEL"] = modelName
+		applySetting(cfg, "MODEL", modelName, sourceInteractive, "/model")
 		fmt.Fprintf(os.Stderr, "%sModel set to %s%s\n", green, modelName, normal)
+		revalidateSettings(cfg, GetModelDefinition(modelName))
+		if err := persistModelToFile(convFile, modelName); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to save the model: %v%s\n", red, err, normal)
+		}
+		autosaveAfterChange(convFile, cfg)
 		return true
 	case "modelinfo":
 		var modelName string
@@ -1847,6 +3390,7 @@ func handleInteractiveInput(userInput, convFile string, cfg map[string]string) b
 		allConfigurableParams := append(paramNames, "stream", "history_limit")
 
 		fmt.Fprintln(os.Stderr, "Interactively configure settings. Press Enter to keep the current value.")
+		changed := false
 
 		for _, paramName := range allConfigurableParams {
 			configKey := strings.ToUpper(paramName)
@@ -1871,75 +3415,85 @@ func handleInteractiveInput(userInput, convFile string, cfg map[string]string) b
 				continue
 			}
 
-			cfg[configKey] = newValue
+			applySetting(cfg, configKey, newValue, sourceInteractive, "/askfor_model_setting")
+			changed = true
 			fmt.Fprintf(os.Stderr, "  %sSet to %s%s\n", green, newValue, normal)
 		}
 		fmt.Fprintf(os.Stderr, "\n%sFinished updating settings.%s\n", green, normal)
+		if changed {
+			autosaveAfterChange(convFile, cfg)
+		}
 		return true
 	}
 
 	// --- Dynamic parameter setting commands ---
-	modelDef := GetModelDefinition(cfg["MODEL"])
-	if _, ok := modelDef.Parameters[commandName]; ok || commandName == "stream" || commandName == "history_limit" {
+	if isSessionParameter(cfg, commandName) {
 		if len(parts) < 2 {
 			fmt.Fprintf(os.Stderr, "Usage: /%s <value> or /%s unset\n", commandName, commandName)
 			return true
 		}
 		value := parts[1]
-		configKey := strings.ToUpper(commandName)
-
+		if err := setSessionParameter(cfg, commandName, value); err != nil {
+			fmt.Fprintf(os.Stderr, "%sError: %v%s\n", red, err, normal)
+			return true
+		}
 		if value == "unset" {
-			// Find the default value from the model definition and set it
-			param, exists := modelDef.Parameters[commandName]
-			if !exists {
-				// Handle global settings
-				if commandName == "stream" {
-					cfg["STREAM"] = strconv.FormatBool(true)
-				} else if commandName == "history_limit" {
-					cfg["HISTORY_LIMIT"] = fmt.Sprintf("%d", defaultHistoryLimit)
-				}
-			} else {
-				// Convert default value to string and set it in cfg
-				defaultValStr := ""
-				if f, ok := param.Default.(float64); ok {
-					defaultValStr = fmt.Sprintf("%g", f)
-				} else {
-					defaultValStr = fmt.Sprintf("%v", param.Default)
-				}
-				cfg[configKey] = defaultValStr
-			}
 			fmt.Fprintf(os.Stderr, "%s%s unset (reverted to default)%s\n", green, commandName, normal)
 		} else {
-			// Validate and set the new value
-			if err := validateParameter(commandName, value, modelDef); err != nil {
-				fmt.Fprintf(os.Stderr, "%sError: %v%s\n", red, err, normal)
-				return true
-			}
-			cfg[configKey] = value
 			fmt.Fprintf(os.Stderr, "%s%s set to %s%s\n", green, commandName, value, normal)
 		}
+		autosaveAfterChange(convFile, cfg)
 		return true
 	}
 
 	return false
 }
 
-func copyFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
+// isSessionParameter reports whether name is a parameter of the current model, stream or
+// history_limit, which can be set during a session.
+func isSessionParameter(cfg map[string]string, name string) bool {
+	_, ok := GetModelDefinition(cfg["MODEL"]).Parameters[name]
+	return ok || name == "stream" || name == "history_limit"
+}
+
+// setSessionParameter validates and sets a session parameter, or reverts it to its default when
+// value is "unset".
+func setSessionParameter(cfg map[string]string, name, value string) error {
+	modelDef := GetModelDefinition(cfg["MODEL"])
+	configKey := strings.ToUpper(name)
+	if value == "unset" {
+		// Find the default value from the model definition and set it
+		param, exists := modelDef.Parameters[name]
+		if !exists {
+			// Handle global settings
+			if name == "stream" {
+				applySetting(cfg, "STREAM", strconv.FormatBool(true), sourceInteractive, "/stream unset")
+			} else if name == "history_limit" {
+				applySetting(cfg, "HISTORY_LIMIT", fmt.Sprintf("%d", defaultHistoryLimit), sourceInteractive, "/history_limit unset")
+			}
+		} else {
+			applySetting(cfg, configKey, defaultValueString(param), sourceInteractive, "/"+name+" unset")
+		}
+		return nil
 	}
-	defer in.Close()
-	out, err := os.Create(dst)
-	if err != nil {
+	if err := validateParameter(name, value, modelDef); err != nil {
 		return err
 	}
-	defer out.Close()
-	_, err = io.Copy(out, in)
-	if err != nil {
-		return err
+	applySetting(cfg, configKey, value, sourceInteractive, "/"+name)
+	return nil
+}
+
+// lastByteWriter remembers the last byte written so callers can tell whether output ended with a newline.
+type lastByteWriter struct {
+	w    io.Writer
+	last byte
+}
+
+func (lw *lastByteWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		lw.last = p[len(p)-1]
 	}
-	return out.Sync()
+	return lw.w.Write(p)
 }
 
 func fileExists(path string) bool {
@@ -1948,75 +3502,54 @@ func fileExists(path string) bool {
 }
 
 // Quieter stream handler for --prompt mode
-func handleStreamQuiet(respBody io.Reader) error {
-	scanner := bufio.NewScanner(respBody)
-	const maxCapacity = 1024 * 1024
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, maxCapacity)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "data: ") {
-			line = strings.TrimPrefix(line, "data: ")
-		}
-		line = strings.TrimSpace(line)
-		if line == "" || line == "[DONE]" {
-			continue
+func handleStreamQuiet(respBody io.Reader, out io.Writer) (tokenUsage, error) {
+	var usage tokenUsage
+	stream := nvidiachat.NewStreamReader(respBody)
+	for {
+		d, err := stream.Next()
+		if err == io.EOF {
+			emitUsage(usage)
+			return usage, nil
 		}
-		var chunk StreamChunk
-		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
-			continue
+		if err != nil {
+			return usage, err
 		}
-		if len(chunk.Choices) > 0 {
-			choice := chunk.Choices[0]
-			var content string
-			if choice.Delta != nil && choice.Delta.Content != nil {
-				content = *choice.Delta.Content
-			} else if msg := choice.Message; msg != nil {
-				if v, ok := msg["content"].(string); ok {
-					content = v
-				}
-			}
-			if content != "" {
-				fmt.Print(content)
-			}
+		if d.Usage != nil {
+			usage = *d.Usage
+		}
+		emitChunk(d.Reasoning, d.Content)
+		if d.Content != "" {
+			fmt.Fprint(out, d.Content)
 		}
 	}
-	return scanner.Err()
 }
 
 // Quieter non-stream handler for --prompt mode
-func handleNonStreamQuiet(body []byte) error {
-	var j map[string]interface{}
-	if err := json.Unmarshal(body, &j); err != nil {
-		fmt.Print(string(body)) // fallback to printing raw body
-		return err
-	}
-	var content string
-	if choices, ok := j["choices"].([]interface{}); ok && len(choices) > 0 {
-		if first, ok := choices[0].(map[string]interface{}); ok {
-			if msg, ok := first["message"].(map[string]interface{}); ok {
-				if c, ok := msg["content"].(string); ok {
-					content = c
-				}
-			}
-		}
+func handleNonStreamQuiet(body []byte, out io.Writer) (tokenUsage, error) {
+	reply, err := nvidiachat.ParseResponse(body)
+	if err != nil {
+		fmt.Fprint(out, string(body)) // fallback to printing raw body
+		return tokenUsage{}, err
 	}
+	emitChunk(reply.Reasoning, reply.Content)
+	emitUsage(reply.Usage)
 
-	if content != "" {
-		fmt.Print(content)
+	if reply.Content != "" {
+		fmt.Fprint(out, reply.Content)
 	} else {
-		fmt.Print(string(body)) // fallback
+		fmt.Fprint(out, string(body)) // fallback
 	}
-	return nil
+	return reply.Usage, nil
 }
 
 // processSinglePrompt is for non-interactive mode. It sends a single prompt and prints the response.
-func processSinglePrompt(userInput string, cfg map[string]string, sysPromptContent, accessToken string) error {
-	var messages []Message
-	if sysPromptContent != "" {
-		messages = append(messages, Message{Role: "system", Content: sysPromptContent})
-	}
+func processSinglePrompt(userInput string, cfg map[string]string, sysPromptContent, accessToken string, out io.Writer) (err error) {
+	span := startSpan("chat.prompt")
+	span.set("model", cfg["MODEL"])
+	defer func() { span.finish(err) }()
+
+	build := startSpan("request.build")
+	messages := buildMessages(cfg, sysPromptContent, &ConversationFile{})
 	messages = append(messages, Message{Role: "user", Content: userInput})
 
 	payloadBytes, err := buildPayload(cfg, messages)
@@ -2024,27 +3557,68 @@ func processSinglePrompt(userInput string, cfg map[string]string, sysPromptConte
 		return fmt.Errorf("build payload: %w", err)
 	}
 
-	url := cfg["BASE_URL"] + "/chat/completions"
-	req, _ := http.NewRequest("POST", url, bytes.NewReader(payloadBytes))
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Content-Type", "application/json")
+	cacheKey := ""
+	if cfg["CACHE"] == "true" {
+		cacheKey = responseCacheKey(cfg, payloadBytes)
+		ttl, _ := parseDurationSetting(cfg["CACHE_TTL"])
+		if c, ok := lookupCachedResponse(cacheKey, ttl); ok {
+			span.set("cache_hit", true)
+			fmt.Fprintf(os.Stderr, "Using cached response from %s\n", c.Created.Format(time.RFC3339))
+			fmt.Fprint(out, c.Text)
+			emitChunk("", c.Text)
+			runResponseHook(cfg, "", c.Text)
+			return nil
+		}
+	}
+
+	if err := checkBudget(nil, messages); err != nil {
+		return err
+	}
+	req, err := newChatRequest(cfg, payloadBytes, accessToken)
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	build.set("request_bytes", len(payloadBytes))
+	build.finish(nil)
 
-	client := &http.Client{Timeout: 0}
-	resp, err := client.Do(req)
+	api := startSpan("api.request").client()
+	resp, err := sendChatRequest(cfg, req)
 	if err != nil {
 		return fmt.Errorf("request failed: %w", err)
 	}
+	resp.Body = rawBody(resp.Body)
 	defer resp.Body.Close()
+	api.set("http.status_code", resp.StatusCode)
 
 	if resp.StatusCode >= 400 {
 		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("api error: %s\n%s", resp.Status, string(body))
+		return newAPIError(resp, body)
 	}
+	api.finish(nil)
+	stream := startSpan("response.stream")
+	resp.Body = traceBody(resp.Body, stream)
 
+	// keep a copy of the reply for the response hook
+	var reply bytes.Buffer
+	stdout := newStreamWriter(out, cfg)
+	out = io.MultiWriter(stdout, &reply)
+	var usage tokenUsage
 	if cfg["STREAM"] == "true" {
-		return handleStreamQuiet(resp.Body)
+		usage, err = handleStreamQuiet(resp.Body, out)
 	} else {
 		body, _ := ioutil.ReadAll(resp.Body)
-		return handleNonStreamQuiet(body)
+		usage, err = handleNonStreamQuiet(body, out)
+	}
+	flushOutput(stdout)
+	recordUsage(usage)
+	stream.set("prompt_tokens", usage.PromptTokens)
+	stream.set("completion_tokens", usage.CompletionTokens)
+	stream.finish(err)
+	if err == nil && cacheKey != "" && reply.Len() > 0 {
+		if err := storeCachedResponse(cacheKey, cfg["MODEL"], reply.String()); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to cache the response: %v%s\n", red, err, normal)
+		}
 	}
+	runResponseHook(cfg, "", reply.String())
+	return err
 }
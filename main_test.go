@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the command itself instead of the tests when runCommand starts the test binary.
+func TestMain(m *testing.M) {
+	if os.Getenv("NVCHAT_TEST_RUN_MAIN") == "1" {
+		main()
+		os.Exit(exitOK)
+	}
+	os.Exit(m.Run())
+}
+
+// runCommand runs nvidia-chat with args in a fresh home directory, with stdin not a terminal, and
+// returns its exit code and its standard error.
+func runCommand(t *testing.T, env []string, args ...string) (int, string) {
+	t.Helper()
+	home := t.TempDir()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append([]string{
+		"NVCHAT_TEST_RUN_MAIN=1",
+		"HOME=" + home,
+		"XDG_CONFIG_HOME=" + filepath.Join(home, ".config"),
+		"XDG_CACHE_HOME=" + filepath.Join(home, ".cache"),
+		"PATH=" + os.Getenv("PATH"),
+	}, env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return exit.ExitCode(), stderr.String()
+	} else if err != nil {
+		t.Fatalf("running the command: %v", err)
+	}
+	return exitOK, stderr.String()
+}
+
+func TestRawOutputToFile(t *testing.T) {
+	stream := sseBody(deltaChunk(map[string]interface{}{"content": "raw answer"}))
+	api := newMockAPI(t, mockResponse{status: http.StatusOK, header: map[string]string{"Content-Type": "text/event-stream"}, body: stream})
+	file := filepath.Join(t.TempDir(), "out.txt")
+	code, stderr := runCommand(t, []string{"NVIDIA_BUILD_AI_ACCESS_TOKEN=test-key"},
+		"--base-url", api.URL, "--prompt", "hi", "--raw", "--output", file)
+	if code != exitOK {
+		t.Fatalf("exit code %d, stderr:\n%s", code, stderr)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"raw answer"`) || !strings.HasSuffix(string(data), "\n") {
+		t.Errorf("output file = %q, want the raw response ending with a newline", data)
+	}
+}
+
+func TestLegacyConversationWithoutSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.json")
+	legacy := `{"system":"Be brief.","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}]}`
+	if err := ioutil.WriteFile(path, []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := mockConfig("http://127.0.0.1:1/v1")
+	cfg["HISTORY_LIMIT"] = "40"
+	if err := ensureHistoryFileStructure(path, cfg); err != nil {
+		t.Fatalf("ensureHistoryFileStructure: %v", err)
+	}
+
+	if backups, _ := filepath.Glob(path + ".bak.*"); len(backups) > 0 {
+		t.Errorf("the file was backed up as malformed: %v", backups)
+	}
+	cf, err := readConversation(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cf.Messages) != 2 || cf.Messages[0].Content != "hi" || cf.Messages[1].Content != "hello" || cf.System != "Be brief." {
+		t.Errorf("conversation = %+v, want its system prompt and messages kept", cf)
+	}
+	s := cf.Settings
+	if s.Model != defaultModel || !s.Stream || s.HistoryLimit != 40 || s.Default == nil || s.Models == nil {
+		t.Errorf("settings = %+v, want the defaults of a new file", s)
+	}
+}
+
+func TestMessageCountLeavesOutQueuedMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queued.json")
+	conv := `{"system":"","settings":{"stream":true,"history_limit":40,"default":{},"models":{}},"messages":[
+		{"role":"user","content":"old","archived":true},
+		{"role":"assistant","content":"old reply","archived":true},
+		{"role":"user","content":"sent"},
+		{"role":"assistant","content":"reply"},
+		{"role":"user","content":"queued 1","status":"pending"},
+		{"role":"user","content":"queued 2","status":"pending"}]}`
+	if err := ioutil.WriteFile(path, []byte(conv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	n, err := messageCount(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("messageCount = %d, want the 2 messages sent", n)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// mcpProtocolVersion is the Model Context Protocol revision this client speaks.
+const mcpProtocolVersion = "2024-11-05"
+
+// mcpServerConfig describes how to launch an MCP server over stdio. The config file uses the
+// common {"mcpServers": {"name": {...}}} layout.
+type mcpServerConfig struct {
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Env     map[string]string `json:"env"`
+}
+
+type mcpConfigFile struct {
+	MCPServers map[string]mcpServerConfig `json:"mcpServers"`
+}
+
+// mcpConfigPath returns the default MCP server configuration file.
+func mcpConfigPath() string {
+	return filepath.Join(configDir(), "mcp.json")
+}
+
+// mcpClient is a JSON-RPC connection to one MCP server process.
+type mcpClient struct {
+	name   string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	mu     sync.Mutex
+	nextID int
+}
+
+type mcpResponse struct {
+	ID     *int            `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type mcpTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+// startMCPClient launches the server and performs the initialize handshake.
+func startMCPClient(name string, sc mcpServerConfig) (*mcpClient, error) {
+	cmd := exec.Command(sc.Command, sc.Args...)
+	cmd.Env = os.Environ()
+	for k, v := range sc.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stderr = ioutil.Discard
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	c := &mcpClient{name: name, cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}
+
+	initParams := map[string]interface{}{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]string{"name": "nvidia-chat", "version": "1.0"},
+	}
+	if _, err := c.call("initialize", initParams); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("initialize: %w", err)
+	}
+	if err := c.send(map[string]interface{}{"jsonrpc": "2.0", "method": "notifications/initialized"}); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *mcpClient) send(msg interface{}) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = c.stdin.Write(append(b, '\n'))
+	return err
+}
+
+// call sends a request and waits for its response, skipping notifications and server requests.
+func (c *mcpClient) call(method string, params interface{}) (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	id := c.nextID
+	if err := c.send(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params}); err != nil {
+		return nil, err
+	}
+	for {
+		line, err := c.stdout.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			var resp mcpResponse
+			if json.Unmarshal(line, &resp) == nil && resp.ID != nil && *resp.ID == id && (resp.Result != nil || resp.Error != nil) {
+				if resp.Error != nil {
+					return nil, fmt.Errorf("%s (code %d)", resp.Error.Message, resp.Error.Code)
+				}
+				return resp.Result, nil
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("server %s closed the connection: %w", c.name, err)
+		}
+	}
+}
+
+// listTools returns all tools the server exposes, following pagination.
+func (c *mcpClient) listTools() ([]mcpTool, error) {
+	var tools []mcpTool
+	params := map[string]interface{}{}
+	for {
+		raw, err := c.call("tools/list", params)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Tools      []mcpTool `json:"tools"`
+			NextCursor string    `json:"nextCursor"`
+		}
+		if err := json.Unmarshal(raw, &page); err != nil {
+			return nil, err
+		}
+		tools = append(tools, page.Tools...)
+		if page.NextCursor == "" {
+			return tools, nil
+		}
+		params = map[string]interface{}{"cursor": page.NextCursor}
+	}
+}
+
+// callTool invokes a tool and flattens its text content into a single string.
+func (c *mcpClient) callTool(name, arguments string) (string, error) {
+	args := map[string]interface{}{}
+	if strings.TrimSpace(arguments) != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return "", fmt.Errorf("invalid tool arguments: %w", err)
+		}
+	}
+	raw, err := c.call("tools/call", map[string]interface{}{"name": name, "arguments": args})
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		IsError bool `json:"isError"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return "", err
+	}
+	var parts []string
+	for _, item := range result.Content {
+		if item.Type == "text" {
+			parts = append(parts, item.Text)
+		} else {
+			parts = append(parts, fmt.Sprintf("[%s content omitted]", item.Type))
+		}
+	}
+	text := strings.Join(parts, "\n")
+	if result.IsError {
+		return "", errors.New(text)
+	}
+	return text, nil
+}
+
+// Close stops the server process.
+func (c *mcpClient) Close() {
+	c.stdin.Close()
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+	}
+	c.cmd.Wait()
+}
+
+var unsafeToolNameChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// mcpToolName namespaces a server's tool so names from different servers cannot clash.
+// Function names are limited to 64 characters by the API.
+func mcpToolName(server, tool string) string {
+	name := unsafeToolNameChars.ReplaceAllString(server+"__"+tool, "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// loadMCPServers starts the servers listed in the config file (the default one if path is empty)
+// and registers their tools. A missing default config file is not an error; servers that fail to
+// start are reported and skipped.
+func loadMCPServers(path string) ([]*mcpClient, error) {
+	required := path != ""
+	if path == "" {
+		path = mcpConfigPath()
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var conf mcpConfigFile
+	if err := json.Unmarshal(b, &conf); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	names := make([]string, 0, len(conf.MCPServers))
+	for name := range conf.MCPServers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var clients []*mcpClient
+	for _, name := range names {
+		client, err := startMCPClient(name, conf.MCPServers[name])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sMCP server %s: %v%s\n", red, name, err, normal)
+			continue
+		}
+		tools, err := client.listTools()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sMCP server %s: list tools: %v%s\n", red, name, err, normal)
+			client.Close()
+			continue
+		}
+		for _, t := range tools {
+			c, toolName := client, t.Name
+			registerTool(&Tool{
+				Name:        mcpToolName(name, t.Name),
+				Description: t.Description,
+				Parameters:  t.InputSchema,
+				Source:      "mcp:" + name,
+				Run: func(arguments string) (string, error) {
+					return c.callTool(toolName, arguments)
+				},
+			})
+		}
+		fmt.Fprintf(os.Stderr, "%sMCP server %s: %d tool(s)%s\n", green, name, len(tools), normal)
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
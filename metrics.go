@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request duration histogram.
+var latencyBuckets = []float64{0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// tokenUsage is the "usage" object of a chat completion response.
+type tokenUsage = nvidiachat.Usage
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// serveMetrics collects per-model statistics of the proxy and renders them in the Prometheus
+// text exposition format.
+type serveMetrics struct {
+	mu        sync.Mutex
+	requests  map[[2]string]uint64 // {model, status code or "error"}
+	latencies map[string]*histogram
+	tokens    map[[2]string]uint64 // {model, "prompt" or "completion"}
+}
+
+func newServeMetrics() *serveMetrics {
+	return &serveMetrics{
+		requests:  map[[2]string]uint64{},
+		latencies: map[string]*histogram{},
+		tokens:    map[[2]string]uint64{},
+	}
+}
+
+// observe records one forwarded request. status is the backend's HTTP status, or 0 if it could not be reached.
+func (m *serveMetrics) observe(model string, status int, elapsed time.Duration, usage tokenUsage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code := "error"
+	if status > 0 {
+		code = strconv.Itoa(status)
+	}
+	m.requests[[2]string{model, code}]++
+
+	h := m.latencies[model]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latencies[model] = h
+	}
+	secs := elapsed.Seconds()
+	for i, bound := range latencyBuckets {
+		if secs <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += secs
+	h.count++
+
+	m.tokens[[2]string{model, "prompt"}] += uint64(usage.PromptTokens)
+	m.tokens[[2]string{model, "completion"}] += uint64(usage.CompletionTokens)
+}
+
+func (m *serveMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+func (m *serveMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP nvidia_chat_requests_total Chat completion requests forwarded, by model and backend status (\"error\" if the backend was unreachable).")
+	fmt.Fprintln(w, "# TYPE nvidia_chat_requests_total counter")
+	for _, k := range sortedPairs(m.requests) {
+		fmt.Fprintf(w, "nvidia_chat_requests_total{model=%s,status=%s} %d\n", promLabel(k[0]), promLabel(k[1]), m.requests[k])
+	}
+
+	fmt.Fprintln(w, "# HELP nvidia_chat_request_duration_seconds Time until the backend response was fully relayed.")
+	fmt.Fprintln(w, "# TYPE nvidia_chat_request_duration_seconds histogram")
+	models := make([]string, 0, len(m.latencies))
+	for model := range m.latencies {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		h := m.latencies[model]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "nvidia_chat_request_duration_seconds_bucket{model=%s,le=\"%s\"} %d\n", promLabel(model), strconv.FormatFloat(bound, 'f', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "nvidia_chat_request_duration_seconds_bucket{model=%s,le=\"+Inf\"} %d\n", promLabel(model), h.count)
+		fmt.Fprintf(w, "nvidia_chat_request_duration_seconds_sum{model=%s} %s\n", promLabel(model), strconv.FormatFloat(h.sum, 'f', -1, 64))
+		fmt.Fprintf(w, "nvidia_chat_request_duration_seconds_count{model=%s} %d\n", promLabel(model), h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP nvidia_chat_tokens_total Tokens reported in response usage, by model and type.")
+	fmt.Fprintln(w, "# TYPE nvidia_chat_tokens_total counter")
+	for _, k := range sortedPairs(m.tokens) {
+		fmt.Fprintf(w, "nvidia_chat_tokens_total{model=%s,type=%s} %d\n", promLabel(k[0]), promLabel(k[1]), m.tokens[k])
+	}
+}
+
+func sortedPairs(m map[[2]string]uint64) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+// promLabel quotes a label value for the exposition format.
+func promLabel(v string) string {
+	v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+	return `"` + v + `"`
+}
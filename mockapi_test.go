@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockResponse is a canned answer of the mock API.
+type mockResponse struct {
+	status int
+	header map[string]string
+	body   string
+}
+
+// mockAPI is an httptest server standing in for the chat completions endpoint. It answers with its
+// responses in order, repeating the last one, and keeps the request bodies and headers it received.
+type mockAPI struct {
+	*httptest.Server
+	mu        sync.Mutex
+	responses []mockResponse
+	bodies    []string
+	headers   []http.Header
+}
+
+func newMockAPI(t *testing.T, responses ...mockResponse) *mockAPI {
+	t.Helper()
+	m := &mockAPI{responses: responses}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		m.mu.Lock()
+		m.bodies = append(m.bodies, string(body))
+		m.headers = append(m.headers, r.Header.Clone())
+		n := len(m.bodies) - 1
+		if n >= len(m.responses) {
+			n = len(m.responses) - 1
+		}
+		resp := m.responses[n]
+		m.mu.Unlock()
+		for name, value := range resp.header {
+			w.Header().Set(name, value)
+		}
+		w.WriteHeader(resp.status)
+		fmt.Fprint(w, resp.body)
+	}))
+	t.Cleanup(m.Close)
+	return m
+}
+
+// requests returns the bodies of the requests received so far.
+func (m *mockAPI) requests() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.bodies...)
+}
+
+// requestHeaders returns the headers of the requests received so far.
+func (m *mockAPI) requestHeaders() []http.Header {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]http.Header(nil), m.headers...)
+}
+
+// sseBody returns a streamed response made of the given chunks, as the API sends it.
+func sseBody(chunks ...interface{}) string {
+	var b strings.Builder
+	for _, c := range chunks {
+		data, _ := json.Marshal(c)
+		b.WriteString("data: " + string(data) + "\n\n")
+	}
+	b.WriteString("data: [DONE]\n\n")
+	return b.String()
+}
+
+func deltaChunk(delta map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"choices": []interface{}{map[string]interface{}{"delta": delta}}}
+}
+
+func mockConfig(baseURL string) map[string]string {
+	return map[string]string{
+		"BASE_URL":        baseURL,
+		"MODEL":           defaultModel,
+		"STREAM":          "true",
+		"TIMEOUT":         "10",
+		"CONNECT_TIMEOUT": "5",
+		"IDLE_TIMEOUT":    "5",
+		"MAX_RETRIES":     "0",
+	}
+}
+
+// postChat sends payload to the mock through sendChatRequest and returns the status and the body.
+func postChat(t *testing.T, cfg map[string]string, payload string) (int, string, error) {
+	t.Helper()
+	req, err := newChatRequest(cfg, []byte(payload), "test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := sendChatRequest(cfg, req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body), nil
+}
+
+func TestHandleStream(t *testing.T) {
+	body := sseBody(
+		deltaChunk(map[string]interface{}{"reasoning_content": "Let me think."}),
+		deltaChunk(map[string]interface{}{"content": "Hello"}),
+		deltaChunk(map[string]interface{}{"content": ", world"}),
+		deltaChunk(map[string]interface{}{"tool_calls": []interface{}{map[string]interface{}{
+			"index": 0, "id": "call_1", "type": "function",
+			"function": map[string]interface{}{"name": "shell", "arguments": `{"command":`},
+		}}}),
+		deltaChunk(map[string]interface{}{"tool_calls": []interface{}{map[string]interface{}{
+			"index": 0, "function": map[string]interface{}{"arguments": `"ls"}`},
+		}}}),
+		map[string]interface{}{"choices": []interface{}{}, "usage": map[string]int{"prompt_tokens": 12, "completion_tokens": 5}},
+	)
+	var out bytes.Buffer
+	text, calls, usage, err := handleStream(strings.NewReader(body), "", &out)
+	if err != nil {
+		t.Fatalf("handleStream: %v", err)
+	}
+	want := "[Begin of Assistant Reasoning]\nLet me think.\n[/End of Assistant Reasoning]\n\nHello, world"
+	if text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+	if !strings.Contains(out.String(), "\n[Begin of Assistant Reasoning]\n") || !strings.HasSuffix(out.String(), "Hello, world\n") {
+		t.Errorf("output = %q, want plain reasoning markers and the answer", out.String())
+	}
+	if len(calls) != 1 || calls[0].Function.Name != "shell" || calls[0].Function.Arguments != `{"command":"ls"}` {
+		t.Errorf("tool calls = %+v, want one merged shell call", calls)
+	}
+	if usage.PromptTokens != 12 || usage.CompletionTokens != 5 {
+		t.Errorf("usage = %+v, want 12 prompt and 5 completion tokens", usage)
+	}
+}
+
+func TestHandleStreamWithoutDone(t *testing.T) {
+	// a stream cut off before [DONE] still returns what arrived
+	body := strings.TrimSuffix(sseBody(deltaChunk(map[string]interface{}{"content": "partial"})), "data: [DONE]\n\n")
+	var out bytes.Buffer
+	text, _, _, err := handleStream(strings.NewReader(body), "", &out)
+	if err != nil {
+		t.Fatalf("handleStream: %v", err)
+	}
+	if text != "partial" {
+		t.Errorf("text = %q, want %q", text, "partial")
+	}
+}
+
+func TestHandleNonStream(t *testing.T) {
+	body := `{"choices":[{"message":{"role":"assistant","content":"Paris.","reasoning_content":"A capital."}}],` +
+		`"usage":{"prompt_tokens":9,"completion_tokens":2}}`
+	var out bytes.Buffer
+	text, calls, usage, err := handleNonStream([]byte(body), &out)
+	if err != nil {
+		t.Fatalf("handleNonStream: %v", err)
+	}
+	if !strings.Contains(text, "A capital.") || !strings.HasSuffix(text, "Paris.") {
+		t.Errorf("text = %q, want the reasoning then the answer", text)
+	}
+	if !strings.Contains(out.String(), "[Begin of Assistant Reasoning]") || !strings.Contains(out.String(), "Paris.") {
+		t.Errorf("output = %q", out.String())
+	}
+	if len(calls) != 0 || usage.PromptTokens != 9 || usage.CompletionTokens != 2 {
+		t.Errorf("calls = %+v, usage = %+v", calls, usage)
+	}
+
+	if _, _, _, err := handleNonStream([]byte("not json"), &out); err == nil {
+		t.Error("handleNonStream accepted a body that is not JSON")
+	}
+}
+
+func TestSendChatRequestRetries(t *testing.T) {
+	api := newMockAPI(t,
+		mockResponse{status: http.StatusServiceUnavailable, body: `{"error":"overloaded"}`},
+		mockResponse{status: http.StatusOK, body: `{"choices":[{"message":{"content":"ok"}}]}`},
+	)
+	cfg := mockConfig(api.URL)
+	cfg["MAX_RETRIES"] = "1"
+	payload := `{"model":"m","messages":[{"role":"user","content":"hi"}]}`
+	status, body, err := postChat(t, cfg, payload)
+	if err != nil {
+		t.Fatalf("sendChatRequest: %v", err)
+	}
+	if status != http.StatusOK || !strings.Contains(body, `"ok"`) {
+		t.Errorf("got %d %s, want the answer of the retry", status, body)
+	}
+	requests := api.requests()
+	if len(requests) != 2 {
+		t.Fatalf("the server got %d requests, want 2", len(requests))
+	}
+	for i, r := range requests {
+		if r != payload {
+			t.Errorf("request %d sent %q, want the payload again", i+1, r)
+		}
+	}
+}
+
+func TestSendChatRequestDoesNotRetryClientErrors(t *testing.T) {
+	api := newMockAPI(t, mockResponse{status: http.StatusBadRequest, body: `{"error":"bad request"}`})
+	cfg := mockConfig(api.URL)
+	cfg["MAX_RETRIES"] = "3"
+	status, _, err := postChat(t, cfg, `{"model":"m","messages":[]}`)
+	if err != nil {
+		t.Fatalf("sendChatRequest: %v", err)
+	}
+	if status != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", status)
+	}
+	if n := len(api.requests()); n != 1 {
+		t.Errorf("the server got %d requests, want 1", n)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fuzzyScore reports whether the characters of pattern appear in order in s, ignoring case.
+// Lower scores are better matches: gaps between matched characters and a late first match cost.
+func fuzzyScore(pattern, s string) (int, bool) {
+	pattern, s = strings.ToLower(pattern), strings.ToLower(s)
+	if i := strings.Index(s, pattern); i >= 0 {
+		return i, true // substring matches rank first
+	}
+	score, pos, last := len(s), 0, -1
+	for _, r := range pattern {
+		if r == ' ' {
+			continue
+		}
+		i := strings.IndexRune(s[pos:], r)
+		if i < 0 {
+			return 0, false
+		}
+		if last >= 0 {
+			score += pos + i - last - 1
+		}
+		last = pos + i
+		pos = last + 1
+	}
+	return score, true
+}
+
+// fuzzyRank returns the indexes of the items matching the query, best matches first.
+func fuzzyRank(items []string, query string) []int {
+	type match struct {
+		score int
+		index int
+	}
+	var matches []match
+	for i, item := range items {
+		if score, ok := fuzzyScore(strings.TrimSpace(query), item); ok {
+			matches = append(matches, match{score, i})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score < matches[j].score
+		}
+		return matches[i].index < matches[j].index
+	})
+	indexes := make([]int, len(matches))
+	for i, m := range matches {
+		indexes[i] = m.index
+	}
+	return indexes
+}
+
+// fuzzyFilterModels returns the models matching the query, best matches first.
+func fuzzyFilterModels(models []string, query string) []string {
+	if strings.TrimSpace(query) == "" {
+		return models
+	}
+	var names []string
+	for _, i := range fuzzyRank(models, query) {
+		names = append(names, models[i])
+	}
+	return names
+}
+
+// pickModel lets the user choose a model by typing part of its name to narrow the list, or a number
+// to select an entry. It returns false when the user cancels with an empty line.
+func pickModel(current string) (string, bool) {
+	return pickModelFrom(modelsList, current)
+}
+
+// pickModelFrom is pickModel choosing among models.
+func pickModelFrom(models []string, current string) (string, bool) {
+	candidates := models
+	for {
+		fmt.Fprintf(os.Stderr, "%sModels:%s\n", bold, normal)
+		for i, name := range candidates {
+			marker := " "
+			if name == current {
+				marker = "*"
+			}
+			md := GetModelDefinition(name)
+			note := strings.Join(md.Capabilities, ",")
+			if md.ContextWindow > 0 {
+				note = strings.TrimLeft(note+fmt.Sprintf(" %dk", md.ContextWindow/1024), " ")
+			}
+			fmt.Fprintf(os.Stderr, "%s%3d) %-45s %s\n", marker, i+1, name, note)
+		}
+		if len(candidates) == 1 {
+			fmt.Fprintf(os.Stderr, "Select %s? [Y/n, or type to search again] ", candidates[0])
+		} else {
+			fmt.Fprint(os.Stderr, "Type to search, a number to select, or Enter to cancel: ")
+		}
+		input, err := readSingleLine(nil, []string{"\n"}, true)
+		input = strings.TrimSpace(input)
+		if len(candidates) == 1 && (strings.EqualFold(input, "y") || (input == "" && err == nil)) {
+			return candidates[0], true
+		}
+		if input == "" || err != nil {
+			return "", false
+		}
+		if len(candidates) == 1 && strings.EqualFold(input, "n") {
+			return "", false
+		}
+		if n, err := strconv.Atoi(input); err == nil {
+			if n >= 1 && n <= len(candidates) {
+				return candidates[n-1], true
+			}
+			fmt.Fprintf(os.Stderr, "%sNo entry %d.%s\n", red, n, normal)
+			continue
+		}
+		matches := fuzzyFilterModels(models, input)
+		if len(matches) == 0 {
+			fmt.Fprintf(os.Stderr, "%sNo model matches %q.%s\n", red, input, normal)
+			continue
+		}
+		candidates = matches
+	}
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -14,8 +19,46 @@ const (
 	String  ParameterType = "string"
 	Bool    ParameterType = "bool"
 	StringA ParameterType = "string_array"
+	// IntMap is a comma-separated list of key:value pairs with integer values, such as the token
+	// weights of logit_bias ("1234:-100,5678:5"), sent as a JSON object.
+	IntMap ParameterType = "int_map"
 )
 
+// parseIntMap parses the value of an IntMap parameter. An empty value is an empty map.
+func parseIntMap(value string) (map[string]int, error) {
+	m := map[string]int{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid pair %q (expected key:value)", pair)
+		}
+		v, err := strconv.Atoi(strings.TrimSpace(pair[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer value in %q", pair)
+		}
+		m[strings.TrimSpace(pair[:i])] = v
+	}
+	return m, nil
+}
+
+// formatIntMap formats an IntMap value, with its keys sorted.
+func formatIntMap(m map[string]int) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s:%d", k, m[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
 // ModelParameter defines the schema for a single model setting.
 type ModelParameter struct {
 	Type        ParameterType `json:"type"`
@@ -29,6 +72,20 @@ type ModelParameter struct {
 
 // ModelDefinition holds all the parameters for a specific model.
 type ModelDefinition struct {
+	// Capabilities are tags from modelCapabilities, used to filter the model list.
+	Capabilities []string `json:"capabilities,omitempty"`
+	// ContextWindow is the maximum number of prompt and output tokens, 0 when unknown.
+	ContextWindow int `json:"context_window,omitempty"`
+
+	// BaseURL overrides the API endpoint for this model, e.g. a self-hosted NIM deployment.
+	// It is ignored when --base-url is given.
+	BaseURL string `json:"base_url,omitempty"`
+
+	// RoleMap renames message roles for the model's backend. Without one, developer messages are
+	// sent as system messages, which every backend understands; {"developer": "developer"} keeps
+	// them for backends that distinguish the two.
+	RoleMap map[string]string `json:"role_map,omitempty"`
+
 	// Special properties for some models
 	PrependedSystemMessageOnThinking string `json:"prepended_system_message_on_thinking,omitempty"`
 	ChatTemplateKwargsThinking       bool   `json:"chat_template_kwargs_thinking,omitempty"`
@@ -36,9 +93,14 @@ type ModelDefinition struct {
 	Parameters map[string]ModelParameter `json:"parameters"`
 }
 
+// modelCapabilities are the capability tags a model definition may declare.
+var modelCapabilities = []string{"vision", "tools", "reasoning", "code"}
+
 // ModelDefinitions is a map of all supported model definitions.
 var ModelDefinitions = map[string]ModelDefinition{
 	"openai/gpt-oss-120b": {
+		Capabilities:  []string{"reasoning", "tools", "code"},
+		ContextWindow: 131072,
 		Parameters: map[string]ModelParameter{
 			"temperature":       {Type: Float, Default: 1.0, Min: 0, Max: 1, Description: "The sampling temperature to use for text generation. The higher the temperature value is, the less deterministic the output text will be. It is not recommended to modify both temperature and top_p in the same call.", APIKey: "temperature"},
 			"top_p":             {Type: Float, Default: 1.0, Min: 0.01, Max: 1, Description: "The top-p sampling mass used for text generation. The top-p value determines the probability mass that is sampled at sampling time. For example, if top_p = 0.2, only the most likely tokens (summing to 0.2 cumulative probability) will be sampled. It is not recommended to modify both temperature and top_p in the same call.", APIKey: "top_p"},
@@ -46,10 +108,13 @@ var ModelDefinitions = map[string]ModelDefinition{
 			"presence_penalty":  {Type: Float, Default: 0.0, Min: -2, Max: 2, Description: "Positive values penalize new tokens based on whether they appear in the text so far, increasing model likelihood to talk about new topics.", APIKey: "presence_penalty"},
 			"max_tokens":        {Type: Int, Default: 4096, Min: 1, Max: 4096, Description: "The maximum number of tokens to generate in any given call. Note that the model is not aware of this value, and generation will simply stop at the number of tokens specified.", APIKey: "max_tokens"},
 			"stop":              {Type: StringA, Default: "", Description: "A string or a list of strings where the API will stop generating further tokens. The returned text will not contain the stop sequence.", APIKey: "stop"},
+			"logit_bias":        {Type: IntMap, Default: "", Min: -100, Max: 100, Description: "Token IDs mapped to a bias from -100 (ban the token) to 100 (force it), added to their logits before sampling, e.g. 1234:-100,5678:5.", APIKey: "logit_bias"},
 			"reasoning_effort":  {Type: String, Default: "medium", Options: []string{"low", "medium", "high"}, Description: "Controls the effort level for reasoning in reasoning-capable models. 'low' provides basic reasoning, 'medium' provides balanced reasoning, and 'high' provides detailed step-by-step reasoning.", APIKey: "reasoning_effort"},
 		},
 	},
 	"bytedance/seed-oss-36b-instruct": {
+		Capabilities:  []string{"reasoning", "tools", "code"},
+		ContextWindow: 524288,
 		Parameters: map[string]ModelParameter{
 			"temperature":       {Type: Float, Default: 1.1, Min: 0, Max: 2, Description: "The sampling temperature to use for text generation.", APIKey: "temperature"},
 			"top_p":             {Type: Float, Default: 0.95, Min: 0.01, Max: 1, Description: "The top-p sampling mass used for text generation.", APIKey: "top_p"},
@@ -62,6 +127,8 @@ var ModelDefinitions = map[string]ModelDefinition{
 		},
 	},
 	"qwen/qwen3-coder-480b-a35b-instruct": {
+		Capabilities:  []string{"tools", "code"},
+		ContextWindow: 262144,
 		Parameters: map[string]ModelParameter{
 			"temperature":       {Type: Float, Default: 0.7, Min: 0, Max: 1, Description: "Sampling temperature.", APIKey: "temperature"},
 			"top_p":             {Type: Float, Default: 0.8, Min: 0.01, Max: 1, Description: "Top-p sampling.", APIKey: "top_p"},
@@ -69,9 +136,12 @@ var ModelDefinitions = map[string]ModelDefinition{
 			"presence_penalty":  {Type: Float, Default: 0.0, Min: -2, Max: 2, Description: "Presence penalty.", APIKey: "presence_penalty"},
 			"max_tokens":        {Type: Int, Default: 4096, Min: 1, Max: 16384, Description: "Maximum tokens to generate.", APIKey: "max_tokens"},
 			"stop":              {Type: StringA, Default: "", Description: "Stop sequences.", APIKey: "stop"},
+			"min_tokens":        {Type: Int, Default: 0, Min: 0, Max: 16384, Description: "Minimum tokens to generate, e.g. to keep summaries from being one-liners. At most max_tokens; default 0 means not included.", APIKey: "min_tokens"},
 		},
 	},
 	"nvidia/nvidia-nemotron-nano-9b-v2": {
+		Capabilities:                     []string{"reasoning", "tools"},
+		ContextWindow:                    131072,
 		PrependedSystemMessageOnThinking: "/think",
 		Parameters: map[string]ModelParameter{
 			"temperature":         {Type: Float, Default: 0.6, Min: 0, Max: 1, Description: "Sampling temperature.", APIKey: "temperature"},
@@ -82,10 +152,15 @@ var ModelDefinitions = map[string]ModelDefinition{
 			"frequency_penalty":   {Type: Float, Default: 0.0, Min: -2, Max: 2, Description: "Frequency penalty.", APIKey: "frequency_penalty"},
 			"presence_penalty":    {Type: Float, Default: 0.0, Min: -2, Max: 2, Description: "Presence penalty.", APIKey: "presence_penalty"},
 			"stop":                {Type: StringA, Default: "", Description: "Stop sequences.", APIKey: "stop"},
+			"logit_bias":          {Type: IntMap, Default: "", Min: -100, Max: 100, Description: "Token IDs mapped to a bias from -100 (ban the token) to 100 (force it), added to their logits before sampling, e.g. 1234:-100,5678:5.", APIKey: "logit_bias"},
 			"seed":                {Type: Int, Default: 0, Description: "Seed for reproducibility. Default 0 means not included.", APIKey: "seed"},
+			"min_tokens":          {Type: Int, Default: 0, Min: 0, Max: 8192, Description: "Minimum tokens to generate, e.g. to keep summaries from being one-liners. At most max_tokens; default 0 means not included.", APIKey: "min_tokens"},
+			"length_penalty":      {Type: Float, Default: 1.0, Min: 0, Max: 10, Description: "Length penalty: above 1 favors longer responses, below 1 shorter ones. Default 1 means not included.", APIKey: "length_penalty"},
 		},
 	},
 	"nvidia/llama-3.3-nemotron-super-49b-v1.5": {
+		Capabilities:                     []string{"reasoning", "tools"},
+		ContextWindow:                    131072,
 		PrependedSystemMessageOnThinking: "/think",
 		Parameters: map[string]ModelParameter{
 			"temperature":       {Type: Float, Default: 0.6, Min: 0, Max: 1, Description: "Sampling temperature.", APIKey: "temperature"},
@@ -94,11 +169,16 @@ var ModelDefinitions = map[string]ModelDefinition{
 			"frequency_penalty": {Type: Float, Default: 0.0, Min: -2, Max: 2, Description: "Frequency penalty.", APIKey: "frequency_penalty"},
 			"presence_penalty":  {Type: Float, Default: 0.0, Min: -2, Max: 2, Description: "Presence penalty.", APIKey: "presence_penalty"},
 			"stop":              {Type: StringA, Default: "", Description: "Stop sequences.", APIKey: "stop"},
+			"logit_bias":        {Type: IntMap, Default: "", Min: -100, Max: 100, Description: "Token IDs mapped to a bias from -100 (ban the token) to 100 (force it), added to their logits before sampling, e.g. 1234:-100,5678:5.", APIKey: "logit_bias"},
 			"seed":              {Type: Int, Default: 0, Description: "Seed for reproducibility. Default 0 means not included.", APIKey: "seed"},
 			"thinking":          {Type: Bool, Default: false, Description: "Enable thinking mode. Prepends a system message to enable/disable thinking.", APIKey: ""}, // Not a direct API key
+			"min_tokens":        {Type: Int, Default: 0, Min: 0, Description: "Minimum tokens to generate, e.g. to keep summaries from being one-liners. At most max_tokens; default 0 means not included.", APIKey: "min_tokens"},
+			"length_penalty":    {Type: Float, Default: 1.0, Min: 0, Max: 10, Description: "Length penalty: above 1 favors longer responses, below 1 shorter ones. Default 1 means not included.", APIKey: "length_penalty"},
 		},
 	},
 	"mistralai/mistral-nemotron": {
+		Capabilities:  []string{"tools", "code"},
+		ContextWindow: 131072,
 		Parameters: map[string]ModelParameter{
 			"temperature":       {Type: Float, Default: 0.6, Min: 0, Max: 1, Description: "Sampling temperature.", APIKey: "temperature"},
 			"top_p":             {Type: Float, Default: 0.7, Min: 0.01, Max: 1, Description: "Top-p sampling.", APIKey: "top_p"},
@@ -106,9 +186,12 @@ var ModelDefinitions = map[string]ModelDefinition{
 			"presence_penalty":  {Type: Float, Default: 0.0, Min: -2, Max: 2, Description: "Presence penalty.", APIKey: "presence_penalty"},
 			"max_tokens":        {Type: Int, Default: 4096, Min: 1, Max: 4096, Description: "Maximum tokens to generate.", APIKey: "max_tokens"},
 			"stop":              {Type: StringA, Default: "", Description: "Stop sequences.", APIKey: "stop"},
+			"min_tokens":        {Type: Int, Default: 0, Min: 0, Max: 4096, Description: "Minimum tokens to generate, e.g. to keep summaries from being one-liners. At most max_tokens; default 0 means not included.", APIKey: "min_tokens"},
 		},
 	},
 	"mistralai/mistral-small-24b-instruct": {
+		Capabilities:  []string{"tools"},
+		ContextWindow: 32768,
 		Parameters: map[string]ModelParameter{
 			"temperature":       {Type: Float, Default: 0.2, Min: 0, Max: 1, Description: "Sampling temperature.", APIKey: "temperature"},
 			"top_p":             {Type: Float, Default: 0.7, Min: 0.01, Max: 1, Description: "Top-p sampling.", APIKey: "top_p"},
@@ -116,9 +199,13 @@ var ModelDefinitions = map[string]ModelDefinition{
 			"presence_penalty":  {Type: Float, Default: 0.0, Min: -2, Max: 2, Description: "Presence penalty.", APIKey: "presence_penalty"},
 			"max_tokens":        {Type: Int, Default: 1024, Min: 1, Max: 8192, Description: "Maximum tokens to generate.", APIKey: "max_tokens"},
 			"stop":              {Type: StringA, Default: "", Description: "Stop sequences.", APIKey: "stop"},
+			"logit_bias":        {Type: IntMap, Default: "", Min: -100, Max: 100, Description: "Token IDs mapped to a bias from -100 (ban the token) to 100 (force it), added to their logits before sampling, e.g. 1234:-100,5678:5.", APIKey: "logit_bias"},
+			"min_tokens":        {Type: Int, Default: 0, Min: 0, Max: 8192, Description: "Minimum tokens to generate, e.g. to keep summaries from being one-liners. At most max_tokens; default 0 means not included.", APIKey: "min_tokens"},
 		},
 	},
 	"deepseek-ai/deepseek-v3.1": {
+		Capabilities:               []string{"reasoning", "tools", "code"},
+		ContextWindow:              131072,
 		ChatTemplateKwargsThinking: true,
 		Parameters: map[string]ModelParameter{
 			"temperature": {Type: Float, Default: 0.2, Min: 0.01, Max: 1, Description: "Sampling temperature.", APIKey: "temperature"},
@@ -130,6 +217,8 @@ var ModelDefinitions = map[string]ModelDefinition{
 		},
 	},
 	"deepseek-ai/deepseek-r1-distill-qwen-32b": {
+		Capabilities:  []string{"reasoning", "code"},
+		ContextWindow: 131072,
 		Parameters: map[string]ModelParameter{
 			"temperature":       {Type: Float, Default: 0.6, Min: 0, Max: 1, Description: "Sampling temperature.", APIKey: "temperature"},
 			"top_p":             {Type: Float, Default: 0.7, Min: 0.01, Max: 1, Description: "Top-p sampling.", APIKey: "top_p"},
@@ -137,9 +226,12 @@ var ModelDefinitions = map[string]ModelDefinition{
 			"presence_penalty":  {Type: Float, Default: 0.0, Min: -2, Max: 2, Description: "Presence penalty.", APIKey: "presence_penalty"},
 			"max_tokens":        {Type: Int, Default: 4096, Min: 1, Max: 4096, Description: "Maximum tokens to generate.", APIKey: "max_tokens"},
 			"stop":              {Type: StringA, Default: "", Description: "Stop sequences.", APIKey: "stop"},
+			"min_tokens":        {Type: Int, Default: 0, Min: 0, Max: 4096, Description: "Minimum tokens to generate, e.g. to keep summaries from being one-liners. At most max_tokens; default 0 means not included.", APIKey: "min_tokens"},
 		},
 	},
 	"deepseek-ai/deepseek-r1-distill-llama-8b": {
+		Capabilities:  []string{"reasoning"},
+		ContextWindow: 131072,
 		Parameters: map[string]ModelParameter{
 			"temperature":       {Type: Float, Default: 0.6, Min: 0, Max: 1, Description: "Sampling temperature.", APIKey: "temperature"},
 			"top_p":             {Type: Float, Default: 0.7, Min: 0.01, Max: 1, Description: "Top-p sampling.", APIKey: "top_p"},
@@ -147,9 +239,12 @@ var ModelDefinitions = map[string]ModelDefinition{
 			"presence_penalty":  {Type: Float, Default: 0.0, Min: -2, Max: 2, Description: "Presence penalty.", APIKey: "presence_penalty"},
 			"max_tokens":        {Type: Int, Default: 4096, Min: 1, Max: 4096, Description: "Maximum tokens to generate.", APIKey: "max_tokens"},
 			"stop":              {Type: StringA, Default: "", Description: "Stop sequences.", APIKey: "stop"},
+			"min_tokens":        {Type: Int, Default: 0, Min: 0, Max: 4096, Description: "Minimum tokens to generate, e.g. to keep summaries from being one-liners. At most max_tokens; default 0 means not included.", APIKey: "min_tokens"},
 		},
 	},
 	"deepseek-ai/deepseek-r1-0528": {
+		Capabilities:  []string{"reasoning", "code"},
+		ContextWindow: 131072,
 		Parameters: map[string]ModelParameter{
 			"temperature":       {Type: Float, Default: 0.6, Min: 0, Max: 1, Description: "Sampling temperature.", APIKey: "temperature"},
 			"top_p":             {Type: Float, Default: 0.7, Min: 0.01, Max: 1, Description: "Top-p sampling.", APIKey: "top_p"},
@@ -160,6 +255,8 @@ var ModelDefinitions = map[string]ModelDefinition{
 		},
 	},
 	"qwen/qwen3-next-80b-a3b-instruct": {
+		Capabilities:  []string{"tools"},
+		ContextWindow: 262144,
 		Parameters: map[string]ModelParameter{
 			"temperature":       {Type: Float, Default: 0.6, Min: 0, Max: 1, Description: "Sampling temperature.", APIKey: "temperature"},
 			"top_p":             {Type: Float, Default: 0.7, Min: 0.01, Max: 1, Description: "Top-p sampling.", APIKey: "top_p"},
@@ -167,9 +264,13 @@ var ModelDefinitions = map[string]ModelDefinition{
 			"presence_penalty":  {Type: Float, Default: 0.0, Min: -2, Max: 2, Description: "Presence penalty.", APIKey: "presence_penalty"},
 			"max_tokens":        {Type: Int, Default: 4096, Min: 1, Max: 4096, Description: "Maximum tokens to generate.", APIKey: "max_tokens"},
 			"stop":              {Type: StringA, Default: "", Description: "Stop sequences.", APIKey: "stop"},
+			"logit_bias":        {Type: IntMap, Default: "", Min: -100, Max: 100, Description: "Token IDs mapped to a bias from -100 (ban the token) to 100 (force it), added to their logits before sampling, e.g. 1234:-100,5678:5.", APIKey: "logit_bias"},
+			"min_tokens":        {Type: Int, Default: 0, Min: 0, Max: 4096, Description: "Minimum tokens to generate, e.g. to keep summaries from being one-liners. At most max_tokens; default 0 means not included.", APIKey: "min_tokens"},
 		},
 	},
 	"qwen/qwen3-next-80b-a3b-thinking": {
+		Capabilities:  []string{"reasoning", "tools"},
+		ContextWindow: 262144,
 		Parameters: map[string]ModelParameter{
 			"temperature":       {Type: Float, Default: 0.6, Min: 0, Max: 1, Description: "Sampling temperature.", APIKey: "temperature"},
 			"top_p":             {Type: Float, Default: 0.7, Min: 0.01, Max: 1, Description: "Top-p sampling.", APIKey: "top_p"},
@@ -177,9 +278,12 @@ var ModelDefinitions = map[string]ModelDefinition{
 			"presence_penalty":  {Type: Float, Default: 0.0, Min: -2, Max: 2, Description: "Presence penalty.", APIKey: "presence_penalty"},
 			"max_tokens":        {Type: Int, Default: 4096, Min: 1, Max: 4096, Description: "Maximum tokens to generate.", APIKey: "max_tokens"},
 			"stop":              {Type: StringA, Default: "", Description: "Stop sequences.", APIKey: "stop"},
+			"min_tokens":        {Type: Int, Default: 0, Min: 0, Max: 4096, Description: "Minimum tokens to generate, e.g. to keep summaries from being one-liners. At most max_tokens; default 0 means not included.", APIKey: "min_tokens"},
 		},
 	},
 	"moonshotai/kimi-k2-instruct-0905": {
+		Capabilities:  []string{"tools", "code"},
+		ContextWindow: 262144,
 		Parameters: map[string]ModelParameter{
 			"temperature": {Type: Float, Default: 0.6, Min: 0, Max: 1, Description: "Sampling temperature.", APIKey: "temperature"},
 			"top_p":       {Type: Float, Default: 0.9, Min: 0.01, Max: 1, Description: "Top-p sampling.", APIKey: "top_p"},
@@ -188,6 +292,8 @@ var ModelDefinitions = map[string]ModelDefinition{
 		},
 	},
 	"google/codegemma-7b": {
+		Capabilities:  []string{"code"},
+		ContextWindow: 8192,
 		Parameters: map[string]ModelParameter{
 			"temperature": {Type: Float, Default: 0.5, Min: 0, Max: 1, Description: "Sampling temperature.", APIKey: "temperature"},
 			"top_p":       {Type: Float, Default: 1.0, Min: 0, Max: 1, Description: "Top-p sampling.", APIKey: "top_p"},
@@ -197,6 +303,7 @@ var ModelDefinitions = map[string]ModelDefinition{
 		},
 	},
 	"google/gemma-7b": {
+		ContextWindow: 8192,
 		Parameters: map[string]ModelParameter{
 			"temperature": {Type: Float, Default: 0.5, Min: 0, Max: 1, Description: "Sampling temperature.", APIKey: "temperature"},
 			"top_p":       {Type: Float, Default: 1.0, Min: 0, Max: 1, Description: "Top-p sampling.", APIKey: "top_p"},
@@ -205,6 +312,8 @@ var ModelDefinitions = map[string]ModelDefinition{
 		},
 	},
 	"mistralai/mixtral-8x22b-instruct-v0.1": {
+		Capabilities:  []string{"tools", "code"},
+		ContextWindow: 65536,
 		Parameters: map[string]ModelParameter{
 			"temperature": {Type: Float, Default: 0.5, Min: 0, Max: 1, Description: "Sampling temperature.", APIKey: "temperature"},
 			"top_p":       {Type: Float, Default: 1.0, Min: 0, Max: 1, Description: "Top-p sampling.", APIKey: "top_p"},
@@ -225,6 +334,18 @@ var ModelDefinitions = map[string]ModelDefinition{
 	},
 }
 
+// ModelReplacements maps retired or renamed models to their suggested successors.
+var ModelReplacements = map[string]string{
+	"nvidia/llama-3.1-nemotron-70b-instruct":   "nvidia/llama-3.3-nemotron-super-49b-v1.5",
+	"nvidia/llama-3.3-nemotron-super-49b-v1":   "nvidia/llama-3.3-nemotron-super-49b-v1.5",
+	"nvidia/llama-3.1-nemotron-nano-8b-v1":     "nvidia/nvidia-nemotron-nano-9b-v2",
+	"deepseek-ai/deepseek-r1":                  "deepseek-ai/deepseek-r1-0528",
+	"deepseek-ai/deepseek-v3":                  "deepseek-ai/deepseek-v3.1",
+	"moonshotai/kimi-k2-instruct":              "moonshotai/kimi-k2-instruct-0905",
+	"mistralai/mixtral-8x7b-instruct-v0.1":     "mistralai/mixtral-8x22b-instruct-v0.1",
+	"mistralai/mistral-small-3.1-24b-instruct": "mistralai/mistral-small-24b-instruct",
+}
+
 // GetModelDefinition returns the definition for a given model, or the generic definition if not found.
 func GetModelDefinition(modelName string) ModelDefinition {
 	if def, ok := ModelDefinitions[modelName]; ok {
@@ -233,6 +354,165 @@ func GetModelDefinition(modelName string) ModelDefinition {
 	return ModelDefinitions["others"]
 }
 
+// HasCapability reports whether the model declares the capability tag.
+func (md ModelDefinition) HasCapability(tag string) bool {
+	for _, c := range md.Capabilities {
+		if c == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// modelFilter selects models by capability tags and minimum context window.
+type modelFilter struct {
+	tags       []string
+	minContext int
+}
+
+// parseModelFilter parses a comma-separated filter such as "tools,vision" or "code,128k". A number,
+// optionally suffixed with k, is a minimum context window in tokens.
+func parseModelFilter(s string) (modelFilter, error) {
+	var f modelFilter
+	for _, tok := range strings.Split(s, ",") {
+		tok = strings.ToLower(strings.TrimSpace(tok))
+		if tok == "" {
+			continue
+		}
+		num, mult := tok, 1
+		if strings.HasSuffix(num, "k") {
+			num, mult = strings.TrimSuffix(num, "k"), 1024
+		}
+		if n, err := strconv.Atoi(num); err == nil && n > 0 {
+			f.minContext = n * mult
+			continue
+		}
+		known := false
+		for _, c := range modelCapabilities {
+			known = known || c == tok
+		}
+		if !known {
+			return f, fmt.Errorf("unknown capability %q (use %s, or a minimum context such as 128k)", tok, strings.Join(modelCapabilities, ", "))
+		}
+		f.tags = append(f.tags, tok)
+	}
+	return f, nil
+}
+
+func (f modelFilter) matches(md ModelDefinition) bool {
+	for _, tag := range f.tags {
+		if !md.HasCapability(tag) {
+			return false
+		}
+	}
+	return md.ContextWindow >= f.minContext
+}
+
+// formatModelList lists the models matching the filter, one per line, with their capabilities
+// and context window.
+func formatModelList(f modelFilter) string {
+	var builder strings.Builder
+	for _, name := range modelsList {
+		md := GetModelDefinition(name)
+		if !f.matches(md) {
+			continue
+		}
+		line := fmt.Sprintf("  %-45s", name)
+		if len(md.Capabilities) > 0 {
+			line += " " + strings.Join(md.Capabilities, ",")
+		}
+		if md.ContextWindow > 0 {
+			line += fmt.Sprintf(" (%dk context)", md.ContextWindow/1024)
+		}
+		builder.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return builder.String()
+}
+
+// userModelsDir returns the directory of user model definition files.
+func userModelsDir() string {
+	return filepath.Join(configDir(), "models.d")
+}
+
+// loadUserModelDefinitions merges the *.json files of dir, in name order, into ModelDefinitions.
+// Each file maps model names to definitions. For a known model, a file only needs to give the
+// fields it changes: parameters are merged one by one and each parameter keeps the fields the
+// file leaves out. New models are added to the model list.
+func loadUserModelDefinitions(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		if err := loadModelDefinitionsFile(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadModelDefinitionsFile merges one definitions file into ModelDefinitions.
+func loadModelDefinitionsFile(file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var defs map[string]json.RawMessage
+	if err := json.Unmarshal(b, &defs); err != nil {
+		return fmt.Errorf("parse %s: %w", file, err)
+	}
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := mergeModelDefinition(name, defs[name]); err != nil {
+			return fmt.Errorf("%s: model %s: %w", file, name, err)
+		}
+	}
+	return nil
+}
+
+func mergeModelDefinition(name string, raw json.RawMessage) error {
+	var fields struct {
+		ModelDefinition
+		Parameters map[string]json.RawMessage `json:"parameters"`
+	}
+	def, known := ModelDefinitions[name]
+	fields.ModelDefinition = def
+	fields.ModelDefinition.Parameters = nil
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return err
+	}
+
+	params := make(map[string]ModelParameter, len(def.Parameters)+len(fields.Parameters))
+	for k, p := range def.Parameters {
+		params[k] = p
+	}
+	for k, rawParam := range fields.Parameters {
+		p := params[k]
+		if err := json.Unmarshal(rawParam, &p); err != nil {
+			return fmt.Errorf("parameter %s: %w", k, err)
+		}
+		switch p.Type {
+		case Float, Int, String, Bool, StringA, IntMap:
+		default:
+			return fmt.Errorf("parameter %s: unknown type %q", k, p.Type)
+		}
+		params[k] = p
+	}
+	def = fields.ModelDefinition
+	def.Parameters = params
+	ModelDefinitions[name] = def
+
+	if !known && name != "others" {
+		modelsList = append(modelsList, name)
+	}
+	return nil
+}
+
 // Format a description of a model's parameters for help text.
 func (md ModelDefinition) FormatForHelp() string {
 	var builder strings.Builder
@@ -257,6 +537,8 @@ func (md ModelDefinition) FormatForHelp() string {
 			builder.WriteString("<true|false>")
 		case StringA:
 			builder.WriteString("<string>")
+		case IntMap:
+			builder.WriteString("<key:value,...>")
 		}
 
 		builder.WriteString(fmt.Sprintf(" (default: %v)\n", param.Default))
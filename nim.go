@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A self-hosted NIM registered with `nim add` is a profile: its models are written to
+// models.d/nim-<name>.json with the NIM's URL as their base_url, so selecting one of them sends the
+// requests there, and its API key, when it needs one, is stored in the OS keyring under the
+// profile's name for --profile.
+
+// nimNamePattern restricts profile names, which become file names.
+var nimNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// nimDefinitionsPath returns the model definitions file of a NIM profile.
+func nimDefinitionsPath(name string) string {
+	return filepath.Join(userModelsDir(), "nim-"+name+".json")
+}
+
+// nimBaseURL returns the API base URL of a NIM given as http://host:port, with or without /v1.
+func nimBaseURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(strings.TrimSpace(raw), "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid NIM URL %q, expected e.g. http://host:8000", raw)
+	}
+	if !strings.HasSuffix(u.Path, "/v1") {
+		u.Path += "/v1"
+	}
+	return u.String(), nil
+}
+
+// nimDefaultName names a profile after the NIM's host and port, e.g. gpu-box-8000.
+func nimDefaultName(baseURL string) string {
+	u, _ := url.Parse(baseURL)
+	return strings.NewReplacer(":", "-", "[", "", "]", "").Replace(u.Host)
+}
+
+func printNIMHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat nim add URL [--name NAME] [-k KEY]\n", bold, normal))
+	builder.WriteString("       nvidia-chat nim list\n")
+	builder.WriteString("       nvidia-chat nim remove NAME\n\n")
+	builder.WriteString("Register a self-hosted NIM: add probes URL/v1/models and writes the models it serves, with\n")
+	builder.WriteString("their context length and the generic parameters, to " + nimDefinitionsPath("NAME") + ".\n")
+	builder.WriteString("Selecting one of them with -m sends the requests to the NIM. A model that also has a built-in\n")
+	builder.WriteString("definition keeps its parameters but is served by the NIM from then on.\n\n")
+	builder.WriteString("Options:\n")
+	builder.WriteString("  --name NAME           Name of the profile (default: the NIM's host and port).\n")
+	builder.WriteString("  -k, --access-token KEY  API key of a NIM behind authentication, stored in the OS keyring\n")
+	builder.WriteString("                        under the profile's name: chat with --profile NAME to use it. A NIM\n")
+	builder.WriteString("                        without authentication needs no key.\n")
+	builder.WriteString("  -f, --force           Replace an existing profile of the same name.\n")
+	fmt.Print(builder.String())
+}
+
+// runNIMCommand implements the `nim` subcommand and returns the process exit code.
+func runNIMCommand(args []string) int {
+	name, key, force := "", "", false
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printNIMHelp()
+			return exitOK
+		case "-f", "--force":
+			force = true
+		case "--name", "-k", "--access-token":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%smissing value for %s%s\n", red, args[i], normal)
+				return exitUsage
+			}
+			if args[i] == "--name" {
+				name = args[i+1]
+			} else {
+				key = args[i+1]
+			}
+			i++
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	switch {
+	case len(rest) == 2 && rest[0] == "add":
+		return addNIM(rest[1], name, key, force)
+	case len(rest) == 1 && rest[0] == "list":
+		return listNIMs()
+	case len(rest) == 2 && rest[0] == "remove":
+		return removeNIM(rest[1])
+	}
+	printNIMHelp()
+	return exitUsage
+}
+
+func addNIM(rawURL, name, key string, force bool) int {
+	baseURL, err := nimBaseURL(rawURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitUsage
+	}
+	if name == "" {
+		name = nimDefaultName(baseURL)
+	}
+	if !nimNamePattern.MatchString(name) || name == defaultProfile {
+		fmt.Fprintf(os.Stderr, "%sInvalid profile name %q: use letters, digits, dots, dashes and underscores, other than %s.%s\n", red, name, defaultProfile, normal)
+		return exitUsage
+	}
+	path := nimDefinitionsPath(name)
+	if fileExists(path) && !force {
+		fmt.Fprintf(os.Stderr, "%sThe profile %s already exists (%s); use --force to replace it.%s\n", red, name, path, normal)
+		return exitUsage
+	}
+
+	cfg := map[string]string{
+		"MODEL":           "",
+		"BASE_URL":        baseURL,
+		"TIMEOUT":         "30",
+		"CONNECT_TIMEOUT": defaultConnectTimeout,
+		"IDLE_TIMEOUT":    defaultIdleTimeout,
+		"MAX_RETRIES":     "1",
+	}
+	if key != "" {
+		apiKeys = newKeyPool([]string{key})
+	}
+	fmt.Fprintf(os.Stderr, "Probing %s/models...\n", baseURL)
+	models, err := fetchCatalog(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sThe NIM did not answer: %s%s\n", red, describeError(err), normal)
+		return exitCodeFor(err)
+	}
+
+	defs := map[string]interface{}{}
+	var names []string
+	for _, m := range models {
+		if m.ID == "" || !m.isChatModel() {
+			continue
+		}
+		entry := catalogEntry(m)
+		entry["base_url"] = baseURL
+		defs[m.ID] = entry
+		names = append(names, m.ID)
+	}
+	if len(defs) == 0 {
+		fmt.Fprintf(os.Stderr, "%sThe NIM serves no chat model.%s\n", red, normal)
+		return exitGeneral
+	}
+	b, err := json.MarshalIndent(defs, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	if err := os.MkdirAll(userModelsDir(), 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	if err := ioutil.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to write %s: %v%s\n", red, path, err, normal)
+		return exitGeneral
+	}
+	if key != "" {
+		if err := keyringSet(name, key); err != nil {
+			fmt.Fprintf(os.Stderr, "%sCould not store the key in the OS keyring: %v%s\n", red, err, normal)
+		} else {
+			fmt.Fprintf(os.Stderr, "API key stored in the OS keyring (profile %s).\n", name)
+		}
+	}
+
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "%sRegistered the NIM %s as profile %s, in %s:%s\n", green, baseURL, name, path, normal)
+	for _, id := range names {
+		note := ""
+		if _, builtin := ModelDefinitions[id]; builtin {
+			note = " (built-in model, now served by this NIM)"
+		}
+		fmt.Fprintf(os.Stderr, "  %s%s\n", id, note)
+	}
+	usage := "nvidia-chat -m " + names[0]
+	if key != "" {
+		usage += " --profile " + name
+	}
+	fmt.Fprintf(os.Stderr, "Chat with: %s\n", usage)
+	return exitOK
+}
+
+// nimProfiles returns the names of the registered NIM profiles.
+func nimProfiles() []string {
+	files, _ := filepath.Glob(nimDefinitionsPath("*"))
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), "nim-"), ".json"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func listNIMs() int {
+	names := nimProfiles()
+	if len(names) == 0 {
+		fmt.Fprintln(os.Stderr, "No NIM registered; add one with `nvidia-chat nim add URL`.")
+		return exitOK
+	}
+	for _, name := range names {
+		b, err := ioutil.ReadFile(nimDefinitionsPath(name))
+		var defs map[string]struct {
+			BaseURL string `json:"base_url"`
+		}
+		if err == nil {
+			err = json.Unmarshal(b, &defs)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%s: %v%s\n", red, name, err, normal)
+			continue
+		}
+		ids := make([]string, 0, len(defs))
+		baseURL := ""
+		for id, d := range defs {
+			ids = append(ids, id)
+			baseURL = d.BaseURL
+		}
+		sort.Strings(ids)
+		fmt.Printf("%s\t%s\t%s\n", name, baseURL, strings.Join(ids, ", "))
+	}
+	return exitOK
+}
+
+func removeNIM(name string) int {
+	path := nimDefinitionsPath(name)
+	if !nimNamePattern.MatchString(name) || !fileExists(path) {
+		fmt.Fprintf(os.Stderr, "%sNo NIM profile named %s.%s\n", red, name, normal)
+		return exitUsage
+	}
+	if err := os.Remove(path); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	_ = keyringDelete(name) // the profile may have no key
+	fmt.Fprintf(os.Stderr, "%sRemoved the NIM profile %s.%s\n", green, name, normal)
+	return exitOK
+}
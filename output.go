@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// streamFlushInterval bounds how long streamed text may wait in the output buffer.
+const streamFlushInterval = 50 * time.Millisecond
+
+// streamWriter buffers streamed output and flushes it at each newline, and otherwise at most
+// streamFlushInterval after the first pending write, so a response costs a few writes per line
+// instead of one per token.
+type streamWriter struct {
+	mu       sync.Mutex
+	w        *bufio.Writer
+	timer    *time.Timer
+	terminal bool // the wrapped writer is a terminal
+}
+
+// newStreamWriter wraps out for streaming, unless cfg asks for unbuffered output. The output is
+// also copied to the --tee file, if any.
+func newStreamWriter(out io.Writer, cfg map[string]string) io.Writer {
+	if cfg["UNBUFFERED"] == "true" {
+		return withTee(out)
+	}
+	return &streamWriter{w: bufio.NewWriterSize(withTee(out), 16*1024), terminal: isTerminal(out)}
+}
+
+// isTerminal reports whether w writes to a terminal, rather than to a file or a pipe.
+func isTerminal(w io.Writer) bool {
+	switch w := w.(type) {
+	case *streamWriter:
+		return w.terminal
+	case *statusWriter:
+		return isTerminal(w.out)
+	case *os.File:
+		info, err := w.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0
+	}
+	return false
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if bytes.IndexByte(p, '\n') >= 0 {
+		return n, s.flushLocked()
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(streamFlushInterval, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.timer = nil
+			s.w.Flush()
+		})
+	}
+	return n, nil
+}
+
+func (s *streamWriter) flushLocked() error {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	return s.w.Flush()
+}
+
+// Flush writes any pending output.
+func (s *streamWriter) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked()
+}
+
+// rawOutput receives the unmodified bytes of the API responses with --raw, nil otherwise.
+var rawOutput io.Writer
+
+// rawBody copies body to rawOutput as it is read, when --raw is on.
+func rawBody(body io.ReadCloser) io.ReadCloser {
+	if rawOutput == nil {
+		return body
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(body, rawOutput), body}
+}
+
+// flushOutput flushes w if it is a streamWriter, at the end of a response.
+func flushOutput(w io.Writer) {
+	if s, ok := w.(*streamWriter); ok {
+		s.Flush()
+	}
+	if teeOutput != nil {
+		teeOutput.endResponse()
+	}
+}
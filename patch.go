@@ -0,0 +1,410 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// filePatch is the part of a unified diff about one file. oldPath is empty for a new file and
+// newPath for a deleted one.
+type filePatch struct {
+	oldPath, newPath string
+	hunks            []hunk
+}
+
+// hunk is one @@ section of a unified diff. oldStart is the 1-based line it claims to start at;
+// models often get it wrong, so it is only a hint.
+type hunk struct {
+	oldStart int
+	lines    []string // with their " ", "-" or "+" prefix
+}
+
+// patchPath returns the path of a ---/+++ header line, without the a/ or b/ prefix and the
+// timestamp some tools append, or "" for /dev/null.
+func patchPath(header string) string {
+	path := strings.TrimSpace(header[4:])
+	if i := strings.Index(path, "\t"); i >= 0 {
+		path = path[:i]
+	}
+	path = strings.Trim(path, `"`)
+	if path == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(path, "a/") || strings.HasPrefix(path, "b/") {
+		path = path[2:]
+	}
+	return path
+}
+
+// parseUnifiedDiff reads the file patches of a unified diff. The line counts of the hunk headers
+// are ignored: a hunk runs until the next hunk or file header.
+func parseUnifiedDiff(text string) []filePatch {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var patches []filePatch
+	var current *filePatch
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			patches = append(patches, filePatch{oldPath: patchPath(line), newPath: patchPath(lines[i+1])})
+			current = &patches[len(patches)-1]
+			i++
+		case current == nil:
+		case strings.HasPrefix(line, "@@"):
+			h := hunk{}
+			if f := strings.Fields(line); len(f) > 1 && strings.HasPrefix(f[1], "-") {
+				h.oldStart, _ = strconv.Atoi(strings.SplitN(f[1][1:], ",", 2)[0])
+			}
+			current.hunks = append(current.hunks, h)
+		case len(current.hunks) == 0, strings.HasPrefix(line, `\`):
+		case strings.HasPrefix(line, "diff "), strings.HasPrefix(line, "index "):
+			current = nil
+		case line == "":
+			// models often drop the space of empty context lines
+			h := &current.hunks[len(current.hunks)-1]
+			h.lines = append(h.lines, " ")
+		case strings.ContainsAny(line[:1], " -+"):
+			h := &current.hunks[len(current.hunks)-1]
+			h.lines = append(h.lines, line)
+		}
+	}
+	// the empty line that ends a block is not context
+	for p := range patches {
+		for h := range patches[p].hunks {
+			hl := patches[p].hunks[h].lines
+			for len(hl) > 0 && hl[len(hl)-1] == " " {
+				hl = hl[:len(hl)-1]
+			}
+			patches[p].hunks[h].lines = hl
+		}
+	}
+	return patches
+}
+
+// diffBlocks returns the unified diffs among the code blocks of a response.
+func diffBlocks(response string) []filePatch {
+	var patches []filePatch
+	for _, b := range extractCodeBlocks(filterThinkingBlock(response)) {
+		if b.Lang != "diff" && b.Lang != "patch" && !strings.Contains(b.Code, "\n+++ ") {
+			continue
+		}
+		patches = append(patches, parseUnifiedDiff(b.Code)...)
+	}
+	return patches
+}
+
+// findLines returns the index at which want occurs in lines, at or after from and as close to
+// hint as possible, or -1. Trailing whitespace is ignored.
+func findLines(lines, want []string, from, hint int) int {
+	best := -1
+	for i := from; i+len(want) <= len(lines); i++ {
+		match := true
+		for j, w := range want {
+			if strings.TrimRight(lines[i+j], " \t\r") != strings.TrimRight(w, " \t\r") {
+				match = false
+				break
+			}
+		}
+		if match && (best < 0 || abs(i-hint) < abs(best-hint)) {
+			best = i
+		}
+	}
+	return best
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// applyHunks applies the hunks to content and returns the new content.
+func applyHunks(content string, hunks []hunk) (string, error) {
+	trailingNewline := content == "" || strings.HasSuffix(content, "\n")
+	var lines []string
+	if content != "" {
+		lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
+	var out []string
+	pos := 0
+	for n, h := range hunks {
+		var old, repl []string
+		for _, l := range h.lines {
+			switch l[0] {
+			case ' ':
+				old, repl = append(old, l[1:]), append(repl, l[1:])
+			case '-':
+				old = append(old, l[1:])
+			case '+':
+				repl = append(repl, l[1:])
+			}
+		}
+		at := h.oldStart - 1
+		if len(old) == 0 {
+			// pure insertion: after line oldStart
+			at = h.oldStart
+			if at < pos || at > len(lines) {
+				return "", fmt.Errorf("hunk %d: line %d is out of range", n+1, h.oldStart)
+			}
+		} else if at = findLines(lines, old, pos, at); at < 0 {
+			return "", fmt.Errorf("hunk %d does not match the file", n+1)
+		}
+		out = append(append(out, lines[pos:at]...), repl...)
+		pos = at + len(old)
+	}
+	out = append(out, lines[pos:]...)
+	result := strings.Join(out, "\n")
+	if trailingNewline && len(out) > 0 {
+		result += "\n"
+	}
+	return result, nil
+}
+
+// plannedChange is the result of applying a file patch in memory.
+type plannedChange struct {
+	path     string // file to write, or to delete when content is nil
+	oldPath  string // file renamed from, if any
+	content  *string
+	existing bool
+	summary  string
+}
+
+// patchTree holds the files as the patches planned so far leave them, by path, so that several
+// patches to one file apply in order; a nil content stands for a deleted file. Files it does not
+// hold are read from disk.
+type patchTree map[string]*string
+
+func (t patchTree) exists(path string) bool {
+	if c, ok := t[path]; ok {
+		return c != nil
+	}
+	return fileExists(path)
+}
+
+func (t patchTree) read(path string) (string, error) {
+	if c, ok := t[path]; ok {
+		if c == nil {
+			return "", fmt.Errorf("%s is deleted by an earlier patch", path)
+		}
+		return *c, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	return string(b), err
+}
+
+// record applies a planned change to the tree.
+func (t patchTree) record(c plannedChange) {
+	if c.oldPath != "" {
+		t[c.oldPath] = nil
+	}
+	t[c.path] = c.content
+}
+
+// planPatch computes the change a file patch makes to the working tree under dir, as tree leaves
+// it.
+func planPatch(dir string, p filePatch, tree patchTree) (plannedChange, error) {
+	added, removed := 0, 0
+	for _, h := range p.hunks {
+		for _, l := range h.lines {
+			switch l[0] {
+			case '+':
+				added++
+			case '-':
+				removed++
+			}
+		}
+	}
+	c := plannedChange{}
+	name := p.newPath
+	if name == "" {
+		name = p.oldPath
+	}
+	if name == "" {
+		return c, fmt.Errorf("the patch names no file")
+	}
+	path, err := safeJoin(dir, name)
+	if err != nil {
+		return c, err
+	}
+	c.path = path
+
+	old := ""
+	if p.oldPath != "" {
+		src, err := safeJoin(dir, p.oldPath)
+		if err != nil {
+			return c, err
+		}
+		if old, err = tree.read(src); err != nil {
+			return c, err
+		}
+		c.existing = true
+		if src != path && p.newPath != "" {
+			if tree.exists(path) {
+				return c, fmt.Errorf("%s already exists", path)
+			}
+			c.oldPath = src
+		}
+	} else if tree.exists(path) {
+		return c, fmt.Errorf("%s already exists", path)
+	}
+
+	switch {
+	case p.newPath == "":
+		c.summary = fmt.Sprintf("delete %s", c.path)
+	case p.oldPath == "":
+		c.summary = fmt.Sprintf("create %s (+%d)", c.path, added)
+	case c.oldPath != "":
+		c.summary = fmt.Sprintf("rename %s to %s (+%d -%d)", c.oldPath, c.path, added, removed)
+	default:
+		c.summary = fmt.Sprintf("modify %s (+%d -%d)", c.path, added, removed)
+	}
+	if p.newPath == "" {
+		return c, nil
+	}
+	content, err := applyHunks(old, p.hunks)
+	if err != nil {
+		return c, err
+	}
+	c.content = &content
+	return c, nil
+}
+
+// printPatchPreview shows a file patch with its added and removed lines in colour.
+func printPatchPreview(p filePatch) {
+	from, to := p.oldPath, p.newPath
+	if from == "" {
+		from = "/dev/null"
+	}
+	if to == "" {
+		to = "/dev/null"
+	}
+	fmt.Fprintf(os.Stderr, "%s--- %s\n+++ %s%s\n", bold, from, to, normal)
+	for _, h := range p.hunks {
+		fmt.Fprintf(os.Stderr, "%s@@ line %d @@%s\n", blue, h.oldStart, normal)
+		for _, l := range h.lines {
+			switch l[0] {
+			case '+':
+				fmt.Fprintf(os.Stderr, "%s%s%s\n", green, l, normal)
+			case '-':
+				fmt.Fprintf(os.Stderr, "%s%s%s\n", red, l, normal)
+			default:
+				fmt.Fprintln(os.Stderr, l)
+			}
+		}
+	}
+}
+
+// backupFile copies path into the backup directory, keeping its path relative to the working tree.
+func backupFile(backupDir, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(".", path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = strings.TrimLeft(filepath.ToSlash(path), "/")
+	}
+	target := filepath.Join(backupDir, rel)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(target, b, 0o644)
+}
+
+// writeKeepingMode writes content to path with the mode of the file it replaces, or of the file
+// it is renamed from, so that scripts stay executable.
+func writeKeepingMode(path, oldPath, content string) error {
+	mode := os.FileMode(0o644)
+	for _, from := range []string{path, oldPath} {
+		if info, err := os.Stat(from); from != "" && err == nil {
+			mode = info.Mode().Perm()
+			break
+		}
+	}
+	if err := ioutil.WriteFile(path, []byte(content), mode); err != nil {
+		return err
+	}
+	// WriteFile only sets the mode of a file it creates
+	return os.Chmod(path, mode)
+}
+
+// applyResponsePatches implements /apply [n]: it finds the unified diffs of the Nth-to-last
+// assistant response, previews them and, after confirmation, applies them to the working tree.
+// Files are backed up under the cache directory before they are changed.
+func applyResponsePatches(convFile string, n int) error {
+	content, err := nthAssistantResponse(convFile, n)
+	if err != nil {
+		return err
+	}
+	patches := diffBlocks(content)
+	if len(patches) == 0 {
+		return fmt.Errorf("the response has no unified diff")
+	}
+
+	var changes []plannedChange
+	tree := patchTree{}
+	for _, p := range patches {
+		printPatchPreview(p)
+		c, err := planPatch(".", p, tree)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sCannot apply: %v%s\n\n", red, err, normal)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%sWill %s%s\n\n", green, c.summary, normal)
+		tree.record(c)
+		changes = append(changes, c)
+	}
+	if len(changes) == 0 {
+		return fmt.Errorf("none of the %d file patch(es) applies to the working tree", len(patches))
+	}
+	if len(changes) < len(patches) {
+		fmt.Fprintf(os.Stderr, "Apply the %d of %d file patch(es) that apply cleanly? [y/N] ", len(changes), len(patches))
+	} else {
+		fmt.Fprintf(os.Stderr, "Apply %d file patch(es)? [y/N] ", len(changes))
+	}
+	answer, _ := readSingleLine(nil, []string{"\n"}, true)
+	if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+		fmt.Fprintln(os.Stderr, "Nothing applied.")
+		return nil
+	}
+
+	backupDir := filepath.Join(conversationDir(), "backups", time.Now().Format("20060102-150405"))
+	backedUp := map[string]bool{}
+	for _, c := range changes {
+		for _, path := range []string{c.oldPath, c.path} {
+			if path != "" && !backedUp[path] && fileExists(path) {
+				if err := backupFile(backupDir, path); err != nil {
+					return fmt.Errorf("back up %s: %w", path, err)
+				}
+				backedUp[path] = true
+			}
+		}
+	}
+	// the changes are written in order: each holds the result of the earlier ones to its file
+	for _, c := range changes {
+		if c.content == nil {
+			err = os.Remove(c.path)
+		} else {
+			if err = os.MkdirAll(filepath.Dir(c.path), 0o755); err == nil {
+				err = writeKeepingMode(c.path, c.oldPath, *c.content)
+			}
+			if err == nil && c.oldPath != "" {
+				err = os.Remove(c.oldPath)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.summary, err)
+		}
+		fmt.Fprintf(os.Stderr, "%sDone: %s%s\n", green, c.summary, normal)
+	}
+	if len(backedUp) > 0 {
+		fmt.Fprintf(os.Stderr, "The previous versions were saved to %s\n", backupDir)
+	}
+	return nil
+}
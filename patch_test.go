@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestPatchesToOneFileApplyInOrder(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "run.sh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho one\necho two\necho three\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	response := "```diff\n" +
+		"--- a/run.sh\n+++ b/run.sh\n@@ -2,1 +2,1 @@\n-echo one\n+echo ONE\n" +
+		"```\n\nAnd then:\n\n```diff\n" +
+		"--- a/run.sh\n+++ b/run.sh\n@@ -4,1 +4,1 @@\n-echo three\n+echo THREE\n" +
+		"```\n"
+	patches := diffBlocks(response)
+	if len(patches) != 2 {
+		t.Fatalf("found %d patches, want 2", len(patches))
+	}
+
+	tree := patchTree{}
+	var last plannedChange
+	for i, p := range patches {
+		c, err := planPatch(dir, p, tree)
+		if err != nil {
+			t.Fatalf("patch %d: %v", i+1, err)
+		}
+		tree.record(c)
+		last = c
+	}
+	want := "#!/bin/sh\necho ONE\necho two\necho THREE\n"
+	if *last.content != want {
+		t.Errorf("after both patches the file holds %q, want %q", *last.content, want)
+	}
+
+	if err := writeKeepingMode(last.path, last.oldPath, *last.content); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0o755 {
+		t.Errorf("mode = %v, want the script to stay executable", info.Mode().Perm())
+	}
+}
+
+func TestRenamedFileKeepsMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no executable bit")
+	}
+	dir := t.TempDir()
+	old, renamed := filepath.Join(dir, "old.sh"), filepath.Join(dir, "new.sh")
+	if err := ioutil.WriteFile(old, []byte("echo hi\n"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeKeepingMode(renamed, old, "echo hello\n"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(renamed); err != nil || info.Mode().Perm() != 0o750 {
+		t.Errorf("renamed file: %v, %v; want mode 0750", info, err)
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The PDF export lays out the markdown transcript itself and writes a PDF 1.4 file using the
+// standard Helvetica and Courier fonts, so no renderer or font files are needed. Those fonts only
+// cover the WinAnsi (Latin-1) character set: a conversation with other characters is refused
+// rather than exported with them replaced by "?".
+
+const (
+	pdfPageWidth  = 595.0 // A4, in points
+	pdfPageHeight = 842.0
+	pdfMargin     = 56.0
+)
+
+type pdfFont int
+
+const (
+	pdfRegular pdfFont = iota
+	pdfBold
+	pdfMono
+)
+
+var pdfFontNames = []string{"Helvetica", "Helvetica-Bold", "Courier"}
+
+// helveticaWidths are the Helvetica glyph widths of ASCII 32..126 in 1/1000 em.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+type pdfColor [3]float64
+
+func (c pdfColor) String() string {
+	return fmt.Sprintf("%.2f %.2f %.2f", c[0], c[1], c[2])
+}
+
+var (
+	pdfTextColor     = pdfColor{0.1, 0.1, 0.1}
+	pdfMutedColor    = pdfColor{0.45, 0.45, 0.45}
+	pdfCodeColor     = pdfColor{0.55, 0.1, 0.3}
+	pdfCodeFill      = pdfColor{0.95, 0.95, 0.95}
+	pdfKeywordColor  = pdfColor{0.0, 0.25, 0.65}
+	pdfStringColor   = pdfColor{0.65, 0.15, 0.1}
+	pdfCommentColor  = pdfColor{0.4, 0.5, 0.4}
+	pdfNumberColor   = pdfColor{0.5, 0.2, 0.6}
+	pdfHeadingColors = map[string]pdfColor{"User": {0.1, 0.3, 0.6}, "Assistant": {0.1, 0.45, 0.2}}
+)
+
+// pdfRun is a piece of a line in one font and color; text is WinAnsi encoded.
+type pdfRun struct {
+	font  pdfFont
+	color pdfColor
+	text  string
+}
+
+var winAnsiExtra = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94,
+	'•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// toWinAnsi converts UTF-8 text to the encoding of the standard PDF fonts.
+func toWinAnsi(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\t':
+			b.WriteString("    ")
+		case r >= 32 && r < 127, r >= 0xA0 && r <= 0xFF:
+			b.WriteByte(byte(r))
+		case r < 32:
+		default:
+			if c, ok := winAnsiExtra[r]; ok {
+				b.WriteByte(c)
+			} else {
+				b.WriteByte('?')
+			}
+		}
+	}
+	return b.String()
+}
+
+// nonWinAnsi returns the distinct characters of s that toWinAnsi cannot encode, in order.
+func nonWinAnsi(s string) []rune {
+	var found []rune
+	seen := map[rune]bool{}
+	for _, r := range s {
+		if r < 127 || r >= 0xA0 && r <= 0xFF || seen[r] {
+			continue
+		}
+		if _, ok := winAnsiExtra[r]; !ok {
+			seen[r] = true
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func pdfEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(s)
+}
+
+// pdfTextWidth returns the width of WinAnsi text in points.
+func pdfTextWidth(font pdfFont, s string, size float64) float64 {
+	if font == pdfMono {
+		return float64(len(s)) * 600 * size / 1000
+	}
+	total := 0
+	for i := 0; i < len(s); i++ {
+		w := 556
+		if c := s[i]; c >= 32 && c <= 126 {
+			w = helveticaWidths[c-32]
+		}
+		total += w
+	}
+	width := float64(total) * size / 1000
+	if font == pdfBold {
+		width *= 1.1 // bold glyphs are slightly wider
+	}
+	return width
+}
+
+func appendRun(runs []pdfRun, r pdfRun) []pdfRun {
+	if n := len(runs); n > 0 && runs[n-1].font == r.font && runs[n-1].color == r.color {
+		runs[n-1].text += r.text
+		return runs
+	}
+	return append(runs, r)
+}
+
+var wrapToken = regexp.MustCompile(`^\s+|\S+\s*`)
+
+// wrapRuns breaks runs into lines no wider than maxWidth, splitting at spaces and inside words
+// that do not fit on a line of their own.
+func wrapRuns(runs []pdfRun, maxWidth, size float64) [][]pdfRun {
+	var lines [][]pdfRun
+	var current []pdfRun
+	width := 0.0
+	for _, r := range runs {
+		for _, tok := range wrapToken.FindAllString(r.text, -1) {
+			word := strings.TrimRight(tok, " ")
+			if width > 0 && width+pdfTextWidth(r.font, word, size) > maxWidth {
+				lines = append(lines, current)
+				current, width = nil, 0
+				if tok = strings.TrimLeft(tok, " "); tok == "" {
+					continue
+				}
+				word = strings.TrimRight(tok, " ")
+			}
+			for len(word) > 1 && pdfTextWidth(r.font, word, size) > maxWidth {
+				n := 1
+				for n < len(word) && pdfTextWidth(r.font, word[:n+1], size) <= maxWidth {
+					n++
+				}
+				lines = append(lines, appendRun(current, pdfRun{r.font, r.color, word[:n]}))
+				current, width = nil, 0
+				word, tok = word[n:], tok[n:]
+			}
+			current = appendRun(current, pdfRun{r.font, r.color, tok})
+			width += pdfTextWidth(r.font, tok, size)
+		}
+	}
+	if len(current) > 0 || len(lines) == 0 {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+var markdownLink = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+
+// parseInline turns **bold**, *emphasis* and `code` spans into runs. Emphasis markers are dropped.
+func parseInline(s string, font pdfFont, color pdfColor) []pdfRun {
+	s = markdownLink.ReplaceAllString(s, "$1 ($2)")
+	var runs []pdfRun
+	var buf strings.Builder
+	bold, emphasis := font == pdfBold, false
+	emit := func() {
+		if buf.Len() == 0 {
+			return
+		}
+		f := pdfRegular
+		if bold {
+			f = pdfBold
+		}
+		runs = appendRun(runs, pdfRun{f, color, toWinAnsi(buf.String())})
+		buf.Reset()
+	}
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '`':
+			if end := strings.IndexByte(s[i+1:], '`'); end >= 0 {
+				emit()
+				runs = appendRun(runs, pdfRun{pdfMono, pdfCodeColor, toWinAnsi(s[i+1 : i+1+end])})
+				i += end + 2
+				continue
+			}
+		case strings.HasPrefix(s[i:], "**"):
+			emit()
+			bold = !bold
+			i += 2
+			continue
+		case s[i] == '*' && (emphasis || (i+1 < len(s) && s[i+1] != ' ' && strings.Contains(s[i+1:], "*"))):
+			emphasis = !emphasis
+			i++
+			continue
+		}
+		buf.WriteByte(s[i])
+		i++
+	}
+	emit()
+	return runs
+}
+
+var codeKeywords = map[string]bool{}
+
+func init() {
+	for _, k := range strings.Fields(`break case catch chan class const continue def default defer del do done
+		elif else enum except export extends false fi finally fn for from func function go if impl import in
+		interface lambda let local loop map match mod mut new nil none not null or package pass pub raise
+		range return select self static struct super switch then this throw true try type use var void
+		while with yield async await and None True False SELECT FROM WHERE INSERT UPDATE DELETE JOIN`) {
+		codeKeywords[k] = true
+	}
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// highlightCode colors keywords, strings, numbers and line comments of a code line.
+func highlightCode(line, lang string) []pdfRun {
+	mono := func(text string, color pdfColor) pdfRun { return pdfRun{pdfMono, color, toWinAnsi(text)} }
+	if lang == "" || lang == "text" || lang == "txt" {
+		return []pdfRun{mono(line, pdfTextColor)}
+	}
+	comment := "//"
+	switch lang {
+	case "python", "py", "sh", "bash", "shell", "zsh", "ruby", "rb", "yaml", "yml", "toml", "dockerfile", "makefile", "perl", "r":
+		comment = "#"
+	case "sql", "lua", "haskell":
+		comment = "--"
+	}
+	var runs []pdfRun
+	for i := 0; i < len(line); {
+		c := line[i]
+		j := i + 1
+		switch {
+		case strings.HasPrefix(line[i:], comment):
+			return appendRun(runs, mono(line[i:], pdfCommentColor))
+		case c == '"' || c == '\'' || c == '`':
+			for j < len(line) && line[j] != c {
+				if line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j++; j > len(line) {
+				j = len(line)
+			}
+			runs = appendRun(runs, mono(line[i:j], pdfStringColor))
+		case c >= '0' && c <= '9':
+			for j < len(line) && (isIdentByte(line[j]) || line[j] == '.') {
+				j++
+			}
+			runs = appendRun(runs, mono(line[i:j], pdfNumberColor))
+		case isIdentByte(c):
+			for j < len(line) && isIdentByte(line[j]) {
+				j++
+			}
+			color := pdfTextColor
+			if codeKeywords[line[i:j]] {
+				color = pdfKeywordColor
+			}
+			runs = appendRun(runs, mono(line[i:j], color))
+		default:
+			for j < len(line) && !isIdentByte(line[j]) && !strings.ContainsRune("\"'`", rune(line[j])) && !strings.HasPrefix(line[j:], comment) {
+				j++
+			}
+			runs = appendRun(runs, mono(line[i:j], pdfTextColor))
+		}
+		i = j
+	}
+	return runs
+}
+
+// pdfWriter collects the content streams of the pages.
+type pdfWriter struct {
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	y     float64 // top of the next line
+}
+
+func (w *pdfWriter) newPage() {
+	w.page = &bytes.Buffer{}
+	w.pages = append(w.pages, w.page)
+	w.y = pdfPageHeight - pdfMargin
+}
+
+func (w *pdfWriter) gap(h float64) {
+	w.y -= h
+}
+
+// writeLine writes one line of runs at x, optionally over a filled background.
+func (w *pdfWriter) writeLine(runs []pdfRun, x, size, leading float64, fill *pdfColor) {
+	if w.page == nil || w.y-leading < pdfMargin {
+		w.newPage()
+	}
+	w.y -= leading
+	if fill != nil {
+		fmt.Fprintf(w.page, "%s rg %.2f %.2f %.2f %.2f re f\n", *fill, x-4, w.y, pdfPageWidth-pdfMargin-x+8, leading)
+	}
+	fmt.Fprintf(w.page, "BT %.2f %.2f Td\n", x, w.y+(leading-size)/2+size*0.22)
+	for _, r := range runs {
+		fmt.Fprintf(w.page, "/F%d %.1f Tf %s rg (%s) Tj\n", r.font, size, r.color, pdfEscape(r.text))
+	}
+	w.page.WriteString("ET\n")
+}
+
+func (w *pdfWriter) paragraph(runs []pdfRun, x, size, leading float64) {
+	for _, line := range wrapRuns(runs, pdfPageWidth-pdfMargin-x, size) {
+		w.writeLine(line, x, size, leading, nil)
+	}
+}
+
+func (w *pdfWriter) codeBlock(lines []string, lang string) {
+	const size, leading = 8.5, 11.0
+	w.gap(2)
+	for _, line := range lines {
+		for _, wrapped := range wrapRuns(highlightCode(strings.ReplaceAll(line, "\t", "    "), lang), pdfPageWidth-2*pdfMargin-8, size) {
+			w.writeLine(wrapped, pdfMargin+4, size, leading, &pdfCodeFill)
+		}
+	}
+	w.gap(8)
+}
+
+func (w *pdfWriter) rule() {
+	if w.page == nil || w.y-12 < pdfMargin {
+		w.newPage()
+	}
+	w.y -= 6
+	fmt.Fprintf(w.page, "0.75 0.75 0.75 RG 0.5 w %.2f %.2f m %.2f %.2f l S\n", pdfMargin, w.y, pdfPageWidth-pdfMargin, w.y)
+	w.y -= 6
+}
+
+var markdownListItem = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+
+// renderMarkdown lays out headings, paragraphs, lists, quotes, rules, tables and fenced code
+// blocks. Assistant reasoning blocks are shown in gray.
+func (w *pdfWriter) renderMarkdown(text string) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var para []string
+	color := pdfTextColor
+	flush := func() {
+		if len(para) > 0 {
+			w.paragraph(parseInline(strings.Join(para, " "), pdfRegular, color), pdfMargin, 10.5, 15)
+			w.gap(5)
+			para = nil
+		}
+	}
+	for i := 0; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+		switch {
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flush()
+			fence := trimmed[:3]
+			lang, _ := parseInfoString(strings.TrimLeft(trimmed, fence[:1]))
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			w.codeBlock(code, lang)
+		case trimmed == "":
+			flush()
+		case trimmed == "[Begin of Assistant Reasoning]":
+			flush()
+			color = pdfMutedColor
+		case strings.HasSuffix(trimmed, "End of Assistant Reasoning]"):
+			flush()
+			color = pdfTextColor
+		case level > 0 && level <= 6 && strings.HasPrefix(trimmed[level:], " "):
+			flush()
+			title := strings.TrimSpace(trimmed[level:])
+			size := map[int]float64{1: 18, 2: 15, 3: 13}[level]
+			if size == 0 {
+				size = 11.5
+			}
+			headingColor := pdfTextColor
+			for role, c := range pdfHeadingColors {
+				if strings.HasPrefix(title, role+" (#") || strings.HasPrefix(title, role+", ") {
+					headingColor = c
+				}
+			}
+			w.gap(6)
+			w.paragraph(parseInline(title, pdfBold, headingColor), pdfMargin, size, size*1.35)
+			w.gap(3)
+		case len(trimmed) >= 3 && strings.Trim(trimmed, trimmed[:1]) == "" && strings.Contains("-*_", trimmed[:1]):
+			flush()
+			w.rule()
+		case markdownListItem.MatchString(lines[i]):
+			flush()
+			m := markdownListItem.FindStringSubmatch(lines[i])
+			marker := "• "
+			if m[2][0] >= '0' && m[2][0] <= '9' {
+				marker = m[2] + " "
+			}
+			x := pdfMargin + 12 + 12*float64(len(strings.ReplaceAll(m[1], "\t", "  "))/2)
+			runs := append([]pdfRun{{pdfRegular, color, toWinAnsi(marker)}}, parseInline(m[3], pdfRegular, color)...)
+			w.paragraph(runs, x, 10.5, 15)
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			w.paragraph(parseInline(strings.TrimSpace(strings.TrimLeft(trimmed, ">")), pdfRegular, pdfMutedColor), pdfMargin+14, 10.5, 15)
+		case strings.HasPrefix(trimmed, "|"):
+			flush()
+			w.writeLine([]pdfRun{{pdfMono, color, toWinAnsi(trimmed)}}, pdfMargin, 8.5, 11, nil)
+		default:
+			para = append(para, trimmed)
+		}
+	}
+	flush()
+}
+
+// bytes assembles the PDF file, numbering the pages in their footers.
+func (w *pdfWriter) bytes(title string) []byte {
+	for i, p := range w.pages {
+		label := fmt.Sprintf("%d / %d", i+1, len(w.pages))
+		fmt.Fprintf(p, "BT /F%d 8.0 Tf %s rg %.2f %.2f Td (%s) Tj ET\n", pdfRegular, pdfMutedColor,
+			(pdfPageWidth-pdfTextWidth(pdfRegular, label, 8))/2, pdfMargin/2, label)
+	}
+
+	var out bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	firstPage := 3 + len(pdfFontNames) // objects: catalog, pages, fonts, then page and content pairs
+	var kids, fonts []string
+	for i := range w.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPage+2*i))
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(w.pages)))
+	for i, name := range pdfFontNames {
+		obj(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+		fonts = append(fonts, fmt.Sprintf("/F%d %d 0 R", i, 3+i))
+	}
+	for i, p := range w.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, strings.Join(fonts, " "), firstPage+2*i+1))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", p.Len(), p.Bytes()))
+	}
+	obj(fmt.Sprintf("<< /Title (%s) /Producer (nvidia-chat) /CreationDate (D:%s) >>",
+		pdfEscape(toWinAnsi(title)), time.Now().UTC().Format("20060102150405Z")))
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, len(offsets), xref)
+	return out.Bytes()
+}
+
+// exportPDF renders the whole conversation as a PDF transcript.
+func exportPDF(convFile, targetFile string, cfg map[string]string, filterThinking bool) error {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		return fmt.Errorf("reading conversation file: %w", err)
+	}
+	if len(cf.Messages) == 0 {
+		return fmt.Errorf("the conversation has no messages")
+	}
+	title := conversationTitle(convFile, cf)
+	model := cfg["MODEL"]
+	if cf.Settings.Model != "" {
+		model = cf.Settings.Model
+	}
+
+	transcript := formatTranscript(cf.Messages, 1, filterThinking)
+	if found := nonWinAnsi(title + model + transcript); len(found) > 0 {
+		if len(found) > 10 {
+			found = found[:10]
+		}
+		return fmt.Errorf("the PDF fonts cannot show some characters of the conversation (%q); export it with /exportrange or /export org instead", string(found))
+	}
+
+	w := &pdfWriter{}
+	w.paragraph(parseInline(title, pdfBold, pdfTextColor), pdfMargin, 20, 26)
+	w.paragraph([]pdfRun{{pdfRegular, pdfMutedColor, toWinAnsi(model + " · " + time.Now().Format("2006-01-02 15:04"))}}, pdfMargin, 9, 13)
+	w.rule()
+	w.renderMarkdown(transcript)
+	return ioutil.WriteFile(targetFile, w.bytes(title), 0o644)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pipelineStep is a step of a pipeline file: a prompt rendered from a template with the variables
+// known so far, whose reply is stored in the output variable for the steps after it.
+type pipelineStep struct {
+	Name     string `json:"name"`
+	Template string `json:"template"` // template name or path, resolved like --template
+	Prompt   string `json:"prompt"`   // inline template text, instead of a template file
+	Model    string `json:"model"`
+	System   string `json:"system"`
+	Output   string `json:"output"`
+}
+
+type pipelineFile struct {
+	Vars  map[string]string `json:"vars"`
+	Steps []pipelineStep    `json:"steps"`
+}
+
+var pipelineVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func printPipelineHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat pipeline STEPS_FILE [--var key=value]... [options]\n\n", bold, normal))
+	builder.WriteString("Run the steps of a YAML (or JSON) file one after the other. Each step renders a template with\n")
+	builder.WriteString("the variables known so far, sends it to its model, and stores the reply in its output variable,\n")
+	builder.WriteString("which the following steps can use. The replies of the steps are shown on stderr, except the\n")
+	builder.WriteString("last one, which is printed on stdout.\n\n")
+	builder.WriteString("Options:\n")
+	builder.WriteString("  --var key=value       Set a template variable; a value of @path is replaced by the file's contents.\n")
+	builder.WriteString("  -m, --model NAME      Model of the steps that do not name one (default: " + defaultModel + ").\n")
+	builder.WriteString("  -o, --output FILE     Also write the reply of the last step to FILE.\n")
+	builder.WriteString("  -k, --access-token KEY\n                        API key (repeatable). Defaults to the OS keyring, then the environment.\n")
+	builder.WriteString("  --profile NAME        Use the API keys stored in the OS keyring under NAME.\n")
+	builder.WriteString("  --base-url URL        API base URL (default: the model's endpoint, else " + defaultBaseURL + ").\n")
+	builder.WriteString("\nExample steps file:\n")
+	builder.WriteString("  steps:\n")
+	builder.WriteString("    - template: summarize     # {{.input}} is set with --var input=@notes.md\n")
+	builder.WriteString("      output: summary\n")
+	builder.WriteString("    - prompt: \"Critique this summary:\\n{{.summary}}\"\n")
+	builder.WriteString("      model: meta/llama-3.1-8b-instruct\n")
+	builder.WriteString("      output: critique\n")
+	builder.WriteString("    - prompt: \"Rewrite the summary to address the critique.\\n{{.summary}}\\n{{.critique}}\"\n")
+	builder.WriteString("      output: final\n")
+	fmt.Print(builder.String())
+}
+
+// loadPipeline reads a pipeline file and checks its steps: each one has a template or a prompt and
+// an output variable, and only uses the variables set before it.
+func loadPipeline(path string, vars map[string]string) (*pipelineFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isYAMLPath(path) {
+		v, err := parseYAML(path, data)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	var p pipelineFile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(p.Steps) == 0 {
+		return nil, fmt.Errorf("%s: no steps", path)
+	}
+	known := map[string]string{}
+	for k := range p.Vars {
+		known[k] = ""
+	}
+	for k := range vars {
+		known[k] = ""
+	}
+	dir := filepath.Dir(path)
+	for i := range p.Steps {
+		s := &p.Steps[i]
+		if s.Name == "" {
+			s.Name = fmt.Sprintf("step %d", i+1)
+		}
+		if (s.Template == "") == (s.Prompt == "") {
+			return nil, fmt.Errorf("%s: %s needs either a template or a prompt", path, s.Name)
+		}
+		if !pipelineVarName.MatchString(s.Output) {
+			return nil, fmt.Errorf("%s: %s needs an output variable name (letters, digits and _), not %q", path, s.Name, s.Output)
+		}
+		if s.Template != "" {
+			// templates next to the pipeline file come first
+			for _, candidate := range []string{s.Template, s.Template + ".tmpl"} {
+				if local := filepath.Join(dir, candidate); fileExists(local) {
+					s.Template = local
+					break
+				}
+			}
+			b, err := templateText(s.Template)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", s.Name, err)
+			}
+			s.Prompt = b
+		}
+		if _, err := executeTemplate(s.Name, s.Prompt, known); err != nil {
+			return nil, fmt.Errorf("%s: %v", s.Name, err)
+		}
+		known[s.Output] = ""
+	}
+	return &p, nil
+}
+
+// templateText returns the text of a template given by path or by name.
+func templateText(name string) (string, error) {
+	path, err := resolveTemplatePath(name)
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(path)
+	return string(b), err
+}
+
+// runPipelineCommand implements the `pipeline` subcommand and returns the process exit code.
+func runPipelineCommand(args []string) int {
+	base := map[string]string{
+		"BASE_URL":        "",
+		"TIMEOUT":         defaultTimeout,
+		"CONNECT_TIMEOUT": defaultConnectTimeout,
+		"IDLE_TIMEOUT":    defaultIdleTimeout,
+		"MAX_RETRIES":     defaultMaxRetries,
+		"HISTORY_LIMIT":   strconv.Itoa(defaultHistoryLimit),
+	}
+	model, output, profile, stepsFile := defaultModel, "", defaultProfile, ""
+	var flagKeys, varPairs []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printPipelineHelp()
+			return exitOK
+		case "--var", "-m", "--model", "-o", "--output", "-k", "--access-token", "--profile", "--base-url":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%smissing value for %s%s\n", red, args[i], normal)
+				return exitUsage
+			}
+			val := args[i+1]
+			switch args[i] {
+			case "--var":
+				varPairs = append(varPairs, val)
+			case "-m", "--model":
+				model = val
+			case "-o", "--output":
+				output = val
+			case "-k", "--access-token":
+				flagKeys = append(flagKeys, val)
+			case "--profile":
+				profile = val
+			case "--base-url":
+				base["BASE_URL"] = val
+			}
+			i++
+		default:
+			if strings.HasPrefix(args[i], "-") || stepsFile != "" {
+				fmt.Fprintf(os.Stderr, "Unknown option: %s\n", args[i])
+				printPipelineHelp()
+				return exitUsage
+			}
+			stepsFile = args[i]
+		}
+	}
+	if stepsFile == "" {
+		printPipelineHelp()
+		return exitUsage
+	}
+	flagVars, err := parseTemplateVars(varPairs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitUsage
+	}
+	p, err := loadPipeline(stepsFile, flagVars)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitUsage
+	}
+	vars := map[string]string{}
+	for k, v := range p.Vars {
+		vars[k] = v
+	}
+	for k, v := range flagVars {
+		vars[k] = v
+	}
+
+	keys, _ := collectAPIKeys(flagKeys, profile)
+	if len(keys) == 0 {
+		fmt.Fprintf(os.Stderr, "%sNo API key found.%s Run `nvidia-chat auth login` or set NVIDIA_BUILD_AI_ACCESS_TOKEN.\n", red, normal)
+		return exitAuth
+	}
+	apiKeys = newKeyPool(keys)
+
+	for n, step := range p.Steps {
+		stepModel := step.Model
+		if stepModel == "" {
+			stepModel = model
+		}
+		prompt, err := executeTemplate(step.Name, step.Prompt, vars)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%s: %v%s\n", red, step.Name, err, normal)
+			return exitUsage
+		}
+		fmt.Fprintf(os.Stderr, "\n%sStep %d of %d:%s %s (%s)\n", bold, n+1, len(p.Steps), normal, step.Name, stepModel)
+		var messages []Message
+		if step.System != "" {
+			messages = append(messages, Message{Role: "system", Content: step.System})
+		}
+		messages = append(messages, Message{Role: "user", Content: prompt})
+		out := os.Stderr
+		if n == len(p.Steps)-1 {
+			out = os.Stdout
+		}
+		text, _, err := streamCompletion(benchConfig(base, stepModel), messages, out)
+		fmt.Fprintln(out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%s: %s%s\n", red, step.Name, describeError(err), normal)
+			return exitCodeFor(err)
+		}
+		vars[step.Output] = strings.TrimSpace(filterThinkingBlock(text))
+	}
+	if output != "" {
+		if err := ioutil.WriteFile(output, []byte(vars[p.Steps[len(p.Steps)-1].Output]+"\n"), 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to write %s: %v%s\n", red, output, err, normal)
+			return exitGeneral
+		}
+		fmt.Fprintf(os.Stderr, "%sWrote the result to %s%s\n", green, output, normal)
+	}
+	return exitOK
+}
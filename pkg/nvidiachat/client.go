@@ -0,0 +1,227 @@
+package nvidiachat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// DefaultBaseURL is the NVIDIA build API endpoint.
+const DefaultBaseURL = "https://integrate.api.nvidia.com/v1"
+
+// Request is a chat completions request.
+type Request struct {
+	Model    string
+	Messages []Message
+	Stream   bool
+	// Params are the model settings sent with the request, by API name (temperature, top_p, ...).
+	Params map[string]interface{}
+	// Tools are the function definitions the model may call, in the API's JSON form.
+	Tools []interface{}
+	// Extra is merged into the payload last, for parameters without a setting of their own:
+	// objects are merged key by key, other values replace those of the payload.
+	Extra map[string]interface{}
+	// RoleMap renames message roles for backends that name them differently, e.g.
+	// {"developer": "system"} for one without the developer role. Other roles are sent as they are.
+	RoleMap map[string]string
+}
+
+// Payload returns the JSON body of the request.
+func (r Request) Payload() ([]byte, error) {
+	payload := map[string]interface{}{}
+	for k, v := range r.Params {
+		payload[k] = v
+	}
+	payload["model"] = r.Model
+	payload["messages"] = r.Messages
+	if len(r.RoleMap) > 0 {
+		messages := make([]Message, len(r.Messages))
+		for i, m := range r.Messages {
+			if role, ok := r.RoleMap[m.Role]; ok {
+				m.Role = role
+			}
+			messages[i] = m
+		}
+		payload["messages"] = messages
+	}
+	payload["stream"] = r.Stream
+	if r.Stream {
+		// ask for a final usage chunk so token usage is known for streamed replies too
+		payload["stream_options"] = map[string]bool{"include_usage": true}
+	}
+	if len(r.Tools) > 0 {
+		payload["tools"] = r.Tools
+	}
+	if len(r.Extra) > 0 {
+		// round-trip through JSON so that the payload's own objects can be merged into
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		payload = map[string]interface{}{}
+		if err := dec.Decode(&payload); err != nil {
+			return nil, err
+		}
+		mergeJSON(payload, r.Extra)
+	}
+	return json.Marshal(payload)
+}
+
+// mergeJSON merges src into dst: objects present in both are merged recursively, any other value
+// of src replaces that of dst.
+func mergeJSON(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sv, ok := v.(map[string]interface{}); ok {
+			if dv, ok := dst[k].(map[string]interface{}); ok {
+				mergeJSON(dv, sv)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+// Client sends requests to a chat completions endpoint.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	model      string
+	params     map[string]interface{}
+	roleMap    map[string]string
+	httpClient *http.Client
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithBaseURL sets the API base URL (default DefaultBaseURL).
+func WithBaseURL(url string) Option {
+	return func(c *Client) { c.baseURL = strings.TrimSuffix(url, "/") }
+}
+
+// WithAPIKey sets the API key sent as a bearer token; without one, no Authorization header is sent.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithModel sets the model used by Send.
+func WithModel(model string) Option {
+	return func(c *Client) { c.model = model }
+}
+
+// WithParams sets the model settings used by Send, by API name.
+func WithParams(params map[string]interface{}) Option {
+	return func(c *Client) { c.params = params }
+}
+
+// WithRoleMap sets the role renames of the requests, see Request.RoleMap.
+func WithRoleMap(roles map[string]string) Option {
+	return func(c *Client) { c.roleMap = roles }
+}
+
+// WithHTTPClient sets the HTTP client used for requests (default http.DefaultClient).
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// New returns a client configured by opts.
+func New(opts ...Option) *Client {
+	c := &Client{baseURL: DefaultBaseURL, httpClient: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// NewHTTPRequest prepares the HTTP request for a JSON payload.
+func (c *Client) NewHTTPRequest(ctx context.Context, payload []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/chat/completions", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// Do sends the request and returns the reply. A streamed request calls onDelta for each chunk as
+// it arrives; onDelta may be nil. If onDelta returns an error, the response is abandoned and the
+// error returned.
+func (c *Client) Do(ctx context.Context, r Request, onDelta func(Delta) error) (*Response, error) {
+	payload, err := r.Payload()
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.NewHTTPRequest(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, NewAPIError(resp, body)
+	}
+	if !r.Stream {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return ParseResponse(body)
+	}
+
+	reply := &Response{}
+	stream := NewStreamReader(resp.Body)
+	for {
+		d, err := stream.Next()
+		if err == io.EOF {
+			return reply, nil
+		}
+		if err != nil {
+			return reply, err
+		}
+		reply.add(d)
+		if onDelta != nil {
+			if err := onDelta(d); err != nil {
+				return reply, err
+			}
+		}
+	}
+}
+
+// Send adds text to the conversation as a user message, sends the conversation with the client's
+// model and settings, and adds the reply and its token usage to the conversation. The request is
+// streamed when onDelta is not nil. If the request fails, the conversation is left unchanged.
+func (c *Client) Send(ctx context.Context, conv *Conversation, text string, onDelta func(Delta) error) (*Response, error) {
+	conv.Append("user", text)
+	sent := *conv
+	sent.System = conv.SystemFor(c.model)
+	reply, err := c.Do(ctx, Request{
+		Model:    c.model,
+		Messages: sent.APIMessages(),
+		Stream:   onDelta != nil,
+		Params:   c.params,
+		RoleMap:  c.roleMap,
+	}, onDelta)
+	if err != nil {
+		conv.Messages = conv.Messages[:len(conv.Messages)-1]
+		return reply, err
+	}
+	msg := Reply(c.model, reply.Content, reply.Usage)
+	msg.ToolCalls = reply.ToolCalls
+	conv.Messages = append(conv.Messages, msg)
+	conv.Settings.Model = c.model
+	conv.AddUsage(reply.Usage)
+	return reply, nil
+}
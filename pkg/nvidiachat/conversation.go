@@ -0,0 +1,189 @@
+package nvidiachat
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ModelSettings holds the settings of one model, or the default settings, by parameter name. The
+// settings of a model may also hold a "system" text, which replaces the conversation's system prompt
+// while that model is used.
+type ModelSettings map[string]interface{}
+
+// Settings are the settings stored in a conversation file.
+type Settings struct {
+	// Model is the model the conversation last talked to, restored when it is reopened.
+	Model        string                   `json:"model,omitempty"`
+	Stream       bool                     `json:"stream"`
+	HistoryLimit int                      `json:"history_limit"`
+	Default      ModelSettings            `json:"default"`
+	Models       map[string]ModelSettings `json:"models"`
+	// ToolChoice and ParallelToolCalls are the tool_choice and parallel_tool_calls request fields
+	// the conversation last used with tools, empty and nil when the backend's default applied.
+	ToolChoice        string `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool  `json:"parallel_tool_calls,omitempty"`
+	// Migrated reports that the settings were read from the flat format of older versions.
+	Migrated bool `json:"-"`
+}
+
+// UnmarshalJSON reads settings, also in the flat format of older versions, which kept the
+// parameters of the conversation's model next to stream and history_limit. They become the settings
+// of that model, or the default settings when the file names no model.
+func (s *Settings) UnmarshalJSON(data []byte) error {
+	type settings Settings // without this method
+	if err := json.Unmarshal(data, (*settings)(s)); err != nil {
+		return err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil || raw == nil {
+		return err
+	}
+	_, hasDefault := raw["default"]
+	_, hasModels := raw["models"]
+	if hasDefault || hasModels {
+		return nil
+	}
+	params := ModelSettings{}
+	for k, v := range raw {
+		if k != "model" && k != "stream" && k != "history_limit" {
+			params[k] = v
+		}
+	}
+	s.Default, s.Models = ModelSettings{}, map[string]ModelSettings{}
+	if s.Model != "" {
+		s.Models[s.Model] = params
+	} else {
+		s.Default = params
+	}
+	s.Migrated = true
+	return nil
+}
+
+// Conversation is a conversation and the settings it was held with, in the JSON format of the
+// nvidia-chat conversation files.
+type Conversation struct {
+	Title string `json:"title,omitempty"`
+	// Tags are labels set with /tag; nvidia-chat gc --keep-tagged never removes a tagged
+	// conversation.
+	Tags   []string `json:"tags,omitempty"`
+	System string   `json:"system"`
+	// Summary stands for earlier messages that were removed to save context. It is sent after the
+	// system prompt.
+	Summary  string    `json:"summary,omitempty"`
+	Settings Settings  `json:"settings"`
+	Messages []Message `json:"messages"`
+	Usage    *Usage    `json:"usage,omitempty"` // tokens used by all requests so far
+	// Previous is the conversation file this one continues, when the other reached its message
+	// limit.
+	Previous string `json:"previous,omitempty"`
+}
+
+// SystemFor returns the system prompt used with model: the "system" text of the model's settings,
+// if it has one, or the conversation's.
+func (c *Conversation) SystemFor(model string) string {
+	if s, ok := c.Settings.Models[model]["system"].(string); ok && s != "" {
+		return s
+	}
+	return c.System
+}
+
+// SummaryPrefix introduces the summary of a conversation in requests.
+const SummaryPrefix = "Summary of the earlier conversation:\n\n"
+
+// ErrYAMLConversation is returned by LoadConversation and Conversation.Save for a .yaml or .yml
+// path. The package only reads and writes the JSON format; the YAML conversation files of the
+// command line tool have to be converted to JSON first, e.g. with its /save chat.json command.
+var ErrYAMLConversation = errors.New("YAML conversation files are not supported, use a .json path")
+
+func isYAMLPath(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// LoadConversation reads a conversation file in the JSON format.
+func LoadConversation(path string) (*Conversation, error) {
+	if isYAMLPath(path) {
+		return nil, ErrYAMLConversation
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Conversation
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Save writes the conversation to path in the JSON format. The file is replaced atomically, so
+// readers never see a partly written conversation.
+func (c *Conversation) Save(path string) error {
+	if isYAMLPath(path) {
+		return ErrYAMLConversation
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Append adds a message with the given role and content.
+func (c *Conversation) Append(role, content string) {
+	c.Messages = append(c.Messages, Message{Role: role, Content: content})
+}
+
+// Reply returns an assistant message written by model, stamped with the current time and with the
+// tokens of its request when they are known.
+func Reply(model, content string, u Usage) Message {
+	now := time.Now()
+	m := Message{Role: "assistant", Content: content, Model: model, Time: &now}
+	if u != (Usage{}) {
+		m.Usage = &u
+	}
+	return m
+}
+
+// AddUsage adds the tokens of one request to the conversation's total.
+func (c *Conversation) AddUsage(u Usage) {
+	if u == (Usage{}) {
+		return
+	}
+	if c.Usage == nil {
+		c.Usage = &Usage{}
+	}
+	c.Usage.PromptTokens += u.PromptTokens
+	c.Usage.CompletionTokens += u.CompletionTokens
+}
+
+// APIMessages returns the messages to send for the conversation: the system prompt and the summary,
+// if any, then the history without pending and archived messages and without the fields that are
+// only kept in the file (Incomplete, Persona, Comparison, Status, Bookmark, Model, Time, Usage).
+func (c *Conversation) APIMessages() []Message {
+	var messages []Message
+	if c.System != "" {
+		messages = append(messages, Message{Role: "system", Content: c.System})
+	}
+	if c.Summary != "" {
+		messages = append(messages, Message{Role: "system", Content: SummaryPrefix + c.Summary})
+	}
+	for _, m := range c.Messages {
+		if m.Status == StatusPending || m.Archived {
+			continue
+		}
+		m.Incomplete, m.Persona, m.Comparison, m.Status, m.Bookmark = false, "", nil, "", nil
+		m.Model, m.Time, m.Usage = "", nil, nil
+		messages = append(messages, m)
+	}
+	return messages
+}
@@ -0,0 +1,151 @@
+package nvidiachat
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The reasons an *APIError can wrap, to test with errors.Is and errors.As.
+var (
+	// ErrAuth means the API rejected the key (401 or 403).
+	ErrAuth = errors.New("API key rejected")
+	// ErrModelNotFound means the API does not serve the requested model, e.g. a misspelled or
+	// retired one.
+	ErrModelNotFound = errors.New("model not found")
+)
+
+// ErrRateLimited means the API rejected the request with 429 Too Many Requests.
+type ErrRateLimited struct {
+	// RetryAfter is the delay the server asked for in its Retry-After header, 0 when it gave none.
+	RetryAfter time.Duration
+}
+
+func (e *ErrRateLimited) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited, retry after %s", e.RetryAfter)
+	}
+	return "rate limited"
+}
+
+// ErrContextLength means the request does not fit the model's context window. The sizes are 0 when
+// the server's message does not give them.
+type ErrContextLength struct {
+	Limit     int // the context window
+	Requested int // the tokens the request needed
+	Messages  int // the part of Requested taken by the messages, when the server reports it
+	Overflow  int // Requested - Limit
+}
+
+func (e *ErrContextLength) Error() string {
+	if e.Overflow > 0 {
+		return fmt.Sprintf("context length exceeded by %d tokens (%d requested, %d allowed)", e.Overflow, e.Requested, e.Limit)
+	}
+	return "context length exceeded"
+}
+
+// APIError is returned when the API responds with an HTTP error status. Reason, when the status or
+// the body is recognized, is one of ErrAuth, ErrModelNotFound, *ErrRateLimited or
+// *ErrContextLength; errors.Is and errors.As see through the APIError to it.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	Reason     error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api error: %s\n%s", e.Status, e.Body)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Reason
+}
+
+// NewAPIError returns the error for an HTTP error response whose body was read.
+func NewAPIError(resp *http.Response, body []byte) *APIError {
+	e := &APIError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+	e.Reason = errorReason(resp.StatusCode, resp.Header, e.Body)
+	return e
+}
+
+// errorReason recognizes the reason of an error response, or returns nil.
+func errorReason(code int, header http.Header, body string) error {
+	lower := strings.ToLower(body)
+	switch {
+	case code == 401 || code == 403:
+		return ErrAuth
+	case code == 429:
+		return &ErrRateLimited{RetryAfter: RetryAfter(header)}
+	case strings.Contains(lower, "context length") || strings.Contains(lower, "context_length") || strings.Contains(lower, "maximum context"):
+		e := &ErrContextLength{}
+		if limit, requested, messages, ok := parseContextOverflow(lower); ok {
+			e.Limit, e.Requested, e.Messages, e.Overflow = limit, requested, messages, requested-limit
+		}
+		return e
+	case code == 400 || code == 404 || code == 410 || code == 422:
+		// NVIDIA answers 404 for functions it no longer hosts, naming the function. Other 404s, such
+		// as a wrong base URL, say nothing about the model and keep no reason.
+		if strings.Contains(lower, "model_not_found") {
+			return ErrModelNotFound
+		}
+		if !strings.Contains(lower, "model") && !strings.Contains(lower, "function") {
+			return nil
+		}
+		for _, s := range []string{"not found", "does not exist", "deprecated", "retired", "end of life", "no longer"} {
+			if strings.Contains(lower, s) {
+				return ErrModelNotFound
+			}
+		}
+	}
+	return nil
+}
+
+// RetryAfter returns the delay of a Retry-After header, given in seconds or as a date, or 0.
+func RetryAfter(header http.Header) time.Duration {
+	v := strings.TrimSpace(header.Get("Retry-After"))
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && time.Until(t) > 0 {
+		return time.Until(t).Round(time.Second)
+	}
+	return 0
+}
+
+// contextOverflowPatterns extract the context window and the requested size from the error
+// messages of common OpenAI-compatible servers.
+var contextOverflowPatterns = []struct {
+	re                   *regexp.Regexp
+	limitIdx, requestIdx int
+}{
+	{regexp.MustCompile(`maximum context length is (\d+) tokens.*?(?:requested|resulted in|contains?) (\d+) tokens`), 1, 2},
+	{regexp.MustCompile(`(\d+) tokens > (\d+) maximum`), 2, 1},
+	{regexp.MustCompile(`input length (?:of )?(\d+) exceeds (?:the )?maximum (?:context length )?(?:of )?(\d+)`), 2, 1},
+}
+
+var contextMessageTokens = regexp.MustCompile(`(\d+) (?:tokens )?in the messages`)
+
+// parseContextOverflow returns the context window and the number of tokens the request needed,
+// and the part of it taken by the messages when the server reports it (0 otherwise), from a
+// lowercased error body.
+func parseContextOverflow(lower string) (limit, requested, messages int, ok bool) {
+	for _, p := range contextOverflowPatterns {
+		if m := p.re.FindStringSubmatch(lower); m != nil {
+			limit, _ = strconv.Atoi(m[p.limitIdx])
+			requested, _ = strconv.Atoi(m[p.requestIdx])
+			if m := contextMessageTokens.FindStringSubmatch(lower); m != nil {
+				messages, _ = strconv.Atoi(m[1])
+			}
+			return limit, requested, messages, requested > limit
+		}
+	}
+	return 0, 0, 0, false
+}
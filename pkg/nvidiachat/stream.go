@@ -0,0 +1,146 @@
+package nvidiachat
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// ChoiceDelta is the delta of a streamed choice.
+type ChoiceDelta struct {
+	Content          *string         `json:"content,omitempty"`
+	ReasoningContent *string         `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+// ChoiceStream is a choice of a streamed chunk.
+type ChoiceStream struct {
+	Delta   *ChoiceDelta           `json:"delta,omitempty"`
+	Message map[string]interface{} `json:"message,omitempty"` // fallback
+}
+
+// StreamChunk is one server-sent event of a streamed response.
+type StreamChunk struct {
+	Choices []ChoiceStream `json:"choices"`
+	Usage   *Usage         `json:"usage,omitempty"` // final chunk, when stream_options.include_usage is set
+}
+
+// Delta is the part of a response carried by one chunk.
+type Delta struct {
+	Reasoning string
+	Content   string
+	ToolCalls []ToolCallDelta
+	Usage     *Usage
+}
+
+// StreamReader parses a streamed (server-sent events) response.
+type StreamReader struct {
+	scanner *bufio.Scanner
+}
+
+// NewStreamReader returns a reader for the streamed response r.
+func NewStreamReader(r io.Reader) *StreamReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return &StreamReader{scanner: scanner}
+}
+
+// Next returns the next chunk of the response. Lines that are not JSON chunks are skipped. At the
+// end of the stream it returns io.EOF.
+func (s *StreamReader) Next() (Delta, error) {
+	for s.scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(s.scanner.Text(), "data: "))
+		if line == "" || line == "[DONE]" {
+			continue
+		}
+		var chunk StreamChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			continue
+		}
+		d := Delta{Usage: chunk.Usage}
+		if len(chunk.Choices) > 0 {
+			choice := chunk.Choices[0]
+			if choice.Delta != nil {
+				if choice.Delta.ReasoningContent != nil {
+					d.Reasoning = *choice.Delta.ReasoningContent
+				}
+				if choice.Delta.Content != nil {
+					d.Content = *choice.Delta.Content
+				}
+				d.ToolCalls = choice.Delta.ToolCalls
+			} else if msg := choice.Message; msg != nil {
+				// some servers put the content under message
+				d.Reasoning, _ = msg["reasoning_content"].(string)
+				d.Content, _ = msg["content"].(string)
+			}
+		}
+		return d, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return Delta{}, err
+	}
+	return Delta{}, io.EOF
+}
+
+// Response is a complete reply of the model.
+type Response struct {
+	Reasoning string
+	Content   string
+	ToolCalls []ToolCall
+	Usage     Usage
+}
+
+// add merges a streamed chunk into the response.
+func (r *Response) add(d Delta) {
+	r.Reasoning += d.Reasoning
+	r.Content += d.Content
+	r.ToolCalls = MergeToolCallDeltas(r.ToolCalls, d.ToolCalls)
+	if d.Usage != nil {
+		r.Usage = *d.Usage
+	}
+}
+
+// ParseResponse parses the body of a non-streamed response.
+func ParseResponse(body []byte) (*Response, error) {
+	var parsed struct {
+		Choices []struct {
+			Delta   *ChoiceDelta `json:"delta"`
+			Message *struct {
+				Content          *string    `json:"content"`
+				ReasoningContent *string    `json:"reasoning_content"`
+				ToolCalls        []ToolCall `json:"tool_calls"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage *Usage `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, err
+	}
+	r := &Response{}
+	if parsed.Usage != nil {
+		r.Usage = *parsed.Usage
+	}
+	if len(parsed.Choices) == 0 {
+		return r, nil
+	}
+	first := parsed.Choices[0]
+	if d := first.Delta; d != nil {
+		if d.ReasoningContent != nil {
+			r.Reasoning = *d.ReasoningContent
+		}
+		if d.Content != nil {
+			r.Content = *d.Content
+		}
+	}
+	if m := first.Message; m != nil {
+		if m.ReasoningContent != nil && r.Reasoning == "" {
+			r.Reasoning = *m.ReasoningContent
+		}
+		if m.Content != nil && r.Content == "" {
+			r.Content = *m.Content
+		}
+		r.ToolCalls = m.ToolCalls
+	}
+	return r, nil
+}
@@ -0,0 +1,111 @@
+// Package nvidiachat is a client for the OpenAI-compatible chat completions API of NVIDIA build
+// (https://build.nvidia.com) and other compatible endpoints. It is the engine of the nvidia-chat
+// command and can be embedded in other Go programs:
+//
+//	client := nvidiachat.New(
+//		nvidiachat.WithAPIKey(os.Getenv("NVIDIA_BUILD_AI_ACCESS_TOKEN")),
+//		nvidiachat.WithModel("openai/gpt-oss-120b"),
+//		nvidiachat.WithParams(map[string]interface{}{"temperature": 0.5}),
+//	)
+//	conv, _ := nvidiachat.LoadConversation("chat.json") // or &nvidiachat.Conversation{}
+//	resp, err := client.Send(ctx, conv, "Hello!", func(d nvidiachat.Delta) error {
+//		fmt.Print(d.Content)
+//		return nil
+//	})
+//	conv.Save("chat.json")
+//
+// Conversations use the same JSON file format as the nvidia-chat command.
+package nvidiachat
+
+import "time"
+
+// Message is one message of a conversation.
+type Message struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	// Incomplete marks a response that was cut off while streaming. It is never sent to the API.
+	Incomplete bool `json:"incomplete,omitempty"`
+	// Persona names the round-table participant that wrote an assistant message. It is never sent
+	// to the API.
+	Persona string `json:"persona,omitempty"`
+	// Comparison records the A/B comparison an assistant message was kept from. It is never sent
+	// to the API.
+	Comparison *Comparison `json:"comparison,omitempty"`
+	// Status is StatusPending for a message queued while the API could not be reached. Pending
+	// messages are left out of requests until they are sent.
+	Status string `json:"status,omitempty"`
+	// Archived marks a message that was replaced by the conversation's summary or trimmed to fit the
+	// context window. It stays in the file for the record but is never sent to the API.
+	Archived bool `json:"archived,omitempty"`
+	// Bookmark marks an assistant message saved with /bookmark. It is never sent to the API.
+	Bookmark *Bookmark `json:"bookmark,omitempty"`
+	// Model, Time and Usage record which model wrote an assistant message, when, and the tokens
+	// of its request, for usage statistics. They are never sent to the API.
+	Model string     `json:"model,omitempty"`
+	Time  *time.Time `json:"time,omitempty"`
+	Usage *Usage     `json:"usage,omitempty"`
+}
+
+// StatusPending marks a queued message that has not been sent yet.
+const StatusPending = "pending"
+
+// Comparison is the outcome of sending one prompt to two models.
+type Comparison struct {
+	Models   []string `json:"models"`
+	Kept     string   `json:"kept"`               // the model whose answer is the message
+	Rejected string   `json:"rejected,omitempty"` // the other model's answer
+}
+
+// Bookmark is the mark of a message worth finding again, with an optional note.
+type Bookmark struct {
+	Note string    `json:"note,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// ToolCall is a function call requested by the model.
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolFunctionCall `json:"function"`
+}
+
+// ToolFunctionCall holds the function name and its JSON-encoded arguments.
+type ToolFunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolCallDelta is a fragment of a tool call in a streamed response.
+type ToolCallDelta struct {
+	Index    int              `json:"index"`
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolFunctionCall `json:"function"`
+}
+
+// MergeToolCallDeltas adds streamed tool call fragments to the calls assembled so far.
+func MergeToolCallDeltas(calls []ToolCall, deltas []ToolCallDelta) []ToolCall {
+	for _, d := range deltas {
+		for len(calls) <= d.Index {
+			calls = append(calls, ToolCall{Type: "function"})
+		}
+		c := &calls[d.Index]
+		if d.ID != "" {
+			c.ID = d.ID
+		}
+		if d.Type != "" {
+			c.Type = d.Type
+		}
+		c.Function.Name += d.Function.Name
+		c.Function.Arguments += d.Function.Arguments
+	}
+	return calls
+}
+
+// Usage is the "usage" object of a chat completion response.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// projectConfigNames are the project configuration files looked for in the current directory and
+// its parents, in order of preference.
+var projectConfigNames = []string{".nvidia-chat.json", ".nvidia-chat.yaml", ".nvidia-chat.yml"}
+
+// projectConfig holds the settings of a project configuration file. Relative paths are resolved
+// against the directory of the file.
+type projectConfig struct {
+	Path             string `json:"-"`
+	Model            string `json:"model,omitempty"`
+	SystemPromptFile string `json:"system_prompt_file,omitempty"`
+	RAGIndex         string `json:"rag_index,omitempty"`
+	ConversationFile string `json:"conversation_file,omitempty"`
+}
+
+// findProjectConfig returns the project configuration file of dir or of its closest parent that
+// has one, or "" when there is none.
+func findProjectConfig(dir string) string {
+	for {
+		for _, name := range projectConfigNames {
+			if path := filepath.Join(dir, name); fileExists(path) {
+				return path
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// loadProjectConfig reads a project configuration file: JSON, or for .yaml and .yml files the flat
+// subset of YAML made of `key: value` lines and `#` comments.
+func loadProjectConfig(path string) (*projectConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pc := &projectConfig{}
+	if filepath.Ext(path) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(pc); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	} else if err := parseProjectYAML(path, data, pc); err != nil {
+		return nil, err
+	}
+
+	pc.Path = path
+	dir := filepath.Dir(path)
+	for _, p := range []*string{&pc.SystemPromptFile, &pc.RAGIndex, &pc.ConversationFile} {
+		if strings.HasPrefix(*p, "~") {
+			*p = os.Getenv("HOME") + (*p)[1:]
+		}
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	return pc, nil
+}
+
+func parseProjectYAML(path string, data []byte, pc *projectConfig) error {
+	fields := map[string]*string{
+		"model":              &pc.Model,
+		"system_prompt_file": &pc.SystemPromptFile,
+		"rag_index":          &pc.RAGIndex,
+		"conversation_file":  &pc.ConversationFile,
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line == "---" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%s:%d: expected key: value", path, lineNo)
+		}
+		field, ok := fields[strings.TrimSpace(parts[0])]
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown key %q", path, lineNo, strings.TrimSpace(parts[0]))
+		}
+		value := strings.TrimSpace(parts[1])
+		if strings.HasPrefix(value, "\"") {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return fmt.Errorf("%s:%d: invalid string %s", path, lineNo, value)
+			}
+			value = unquoted
+		} else if strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") && len(value) >= 2 {
+			value = value[1 : len(value)-1]
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		*field = value
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
+)
+
+// Queued messages are user messages that could not be sent because the API was unreachable. They
+// stay in the conversation file, in order and next to each other, with a pending status until they
+// are sent before the next message or by `nvidia-chat flush`.
+
+// pendingRange returns the index of the first queued message and the number of queued messages.
+func pendingRange(cf *ConversationFile) (first, n int) {
+	first = -1
+	for i, m := range cf.Messages {
+		if m.Status == nvidiachat.StatusPending {
+			if first < 0 {
+				first = i
+			}
+			n++
+		}
+	}
+	return first, n
+}
+
+// queueLastMessage marks the last message pending and moves it to the start or the end of the queue.
+func queueLastMessage(cf *ConversationFile, atFront bool) {
+	m := cf.Messages[len(cf.Messages)-1]
+	cf.Messages = cf.Messages[:len(cf.Messages)-1]
+	m.Status = nvidiachat.StatusPending
+	first, n := pendingRange(cf)
+	at := len(cf.Messages)
+	if first >= 0 {
+		at = first
+		if !atFront {
+			at = first + n
+		}
+	}
+	cf.Messages = append(cf.Messages[:at], append([]Message{m}, cf.Messages[at:]...)...)
+}
+
+// dequeueMessage moves the first queued message to the end of the conversation, ready to be sent.
+func dequeueMessage(cf *ConversationFile) (Message, bool) {
+	first, _ := pendingRange(cf)
+	if first < 0 {
+		return Message{}, false
+	}
+	m := cf.Messages[first]
+	m.Status = ""
+	cf.Messages = append(append(cf.Messages[:first:first], cf.Messages[first+1:]...), m)
+	return m, true
+}
+
+// requeueUnsent puts the last message of convFile back at the start of the queue when err shows
+// that the API could not be reached before it was answered. It reports whether it did.
+func requeueUnsent(convFile string, err error) bool {
+	if exitCodeFor(err) != exitNetwork {
+		return false
+	}
+	cf, rerr := readConversation(convFile)
+	if rerr != nil || len(cf.Messages) == 0 {
+		return false
+	}
+	if last := cf.Messages[len(cf.Messages)-1]; last.Role != "user" || last.Status != "" {
+		return false
+	}
+	queueLastMessage(cf, true)
+	return writeConversation(convFile, cf) == nil
+}
+
+// flushQueue sends the queued messages of convFile one after the other and returns how many were
+// answered. It stops at the first error; a message the API could not be reached for stays queued.
+func flushQueue(convFile string, cfg map[string]string, sysPromptContent, accessToken string, out io.Writer) (int, error) {
+	for sent := 0; ; sent++ {
+		cf, err := readConversation(convFile)
+		if err != nil {
+			return sent, fmt.Errorf("read conversation: %w", err)
+		}
+		m, ok := dequeueMessage(cf)
+		if !ok {
+			return sent, nil
+		}
+		if err := writeConversation(convFile, cf); err != nil {
+			return sent, fmt.Errorf("dequeue message: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "\n%s %s\n", blue+"You (queued):"+normal, firstLine(m.Content))
+		err = completeConversation(convFile, cfg, sysPromptContent, accessToken, out, func() {
+			fmt.Fprintf(os.Stderr, "\n%s\n", blue+"Assistant:"+normal)
+		})
+		if err != nil {
+			requeueUnsent(convFile, err)
+			return sent, err
+		}
+	}
+}
+
+// completeOrQueue answers the user message just added to convFile. Queued messages are sent
+// first, the new one last; when the API cannot be reached the new message joins the queue.
+func completeOrQueue(convFile string, cfg map[string]string, sysPromptContent, accessToken string, out io.Writer, announce func()) error {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		return fmt.Errorf("read conversation: %w", err)
+	}
+	if _, n := pendingRange(cf); n > 0 {
+		queueLastMessage(cf, false)
+		if err := writeConversation(convFile, cf); err != nil {
+			return fmt.Errorf("queue message: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Sending %d queued message(s) first.\n", n)
+		_, err = flushQueue(convFile, cfg, sysPromptContent, accessToken, out)
+	} else {
+		err = completeConversation(convFile, cfg, sysPromptContent, accessToken, out, announce)
+		requeueUnsent(convFile, err)
+	}
+	if cf, rerr := readConversation(convFile); rerr == nil && exitCodeFor(err) == exitNetwork {
+		if _, n := pendingRange(cf); n > 0 {
+			fmt.Fprintf(os.Stderr, "%sThe API could not be reached; %d message(s) are queued in %s. They are sent before your next message, or run `nvidia-chat flush %s`.%s\n", red, n, convFile, convFile, normal)
+		}
+	}
+	return err
+}
+
+func printFlushHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat flush [options] CONVERSATION_FILE...\n\n", bold, normal))
+	builder.WriteString("Send the messages queued in conversation files while the API could not be reached, in the order\n")
+	builder.WriteString("they were written, and save the replies. Each conversation keeps its model and settings.\n")
+	builder.WriteString("Messages that still cannot be sent stay queued.\n\n")
+	builder.WriteString("Options:\n")
+	builder.WriteString("  -k, --access-token KEY\n                        API key (repeatable). Defaults to the OS keyring, then the environment.\n")
+	builder.WriteString("  --profile NAME        Use the API keys stored in the OS keyring under NAME.\n")
+	builder.WriteString("  --base-url URL        API base URL (default: the model's endpoint, else " + defaultBaseURL + ").\n")
+	fmt.Print(builder.String())
+}
+
+// runFlushCommand implements the `flush` subcommand and returns the process exit code.
+func runFlushCommand(args []string) int {
+	base := map[string]string{
+		"BASE_URL":        "",
+		"TIMEOUT":         defaultTimeout,
+		"CONNECT_TIMEOUT": defaultConnectTimeout,
+		"IDLE_TIMEOUT":    defaultIdleTimeout,
+		"MAX_RETRIES":     defaultMaxRetries,
+		"HISTORY_LIMIT":   strconv.Itoa(defaultHistoryLimit),
+	}
+	profile := defaultProfile
+	var flagKeys, files []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printFlushHelp()
+			return exitOK
+		case "-k", "--access-token", "--profile", "--base-url":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%smissing value for %s%s\n", red, args[i], normal)
+				return exitUsage
+			}
+			val := args[i+1]
+			switch args[i] {
+			case "-k", "--access-token":
+				flagKeys = append(flagKeys, val)
+			case "--profile":
+				profile = val
+			case "--base-url":
+				base["BASE_URL"] = val
+			}
+			i++
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				fmt.Fprintf(os.Stderr, "Unknown option: %s\n", args[i])
+				printFlushHelp()
+				return exitUsage
+			}
+			files = append(files, args[i])
+		}
+	}
+	if len(files) == 0 {
+		printFlushHelp()
+		return exitUsage
+	}
+
+	keys, _ := collectAPIKeys(flagKeys, profile)
+	if len(keys) == 0 {
+		fmt.Fprintf(os.Stderr, "%sNo API key found.%s Run `nvidia-chat auth login` or set NVIDIA_BUILD_AI_ACCESS_TOKEN.\n", red, normal)
+		return exitAuth
+	}
+	apiKeys = newKeyPool(keys)
+
+	for _, convFile := range files {
+		cf, err := readConversation(convFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to read %s: %v%s\n", red, convFile, err, normal)
+			return exitGeneral
+		}
+		if _, n := pendingRange(cf); n == 0 {
+			fmt.Fprintf(os.Stderr, "%s: no queued messages\n", convFile)
+			continue
+		}
+		model := cf.Settings.Model
+		if model == "" {
+			model = defaultModel
+		}
+		cfg := benchConfig(base, model)
+		if err := applyFileSettingsAsDefaults(convFile, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "%sWarning applying file settings: %v%s\n", red, err, normal)
+		}
+		fmt.Fprintf(os.Stderr, "%s%s%s\n", bold, convFile, normal)
+		sent, err := flushQueue(convFile, cfg, "", apiKeys.Current(), os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%s%s\n", red, describeError(err), normal)
+			fmt.Fprintf(os.Stderr, "Sent %d queued message(s); the others stay queued in %s\n", sent, convFile)
+			return exitCodeFor(err)
+		}
+		fmt.Fprintf(os.Stderr, "\n%sSent %d queued message(s) from %s%s\n", green, sent, convFile, normal)
+	}
+	return exitOK
+}
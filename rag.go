@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultEmbeddingModel = "nvidia/nv-embedqa-e5-v5"
+	defaultChunkSize      = 1500 // characters per chunk
+	defaultRAGTopK        = 4
+	maxIndexedFileSize    = 1024 * 1024
+	embeddingBatchSize    = 32
+)
+
+// indexChunk is a piece of an indexed file together with its embedding.
+type indexChunk struct {
+	Path      string    `json:"path"`
+	StartLine int       `json:"start_line"`
+	EndLine   int       `json:"end_line"`
+	Text      string    `json:"text"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// vectorIndex is the local vector store written by `nvidia-chat index`.
+type vectorIndex struct {
+	Model  string       `json:"model"`
+	Root   string       `json:"root"`
+	Chunks []indexChunk `json:"chunks"`
+}
+
+// ragIndex is the index used to add retrieved context to each prompt (--rag), if any.
+var ragIndex *vectorIndex
+
+// ragTopK is the number of chunks retrieved per prompt.
+var ragTopK = defaultRAGTopK
+
+// indexDir returns the directory where named indexes are stored.
+func indexDir() string {
+	return filepath.Join(conversationDir(), "indexes")
+}
+
+// resolveIndexPath maps an index name to its file; an existing path is used as-is.
+func resolveIndexPath(name string) string {
+	if fileExists(name) || strings.HasSuffix(name, ".json") {
+		return name
+	}
+	return filepath.Join(indexDir(), name+".json")
+}
+
+func printIndexHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat index <dir> [options]\n\n", bold, normal))
+	builder.WriteString("Split the text files under <dir> into chunks, embed them with the embeddings endpoint and\n")
+	builder.WriteString("store them in a local index usable with --rag.\n\n")
+	builder.WriteString("Options:\n")
+	builder.WriteString("  --name NAME           Index name (default: the directory name). Stored in " + indexDir() + ".\n")
+	builder.WriteString("  --model NAME          Embedding model (default: " + defaultEmbeddingModel + ").\n")
+	builder.WriteString(fmt.Sprintf("  --chunk-size N        Approximate chunk size in characters (default: %d).\n", defaultChunkSize))
+	builder.WriteString("  --embeddings-url URL  Base URL of the embeddings endpoint (default: the embeddings_url setting,\n                        or the NVIDIA endpoint). --base-url is accepted as an alias.\n")
+	builder.WriteString("  -k, --access-token KEY, --profile NAME\n                        Same as for chat.\n")
+	fmt.Print(builder.String())
+}
+
+// runIndexCommand implements the `index` subcommand and returns the process exit code.
+func runIndexCommand(args []string) int {
+	cfg := map[string]string{
+		"MODEL":           defaultEmbeddingModel,
+		"EMBEDDINGS_URL":  userConfig["settings.embeddings_url"],
+		"TIMEOUT":         "120",
+		"CONNECT_TIMEOUT": defaultConnectTimeout,
+		"IDLE_TIMEOUT":    defaultIdleTimeout,
+		"MAX_RETRIES":     "2",
+	}
+	if v := os.Getenv(envSettingPrefix + "EMBEDDINGS_URL"); v != "" {
+		cfg["EMBEDDINGS_URL"] = v
+	}
+	dir, name, profile := "", "", defaultProfile
+	chunkSize := defaultChunkSize
+	var flagKeys []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printIndexHelp()
+			return exitOK
+		case "--name", "--model", "--chunk-size", "-k", "--access-token", "--profile", "--embeddings-url", "--base-url":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%smissing value for %s%s\n", red, args[i], normal)
+				return exitUsage
+			}
+			val := args[i+1]
+			switch args[i] {
+			case "--name":
+				name = val
+			case "--model":
+				cfg["MODEL"] = val
+			case "--chunk-size":
+				n, err := strconv.Atoi(val)
+				if err != nil || n < 100 {
+					fmt.Fprintf(os.Stderr, "%sInvalid chunk size (>= 100): %s%s\n", red, val, normal)
+					return exitUsage
+				}
+				chunkSize = n
+			case "-k", "--access-token":
+				flagKeys = append(flagKeys, val)
+			case "--profile":
+				profile = val
+			case "--embeddings-url", "--base-url":
+				cfg["EMBEDDINGS_URL"] = val
+			}
+			i++
+		default:
+			if strings.HasPrefix(args[i], "-") || dir != "" {
+				fmt.Fprintf(os.Stderr, "Unknown option: %s\n", args[i])
+				printIndexHelp()
+				return exitUsage
+			}
+			dir = args[i]
+		}
+	}
+	if dir == "" {
+		printIndexHelp()
+		return exitUsage
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitUsage
+	}
+	if name == "" {
+		name = filepath.Base(absDir)
+	}
+
+	keys, _ := collectAPIKeys(flagKeys, profile)
+	if len(keys) == 0 {
+		fmt.Fprintf(os.Stderr, "%sNo API key found.%s Run `nvidia-chat auth login` or set NVIDIA_BUILD_AI_ACCESS_TOKEN.\n", red, normal)
+		return exitAuth
+	}
+	apiKeys = newKeyPool(keys)
+
+	chunks, err := chunkDirectory(absDir, chunkSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed reading %s: %v%s\n", red, dir, err, normal)
+		return exitGeneral
+	}
+	if len(chunks) == 0 {
+		fmt.Fprintf(os.Stderr, "%sNo text files found in %s%s\n", red, dir, normal)
+		return exitGeneral
+	}
+
+	for start := 0; start < len(chunks); start += embeddingBatchSize {
+		end := start + embeddingBatchSize
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+		texts := make([]string, 0, end-start)
+		for _, c := range chunks[start:end] {
+			texts = append(texts, c.Text)
+		}
+		vectors, err := embedTexts(cfg, texts, "passage")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sEmbedding failed: %s%s\n", red, describeError(err), normal)
+			return exitCodeFor(err)
+		}
+		for i, v := range vectors {
+			chunks[start+i].Embedding = v
+		}
+		fmt.Fprintf(os.Stderr, "\rEmbedded %d/%d chunks", end, len(chunks))
+	}
+	fmt.Fprintln(os.Stderr)
+
+	path := resolveIndexPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	b, err := json.Marshal(vectorIndex{Model: cfg["MODEL"], Root: absDir, Chunks: chunks})
+	if err == nil {
+		err = ioutil.WriteFile(path, b, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed writing index: %v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	fmt.Fprintf(os.Stderr, "%sIndexed %d chunks into %s%s\nUse it with: nvidia-chat --rag %s\n", green, len(chunks), path, normal, name)
+	return exitOK
+}
+
+// chunkDirectory splits the text files under root into chunks of about chunkSize characters,
+// breaking at line boundaries. Hidden files and directories, binary and large files are skipped.
+func chunkDirectory(root string, chunkSize int) ([]indexChunk, error) {
+	var chunks []indexChunk
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || info.Size() == 0 || info.Size() > maxIndexedFileSize {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil || isBinary(data) {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+
+		var buf strings.Builder
+		startLine := 1
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		for i, line := range lines {
+			buf.WriteString(line)
+			buf.WriteString("\n")
+			if buf.Len() >= chunkSize || i == len(lines)-1 {
+				if text := strings.TrimSpace(buf.String()); text != "" {
+					chunks = append(chunks, indexChunk{Path: rel, StartLine: startLine, EndLine: i + 1, Text: text})
+				}
+				buf.Reset()
+				startLine = i + 2
+			}
+		}
+		return nil
+	})
+	return chunks, err
+}
+
+// embeddingsBaseURL returns the base URL of the embeddings endpoint: the EMBEDDINGS_URL setting, or
+// the NVIDIA endpoint. The chat BASE_URL is not used, as a chat server often serves no embeddings.
+func embeddingsBaseURL(cfg map[string]string) string {
+	if u := cfg["EMBEDDINGS_URL"]; u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return defaultBaseURL
+}
+
+// embedTexts calls the embeddings endpoint. inputType is "passage" for documents and "query" for
+// prompts, as expected by asymmetric retrieval models.
+func embedTexts(cfg map[string]string, texts []string, inputType string) ([][]float64, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"model":      cfg["MODEL"],
+		"input":      texts,
+		"input_type": inputType,
+		"truncate":   "END",
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", embeddingsBaseURL(cfg)+"/embeddings", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKeys.Current())
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := sendChatRequest(cfg, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, newAPIError(resp, body)
+	}
+	var out struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("parse embeddings: %w", err)
+	}
+	if len(out.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(out.Data))
+	}
+	vectors := make([][]float64, len(texts))
+	for _, d := range out.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, errors.New("embedding index out of range")
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+// loadIndex reads an index by name or path.
+func loadIndex(name string) (*vectorIndex, error) {
+	b, err := ioutil.ReadFile(resolveIndexPath(name))
+	if err != nil {
+		return nil, err
+	}
+	var idx vectorIndex
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return nil, fmt.Errorf("parse index: %w", err)
+	}
+	return &idx, nil
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// retrieve returns the k chunks most similar to the query.
+func (idx *vectorIndex) retrieve(cfg map[string]string, query string, k int) ([]indexChunk, error) {
+	embedCfg := map[string]string{"MODEL": idx.Model}
+	for _, key := range []string{"EMBEDDINGS_URL", "TIMEOUT", "CONNECT_TIMEOUT", "IDLE_TIMEOUT", "MAX_RETRIES"} {
+		embedCfg[key] = cfg[key]
+	}
+	vectors, err := embedTexts(embedCfg, []string{query}, "query")
+	if err != nil {
+		return nil, err
+	}
+	type scored struct {
+		chunk indexChunk
+		score float64
+	}
+	results := make([]scored, 0, len(idx.Chunks))
+	for _, c := range idx.Chunks {
+		results = append(results, scored{c, cosineSimilarity(vectors[0], c.Embedding)})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].score > results[j].score })
+	if k > len(results) {
+		k = len(results)
+	}
+	chunks := make([]indexChunk, k)
+	for i := range chunks {
+		chunks[i] = results[i].chunk
+	}
+	return chunks, nil
+}
+
+// withRAGContext prepends the chunks of ragIndex most relevant to userInput. It returns userInput
+// unchanged when no index is loaded, and with a placeholder for the chunks in a dry run.
+func withRAGContext(cfg map[string]string, userInput string) (string, error) {
+	if ragIndex == nil {
+		return userInput, nil
+	}
+	var builder strings.Builder
+	builder.WriteString("Use the following excerpts from local files as context if they are relevant.\n\n")
+	if cfg["DRY_RUN"] == "true" {
+		// a dry run does not call the embeddings API
+		builder.WriteString(fmt.Sprintf("[up to %d excerpts retrieved from the index]\n\n", ragTopK))
+		return builder.String() + userInput, nil
+	}
+	chunks, err := ragIndex.retrieve(cfg, userInput, ragTopK)
+	if err != nil {
+		return "", fmt.Errorf("retrieve context: %w", err)
+	}
+	for _, c := range chunks {
+		// a fence longer than any backtick run inside the chunk cannot be closed early
+		fence := "```"
+		for strings.Contains(c.Text, fence) {
+			fence += "`"
+		}
+		builder.WriteString(fmt.Sprintf("File: %s (lines %d-%d)\n%s\n%s\n%s\n\n", c.Path, c.StartLine, c.EndLine, fence, c.Text, fence))
+	}
+	builder.WriteString(userInput)
+	fmt.Fprintf(os.Stderr, "%sAdded %d context chunk(s) from the index%s\n", green, len(chunks), normal)
+	return builder.String(), nil
+}
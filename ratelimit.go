@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
+)
+
+// maxRateLimitWait is the longest the client waits on its own for a rate limit to reset, before a
+// request or to retry a 429 response. Longer waits fail as before.
+const maxRateLimitWait = 2 * time.Minute
+
+// rateLimitRetries bounds the 429 responses retried after the wait the server asked for, on top of
+// MAX_RETRIES.
+const rateLimitRetries = 3
+
+// rateLimitQuota is the quota reported by the X-RateLimit-* headers of the last API response, for
+// requests or tokens. Limit and remaining are -1 when the header is missing.
+type rateLimitQuota struct {
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+func (q rateLimitQuota) known() bool {
+	return q.remaining >= 0
+}
+
+var rateLimit struct {
+	mu       sync.Mutex
+	seen     time.Time
+	requests rateLimitQuota
+	tokens   rateLimitQuota
+}
+
+// recordRateLimit remembers the quota reported by the headers of an API response. Both the
+// OpenAI-style per-kind headers (X-RateLimit-Remaining-Requests, -Tokens) and the plain
+// X-RateLimit-Limit/Remaining/Reset, counted as requests, are understood.
+func recordRateLimit(header http.Header) {
+	now := time.Now()
+	requests := parseRateLimitQuota(header, "-Requests", now)
+	if !requests.known() {
+		requests = parseRateLimitQuota(header, "", now)
+	}
+	tokens := parseRateLimitQuota(header, "-Tokens", now)
+	if !requests.known() && !tokens.known() {
+		return
+	}
+	rateLimit.mu.Lock()
+	defer rateLimit.mu.Unlock()
+	rateLimit.seen, rateLimit.requests, rateLimit.tokens = now, requests, tokens
+}
+
+func parseRateLimitQuota(header http.Header, suffix string, now time.Time) rateLimitQuota {
+	q := rateLimitQuota{limit: -1, remaining: -1}
+	if v, err := strconv.Atoi(strings.TrimSpace(header.Get("X-RateLimit-Limit" + suffix))); err == nil {
+		q.limit = v
+	}
+	if v, err := strconv.Atoi(strings.TrimSpace(header.Get("X-RateLimit-Remaining" + suffix))); err == nil {
+		q.remaining = v
+	}
+	if d := parseRateLimitReset(header.Get("X-RateLimit-Reset"+suffix), now); d > 0 {
+		q.reset = now.Add(d)
+	}
+	return q
+}
+
+// parseRateLimitReset reads a reset header: a duration ("1s", "6m0s"), a number of seconds, or a
+// Unix time in seconds or milliseconds.
+func parseRateLimitReset(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if n, err := strconv.ParseFloat(v, 64); err == nil {
+		switch {
+		case n > 1e12:
+			return time.UnixMilli(int64(n)).Sub(now)
+		case n > 1e9:
+			return time.Unix(int64(n), 0).Sub(now)
+		}
+		return time.Duration(n * float64(time.Second))
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return d
+	}
+	return 0
+}
+
+// rateLimitWait returns how long to wait before the next request because the last response said
+// no request is left until the quota resets, or 0.
+func rateLimitWait() time.Duration {
+	rateLimit.mu.Lock()
+	defer rateLimit.mu.Unlock()
+	for _, q := range []rateLimitQuota{rateLimit.requests, rateLimit.tokens} {
+		if q.remaining == 0 && !q.reset.IsZero() {
+			if d := time.Until(q.reset); d > 0 {
+				return d.Round(time.Second)
+			}
+		}
+	}
+	return 0
+}
+
+// retryDelay returns the wait a 429 response asks for, from Retry-After or else from the reset of
+// the exhausted quota, or 0 when it gives none.
+func retryDelay(resp *http.Response) time.Duration {
+	if d := nvidiachat.RetryAfter(resp.Header); d > 0 {
+		return d
+	}
+	recordRateLimit(resp.Header)
+	return rateLimitWait()
+}
+
+// rateLimitSummary describes the remaining quota, e.g. "quota 38/40 req, 9000/10000 tok, resets
+// in 12s", or "" when the API reported none or it has reset since.
+func rateLimitSummary() string {
+	rateLimit.mu.Lock()
+	defer rateLimit.mu.Unlock()
+	if rateLimit.seen.IsZero() {
+		return ""
+	}
+	var parts []string
+	var reset time.Time
+	for _, q := range []struct {
+		quota rateLimitQuota
+		unit  string
+	}{{rateLimit.requests, "req"}, {rateLimit.tokens, "tok"}} {
+		if !q.quota.known() {
+			continue
+		}
+		if !q.quota.reset.IsZero() && time.Now().After(q.quota.reset) {
+			continue
+		}
+		part := strconv.Itoa(q.quota.remaining)
+		if q.quota.limit >= 0 {
+			part += "/" + strconv.Itoa(q.quota.limit)
+		}
+		parts = append(parts, part+" "+q.unit)
+		if !q.quota.reset.IsZero() && (reset.IsZero() || q.quota.reset.After(reset)) {
+			reset = q.quota.reset
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	s := "quota " + strings.Join(parts, ", ")
+	if !reset.IsZero() {
+		s += fmt.Sprintf(", resets in %s", time.Until(reset).Round(time.Second))
+	}
+	return s
+}
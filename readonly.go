@@ -0,0 +1,66 @@
+package main
+
+import "io/ioutil"
+
+// conversationsInMemory keeps conversation files unchanged: with --read-only and --ephemeral,
+// what the session writes to a conversation is kept in memory only, and lost on exit.
+var conversationsInMemory bool
+
+// ephemeralSession is set by --ephemeral: the conversation has no file until /save writes one,
+// and nothing is left under the cache directory.
+var ephemeralSession bool
+
+// ephemeralConversation stands for the path of the conversation of an --ephemeral session.
+const ephemeralConversation = "(ephemeral)"
+
+// memoryConversations holds the conversations written while conversationsInMemory is set, as
+// their files would contain them, by path.
+var memoryConversations = map[string][]byte{}
+
+// savedTo completes the messages reporting a write to the conversation at convFile: "to" the
+// file, or "in memory only" when conversationsInMemory keeps the file unchanged.
+func savedTo(convFile string) string {
+	switch {
+	case ephemeralSession:
+		return "in memory only (ephemeral session)"
+	case conversationsInMemory:
+		return "in memory only (read-only session)"
+	}
+	return "to " + convFile
+}
+
+// conversationExists reports whether there is a conversation at path, in memory or on disk.
+func conversationExists(path string) bool {
+	if _, ok := memoryConversations[path]; ok {
+		return true
+	}
+	return fileExists(path)
+}
+
+// conversationData returns the content of the conversation file at path, from memory or disk.
+func conversationData(path string) ([]byte, error) {
+	if b, ok := memoryConversations[path]; ok {
+		return b, nil
+	}
+	return ioutil.ReadFile(path)
+}
+
+// keepConversationInMemory records cf as the conversation at path instead of writing the file.
+func keepConversationInMemory(path string, cf *ConversationFile) error {
+	b, err := encodeConversation(path, cf)
+	if err != nil {
+		return err
+	}
+	memoryConversations[path] = b
+	return nil
+}
+
+// saveConversationCopy implements /save: it writes the conversation at path, as it stands in
+// memory or on disk, to target, in the format of target's extension.
+func saveConversationCopy(path, target string) error {
+	cf, err := readConversation(path)
+	if err != nil {
+		return err
+	}
+	return saveConversationFile(target, cf)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// recording and replaying are set by --record DIR and --replay DIR. API requests are then saved
+// to, or answered from, the directory's exchange files.
+var recording, replaying *exchangeLog
+
+// recordedExchange is one API request and its response, as saved by --record.
+type recordedExchange struct {
+	Request struct {
+		Method string          `json:"method"`
+		URL    string          `json:"url"`
+		Body   json.RawMessage `json:"body,omitempty"`
+	} `json:"request"`
+	Response struct {
+		StatusCode int         `json:"status_code"`
+		Status     string      `json:"status"`
+		Header     http.Header `json:"header"`
+		Body       string      `json:"body"` // raw, server-sent events included
+	} `json:"response"`
+}
+
+// exchangeLog names the exchange files of a directory. Requests are identified by method, URL path
+// and body; identical requests are numbered in the order they are sent.
+type exchangeLog struct {
+	dir    string
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newExchangeLog(dir string) *exchangeLog {
+	return &exchangeLog{dir: dir, counts: map[string]int{}}
+}
+
+// exchangeKey identifies a request independently of the host and the API key.
+func exchangeKey(req *http.Request, body []byte) string {
+	sum := sha256.Sum256(append([]byte(req.Method+" "+req.URL.Path+"\n"), body...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// next returns the number of the next request with this key.
+func (l *exchangeLog) next(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := l.counts[key]
+	l.counts[key]++
+	return n
+}
+
+func (l *exchangeLog) path(key string, n int) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%s-%d.json", key, n))
+}
+
+// readRequestBody returns the body of req and the request to pass on, whose body is still unread.
+// A RoundTripper must not modify its request: the body is read from a copy made by req.GetBody,
+// or else from req, which is then passed on as a clone holding the body read.
+func readRequestBody(req *http.Request) ([]byte, *http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, req, nil
+	}
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return nil, nil, err
+		}
+		defer rc.Close()
+		body, err := ioutil.ReadAll(rc)
+		return body, req, err
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	clone := req.Clone(req.Context())
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	clone.Body, _ = clone.GetBody()
+	return body, clone, nil
+}
+
+// recordingTransport passes requests on and saves each exchange once its response has been read
+// to the end. Authorization headers are never saved.
+type recordingTransport struct {
+	next http.RoundTripper
+	log  *exchangeLog
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, req, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	key := exchangeKey(req, body)
+	path := t.log.path(key, t.log.next(key))
+	var ex recordedExchange
+	ex.Request.Method, ex.Request.URL = req.Method, req.URL.String()
+	if json.Valid(body) {
+		ex.Request.Body = body
+	}
+	ex.Response.StatusCode, ex.Response.Status, ex.Response.Header = resp.StatusCode, resp.Status, resp.Header
+	resp.Body = &recordingBody{ReadCloser: resp.Body, done: func(data []byte) {
+		ex.Response.Body = string(data)
+		if err := saveExchange(path, &ex); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to record the API response: %v%s\n", red, err, normal)
+		}
+	}}
+	return resp, nil
+}
+
+func saveExchange(path string, ex *recordedExchange) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(ex, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0o644)
+}
+
+// recordingBody keeps a copy of a response body and hands it to done when the body has been read
+// completely. Responses abandoned midway are not recorded.
+type recordingBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	done func([]byte)
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF && b.done != nil {
+		b.done(b.buf.Bytes())
+		b.done = nil
+	}
+	return n, err
+}
+
+// replayTransport answers requests from the exchange files saved by --record, without network
+// access. Once the recordings of a request are used up, the last one is served again.
+type replayTransport struct {
+	log *exchangeLog
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, req, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	key := exchangeKey(req, body)
+	n := t.log.next(key)
+	data, err := ioutil.ReadFile(t.log.path(key, n))
+	if os.IsNotExist(err) && n > 0 {
+		data, err = ioutil.ReadFile(t.log.path(key, n-1))
+	}
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recorded response for %s %s (%s) in %s", req.Method, req.URL.Path, key, t.log.dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ex recordedExchange
+	if err := json.Unmarshal(data, &ex); err != nil {
+		return nil, fmt.Errorf("reading recorded response %s-%d: %w", key, n, err)
+	}
+	return &http.Response{
+		StatusCode:    ex.Response.StatusCode,
+		Status:        ex.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        ex.Response.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(ex.Response.Body))),
+		ContentLength: int64(len(ex.Response.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	t.Cleanup(func() { recording, replaying = nil, nil })
+	stream := sseBody(
+		deltaChunk(map[string]interface{}{"content": "recorded"}),
+		deltaChunk(map[string]interface{}{"content": " answer"}),
+	)
+	api := newMockAPI(t, mockResponse{status: http.StatusOK, header: map[string]string{"Content-Type": "text/event-stream"}, body: stream})
+	cfg := mockConfig(api.URL)
+	payload := `{"model":"m","messages":[{"role":"user","content":"hi"}],"stream":true}`
+
+	recording = newExchangeLog(dir)
+	if _, body, err := postChat(t, cfg, payload); err != nil || body != stream {
+		t.Fatalf("recording: got %q, %v", body, err)
+	}
+	recording = nil
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("recorded %d exchange files, want 1", len(files))
+	}
+	if data, _ := ioutil.ReadFile(files[0]); bytes.Contains(data, []byte("test-key")) {
+		t.Error("the recording holds the API key")
+	}
+
+	api.Close() // replays must not need the server
+	replaying = newExchangeLog(dir)
+	status, body, err := postChat(t, cfg, payload)
+	if err != nil {
+		t.Fatalf("replaying: %v", err)
+	}
+	if status != http.StatusOK || body != stream {
+		t.Errorf("replay = %d %q, want the recorded response", status, body)
+	}
+	text, _, _, err := handleStream(strings.NewReader(body), "", ioutil.Discard)
+	if err != nil || text != "recorded answer" {
+		t.Errorf("replayed stream parsed as %q, %v", text, err)
+	}
+
+	// identical requests are numbered: once used up, the last recording is served again
+	if _, again, err := postChat(t, cfg, payload); err != nil || again != stream {
+		t.Errorf("second replay = %q, %v", again, err)
+	}
+	if _, _, err := postChat(t, cfg, `{"model":"m","messages":[{"role":"user","content":"other"}]}`); err == nil || !strings.Contains(err.Error(), "no recorded response") {
+		t.Errorf("replaying an unrecorded request: err = %v", err)
+	}
+}
+
+func TestReadRequestBodyLeavesRequestUnchanged(t *testing.T) {
+	req, err := http.NewRequest("POST", "http://example.com/v1/chat/completions", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := req.Body
+	body, next, err := readRequestBody(req)
+	if err != nil || string(body) != "payload" {
+		t.Fatalf("readRequestBody = %q, %v", body, err)
+	}
+	if req.Body != original {
+		t.Error("readRequestBody replaced the body of the caller's request")
+	}
+	if rest, _ := ioutil.ReadAll(next.Body); string(rest) != "payload" {
+		t.Errorf("the request passed on has body %q, want it unread", rest)
+	}
+
+	// without GetBody the body can only be read once: a clone carries it on
+	req, _ = http.NewRequest("POST", "http://example.com/v1/chat/completions", nil)
+	original = ioutil.NopCloser(strings.NewReader("once"))
+	req.Body = original
+	body, next, err = readRequestBody(req)
+	if err != nil || string(body) != "once" {
+		t.Fatalf("readRequestBody = %q, %v", body, err)
+	}
+	if req.Body != original || next == req {
+		t.Error("readRequestBody changed the caller's request instead of passing on a clone")
+	}
+	if rest, _ := ioutil.ReadAll(next.Body); string(rest) != "once" {
+		t.Errorf("the clone has body %q", rest)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// builtinDetectors are the redaction rules that can be enabled by name with redact.detectors.
+var builtinDetectors = map[string]string{
+	"api_key":     `\b(?:nvapi-[A-Za-z0-9_-]{20,}|sk-(?:proj-)?[A-Za-z0-9_-]{20,}|gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,}|xox[abprs]-[A-Za-z0-9-]{10,}|AIza[A-Za-z0-9_-]{35})`,
+	"aws_key":     `\b(?:AKIA|ASIA)[A-Z0-9]{16}\b|(?i:aws_secret_access_key)\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`,
+	"email":       `\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`,
+	"private_key": `-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+}
+
+// redactionRule masks the matches of a pattern with [REDACTED:name].
+type redactionRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// redaction is one masked match.
+type redaction struct {
+	rule, value string
+}
+
+// loadRedactionRules returns the rules of the [redact] config section, or none when redaction is
+// not enabled. redact.detectors lists the built-in detectors to use (default: all of them) and
+// each key of [redact.patterns] adds a named regular expression.
+func loadRedactionRules() ([]redactionRule, error) {
+	v := userConfig["redact.enabled"]
+	if v == "" {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid redact.enabled (true|false): %s", v)
+	}
+	if !enabled {
+		return nil, nil
+	}
+	var names []string
+	if v, ok := userConfig["redact.detectors"]; ok {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	} else {
+		for name := range builtinDetectors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	var rules []redactionRule
+	for _, name := range names {
+		pattern, ok := builtinDetectors[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown redaction detector %q in redact.detectors (available: api_key, aws_key, email, private_key)", name)
+		}
+		rules = append(rules, redactionRule{name: name, re: regexp.MustCompile(pattern)})
+	}
+	var custom []string
+	for key := range userConfig {
+		if strings.HasPrefix(key, "redact.patterns.") {
+			custom = append(custom, key)
+		}
+	}
+	sort.Strings(custom)
+	for _, key := range custom {
+		re, err := regexp.Compile(userConfig[key])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s: %v", key, err)
+		}
+		rules = append(rules, redactionRule{name: strings.TrimPrefix(key, "redact.patterns."), re: re})
+	}
+	return rules, nil
+}
+
+// redactText masks every match of rules in text and returns the masked text and what was masked.
+func redactText(text string, rules []redactionRule) (string, []redaction) {
+	var found []redaction
+	for _, r := range rules {
+		text = r.re.ReplaceAllStringFunc(text, func(m string) string {
+			found = append(found, redaction{rule: r.name, value: m})
+			return "[REDACTED:" + r.name + "]"
+		})
+	}
+	return text, found
+}
+
+// redactOutgoing masks the configured patterns in a message about to be sent and tells the user
+// what was masked. hint is printed after the list.
+func redactOutgoing(text, hint string) string {
+	rules, err := loadRedactionRules()
+	if err != nil || len(rules) == 0 {
+		return text // checked at startup
+	}
+	text, found := redactText(text, rules)
+	if len(found) == 0 {
+		return text
+	}
+	fmt.Fprintf(os.Stderr, "%sRedacted %d item(s) before sending:%s\n", red, len(found), normal)
+	for _, f := range found {
+		fmt.Fprintf(os.Stderr, "  %s: %s\n", f.rule, abbreviateSecret(f.value))
+	}
+	if hint != "" {
+		fmt.Fprintln(os.Stderr, hint)
+	}
+	return text
+}
+
+// abbreviateSecret shows enough of a redacted value to recognize it without repeating it whole.
+func abbreviateSecret(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) <= 8 {
+		return s
+	}
+	return s[:6] + "…" + s[len(s)-2:]
+}
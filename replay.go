@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
+)
+
+// replayOutputPath returns the default transcript path for replaying convFile against model:
+// the original name with the model appended, next to the original.
+func replayOutputPath(convFile, model string) string {
+	ext := filepath.Ext(convFile)
+	safe := strings.NewReplacer("/", "_", ":", "_", " ", "_").Replace(model)
+	return strings.TrimSuffix(convFile, ext) + "." + safe + ".json"
+}
+
+func printReplayHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat replay CONVERSATION_FILE --model NAME [-o FILE] [options]\n\n", bold, normal))
+	builder.WriteString("Send the user messages of a conversation, one after the other, to another model and save its\n")
+	builder.WriteString("replies as a new conversation with the same system prompt. Comparing the two transcripts shows\n")
+	builder.WriteString("how the models differ on the same questions. The new model uses its default settings.\n\n")
+	builder.WriteString("Options:\n")
+	builder.WriteString("  -m, --model NAME      Model to replay the conversation against (required).\n")
+	builder.WriteString("  -o, --output FILE     New conversation file (default: the original name with the model appended).\n")
+	builder.WriteString("  -k, --access-token KEY\n                        API key (repeatable). Defaults to the OS keyring, then the environment.\n")
+	builder.WriteString("  --profile NAME        Use the API keys stored in the OS keyring under NAME.\n")
+	builder.WriteString("  --base-url URL        API base URL (default: the model's endpoint, else " + defaultBaseURL + ").\n")
+	fmt.Print(builder.String())
+}
+
+// runReplayCommand implements the `replay` subcommand and returns the process exit code.
+func runReplayCommand(args []string) int {
+	base := map[string]string{
+		"BASE_URL":        "",
+		"TIMEOUT":         defaultTimeout,
+		"CONNECT_TIMEOUT": defaultConnectTimeout,
+		"IDLE_TIMEOUT":    defaultIdleTimeout,
+		"MAX_RETRIES":     defaultMaxRetries,
+		"HISTORY_LIMIT":   strconv.Itoa(defaultHistoryLimit),
+	}
+	model, output, profile, convFile := "", "", defaultProfile, ""
+	var flagKeys []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printReplayHelp()
+			return exitOK
+		case "-m", "--model", "-o", "--output", "-k", "--access-token", "--profile", "--base-url":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%smissing value for %s%s\n", red, args[i], normal)
+				return exitUsage
+			}
+			val := args[i+1]
+			switch args[i] {
+			case "-m", "--model":
+				model = val
+			case "-o", "--output":
+				output = val
+			case "-k", "--access-token":
+				flagKeys = append(flagKeys, val)
+			case "--profile":
+				profile = val
+			case "--base-url":
+				base["BASE_URL"] = val
+			}
+			i++
+		default:
+			if strings.HasPrefix(args[i], "-") || convFile != "" {
+				fmt.Fprintf(os.Stderr, "Unknown option: %s\n", args[i])
+				printReplayHelp()
+				return exitUsage
+			}
+			convFile = args[i]
+		}
+	}
+	if convFile == "" || model == "" {
+		printReplayHelp()
+		return exitUsage
+	}
+	if output == "" {
+		output = replayOutputPath(convFile, model)
+	}
+	if filepath.Clean(output) == filepath.Clean(convFile) {
+		fmt.Fprintf(os.Stderr, "%sThe output file must differ from the conversation being replayed.%s\n", red, normal)
+		return exitUsage
+	}
+
+	old, err := readConversation(convFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to read conversation file: %v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	var prompts []string
+	for _, m := range old.Messages {
+		if m.Role == "user" {
+			prompts = append(prompts, m.Content)
+		}
+	}
+	if len(prompts) == 0 {
+		fmt.Fprintf(os.Stderr, "%sThe conversation has no user messages to replay.%s\n", red, normal)
+		return exitUsage
+	}
+
+	keys, _ := collectAPIKeys(flagKeys, profile)
+	if len(keys) == 0 {
+		fmt.Fprintf(os.Stderr, "%sNo API key found.%s Run `nvidia-chat auth login` or set NVIDIA_BUILD_AI_ACCESS_TOKEN.\n", red, normal)
+		return exitAuth
+	}
+	apiKeys = newKeyPool(keys)
+
+	cfg := benchConfig(base, model)
+	if fileExists(output) {
+		// start over: the transcript must mirror the original conversation
+		if err := os.Remove(output); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to replace %s: %v%s\n", red, output, err, normal)
+			return exitGeneral
+		}
+	}
+	if err := ensureHistoryFileStructure(output, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to setup conversation file: %v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	cf, err := readConversation(output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to read conversation file: %v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	cf.System = old.System
+
+	for n, prompt := range prompts {
+		fmt.Fprintf(os.Stderr, "\n%sMessage %d of %d:%s %s\n", bold, n+1, len(prompts), normal, firstLine(prompt))
+		cf.Append("user", prompt)
+		text, usage, err := streamCompletion(cfg, buildMessages(cfg, "", cf), os.Stdout)
+		cf.AddUsage(usage)
+		if text != "" {
+			msg := nvidiachat.Reply(model, text, usage)
+			msg.Incomplete = err != nil
+			cf.Messages = append(cf.Messages, msg)
+		}
+		if err2 := writeConversation(output, cf); err2 != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to save the conversation: %v%s\n", red, err2, normal)
+			return exitGeneral
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s%s%s\n", red, describeError(err), normal)
+			fmt.Fprintf(os.Stderr, "Replies so far are saved in %s\n", output)
+			return exitCodeFor(err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\n%sReplayed %d message(s) against %s: %s%s\n", green, len(prompts), model, output, normal)
+	return exitOK
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
+)
+
+// persona is a participant of a round-table discussion.
+type persona struct {
+	name, model, system string
+}
+
+// parsePersona parses NAME[:MODEL[:SYSTEM]], where SYSTEM is a system prompt or @file.
+func parsePersona(spec string) (persona, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	p := persona{name: strings.TrimSpace(parts[0]), model: defaultModel}
+	if p.name == "" {
+		return p, fmt.Errorf("invalid persona %q, expected NAME[:MODEL[:SYSTEM]]", spec)
+	}
+	if len(parts) > 1 && strings.TrimSpace(parts[1]) != "" {
+		p.model = strings.TrimSpace(parts[1])
+	}
+	if len(parts) > 2 {
+		p.system = parts[2]
+		if strings.HasPrefix(p.system, "@") {
+			b, err := ioutil.ReadFile(p.system[1:])
+			if err != nil {
+				return p, fmt.Errorf("persona %s: %w", p.name, err)
+			}
+			p.system = string(b)
+		}
+	}
+	return p, nil
+}
+
+// personaMessages returns the messages sent to p: its system prompt and the discussion so far, in
+// which p's own replies are assistant messages and the other participants' replies are user
+// messages prefixed with their names.
+func personaMessages(p persona, all []persona, history []Message) []Message {
+	var others []string
+	for _, o := range all {
+		if o.name != p.name {
+			others = append(others, o.name)
+		}
+	}
+	system := fmt.Sprintf("You are %s, taking part in a round-table discussion with %s. "+
+		"Messages of the other participants start with their name in brackets; the moderator's messages have no name. "+
+		"Reply only as %s and do not start your reply with your name.", p.name, strings.Join(others, ", "), p.name)
+	if strings.TrimSpace(p.system) != "" {
+		system = strings.TrimSpace(p.system) + "\n\n" + system
+	}
+
+	messages := []Message{{Role: "system", Content: system}}
+	for _, m := range history {
+		content := filterThinkingBlock(m.Content)
+		switch {
+		case m.Role == "user":
+			messages = append(messages, Message{Role: "user", Content: content})
+		case m.Persona == p.name:
+			messages = append(messages, Message{Role: "assistant", Content: content})
+		case m.Role == "assistant":
+			name := m.Persona
+			if name == "" {
+				name = "Assistant"
+			}
+			messages = append(messages, Message{Role: "user", Content: "[" + name + "]: " + content})
+		}
+	}
+	return messages
+}
+
+func printRoundTableHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat roundtable --persona NAME[:MODEL[:SYSTEM]] --persona ... [options] [CONVERSATION_FILE]\n\n", bold, normal))
+	builder.WriteString("Let two or more personas discuss a topic, taking turns for a number of rounds. After each round\n")
+	builder.WriteString("you can add a message of your own before the discussion continues. Every reply is saved to the\n")
+	builder.WriteString("conversation file with the name of the persona that wrote it; an existing file is continued.\n\n")
+	builder.WriteString("Options:\n")
+	builder.WriteString("  --persona SPEC        A participant (repeatable, at least two). MODEL defaults to " + defaultModel + ";\n")
+	builder.WriteString("                        SYSTEM is the persona's system prompt, or @file to read it from a file.\n")
+	builder.WriteString("  --topic TEXT          Opening message (default: asked for on the terminal).\n")
+	builder.WriteString("  --rounds N            Number of rounds; each persona speaks once per round (default: 3).\n")
+	builder.WriteString("  -k, --access-token KEY\n                        API key (repeatable). Defaults to the OS keyring, then the environment.\n")
+	builder.WriteString("  --profile NAME        Use the API keys stored in the OS keyring under NAME.\n")
+	builder.WriteString("  --base-url URL        API base URL (default: the model's endpoint, else " + defaultBaseURL + ").\n")
+	builder.WriteString("\nExample:\n")
+	builder.WriteString("  nvidia-chat roundtable --rounds 2 --topic \"Should we rewrite it in Rust?\" \\\n")
+	builder.WriteString("    --persona \"Optimist::You love new technology.\" \\\n")
+	builder.WriteString("    --persona \"Skeptic:meta/llama-3.1-8b-instruct:@skeptic.txt\"\n")
+	fmt.Print(builder.String())
+}
+
+// runRoundTableCommand implements the `roundtable` subcommand and returns the process exit code.
+func runRoundTableCommand(args []string) int {
+	base := map[string]string{
+		"BASE_URL":        "",
+		"TIMEOUT":         defaultTimeout,
+		"CONNECT_TIMEOUT": defaultConnectTimeout,
+		"IDLE_TIMEOUT":    defaultIdleTimeout,
+		"MAX_RETRIES":     defaultMaxRetries,
+		"HISTORY_LIMIT":   strconv.Itoa(defaultHistoryLimit),
+	}
+	var personas []persona
+	topic, profile, convFile := "", defaultProfile, ""
+	rounds := 3
+	var flagKeys []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printRoundTableHelp()
+			return exitOK
+		case "--persona", "--topic", "--rounds", "-k", "--access-token", "--profile", "--base-url":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%smissing value for %s%s\n", red, args[i], normal)
+				return exitUsage
+			}
+			val := args[i+1]
+			switch args[i] {
+			case "--persona":
+				p, err := parsePersona(val)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+					return exitUsage
+				}
+				for _, o := range personas {
+					if o.name == p.name {
+						fmt.Fprintf(os.Stderr, "%sDuplicate persona name: %s%s\n", red, p.name, normal)
+						return exitUsage
+					}
+				}
+				personas = append(personas, p)
+			case "--topic":
+				topic = val
+			case "--rounds":
+				n, err := strconv.Atoi(val)
+				if err != nil || n < 1 {
+					fmt.Fprintf(os.Stderr, "%sInvalid --rounds (>= 1): %s%s\n", red, val, normal)
+					return exitUsage
+				}
+				rounds = n
+			case "-k", "--access-token":
+				flagKeys = append(flagKeys, val)
+			case "--profile":
+				profile = val
+			case "--base-url":
+				base["BASE_URL"] = val
+			}
+			i++
+		default:
+			if strings.HasPrefix(args[i], "-") || convFile != "" {
+				fmt.Fprintf(os.Stderr, "Unknown option: %s\n", args[i])
+				printRoundTableHelp()
+				return exitUsage
+			}
+			convFile = args[i]
+		}
+	}
+	if len(personas) < 2 {
+		fmt.Fprintf(os.Stderr, "%sA round table needs at least two --persona options.%s\n", red, normal)
+		return exitUsage
+	}
+
+	keys, _ := collectAPIKeys(flagKeys, profile)
+	if len(keys) == 0 {
+		fmt.Fprintf(os.Stderr, "%sNo API key found.%s Run `nvidia-chat auth login` or set NVIDIA_BUILD_AI_ACCESS_TOKEN.\n", red, normal)
+		return exitAuth
+	}
+	apiKeys = newKeyPool(keys)
+
+	if convFile == "" {
+		convFile = filepath.Join(conversationDir(), "roundtable-"+time.Now().Format("20060102-150405")+".json")
+	}
+	fileCfg := benchConfig(base, personas[0].model)
+	if err := ensureHistoryFileStructure(convFile, fileCfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to setup conversation file: %v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	fmt.Fprintf(os.Stderr, "%sConversation file:%s %s\n", green, normal, convFile)
+
+	stdin := bufio.NewReader(os.Stdin)
+	interject := true
+	// readLine asks for one line on the terminal; at the end of input no more questions are asked.
+	readLine := func(prompt string) string {
+		if !interject {
+			return ""
+		}
+		fmt.Fprintf(os.Stderr, "%s%s%s", bold, prompt, normal)
+		line, err := stdin.ReadString('\n')
+		if err == io.EOF {
+			interject = false
+			fmt.Fprintln(os.Stderr)
+		}
+		return strings.TrimSpace(line)
+	}
+	if topic == "" {
+		topic = readLine("Topic: ")
+	}
+
+	cf, err := readConversation(convFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to read conversation file: %v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	if topic != "" {
+		cf.Append("user", topic)
+	}
+	if len(cf.Messages) == 0 {
+		fmt.Fprintf(os.Stderr, "%sNo topic given.%s\n", red, normal)
+		return exitUsage
+	}
+
+	for round := 1; round <= rounds; round++ {
+		fmt.Fprintf(os.Stderr, "\n%s--- Round %d of %d ---%s\n", bold, round, rounds, normal)
+		for _, p := range personas {
+			fmt.Printf("\n%s%s%s (%s):\n", blue, p.name, normal, p.model)
+			cfg := benchConfig(base, p.model)
+			text, usage, err := streamCompletion(cfg, personaMessages(p, personas, cf.Messages), os.Stdout)
+			cf.AddUsage(usage)
+			if strings.TrimSpace(filterThinkingBlock(text)) != "" {
+				msg := nvidiachat.Reply(p.model, text, usage)
+				msg.Persona, msg.Incomplete = p.name, err != nil
+				cf.Messages = append(cf.Messages, msg)
+			}
+			if err2 := writeConversation(convFile, cf); err2 != nil {
+				fmt.Fprintf(os.Stderr, "%sFailed to save the conversation: %v%s\n", red, err2, normal)
+				return exitGeneral
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s%s: %s%s\n", red, p.name, describeError(err), normal)
+				return exitCodeFor(err)
+			}
+		}
+		if round == rounds {
+			break
+		}
+		switch line := readLine("\nYour message (Enter to continue, /stop to end): "); line {
+		case "":
+		case "/stop", "/exit", "/quit":
+			fmt.Fprintf(os.Stderr, "%sSaved to %s%s\n", green, convFile, normal)
+			return exitOK
+		default:
+			cf.Append("user", line)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\n%sSaved to %s%s\n", green, convFile, normal)
+	return exitOK
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
+)
+
+func printServeHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat serve [options]\n\n", bold, normal))
+	builder.WriteString("Run a local OpenAI-compatible server. Requests to /v1/chat/completions are forwarded to the\n")
+	builder.WriteString("configured backend with the stored API keys, and every exchange is saved to a conversation file.\n\n")
+	builder.WriteString("Options:\n")
+	builder.WriteString("  --host HOST           Address to listen on (default: 127.0.0.1).\n")
+	builder.WriteString("  --port PORT           Port to listen on (default: 8080).\n")
+	builder.WriteString("  --dir DIR             Directory for conversation files (default: the chat history directory).\n")
+	builder.WriteString("  -k, --access-token KEY\n                        API key (repeatable). Defaults to the OS keyring, then the environment.\n")
+	builder.WriteString("  --profile NAME        Use the API keys stored in the OS keyring under NAME.\n")
+	builder.WriteString("  --base-url URL        Backend base URL (default: the model's endpoint, else " + defaultBaseURL + ").\n")
+	builder.WriteString("  --max-retries N       Retry failed backend requests up to N times.\n")
+	builder.WriteString("\nPrometheus metrics (requests, latency, token usage per model) are exposed at /metrics.\n")
+	builder.WriteString("Clients may send an X-Conversation-Id header to choose the conversation file; otherwise\n")
+	builder.WriteString("requests sharing the same first messages are saved to the same file.\n")
+	fmt.Print(builder.String())
+}
+
+// server forwards chat completions to the backend and records them in conversation files.
+type server struct {
+	cfg     map[string]string
+	dir     string
+	mu      sync.Mutex // serializes conversation file writes
+	metrics *serveMetrics
+}
+
+// runServeCommand implements the `serve` subcommand and returns the process exit code.
+func runServeCommand(args []string) int {
+	cfg := map[string]string{
+		"MODEL":           defaultModel,
+		"BASE_URL":        "",
+		"STREAM":          defaultStream,
+		"HISTORY_LIMIT":   strconv.Itoa(defaultHistoryLimit),
+		"TIMEOUT":         defaultTimeout,
+		"CONNECT_TIMEOUT": defaultConnectTimeout,
+		"IDLE_TIMEOUT":    defaultIdleTimeout,
+		"MAX_RETRIES":     defaultMaxRetries,
+	}
+	host, port, dir, profile := "127.0.0.1", "8080", conversationDir(), defaultProfile
+	var flagKeys []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printServeHelp()
+			return exitOK
+		case "--host", "--port", "--dir", "-k", "--access-token", "--profile", "--base-url", "--max-retries":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%smissing value for %s%s\n", red, args[i], normal)
+				return exitUsage
+			}
+			val := args[i+1]
+			switch args[i] {
+			case "--host":
+				host = val
+			case "--port":
+				port = val
+			case "--dir":
+				dir = val
+			case "-k", "--access-token":
+				flagKeys = append(flagKeys, val)
+			case "--profile":
+				profile = val
+			case "--base-url":
+				cfg["BASE_URL"] = val
+			case "--max-retries":
+				cfg["MAX_RETRIES"] = val
+			}
+			i++
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown option: %s\n", args[i])
+			printServeHelp()
+			return exitUsage
+		}
+	}
+	if err := validateHTTPSettings(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitUsage
+	}
+
+	keys, source := collectAPIKeys(flagKeys, profile)
+	if len(keys) == 0 {
+		fmt.Fprintf(os.Stderr, "%sNo API key found.%s Run `nvidia-chat auth login` or set NVIDIA_BUILD_AI_ACCESS_TOKEN.\n", red, normal)
+		return exitAuth
+	}
+	apiKeys = newKeyPool(keys)
+
+	s := &server{cfg: cfg, dir: dir, metrics: newServeMetrics()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat/completions", s.handleChatCompletions)
+	mux.HandleFunc("/v1/models", s.handleModels)
+	mux.Handle("/metrics", s.metrics)
+
+	addr := net.JoinHostPort(host, port)
+	fmt.Fprintf(os.Stderr, "%sServing on http://%s/v1%s (%d API key(s) from %s, conversations in %s)\n", green, addr, normal, len(keys), source, dir)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	return exitOK
+}
+
+// handleModels forwards the model list request to the backend.
+func (s *server) handleModels(w http.ResponseWriter, r *http.Request) {
+	req, err := http.NewRequest("GET", resolveBaseURL(s.cfg)+"/models", nil)
+	if err != nil {
+		writeServeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKeys.Current())
+	resp, err := sendChatRequest(s.cfg, req)
+	if err != nil {
+		writeServeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer resp.Body.Close()
+	copyResponse(w, resp)
+}
+
+// handleChatCompletions forwards a chat completion request and saves the exchange.
+func (s *server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeServeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeServeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		writeServeError(w, http.StatusBadRequest, fmt.Errorf("invalid JSON: %w", err))
+		return
+	}
+
+	// per-request copy: the model selects the endpoint override
+	cfg := make(map[string]string, len(s.cfg))
+	for k, v := range s.cfg {
+		cfg[k] = v
+	}
+	if model, ok := body["model"].(string); ok && model != "" {
+		cfg["MODEL"] = model
+	} else {
+		body["model"] = cfg["MODEL"]
+		payload, _ = json.Marshal(body)
+	}
+	system, messages := serveMessages(body["messages"])
+	convFile := filepath.Join(s.dir, conversationFileName(r.Header.Get("X-Conversation-Id"), system, messages))
+
+	req, err := newChatRequest(cfg, payload, apiKeys.Current())
+	if err != nil {
+		writeServeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	start := time.Now()
+	resp, err := sendChatRequest(cfg, req)
+	if err != nil {
+		s.metrics.observe(cfg["MODEL"], 0, time.Since(start), tokenUsage{})
+		writeServeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer resp.Body.Close()
+	fmt.Fprintf(os.Stderr, "%s %s -> %s\n", cfg["MODEL"], resp.Status, convFile)
+	if resp.StatusCode >= 400 {
+		copyResponse(w, resp)
+		s.metrics.observe(cfg["MODEL"], resp.StatusCode, time.Since(start), tokenUsage{})
+		return
+	}
+
+	var reply string
+	var usage tokenUsage
+	if stream, _ := body["stream"].(bool); stream {
+		reply, usage = relayStream(w, resp)
+	} else {
+		var buf bytes.Buffer
+		copyResponse(w, &http.Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: ioutil.NopCloser(io.TeeReader(resp.Body, &buf))})
+		var out struct {
+			Choices []struct {
+				Message Message `json:"message"`
+			} `json:"choices"`
+			Usage tokenUsage `json:"usage"`
+		}
+		if json.Unmarshal(buf.Bytes(), &out) == nil {
+			usage = out.Usage
+			if len(out.Choices) > 0 {
+				reply = out.Choices[0].Message.Content
+			}
+		}
+	}
+	s.metrics.observe(cfg["MODEL"], resp.StatusCode, time.Since(start), usage)
+
+	if err := s.saveExchange(convFile, cfg, system, append(messages, Message{Role: "assistant", Content: reply})); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to save conversation %s: %v%s\n", red, convFile, err, normal)
+	}
+}
+
+// relayStream copies an SSE response to the client as it arrives and returns the assembled reply
+// and the token usage, if the backend reported it.
+func relayStream(w http.ResponseWriter, resp *http.Response) (string, tokenUsage) {
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(resp.StatusCode)
+	flusher, _ := w.(http.Flusher)
+
+	var reply strings.Builder
+	var usage tokenUsage
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			w.Write([]byte(line))
+			trimmed := strings.TrimSpace(line)
+			if flusher != nil && trimmed == "" {
+				flusher.Flush()
+			}
+			if strings.HasPrefix(trimmed, "data:") {
+				var chunk nvidiachat.StreamChunk
+				if json.Unmarshal([]byte(strings.TrimSpace(trimmed[len("data:"):])), &chunk) == nil {
+					for _, c := range chunk.Choices {
+						if c.Delta != nil && c.Delta.Content != nil {
+							reply.WriteString(*c.Delta.Content)
+						}
+					}
+					if chunk.Usage != nil {
+						usage = *chunk.Usage
+					}
+				}
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return reply.String(), usage
+}
+
+// saveExchange overwrites convFile with the messages of the latest exchange. Clients resend the
+// whole history with each request, so the newest request always holds the full conversation.
+func (s *server) saveExchange(convFile string, cfg map[string]string, system string, messages []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := ensureHistoryFileStructure(convFile, cfg); err != nil {
+		return err
+	}
+	cf, err := readConversation(convFile)
+	if err != nil {
+		return err
+	}
+	cf.System = system
+	cf.Messages = messages
+	cf.Settings.Model = cfg["MODEL"]
+	return writeConversation(convFile, cf)
+}
+
+// serveMessages converts OpenAI request messages into the system prompt and conversation messages.
+// Content given as an array of parts is reduced to its text parts.
+func serveMessages(raw interface{}) (string, []Message) {
+	items, _ := raw.([]interface{})
+	var systemParts []string
+	messages := []Message{}
+	for _, item := range items {
+		m, _ := item.(map[string]interface{})
+		role, _ := m["role"].(string)
+		var content string
+		switch c := m["content"].(type) {
+		case string:
+			content = c
+		case []interface{}:
+			var parts []string
+			for _, p := range c {
+				if part, ok := p.(map[string]interface{}); ok {
+					if text, ok := part["text"].(string); ok {
+						parts = append(parts, text)
+					}
+				}
+			}
+			content = strings.Join(parts, "\n")
+		}
+		if role == "system" {
+			systemParts = append(systemParts, content)
+			continue
+		}
+		messages = append(messages, Message{Role: role, Content: content})
+	}
+	return strings.Join(systemParts, "\n\n"), messages
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// conversationFileName picks the conversation file for a request: the client's conversation id if
+// given, otherwise a hash of the system prompt and first user message, which stay the same as the
+// conversation grows.
+func conversationFileName(id, system string, messages []Message) string {
+	if id = unsafeFileChars.ReplaceAllString(id, "_"); strings.Trim(id, "._") != "" {
+		return "serve-" + id + ".json"
+	}
+	h := sha256.New()
+	io.WriteString(h, system)
+	for _, m := range messages {
+		if m.Role == "user" {
+			io.WriteString(h, "\x00"+m.Content)
+			break
+		}
+	}
+	return "serve-" + hex.EncodeToString(h.Sum(nil))[:12] + ".json"
+}
+
+// copyResponse relays a backend response (status, content type and body) to the client.
+func copyResponse(w http.ResponseWriter, resp *http.Response) {
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// writeServeError sends an error in the OpenAI error format.
+func writeServeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"message": err.Error(), "type": "proxy_error"},
+	})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// sessionRecorder writes an interactive session to an asciinema v2 file: a JSON header line,
+// then one [seconds, "o"|"i", text] line per output chunk or input line.
+type sessionRecorder struct {
+	mu     sync.Mutex
+	f      *os.File
+	start  time.Time
+	stdout *os.File // the terminal, restored when the recording stops
+	stderr *os.File
+	pipes  []*os.File // write ends replacing os.Stdout and os.Stderr
+	copies sync.WaitGroup
+}
+
+// sessionRecording is the running --record-session recording, if any.
+var sessionRecording *sessionRecorder
+
+// terminalSize returns the size of the terminal, or 80x24 when it cannot be found.
+func terminalSize() (width, height int) {
+	width, height = mustAtoi(os.Getenv("COLUMNS"), 80), mustAtoi(os.Getenv("LINES"), 24)
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = os.Stdin
+	if out, err := cmd.Output(); err == nil {
+		if f := strings.Fields(string(out)); len(f) == 2 && mustAtoi(f[0], 0) > 0 && mustAtoi(f[1], 0) > 0 {
+			height, width = mustAtoi(f[0], 0), mustAtoi(f[1], 0)
+		}
+	}
+	return width, height
+}
+
+// startSessionRecording starts recording everything written to the terminal, and the lines typed,
+// to path. Output goes through a pipe that copies it to the terminal and to the recording.
+func startSessionRecording(path, title string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	width, height := terminalSize()
+	header := map[string]interface{}{
+		"version":   2,
+		"width":     width,
+		"height":    height,
+		"timestamp": time.Now().Unix(),
+		"title":     title,
+		"env":       map[string]string{"SHELL": os.Getenv("SHELL"), "TERM": os.Getenv("TERM")},
+	}
+	b, _ := json.Marshal(header)
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	r := &sessionRecorder{f: f, start: time.Now(), stdout: os.Stdout, stderr: os.Stderr}
+	// A terminal shared by stdout and stderr gets a single pipe, so that their output keeps its order.
+	outInfo, err1 := os.Stdout.Stat()
+	errInfo, err2 := os.Stderr.Stat()
+	if err1 == nil && err2 == nil && os.SameFile(outInfo, errInfo) {
+		w, err := r.tee(os.Stdout)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		os.Stdout, os.Stderr = w, w
+	} else {
+		wOut, err := r.tee(os.Stdout)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		wErr, err := r.tee(os.Stderr)
+		if err != nil {
+			wOut.Close()
+			f.Close()
+			return err
+		}
+		os.Stdout, os.Stderr = wOut, wErr
+	}
+	sessionRecording = r
+	return nil
+}
+
+// tee returns the write end of a pipe whose data is copied to dst and recorded as output.
+func (r *sessionRecorder) tee(dst *os.File) (*os.File, error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	r.pipes = append(r.pipes, pw)
+	r.copies.Add(1)
+	go func() {
+		defer r.copies.Done()
+		defer pr.Close()
+		buf := make([]byte, 32*1024)
+		var pending []byte // the start of a character split across reads
+		for {
+			n, err := pr.Read(buf)
+			if n > 0 {
+				dst.Write(buf[:n])
+				data := append(pending, buf[:n]...)
+				cut := len(data)
+				for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+					if utf8.RuneStart(data[i]) {
+						if !utf8.FullRune(data[i:]) {
+							cut = i
+						}
+						break
+					}
+				}
+				r.event("o", string(data[:cut]))
+				pending = append([]byte(nil), data[cut:]...)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return pw, nil
+}
+
+// event appends an event to the recording. Line feeds become CRLF, as a terminal shows them.
+func (r *sessionRecorder) event(kind, text string) {
+	if text == "" {
+		return
+	}
+	if kind == "o" {
+		text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, _ := json.Marshal([]interface{}{float64(time.Since(r.start).Microseconds()) / 1e6, kind, text})
+	r.f.Write(append(b, '\n'))
+}
+
+// recordSessionInput records a line typed by the user. The terminal echoes what is typed, so the
+// line is recorded as output too, for the replay to show it.
+func recordSessionInput(line string) {
+	if r := sessionRecording; r != nil {
+		r.event("i", line)
+		r.event("o", line)
+	}
+}
+
+// stopSessionRecording restores the terminal, waits for the output still in the pipes and closes
+// the recording. It must be called before the program exits.
+func stopSessionRecording() {
+	r := sessionRecording
+	if r == nil {
+		return
+	}
+	sessionRecording = nil
+	os.Stdout, os.Stderr = r.stdout, r.stderr
+	for _, pw := range r.pipes {
+		pw.Close()
+	}
+	r.copies.Wait()
+	if err := r.f.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to save the session recording: %v%s\n", red, err, normal)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Session recorded to %s\n", r.f.Name())
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// settingSource is a layer settings come from, in increasing order of precedence.
+type settingSource int
+
+const (
+	sourceDefault settingSource = iota
+	sourceConfigFile
+	sourceConversation
+	sourceEnv
+	sourceFlag
+	sourceInteractive
+)
+
+var settingSourceNames = [...]string{"default", "config file", "conversation file", "environment", "command line", "interactive"}
+
+func (s settingSource) String() string {
+	return settingSourceNames[s]
+}
+
+// envSettingPrefix starts the environment variables holding settings, e.g.
+// NVIDIA_CHAT_SET_TEMPERATURE. It differs from the NVIDIA_CHAT_ prefix of the variables passed to
+// hooks, so that nvidia-chat run from a hook does not take the caller's model as a setting.
+const envSettingPrefix = "NVIDIA_CHAT_SET_"
+
+// settingOrigin records where the value of a setting came from; detail names the file, variable
+// or command that set it.
+type settingOrigin struct {
+	source settingSource
+	detail string
+}
+
+// settingOrigins maps the cfg keys to the origin of their current value.
+var settingOrigins = map[string]settingOrigin{}
+
+// applySetting sets cfg[key] to a value from source, unless a source of higher precedence has set
+// it already. It reports whether the value was applied.
+func applySetting(cfg map[string]string, key, value string, source settingSource, detail string) bool {
+	if o, ok := settingOrigins[key]; ok && o.source > source {
+		return false
+	}
+	cfg[key] = value
+	settingOrigins[key] = settingOrigin{source, detail}
+	return true
+}
+
+// recordSettingSource marks keys as set by source, for values already written to cfg.
+func recordSettingSource(keys map[string]bool, source settingSource) {
+	for key := range keys {
+		settingOrigins[key] = settingOrigin{source: source}
+	}
+}
+
+// isSettingKey reports whether key names a session setting: a cfg default or a parameter of a
+// known model.
+func isSettingKey(cfg map[string]string, key string) bool {
+	if _, ok := cfg[key]; ok {
+		return true
+	}
+	name := strings.ToLower(key)
+	for _, def := range ModelDefinitions {
+		if _, ok := def.Parameters[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSettings layers the config file's [settings] section and the NVIDIA_CHAT_SET_* environment
+// variables over the defaults in cfg. Settings from the environment count as given explicitly:
+// they are marked in provided and, like flags, must be valid for the model.
+func resolveSettings(cfg map[string]string, provided map[string]bool) error {
+	for key := range cfg {
+		if _, ok := settingOrigins[key]; !ok {
+			settingOrigins[key] = settingOrigin{source: sourceDefault}
+		}
+	}
+
+	if v := userConfig["cache.enabled"]; v != "" {
+		applySetting(cfg, "CACHE", strconv.FormatBool(v == "true"), sourceConfigFile, "[cache] enabled")
+	}
+	if v := userConfig["cache.ttl"]; v != "" {
+		applySetting(cfg, "CACHE_TTL", v, sourceConfigFile, "[cache] ttl")
+	}
+	names := make([]string, 0, len(userConfig))
+	for k := range userConfig {
+		if strings.HasPrefix(k, "settings.") && k != "settings.autosave" {
+			names = append(names, strings.TrimPrefix(k, "settings."))
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := strings.ToUpper(name)
+		if !isSettingKey(cfg, key) {
+			return fmt.Errorf("Unknown setting %q in the [settings] section of %s", name, userConfigPath())
+		}
+		applySetting(cfg, key, userConfig["settings."+name], sourceConfigFile, "[settings] "+name)
+	}
+
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], envSettingPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(parts[0], envSettingPrefix)
+		if !isSettingKey(cfg, key) {
+			continue
+		}
+		if applySetting(cfg, key, parts[1], sourceEnv, parts[0]) {
+			provided[key] = true
+		}
+	}
+	return nil
+}
+
+// settingsShown lists the settings /settings displays for the model: the model, its parameters,
+// then the session settings.
+func settingsShown(cfg map[string]string) []string {
+	keys := []string{"MODEL"}
+	var params []string
+	for name := range GetModelDefinition(cfg["MODEL"]).Parameters {
+		params = append(params, strings.ToUpper(name))
+	}
+	sort.Strings(params)
+	keys = append(keys, params...)
+	return append(keys, "STREAM", "HISTORY_LIMIT", "BASE_URL", "TIMEOUT", "CONNECT_TIMEOUT", "IDLE_TIMEOUT", "MAX_RETRIES", "CACHE", "CACHE_TTL", "EXTRA_BODY", "TOOL_CHOICE", "PARALLEL_TOOL_CALLS")
+}
+
+// parseExtraBody parses the --extra-body setting, a JSON object merged into the request payloads.
+func parseExtraBody(s string) (map[string]interface{}, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var extra map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(&extra); err != nil || extra == nil || dec.More() {
+		return nil, fmt.Errorf("Invalid extra body, expected a JSON object: %s", s)
+	}
+	return extra, nil
+}
+
+// printSettings implements /settings [sources]: it lists the session settings and, with sources,
+// where each value came from.
+func printSettings(args []string, cfg map[string]string) {
+	withSources := false
+	if len(args) > 0 {
+		if args[0] != "sources" {
+			fmt.Fprintln(os.Stderr, "Usage: /settings [sources]")
+			return
+		}
+		withSources = true
+	}
+	fmt.Fprintf(os.Stderr, "%sSettings:%s\n", bold, normal)
+	for _, key := range settingsShown(cfg) {
+		value := cfg[key]
+		if value == "" {
+			value = "(unset)"
+		}
+		line := fmt.Sprintf("  %-18s %s", strings.ToLower(key), value)
+		if withSources {
+			o := settingOrigins[key]
+			origin := o.source.String()
+			if o.detail != "" {
+				origin += " (" + o.detail + ")"
+			}
+			line = fmt.Sprintf("  %-18s %-24s %s%s%s", strings.ToLower(key), value, blue, origin, normal)
+		}
+		fmt.Fprintln(os.Stderr, line)
+	}
+	if withSources {
+		fmt.Fprintf(os.Stderr, "Precedence: %s\n", strings.Join(settingSourceNames[:], " < "))
+	}
+}
+
+// flattenSettings lists the conversation settings by dotted name, such as stream or
+// models.<model>.temperature, with their values as JSON.
+func flattenSettings(s TopLevelSettings) map[string]string {
+	flat := map[string]string{}
+	b, _ := json.Marshal(s)
+	var fields map[string]interface{}
+	json.Unmarshal(b, &fields)
+	var walk func(prefix string, v interface{}, depth int)
+	walk = func(prefix string, v interface{}, depth int) {
+		if m, ok := v.(map[string]interface{}); ok && depth < 2 {
+			for k, sub := range m {
+				walk(prefix+"."+k, sub, depth+1)
+			}
+			return
+		}
+		encoded, _ := json.Marshal(v)
+		flat[prefix] = string(encoded)
+	}
+	for k, v := range fields {
+		switch k {
+		case "models":
+			walk(k, v, 0)
+		case "default":
+			walk(k, v, 1)
+		default:
+			walk(k, v, 2)
+		}
+	}
+	return flat
+}
+
+// diffSettings implements /diff-settings: it shows what /persist-settings would change in the
+// conversation file, as removed (-) and added (+) values.
+func diffSettings(convFile string, cfg map[string]string) {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed reading conversation: %v%s\n", red, err, normal)
+		return
+	}
+	stored := flattenSettings(cf.Settings)
+	migrated := cf.Settings.Migrated
+	storeSessionSettings(cf, cfg)
+	session := flattenSettings(cf.Settings)
+
+	names := map[string]bool{}
+	for k := range stored {
+		names[k] = true
+	}
+	for k := range session {
+		names[k] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for k := range names {
+		if stored[k] != session[k] {
+			sorted = append(sorted, k)
+		}
+	}
+	sort.Strings(sorted)
+	if len(sorted) == 0 {
+		fmt.Fprintf(os.Stderr, "The settings in %s match the session: /persist-settings would change nothing.\n", convFile)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s/persist-settings would change in %s:%s\n", bold, convFile, normal)
+	for _, k := range sorted {
+		if v, ok := stored[k]; ok {
+			fmt.Fprintf(os.Stderr, "%s- %s = %s%s\n", red, k, v, normal)
+		}
+		if v, ok := session[k]; ok {
+			fmt.Fprintf(os.Stderr, "%s+ %s = %s%s\n", green, k, v, normal)
+		}
+	}
+	if migrated {
+		fmt.Fprintln(os.Stderr, "The file also keeps its settings in the format of older versions, which would be rewritten.")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
+)
+
+// setupNeeded reports whether the first-run wizard should start: no API key was found, there is no
+// config file yet, and someone is at the terminal to answer.
+func setupNeeded(keys []string) bool {
+	if len(keys) > 0 || fileExists(userConfigPath()) {
+		return false
+	}
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// runSetupWizard asks for an API key, checks it and stores it in the OS keyring, lets the user pick
+// a default model from the models the key can use and a directory for the conversations, then
+// writes the config file. It returns the key and the model.
+func runSetupWizard(cfg map[string]string, profile string) (string, string, error) {
+	fmt.Fprintf(os.Stderr, "%sWelcome to nvidia-chat!%s No API key or config file was found, so let's set things up.\n", bold, normal)
+	fmt.Fprintln(os.Stderr, "Get a key at https://build.nvidia.com/ (Ctrl+C leaves the setup).")
+	fmt.Fprintln(os.Stderr)
+
+	var key string
+	for key == "" {
+		k, err := readAPIKey()
+		if err != nil {
+			return "", "", err
+		}
+		fmt.Fprint(os.Stderr, "Checking the key... ")
+		err = checkAPIKey(cfg, k)
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "%svalid.%s\n", green, normal)
+			key = k
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s%s%s\n", red, describeError(err), normal)
+		if errors.Is(err, nvidiachat.ErrAuth) {
+			continue
+		}
+		fmt.Fprint(os.Stderr, "Keep this key anyway? [y/N] ")
+		answer, _ := readSingleLine(nil, []string{"\n"}, true)
+		if strings.EqualFold(strings.TrimSpace(answer), "y") {
+			key = k
+		}
+	}
+	if err := keyringSet(profile, key); err != nil {
+		fmt.Fprintf(os.Stderr, "%sCould not store the key in the OS keyring (%v).%s\n", red, err, normal)
+		fmt.Fprintln(os.Stderr, "It is used for this session only; set NVIDIA_BUILD_AI_ACCESS_TOKEN to keep it.")
+	} else {
+		fmt.Fprintf(os.Stderr, "%sAPI key stored in the OS keyring.%s\n", green, normal)
+	}
+	fmt.Fprintln(os.Stderr)
+
+	apiKeys = newKeyPool([]string{key})
+	models := modelsList
+	if catalog, err := fetchCatalog(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%sCould not list the models (%s); showing the built-in list.%s\n", red, describeError(err), normal)
+	} else {
+		var live []string
+		for _, m := range catalog {
+			if m.isChatModel() {
+				live = append(live, m.ID)
+			}
+		}
+		if len(live) > 0 {
+			sort.Strings(live)
+			models = live
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Choose the default model (Enter keeps %s).\n", cfg["MODEL"])
+	model, ok := pickModelFrom(models, cfg["MODEL"])
+	if !ok {
+		model = cfg["MODEL"]
+	}
+	fmt.Fprintln(os.Stderr)
+
+	dir := conversationDir()
+	fmt.Fprintf(os.Stderr, "Directory for the conversations [%s]: ", dir)
+	answer, _ := readSingleLine(nil, []string{"\n"}, true)
+	if answer = strings.TrimSpace(answer); answer != "" {
+		dir = answer
+	}
+	expanded := dir
+	if strings.HasPrefix(expanded, "~/") {
+		expanded = filepath.Join(os.Getenv("HOME"), expanded[2:])
+	}
+	if err := os.MkdirAll(expanded, 0o755); err != nil {
+		return "", "", fmt.Errorf("cannot create %s: %w", dir, err)
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("# Written by the nvidia-chat setup on %s.\n\n", time.Now().Format("2006-01-02")))
+	builder.WriteString("[settings]\n")
+	builder.WriteString("model = " + strconv.Quote(model) + "\n")
+	if expanded != conversationDir() {
+		builder.WriteString("\n[conversations]\n")
+		builder.WriteString("dir = " + strconv.Quote(dir) + "\n")
+	}
+	if err := os.MkdirAll(configDir(), 0o755); err != nil {
+		return "", "", err
+	}
+	if err := ioutil.WriteFile(userConfigPath(), []byte(builder.String()), 0o644); err != nil {
+		return "", "", err
+	}
+	conf, err := loadConfigFile(userConfigPath())
+	if err != nil {
+		return "", "", err
+	}
+	userConfig = conf
+	fmt.Fprintf(os.Stderr, "%sWrote %s.%s Edit it to change these defaults and for the other settings.\n\n", green, userConfigPath(), normal)
+	return key, model, nil
+}
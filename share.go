@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// /share uploads the conversation, rendered as markdown, following the [share] section of the
+// config file:
+//
+//	[share]
+//	service = "gist"           # or "paste"
+//	token = "ghp_..."          # GitHub token with the gist scope; $GITHUB_TOKEN by default
+//	public = false             # secret gists unless true
+//	url = "https://paste.rs/"  # paste: the text is POSTed there, the answer is the link
+
+// shareTimeout bounds an upload.
+const shareTimeout = 30 * time.Second
+
+// defaultGistAPI is the GitHub endpoint creating gists; url in [share] replaces it, e.g. for GitHub
+// Enterprise.
+const defaultGistAPI = "https://api.github.com/gists"
+
+// shareTranscript renders the conversation for /share: its title, then the messages (from..to when
+// spec is not empty), masked with the [redact] rules.
+func shareTranscript(convFile, spec string, filterThinking bool) (string, error) {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		return "", fmt.Errorf("reading conversation file: %w", err)
+	}
+	if len(cf.Messages) == 0 {
+		return "", errors.New("the conversation has no messages")
+	}
+	from, to := 1, len(cf.Messages)
+	if spec != "" {
+		if from, to, err = parseMessageRange(spec, len(cf.Messages)); err != nil {
+			return "", err
+		}
+	}
+	var builder strings.Builder
+	if cf.Title != "" {
+		builder.WriteString("# " + cf.Title + "\n\n")
+	}
+	builder.WriteString(formatTranscript(cf.Messages[from-1:to], from, filterThinking))
+	rules, err := loadRedactionRules()
+	if err != nil {
+		return "", err
+	}
+	text, _ := redactText(builder.String(), rules)
+	return text, nil
+}
+
+// shareDestination describes where /share uploads, for the confirmation.
+func shareDestination() (string, error) {
+	switch service := userConfig["share.service"]; service {
+	case "", "gist":
+		if shareToken() == "" {
+			return "", fmt.Errorf("no GitHub token: set token in the [share] section of %s or GITHUB_TOKEN", userConfigPath())
+		}
+		if userConfig["share.public"] == "true" {
+			return "a public GitHub gist", nil
+		}
+		return "a secret GitHub gist (anyone with the link can read it)", nil
+	case "paste":
+		if userConfig["share.url"] == "" {
+			return "", fmt.Errorf("no paste service: set url in the [share] section of %s", userConfigPath())
+		}
+		return userConfig["share.url"] + " (anyone with the link can read it)", nil
+	default:
+		return "", fmt.Errorf("unknown share service %q in %s (gist or paste)", service, userConfigPath())
+	}
+}
+
+func shareToken() string {
+	if token := userConfig["share.token"]; token != "" {
+		return token
+	}
+	return os.Getenv("GITHUB_TOKEN")
+}
+
+// uploadShare uploads text and returns its URL.
+func uploadShare(text, name, title string) (string, error) {
+	client := &http.Client{Timeout: shareTimeout}
+	if userConfig["share.service"] == "paste" {
+		req, err := http.NewRequest("POST", userConfig["share.url"], strings.NewReader(text))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		if token := userConfig["share.token"]; token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		body, err := doShareRequest(client, req)
+		if err != nil {
+			return "", err
+		}
+		url := firstLine(strings.TrimSpace(string(body)))
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return "", fmt.Errorf("the paste service answered %q instead of a link", url)
+		}
+		return url, nil
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"description": title,
+		"public":      userConfig["share.public"] == "true",
+		"files":       map[string]interface{}{name: map[string]string{"content": text}},
+	})
+	if err != nil {
+		return "", err
+	}
+	api := defaultGistAPI
+	if v := userConfig["share.url"]; v != "" {
+		api = v
+	}
+	req, err := http.NewRequest("POST", api, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+shareToken())
+	req.Header.Set("Content-Type", "application/json")
+	body, err := doShareRequest(client, req)
+	if err != nil {
+		return "", err
+	}
+	var gist struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.Unmarshal(body, &gist); err != nil || gist.HTMLURL == "" {
+		return "", fmt.Errorf("unexpected answer from GitHub: %s", firstLine(string(body)))
+	}
+	return gist.HTMLURL, nil
+}
+
+func doShareRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("upload failed: %s %s", resp.Status, firstLine(strings.TrimSpace(string(body))))
+	}
+	return body, nil
+}
+
+// shareConversation implements /share [-t] [from..to]: it shows the markdown that would be shared
+// and where, and uploads it after confirmation.
+func shareConversation(args []string, convFile string) {
+	filterThinking, spec := false, ""
+	for _, arg := range args {
+		switch {
+		case arg == "-t":
+			filterThinking = true
+		case spec == "" && strings.Contains(arg, ".."):
+			spec = arg
+		default:
+			fmt.Fprintln(os.Stderr, "Usage: /share [-t] [from..to]")
+			return
+		}
+	}
+	dest, err := shareDestination()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return
+	}
+	text, err := shareTranscript(convFile, spec, filterThinking)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return
+	}
+	lines := strings.Count(strings.TrimRight(text, "\n"), "\n") + 1
+	if _, height := terminalSize(); lines < height-4 || !showInPager(text) {
+		fmt.Fprintf(os.Stderr, "%s----- shared text -----%s\n%s\n%s----- end -----%s\n", bold, normal, strings.TrimRight(text, "\n"), bold, normal)
+	}
+	fmt.Fprintf(os.Stderr, "Upload these %d lines to %s? [y/N] ", lines, dest)
+	answer, _ := readSingleLine(nil, []string{"\n"}, true)
+	if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+		fmt.Fprintln(os.Stderr, "Nothing shared.")
+		return
+	}
+	title := "nvidia-chat conversation"
+	if cf, err := readConversation(convFile); err == nil && cf.Title != "" {
+		title = cf.Title
+	}
+	name := strings.TrimSuffix(filepath.Base(convFile), filepath.Ext(convFile)) + ".md"
+	url, err := uploadShare(text, name, title)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%sShared: %s%s\n", green, url, normal)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// usageStat is the usage of one model in one period.
+type usageStat struct {
+	requests         int
+	promptTokens     int
+	completionTokens int
+}
+
+func (s *usageStat) add(requests int, u tokenUsage) {
+	s.requests += requests
+	s.promptTokens += u.PromptTokens
+	s.completionTokens += u.CompletionTokens
+}
+
+func (s usageStat) total() int {
+	return s.promptTokens + s.completionTokens
+}
+
+// statsPeriod returns the period t falls in: a day (2006-01-02), an ISO week (2006-W01) or a
+// month (2006-01).
+func statsPeriod(t time.Time, by string) string {
+	switch by {
+	case "week":
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case "month":
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
+}
+
+// usageStats aggregates the usage of conversations by period and model.
+type usageStats struct {
+	by            string
+	since         time.Time
+	conversations int
+	cells         map[[2]string]*usageStat // by period and model
+}
+
+func (st *usageStats) add(t time.Time, model string, requests int, u tokenUsage) {
+	if t.Before(st.since) || requests == 0 && u == (tokenUsage{}) {
+		return
+	}
+	if model == "" {
+		model = "(unknown)"
+	}
+	key := [2]string{statsPeriod(t.Local(), st.by), model}
+	if st.cells[key] == nil {
+		st.cells[key] = &usageStat{}
+	}
+	st.cells[key].add(requests, u)
+}
+
+// addConversation adds the usage of a conversation. Replies record their model, time and tokens;
+// the rest of the conversation's total, such as the replies of older versions, of rejected A/B
+// answers or of summaries, is counted for the conversation's model at the file's last change.
+func (st *usageStats) addConversation(cf *ConversationFile, modified time.Time) {
+	st.conversations++
+	rest := conversationUsage(cf)
+	for _, m := range cf.Messages {
+		if m.Role != "assistant" {
+			continue
+		}
+		if m.Time == nil {
+			st.add(modified, cf.Settings.Model, 1, tokenUsage{})
+			continue
+		}
+		u := tokenUsage{}
+		if m.Usage != nil {
+			u = *m.Usage
+		}
+		st.add(*m.Time, m.Model, 1, u)
+		rest.PromptTokens -= u.PromptTokens
+		rest.CompletionTokens -= u.CompletionTokens
+	}
+	if rest.PromptTokens > 0 || rest.CompletionTokens > 0 {
+		st.add(modified, cf.Settings.Model, 0, tokenUsage{PromptTokens: max(rest.PromptTokens, 0), CompletionTokens: max(rest.CompletionTokens, 0)})
+	}
+}
+
+// collectStats reads the conversations found under dir. Files that are not conversations, such as
+// the caches kept next to them, and the backups of summarized conversations are skipped.
+func collectStats(dir, by string, since time.Time) (*usageStats, error) {
+	st := &usageStats{by: by, since: since, cells: map[[2]string]*usageStat{}}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() || !(strings.HasSuffix(name, ".json") || isYAMLPath(name)) || strings.Contains(name, ".before-summary.") || strings.Contains(name, ".bak.") {
+			return nil
+		}
+		if info.ModTime().Before(since) {
+			return nil
+		}
+		cf, err := readConversation(path)
+		if err != nil || cf.Messages == nil || cf.Settings.Models == nil {
+			return nil
+		}
+		st.addConversation(cf, info.ModTime())
+		return nil
+	})
+	return st, err
+}
+
+func printStatsHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat stats [--by day|week|month] [--since DATE] [--csv FILE] [--dir DIR]\n\n", bold, normal))
+	builder.WriteString("Add up the requests and tokens of all saved conversations, per model and per day, week or\n")
+	builder.WriteString("month. Replies saved by older versions have no time or model of their own: they are counted\n")
+	builder.WriteString("for the conversation's model, when its file last changed.\n\n")
+	builder.WriteString("Options:\n")
+	builder.WriteString("  --by PERIOD           Break the usage down by day (default), week or month.\n")
+	builder.WriteString("  --since DATE          Only count usage from DATE (YYYY-MM-DD) on.\n")
+	builder.WriteString("  --csv FILE            Write one row per period and model to FILE as CSV (- for stdout).\n")
+	builder.WriteString("  --dir DIR             Directory of the conversations (default: " + conversationDir() + ").\n")
+	fmt.Print(builder.String())
+}
+
+// runStatsCommand implements the `stats` subcommand and returns the process exit code.
+func runStatsCommand(args []string) int {
+	dir, by, csvFile := conversationDir(), "day", ""
+	var since time.Time
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printStatsHelp()
+			return exitOK
+		case "--by", "--since", "--csv", "--dir":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%smissing value for %s%s\n", red, args[i], normal)
+				return exitUsage
+			}
+			val := args[i+1]
+			switch args[i] {
+			case "--by":
+				if val != "day" && val != "week" && val != "month" {
+					fmt.Fprintf(os.Stderr, "%sInvalid period %q (day|week|month)%s\n", red, val, normal)
+					return exitUsage
+				}
+				by = val
+			case "--since":
+				t, err := time.ParseInLocation("2006-01-02", val, time.Local)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%sInvalid date %q, expected YYYY-MM-DD%s\n", red, val, normal)
+					return exitUsage
+				}
+				since = t
+			case "--csv":
+				csvFile = val
+			case "--dir":
+				dir = val
+			}
+			i++
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown option: %s\n", args[i])
+			printStatsHelp()
+			return exitUsage
+		}
+	}
+
+	st, err := collectStats(dir, by, since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to read the conversations: %v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	keys := make([][2]string, 0, len(st.cells))
+	for k := range st.cells {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	if csvFile != "" {
+		if err := writeStatsCSV(csvFile, st, keys); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to write %s: %v%s\n", red, csvFile, err, normal)
+			return exitGeneral
+		}
+		if csvFile == "-" {
+			return exitOK
+		}
+	}
+
+	var total usageStat
+	models, periods := map[string]*usageStat{}, map[string]*usageStat{}
+	var periodOrder []string
+	for _, k := range keys {
+		c := st.cells[k]
+		u := tokenUsage{PromptTokens: c.promptTokens, CompletionTokens: c.completionTokens}
+		total.add(c.requests, u)
+		if models[k[1]] == nil {
+			models[k[1]] = &usageStat{}
+		}
+		models[k[1]].add(c.requests, u)
+		if periods[k[0]] == nil {
+			periods[k[0]] = &usageStat{}
+			periodOrder = append(periodOrder, k[0])
+		}
+		periods[k[0]].add(c.requests, u)
+	}
+	fmt.Printf("%s%d conversation(s), %d request(s), %d tokens (%d prompt, %d completion)%s\n", bold, st.conversations, total.requests, total.total(), total.promptTokens, total.completionTokens, normal)
+	if len(keys) == 0 {
+		return exitOK
+	}
+
+	modelOrder := make([]string, 0, len(models))
+	for m := range models {
+		modelOrder = append(modelOrder, m)
+	}
+	sort.Slice(modelOrder, func(i, j int) bool {
+		if a, b := models[modelOrder[i]].total(), models[modelOrder[j]].total(); a != b {
+			return a > b
+		}
+		return modelOrder[i] < modelOrder[j]
+	})
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tREQUESTS\tPROMPT\tCOMPLETION\tTOTAL\t")
+	for _, m := range modelOrder {
+		s := models[m]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t\n", m, s.requests, s.promptTokens, s.completionTokens, s.total())
+	}
+	tw.Flush()
+
+	fmt.Println()
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tREQUESTS\tPROMPT\tCOMPLETION\tTOTAL\t\n", strings.ToUpper(by))
+	for _, p := range periodOrder {
+		s := periods[p]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t\n", p, s.requests, s.promptTokens, s.completionTokens, s.total())
+	}
+	tw.Flush()
+	if csvFile != "" {
+		fmt.Fprintf(os.Stderr, "%sWrote %s%s\n", green, csvFile, normal)
+	}
+	return exitOK
+}
+
+// writeStatsCSV writes one row per period and model, in the order of keys.
+func writeStatsCSV(path string, st *usageStats, keys [][2]string) error {
+	out := os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+	w := csv.NewWriter(out)
+	w.Write([]string{st.by, "model", "requests", "prompt_tokens", "completion_tokens", "total_tokens"})
+	for _, k := range keys {
+		c := st.cells[k]
+		w.Write([]string{k[0], k[1], strconv.Itoa(c.requests), strconv.Itoa(c.promptTokens), strconv.Itoa(c.completionTokens), strconv.Itoa(c.total())})
+	}
+	w.Flush()
+	err := w.Error()
+	if out != os.Stdout {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// printSessionStats implements /stats: the usage of the conversation and of this month, and the
+// quota the API reported with its last response.
+func printSessionStats(convFile string) {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed reading conversation: %v%s\n", red, err, normal)
+		return
+	}
+	replies := 0
+	for _, m := range cf.Messages {
+		if m.Role == "assistant" {
+			replies++
+		}
+	}
+	u := conversationUsage(cf)
+	fmt.Fprintf(os.Stderr, "Conversation: %d response(s), %d prompt + %d completion tokens\n", replies, u.PromptTokens, u.CompletionTokens)
+	if months, err := readMonthlyUsage(); err == nil {
+		u := months[currentMonth()]
+		fmt.Fprintf(os.Stderr, "This month:   %d prompt + %d completion tokens\n", u.PromptTokens, u.CompletionTokens)
+	}
+	if quota := rateLimitSummary(); quota != "" {
+		fmt.Fprintf(os.Stderr, "API quota:    %s\n", strings.TrimPrefix(quota, "quota "))
+	} else {
+		fmt.Fprintln(os.Stderr, "API quota:    not reported by the API")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// statusRedrawInterval limits how often a streaming response redraws the status line.
+const statusRedrawInterval = 250 * time.Millisecond
+
+// statusLine is the line kept at the bottom of the terminal during interactive sessions: the
+// model, its temperature, the tokens used against the budgets, the API quota left, and the state
+// of the response. The
+// rows above it are made a scrolling region, so the conversation scrolls without covering it.
+type statusLine struct {
+	mu       sync.Mutex
+	cfg      map[string]string
+	width    int
+	height   int
+	tokens   string // the usage part, read from the conversation and the usage file
+	state    string
+	streamed strings.Builder
+	drawn    time.Time
+}
+
+// status is the status line of the interactive session, nil when it is off. Its methods do nothing
+// on nil.
+var status *statusLine
+
+// startStatusLine turns the status line on when stderr is a terminal, unless the STATUS_LINE
+// setting is off. It is drawn by the first call to ready.
+func startStatusLine(cfg map[string]string) {
+	info, err := os.Stderr.Stat()
+	if cfg["STATUS_LINE"] != "true" || err != nil || info.Mode()&os.ModeCharDevice == 0 || os.Getenv("TERM") == "dumb" {
+		return
+	}
+	status = &statusLine{cfg: cfg}
+}
+
+// stopStatusLine removes the status line and gives the whole terminal back to the scrolling text.
+func stopStatusLine() {
+	s := status
+	if s == nil {
+		return
+	}
+	status = nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "\x1b7\x1b[r\x1b[%d;1H\x1b[2K\x1b8", s.height)
+}
+
+// ready is called before each prompt: it checks the terminal size and reads the token counts.
+func (s *statusLine) ready(convFile string) {
+	if s == nil {
+		return
+	}
+	tokens := statusTokens(convFile)
+	width, height := terminalSize()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if height != s.height && height > 2 {
+		// keep the cursor off the last row, then reserve it
+		fmt.Fprintf(os.Stderr, "\n\x1b[1A\x1b7\x1b[1;%dr\x1b8", height-1)
+		s.height = height
+	}
+	s.width, s.tokens, s.state = width, tokens, "ready"
+	s.streamed.Reset()
+	s.drawLocked()
+}
+
+// set changes the state of the response: waiting, streaming...
+func (s *statusLine) set(state string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	s.drawLocked()
+}
+
+// writer returns out, counting what is written to it as the streamed response.
+func (s *statusLine) writer(out io.Writer) io.Writer {
+	if s == nil {
+		return out
+	}
+	return &statusWriter{out: out, status: s}
+}
+
+type statusWriter struct {
+	out    io.Writer
+	status *statusLine
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	n, err := w.out.Write(p)
+	s := w.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streamed.Write(p[:n])
+	if time.Since(s.drawn) >= statusRedrawInterval {
+		s.state = fmt.Sprintf("streaming ~%d tokens", countTextTokens(s.streamed.String()))
+		s.drawLocked()
+	}
+	return n, err
+}
+
+func (s *statusLine) drawLocked() {
+	if s.height < 3 {
+		return
+	}
+	parts := []string{s.cfg["MODEL"]}
+	if _, ok := GetModelDefinition(s.cfg["MODEL"]).Parameters["temperature"]; ok {
+		parts = append(parts, "temp "+s.cfg["TEMPERATURE"])
+	}
+	if s.tokens != "" {
+		parts = append(parts, s.tokens)
+	}
+	if quota := rateLimitSummary(); quota != "" {
+		parts = append(parts, quota)
+	}
+	parts = append(parts, s.state)
+	line := " " + strings.Join(parts, " | ")
+	if utf8.RuneCountInString(line) > s.width {
+		line = string([]rune(line)[:s.width])
+	}
+	line += strings.Repeat(" ", s.width-utf8.RuneCountInString(line))
+	fmt.Fprintf(os.Stderr, "\x1b7\x1b[%d;1H\x1b[2K\x1b[7m%s\x1b[0m\x1b8", s.height, line)
+	s.drawn = time.Now()
+}
+
+// statusTokens describes the tokens used by the conversation and this month, with the budgets
+// they count against.
+func statusTokens(convFile string) string {
+	b, _ := loadBudget()
+	var parts []string
+	if cf, err := readConversation(convFile); err == nil {
+		u := conversationUsage(cf)
+		part := fmt.Sprintf("%d tokens", u.PromptTokens+u.CompletionTokens)
+		if b.conversationTokens > 0 {
+			part = fmt.Sprintf("%d/%d tokens", u.PromptTokens+u.CompletionTokens, b.conversationTokens)
+		}
+		parts = append(parts, part)
+	}
+	if b.monthlyTokens > 0 {
+		if months, err := readMonthlyUsage(); err == nil {
+			u := months[currentMonth()]
+			parts = append(parts, fmt.Sprintf("month %d/%d", u.PromptTokens+u.CompletionTokens, b.monthlyTokens))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The --stdio-json protocol lets editors and other programs drive a chat: each line read on stdin
+// is a JSON request, and each line written on stdout a JSON event. Diagnostics stay on stderr.
+
+// stdioRequest is a request of the --stdio-json protocol. The id, if any, is repeated in the events
+// the request causes.
+type stdioRequest struct {
+	ID      string      `json:"id,omitempty"`
+	Type    string      `json:"type"`              // "message", "set" or "status"
+	Content string      `json:"content,omitempty"` // message text
+	Param   string      `json:"param,omitempty"`   // setting to change: a model parameter, stream, history_limit or model
+	Value   interface{} `json:"value,omitempty"`   // its new value, or "unset"
+}
+
+// stdioEvent is an event of the --stdio-json protocol.
+type stdioEvent struct {
+	ID               string            `json:"id,omitempty"`
+	Type             string            `json:"type"` // "ready", "reasoning", "delta", "usage", "completion", "ok", "status" or "error"; --json-stream adds "done"
+	Content          string            `json:"content,omitempty"`
+	Conversation     string            `json:"conversation,omitempty"`
+	Model            string            `json:"model,omitempty"`
+	Settings         map[string]string `json:"settings,omitempty"`
+	Reset            map[string]string `json:"reset,omitempty"` // settings a model change reset to the model's defaults
+	PromptTokens     int               `json:"prompt_tokens,omitempty"`
+	CompletionTokens int               `json:"completion_tokens,omitempty"`
+	Error            string            `json:"error,omitempty"`
+	Code             int               `json:"code,omitempty"` // the exit code the error would cause
+}
+
+// stdioEmitter writes events to stdout, one per line.
+type stdioEmitter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (e *stdioEmitter) emit(ev stdioEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(ev)
+}
+
+func (e *stdioEmitter) fail(id string, err error) {
+	e.emit(stdioEvent{ID: id, Type: "error", Error: describeError(err), Code: exitCodeFor(err)})
+}
+
+// stdioStatus describes the session for the ready and status events.
+func stdioStatus(id, typ, convFile string, cfg map[string]string) stdioEvent {
+	settings := map[string]string{}
+	for _, key := range settingsShown(cfg) {
+		settings[strings.ToLower(key)] = cfg[key]
+	}
+	return stdioEvent{ID: id, Type: typ, Conversation: convFile, Model: cfg["MODEL"], Settings: settings}
+}
+
+// runStdioJSON serves the --stdio-json protocol for the conversation until stdin is closed, and
+// returns the process exit code.
+func runStdioJSON(convFile string, cfg map[string]string, sysPromptContent string) int {
+	emitter := &stdioEmitter{enc: json.NewEncoder(os.Stdout)}
+	emitter.enc.SetEscapeHTML(false)
+	emitter.emit(stdioStatus("", "ready", convFile, cfg))
+
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		line, err := reader.ReadString('\n')
+		if strings.TrimSpace(line) != "" {
+			var req stdioRequest
+			if jerr := json.Unmarshal([]byte(line), &req); jerr != nil {
+				emitter.emit(stdioEvent{Type: "error", Error: "invalid request: " + jerr.Error(), Code: exitUsage})
+			} else {
+				sessionMu.Lock()
+				handleStdioRequest(req, convFile, cfg, sysPromptContent, emitter)
+				sessionMu.Unlock()
+			}
+		}
+		if err == io.EOF {
+			return exitOK
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sError reading input: %v%s\n", red, err, normal)
+			return exitGeneral
+		}
+	}
+}
+
+func handleStdioRequest(req stdioRequest, convFile string, cfg map[string]string, sysPromptContent string, emitter *stdioEmitter) {
+	switch req.Type {
+	case "status":
+		emitter.emit(stdioStatus(req.ID, "status", convFile, cfg))
+	case "set":
+		value := ""
+		switch v := req.Value.(type) {
+		case nil:
+			emitter.emit(stdioEvent{ID: req.ID, Type: "error", Error: "missing value", Code: exitUsage})
+			return
+		case string:
+			value = v
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			value = fmt.Sprint(v)
+		}
+		reset, err := setStdioParameter(cfg, convFile, req.Param, value)
+		if err != nil {
+			emitter.emit(stdioEvent{ID: req.ID, Type: "error", Error: err.Error(), Code: exitUsage})
+			return
+		}
+		autosaveAfterChange(convFile, cfg)
+		emitter.emit(stdioEvent{ID: req.ID, Type: "ok", Reset: reset})
+	case "message":
+		if strings.TrimSpace(req.Content) == "" {
+			emitter.emit(stdioEvent{ID: req.ID, Type: "error", Error: "empty message", Code: exitUsage})
+			return
+		}
+		before := tokenUsage{}
+		if cf, err := readConversation(convFile); err == nil {
+			before = conversationUsage(cf)
+		}
+		// the chunks are emitted as they arrive, as with --json-stream; the rendered text is not
+		jsonStream, jsonStreamID = emitter, req.ID
+		err := sendStdioMessage(req.Content, convFile, cfg, sysPromptContent, ioutil.Discard)
+		jsonStream, jsonStreamID = nil, ""
+		if err != nil {
+			emitter.fail(req.ID, err)
+			return
+		}
+		reply, err := lastAssistantMessage(convFile)
+		if err != nil {
+			emitter.fail(req.ID, err)
+			return
+		}
+		ev := stdioEvent{ID: req.ID, Type: "completion", Content: filterThinkingBlock(reply)}
+		if cf, err := readConversation(convFile); err == nil {
+			after := conversationUsage(cf)
+			ev.PromptTokens, ev.CompletionTokens = after.PromptTokens-before.PromptTokens, after.CompletionTokens-before.CompletionTokens
+		}
+		emitter.emit(ev)
+	default:
+		emitter.emit(stdioEvent{ID: req.ID, Type: "error", Error: fmt.Sprintf("unknown request type %q", req.Type), Code: exitUsage})
+	}
+}
+
+// setStdioParameter sets the model or a session parameter for a set request. A new model resets
+// the settings it does not accept to its defaults, without asking since stdin carries the
+// protocol, and their new values are returned.
+func setStdioParameter(cfg map[string]string, convFile, name, value string) (map[string]string, error) {
+	if name != "model" {
+		if !isSessionParameter(cfg, name) {
+			return nil, fmt.Errorf("unknown setting %q for model %s", name, cfg["MODEL"])
+		}
+		return nil, setSessionParameter(cfg, name, value)
+	}
+	if _, exists := ModelDefinitions[value]; !exists {
+		found := false
+		for _, m := range modelsList {
+			if m == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("model %q not found in the list of supported models", value)
+		}
+	}
+	applySetting(cfg, "MODEL", value, sourceInteractive, "--stdio-json")
+	modelDef := GetModelDefinition(value)
+	reset := resetSettings(cfg, modelDef, settingConflicts(cfg, modelDef))
+	return reset, persistModelToFile(convFile, value)
+}
+
+// sendStdioMessage runs a turn for a message of a message request, streaming the reply to out.
+func sendStdioMessage(text, convFile string, cfg map[string]string, sysPromptContent string, out io.Writer) error {
+	userInput, err := withRAGContext(cfg, text)
+	if err != nil {
+		return err
+	}
+	userInput = redactOutgoing(userInput, "")
+	if err := appendMessage(convFile, "user", userInput); err != nil {
+		return fmt.Errorf("append user message: %w", err)
+	}
+	count, _ := messageCount(convFile)
+	if limit, _ := strconv.Atoi(cfg["HISTORY_LIMIT"]); count > limit {
+		return fmt.Errorf("%w (%d)", errHistoryLimitExceeded, limit)
+	}
+	return completeOrQueue(convFile, cfg, sysPromptContent, apiKeys.Current(), out, func() {})
+}
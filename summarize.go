@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
+)
+
+// defaultCompactKeep is the number of recent exchanges /compact keeps verbatim.
+const defaultCompactKeep = 2
+
+// exchangeStart returns the index of the first message of the last n exchanges, an exchange being
+// a user message and the replies to it. It returns 0 when there are no more than n exchanges.
+func exchangeStart(messages []Message, n int) int {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			if n--; n == 0 {
+				return i
+			}
+		}
+	}
+	return 0
+}
+
+// splitPending separates the queued messages, which have not been sent and are neither summarized
+// nor archived, from the others. Archived messages are in neither.
+func splitPending(messages []Message) (sent, pending []Message) {
+	for _, m := range messages {
+		if m.Archived {
+			continue
+		}
+		if m.Status == nvidiachat.StatusPending {
+			pending = append(pending, m)
+		} else {
+			sent = append(sent, m)
+		}
+	}
+	return sent, pending
+}
+
+// archiveOldest archives the first n messages of the conversation that are neither archived nor
+// pending: they stay in the file but are no longer sent or counted against HISTORY_LIMIT.
+func archiveOldest(cf *ConversationFile, n int) {
+	for i := range cf.Messages {
+		if n == 0 {
+			return
+		}
+		if m := &cf.Messages[i]; !m.Archived && m.Status != nvidiachat.StatusPending {
+			m.Archived = true
+			n--
+		}
+	}
+}
+
+// withSummary puts the conversation's earlier summary in front of messages, for it stands for the
+// messages before them.
+func withSummary(cf *ConversationFile, messages []Message) []Message {
+	if cf.Summary == "" {
+		return messages
+	}
+	return append([]Message{{Role: "user", Content: nvidiachat.SummaryPrefix + cf.Summary}}, messages...)
+}
+
+// requestTokens estimates the prompt tokens of the conversation's next request.
+func requestTokens(cf *ConversationFile) int {
+	n := 0
+	for _, m := range cf.APIMessages() {
+		n += estimateTokens(m)
+	}
+	return n
+}
+
+// summarizeConversation implements /summarize [n] [--compact]. It prints a summary of the last n
+// exchanges, or of the whole conversation. --compact is /compact with n defaulting to none kept.
+func summarizeConversation(args []string, convFile string, cfg map[string]string) {
+	n, compact := 0, false
+	for _, a := range args {
+		if a == "--compact" {
+			compact = true
+		} else if v, err := strconv.Atoi(a); err == nil && v > 0 {
+			n = v
+		} else {
+			fmt.Fprintln(os.Stderr, "Usage: /summarize [n] [--compact]")
+			return
+		}
+	}
+	if compact {
+		compactConversation(convFile, cfg, n, true)
+		return
+	}
+	cf, err := readConversation(convFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed reading conversation: %v%s\n", red, err, normal)
+		return
+	}
+	sent, _ := splitPending(cf.Messages)
+	cut := exchangeStart(sent, n)
+	selected := sent[cut:]
+	if len(selected) == 0 {
+		fmt.Fprintln(os.Stderr, "Nothing to summarize.")
+		return
+	}
+	if cut == 0 {
+		selected = withSummary(cf, selected)
+	}
+	summary, err := summarizeSelection(cf, cfg, selected)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to summarize: %s%s\n", red, firstLine(describeError(err)), normal)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\n%s\n", blue+"Summary:"+normal)
+	fmt.Println(summary)
+}
+
+func summarizeSelection(cf *ConversationFile, cfg map[string]string, selected []Message) (string, error) {
+	fmt.Fprintf(os.Stderr, "Summarizing %d message(s)...\n", len(selected))
+	window := GetModelDefinition(cfg["MODEL"]).ContextWindow
+	return summarizeMessages(cfg, cf.SystemFor(cfg["MODEL"]), selected, apiKeys.Current(), window/2)
+}
+
+// compactConversation replaces all but the last keep exchanges of convFile with a summary written
+// by the model. The summary is stored in the conversation's summary field, which is sent as a
+// system message, and the summarized messages are archived.
+func compactConversation(convFile string, cfg map[string]string, keep int, show bool) {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed reading conversation: %v%s\n", red, err, normal)
+		return
+	}
+	sent, _ := splitPending(cf.Messages)
+	cut := len(sent)
+	if keep > 0 {
+		cut = exchangeStart(sent, keep)
+	}
+	if cut == 0 {
+		fmt.Fprintln(os.Stderr, "Nothing to compact: the conversation has no older messages.")
+		return
+	}
+	summary, err := summarizeSelection(cf, cfg, withSummary(cf, sent[:cut]))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to summarize: %s%s\n", red, firstLine(describeError(err)), normal)
+		return
+	}
+	if show {
+		fmt.Fprintf(os.Stderr, "\n%s\n", blue+"Summary:"+normal)
+		fmt.Println(summary)
+	}
+
+	before := requestTokens(cf)
+	cf.Summary = summary
+	archiveOldest(cf, cut)
+	if err := writeConversation(convFile, cf); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to write the conversation: %v%s\n", red, err, normal)
+		return
+	}
+	after := requestTokens(cf)
+	fmt.Fprintf(os.Stderr, "%sReplaced %d message(s) with a summary: about %d tokens per request instead of %d (%d saved).%s\n", green, cut, after, before, before-after, normal)
+	fmt.Fprintln(os.Stderr, "They stay in the file as archived messages, which are not sent.")
+}
+
+// runCompactCommand implements /compact [n].
+func runCompactCommand(args []string, convFile string, cfg map[string]string) {
+	keep := defaultCompactKeep
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 || len(args) > 1 {
+			fmt.Fprintln(os.Stderr, "Usage: /compact [n] (keep the last n exchanges, default 2)")
+			return
+		}
+		keep = n
+	}
+	compactConversation(convFile, cfg, keep, false)
+}
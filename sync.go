@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The conversations can be kept in a git repository with `nvidia-chat sync`, following the [sync]
+// section of the config file:
+//
+//	[sync]
+//	dir = "~/.cache/nvidia-chat"   # the repository, the history directory by default
+//	remote = "git@github.com:me/chats.git"
+
+// syncGitignore is written to a repository created by sync, so that only the conversations are
+// committed, not the caches, indexes, backups and token counts kept next to them.
+const syncGitignore = `# Written by nvidia-chat sync: only the conversations are synchronized.
+/*
+!/.gitignore
+!/*.json
+!/*.yaml
+!/*.yml
+/usage.json
+/backup-state.json
+/models-catalog.json
+*.bak.*
+*.before-summary.*
+*.tmp
+`
+
+// gitIn runs git in dir and returns its trimmed output. Errors carry the first line of git's
+// message.
+func gitIn(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], firstLine(msg))
+		}
+		return "", fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// prepareSyncRepo makes dir a git repository with the given remote as origin, unless it already is
+// one. An existing origin is pointed to remote when they differ.
+func prepareSyncRepo(dir, remote string) error {
+	if _, err := gitIn(dir, "rev-parse", "--git-dir"); err != nil {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		if _, err := gitIn(dir, "init", "-q"); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Created a git repository in %s\n", dir)
+	}
+	if ignore := filepath.Join(dir, ".gitignore"); !fileExists(ignore) {
+		if err := ioutil.WriteFile(ignore, []byte(syncGitignore), 0o644); err != nil {
+			return err
+		}
+	}
+	if remote == "" {
+		return nil
+	}
+	current, err := gitIn(dir, "remote", "get-url", "origin")
+	switch {
+	case err != nil:
+		_, err = gitIn(dir, "remote", "add", "origin", remote)
+	case current != remote:
+		_, err = gitIn(dir, "remote", "set-url", "origin", remote)
+	}
+	return err
+}
+
+func printSyncHelp() {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%sUsage:%s nvidia-chat sync [--dir DIR] [--remote URL] [--no-pull] [--no-push] [-m MESSAGE]\n\n", bold, normal))
+	builder.WriteString("Commit the conversation files to a git repository, then pull the changes made on other machines\n")
+	builder.WriteString("and push. The directory becomes a repository on the first run, with a .gitignore that leaves\n")
+	builder.WriteString("out everything but the conversations. The defaults come from the [sync] section of the config\n")
+	builder.WriteString("file: dir and remote.\n\n")
+	builder.WriteString("Options:\n")
+	builder.WriteString("  --dir DIR             Repository of the conversations (default: " + conversationDir() + ").\n")
+	builder.WriteString("  --remote URL          Use URL as the origin remote.\n")
+	builder.WriteString("  --no-pull             Do not pull before pushing.\n")
+	builder.WriteString("  --no-push             Only commit (and pull).\n")
+	builder.WriteString("  -m, --message TEXT    Commit message (default: the host name and the time).\n")
+	fmt.Print(builder.String())
+}
+
+// runSyncCommand implements the `sync` subcommand and returns the process exit code.
+func runSyncCommand(args []string) int {
+	conf, err := loadConfigFile(userConfigPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to read config: %v%s\n", red, err, normal)
+		return exitUsage
+	}
+	dir, remote, message := conversationDir(), conf["sync.remote"], ""
+	if v := conf["sync.dir"]; v != "" {
+		dir = v
+	}
+	pull, push := true, true
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-h", "--help":
+			printSyncHelp()
+			return exitOK
+		case "--no-pull":
+			pull = false
+		case "--no-push":
+			push = false
+		case "--dir", "--remote", "-m", "--message":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%smissing value for %s%s\n", red, args[i], normal)
+				return exitUsage
+			}
+			val := args[i+1]
+			switch args[i] {
+			case "--dir":
+				dir = val
+			case "--remote":
+				remote = val
+			case "-m", "--message":
+				message = val
+			}
+			i++
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown option: %s\n", args[i])
+			printSyncHelp()
+			return exitUsage
+		}
+	}
+	if strings.HasPrefix(dir, "~/") {
+		dir = filepath.Join(os.Getenv("HOME"), dir[2:])
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		fmt.Fprintf(os.Stderr, "%sgit is not installed%s\n", red, normal)
+		return exitGeneral
+	}
+	if err := prepareSyncRepo(dir, remote); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitGeneral
+	}
+
+	if _, err := gitIn(dir, "add", "-A"); err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	changed, err := gitIn(dir, "status", "--porcelain")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	if changed != "" {
+		if message == "" {
+			host, _ := os.Hostname()
+			message = fmt.Sprintf("Sync from %s at %s", host, time.Now().Format("2006-01-02 15:04"))
+		}
+		if _, err := gitIn(dir, "commit", "-q", "-m", message); err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+			return exitGeneral
+		}
+		fmt.Fprintf(os.Stderr, "Committed %d file(s)\n", len(strings.Split(changed, "\n")))
+	} else {
+		fmt.Fprintln(os.Stderr, "No local changes")
+	}
+
+	if _, err := gitIn(dir, "remote", "get-url", "origin"); err != nil {
+		if pull || push {
+			fmt.Fprintf(os.Stderr, "No remote: set one with --remote or remote in the [sync] section of %s\n", userConfigPath())
+		}
+		return exitOK
+	}
+	branch, err := gitIn(dir, "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+		return exitGeneral
+	}
+	if pull {
+		if _, err := gitIn(dir, "fetch", "-q", "origin"); err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+			return exitNetwork
+		}
+		// the remote branch does not exist until the first push
+		if _, err := gitIn(dir, "rev-parse", "-q", "--verify", "refs/remotes/origin/"+branch); err == nil {
+			if _, err := gitIn(dir, "rebase", "-q", "origin/"+branch); err != nil {
+				conflicts, _ := gitIn(dir, "diff", "--name-only", "--diff-filter=U")
+				gitIn(dir, "rebase", "--abort")
+				fmt.Fprintf(os.Stderr, "%sThe conversations changed on both sides: %s%s\n", red, strings.Join(strings.Fields(conflicts), ", "), normal)
+				fmt.Fprintf(os.Stderr, "Merge them by hand in %s (git pull), then run sync again.\n", dir)
+				return exitGeneral
+			}
+			fmt.Fprintf(os.Stderr, "Pulled origin/%s\n", branch)
+		}
+	}
+	if push {
+		if _, err := gitIn(dir, "push", "-q", "-u", "origin", branch); err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v%s\n", red, err, normal)
+			return exitNetwork
+		}
+		fmt.Fprintf(os.Stderr, "Pushed to origin/%s\n", branch)
+	}
+	fmt.Fprintf(os.Stderr, "%sConversations in sync with %s%s\n", green, dir, normal)
+	return exitOK
+}
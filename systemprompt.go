@@ -0,0 +1,354 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// systemSource is one part of the system prompt, given by -s (a file), --system-text (inline text)
+// or --system-url (a URL).
+type systemSource struct {
+	kind, value string // kind is "file", "text" or "url"
+}
+
+// allowHTTPSystemURL is set by --allow-http-system-url: system prompts may then be downloaded over
+// plain http.
+var allowHTTPSystemURL bool
+
+// developerPrompt holds the instructions of --dev-prompt-file, sent as a developer message after the
+// system prompt.
+var developerPrompt string
+
+// defaultRoleMap is the role map of models whose definition has none: NVIDIA's endpoints know the
+// system, user, assistant and tool roles only.
+var defaultRoleMap = map[string]string{"developer": "system"}
+
+// systemFileSource returns the source for the value of -s: a URL when it has a scheme, such as
+// https://, a file otherwise.
+func systemFileSource(value string) systemSource {
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" && strings.Contains(value, "://") {
+		return systemSource{"url", value}
+	}
+	return systemSource{"file", value}
+}
+
+// composeSystemPrompt reads the sources and joins them, in the order they were given, with a blank
+// line between them.
+func composeSystemPrompt(cfg map[string]string, sources []systemSource) (string, error) {
+	var parts []string
+	for _, s := range sources {
+		var text string
+		switch s.kind {
+		case "file":
+			b, err := ioutil.ReadFile(s.value)
+			if os.IsNotExist(err) {
+				return "", fmt.Errorf("System prompt file not found: %s", s.value)
+			}
+			if err != nil {
+				return "", fmt.Errorf("Failed to read system prompt file: %v", err)
+			}
+			text = string(b)
+		case "url":
+			b, err := fetchSystemPrompt(cfg, s.value)
+			if err != nil {
+				return "", fmt.Errorf("Failed to fetch system prompt %s: %v", s.value, err)
+			}
+			text = b
+		default:
+			text = s.value
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// cachedSystemPrompt is a downloaded system prompt, kept with the validators of its response.
+type cachedSystemPrompt struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Fetched      time.Time `json:"fetched"`
+	Text         string    `json:"text"`
+}
+
+// systemPromptCachePath returns the file caching the system prompt downloaded from rawURL.
+func systemPromptCachePath(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return filepath.Join(conversationDir(), "system-prompts", hex.EncodeToString(sum[:])+".json")
+}
+
+// checkSystemURL refuses the URLs system prompts are not downloaded from: schemes other than https,
+// and http unless --allow-http-system-url is given.
+func checkSystemURL(u *url.URL) error {
+	switch {
+	case u.Scheme == "https":
+		return nil
+	case u.Scheme == "http" && allowHTTPSystemURL:
+		return nil
+	case u.Scheme == "http":
+		return errors.New("refusing to download over plain http; use https, or --allow-http-system-url")
+	}
+	return fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+}
+
+// fetchSystemPrompt downloads a system prompt with the HTTP settings of the API requests. The text
+// is cached with its ETag and Last-Modified date, so that an unchanged prompt is not downloaded
+// again, and the cached copy is used when the server cannot be reached.
+func fetchSystemPrompt(cfg map[string]string, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if err := checkSystemURL(u); err != nil {
+		return "", err
+	}
+	cachePath := systemPromptCachePath(rawURL)
+	var cached *cachedSystemPrompt
+	if b, err := ioutil.ReadFile(cachePath); err == nil {
+		var c cachedSystemPrompt
+		if json.Unmarshal(b, &c) == nil && c.URL == rawURL {
+			cached = &c
+		}
+	}
+
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+	client := newHTTPClient(cfg)
+	client.CheckRedirect = func(r *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return checkSystemURL(r.URL)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if cached != nil {
+			fmt.Fprintf(os.Stderr, "%sCannot reach %s (%v), using the copy downloaded %s%s\n", red, rawURL, err, cached.Fetched.Format("2006-01-02 15:04"), normal)
+			return cached.Text, nil
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return cached.Text, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+	c := cachedSystemPrompt{URL: rawURL, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified"), Fetched: time.Now(), Text: string(body)}
+	if (c.ETag != "" || c.LastModified != "") && !ephemeralSession {
+		if err := storeSystemPrompt(cachePath, &c); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to cache the system prompt: %v%s\n", red, err, normal)
+		}
+	}
+	return c.Text, nil
+}
+
+func storeSystemPrompt(path string, c *cachedSystemPrompt) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0o600)
+}
+
+// sessionSystem is the system prompt composed from -s, --system-text and --system-url, with its
+// sources. It takes precedence over the conversation file's until /system edit or /system clear
+// replaces both.
+var sessionSystem struct {
+	content string
+	sources []systemSource
+}
+
+// describeSystemSources names where the session's system prompt comes from.
+func describeSystemSources(sources []systemSource) string {
+	names := make([]string, len(sources))
+	for i, s := range sources {
+		switch s.kind {
+		case "file":
+			names[i] = "-s " + s.value
+		case "url":
+			names[i] = "--system-url " + s.value
+		default:
+			names[i] = "--system-text"
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// systemCommand implements /system: without arguments it shows the effective system prompt and
+// where it comes from; edit opens it in $VISUAL or $EDITOR and clear removes it, both saving the
+// result in the conversation file. With --model, edit and clear act on the current model's own
+// system prompt, which replaces the conversation's while that model is used.
+func systemCommand(args []string, convFile string, cfg map[string]string) {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed reading conversation: %v%s\n", red, err, normal)
+		return
+	}
+	model := cfg["MODEL"]
+	ownSystem := cf.SystemFor(model) != cf.System
+	if len(args) > 0 && (args[0] == "edit" || args[0] == "clear") && (len(args) == 2 && args[1] == "--model" || len(args) == 1 && ownSystem && sessionSystem.content == "") {
+		modelSystemCommand(args[0], convFile, cfg, cf)
+		return
+	}
+	current, source := cf.SystemFor(model), "the conversation file"
+	if ownSystem {
+		source = "the conversation file, for " + model
+	}
+	if sessionSystem.content != "" {
+		current, source = sessionSystem.content, describeSystemSources(sessionSystem.sources)
+	}
+
+	switch {
+	case len(args) == 0:
+		if current == "" {
+			fmt.Fprintln(os.Stderr, "No system prompt. /system edit writes one.")
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%sSystem prompt (from %s):%s\n%s\n", bold, source, normal, current)
+		if sessionSystem.content != "" && cf.SystemFor(model) != "" {
+			fmt.Fprintln(os.Stderr, "It replaces the one saved in the conversation file for this session.")
+		} else if sessionSystem.content == "" && ownSystem {
+			fmt.Fprintf(os.Stderr, "It replaces the conversation's system prompt while %s is used; /system clear --model removes it.\n", model)
+		}
+	case len(args) == 1 && args[0] == "edit":
+		stopStatusLine()
+		edited, err := editText(current, "system-*.md")
+		startStatusLine(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to edit the system prompt: %v%s\n", red, err, normal)
+			return
+		}
+		if edited = strings.TrimSpace(edited); edited == strings.TrimSpace(current) {
+			fmt.Fprintln(os.Stderr, "System prompt unchanged.")
+			return
+		}
+		if err := persistSystemToFile(convFile, edited); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to persist system prompt: %v%s\n", red, err, normal)
+			return
+		}
+		sessionSystem.content, sessionSystem.sources = "", nil
+		if edited == "" {
+			fmt.Fprintf(os.Stderr, "%sSystem prompt removed%s\n", green, normal)
+		} else {
+			fmt.Fprintf(os.Stderr, "%sSystem prompt saved in %s%s\n", green, convFile, normal)
+		}
+	case len(args) == 1 && args[0] == "clear":
+		if err := persistSystemToFile(convFile, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to persist system prompt: %v%s\n", red, err, normal)
+			return
+		}
+		sessionSystem.content, sessionSystem.sources = "", nil
+		fmt.Fprintf(os.Stderr, "%sSystem prompt removed%s\n", green, normal)
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: /system [edit|clear] [--model]")
+	}
+}
+
+// modelSystemCommand implements /system edit --model and /system clear --model.
+func modelSystemCommand(action, convFile string, cfg map[string]string, cf *ConversationFile) {
+	model := cfg["MODEL"]
+	current, _ := cf.Settings.Models[model]["system"].(string)
+	edited := ""
+	if action == "edit" {
+		text := current
+		if text == "" {
+			text = cf.System // start from the conversation's
+		}
+		stopStatusLine()
+		out, err := editText(text, "system-*.md")
+		startStatusLine(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to edit the system prompt: %v%s\n", red, err, normal)
+			return
+		}
+		if edited = strings.TrimSpace(out); edited == strings.TrimSpace(current) {
+			fmt.Fprintln(os.Stderr, "System prompt unchanged.")
+			return
+		}
+	} else if current == "" {
+		fmt.Fprintf(os.Stderr, "%s has no system prompt of its own.\n", model)
+		return
+	}
+	if err := persistModelSystemToFile(convFile, model, edited); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to persist system prompt: %v%s\n", red, err, normal)
+		return
+	}
+	sessionSystem.content, sessionSystem.sources = "", nil
+	if edited == "" {
+		fmt.Fprintf(os.Stderr, "%sRemoved the system prompt of %s; the conversation's applies again.%s\n", green, model, normal)
+	} else {
+		fmt.Fprintf(os.Stderr, "%sSystem prompt of %s saved in %s%s\n", green, model, convFile, normal)
+	}
+}
+
+// editText lets the user edit text in $VISUAL or $EDITOR (vi, or notepad on Windows) and returns
+// the result. pattern names the temporary file, as for ioutil.TempFile.
+func editText(text, pattern string) (string, error) {
+	f, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	_, err = f.WriteString(text)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], f.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w", editor, err)
+	}
+	b, err := ioutil.ReadFile(f.Name())
+	return string(b), err
+}
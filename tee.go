@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// ansiEscape matches the color sequences written around parts of the output.
+var ansiEscape = regexp.MustCompile(`\x1b(\[[0-9;?]*[A-Za-z]|\([A-Z0-9])`)
+
+// teeWriter appends the streamed responses, without colors, to a file as they arrive, so that
+// they are kept even if the process is killed. Failing writes are reported once and then ignored,
+// so that they never interrupt a response.
+type teeWriter struct {
+	mu     sync.Mutex
+	f      *os.File
+	last   byte
+	failed bool
+}
+
+// teeOutput is the file set by --tee or /tee on, if any.
+var teeOutput *teeWriter
+
+// startTee starts appending the streamed responses to path.
+func startTee(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	stopTee()
+	teeOutput = &teeWriter{f: f, last: '\n'}
+	return nil
+}
+
+// stopTee closes the --tee file, if any.
+func stopTee() {
+	if t := teeOutput; t != nil {
+		teeOutput = nil
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if err := t.f.Close(); err != nil && !t.failed {
+			fmt.Fprintf(os.Stderr, "%sFailed to write %s: %v%s\n", red, t.f.Name(), err, normal)
+		}
+	}
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	text := ansiEscape.ReplaceAll(p, nil)
+	if len(text) == 0 || t.failed {
+		return len(p), nil
+	}
+	if _, err := t.f.Write(text); err != nil {
+		t.failed = true
+		fmt.Fprintf(os.Stderr, "\n%sFailed to write %s, no longer copying the output: %v%s\n", red, t.f.Name(), err, normal)
+		return len(p), nil
+	}
+	t.last = text[len(text)-1]
+	return len(p), nil
+}
+
+// endResponse ends the last response copied with a newline, to keep the next one apart.
+func (t *teeWriter) endResponse() {
+	t.mu.Lock()
+	last := t.last
+	t.mu.Unlock()
+	if last != '\n' {
+		t.Write([]byte("\n"))
+	}
+}
+
+// withTee returns out, also writing to the --tee file when one is set.
+func withTee(out io.Writer) io.Writer {
+	if teeOutput == nil {
+		return out
+	}
+	return io.MultiWriter(out, teeOutput)
+}
+
+// teeCommand implements /tee [on <file>|off].
+func teeCommand(args []string) {
+	switch {
+	case len(args) == 0:
+		if teeOutput == nil {
+			fmt.Fprintln(os.Stderr, "Tee is off.")
+		} else {
+			fmt.Fprintf(os.Stderr, "Copying the responses to %s\n", teeOutput.f.Name())
+		}
+	case args[0] == "on" && len(args) == 2:
+		if err := startTee(args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "%sCannot open %s: %v%s\n", red, args[1], err, normal)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%sCopying the responses to %s%s\n", green, args[1], normal)
+	case args[0] == "off" && len(args) == 1:
+		if teeOutput == nil {
+			fmt.Fprintln(os.Stderr, "Tee is off.")
+			return
+		}
+		name := teeOutput.f.Name()
+		stopTee()
+		fmt.Fprintf(os.Stderr, "%sStopped copying the responses to %s%s\n", green, name, normal)
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: /tee [on <file>|off]")
+	}
+}
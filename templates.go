@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// queuedInput is a user message prepared by an interactive command (e.g. /template)
+// that the interactive loop sends instead of reading from the terminal.
+var queuedInput string
+
+// templateDir returns the directory searched for named prompt templates.
+func templateDir() string {
+	return filepath.Join(configDir(), "templates")
+}
+
+// resolveTemplatePath finds a template either by path or by name in the template directory.
+// Only regular files count, so a directory named like a template is skipped.
+func resolveTemplatePath(name string) (string, error) {
+	if isRegularFile(name) {
+		return name, nil
+	}
+	for _, candidate := range []string{name, name + ".tmpl"} {
+		path := filepath.Join(templateDir(), candidate)
+		if isRegularFile(path) {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("template %q not found (looked in the current directory and %s)", name, templateDir())
+}
+
+// isRegularFile reports whether path exists and is a regular file.
+func isRegularFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// listTemplates returns the names of the templates available in the template directory.
+func listTemplates() []string {
+	entries, err := ioutil.ReadDir(templateDir())
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(e.Name(), ".tmpl"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseTemplateVars parses key=value pairs. A value starting with @ is replaced by the contents of that file.
+func parseTemplateVars(pairs []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid template variable %q (expected key=value)", pair)
+		}
+		value := parts[1]
+		if strings.HasPrefix(value, "@") {
+			b, err := ioutil.ReadFile(value[1:])
+			if err != nil {
+				return nil, fmt.Errorf("template variable %s: %w", parts[0], err)
+			}
+			value = string(b)
+		}
+		vars[parts[0]] = value
+	}
+	return vars, nil
+}
+
+// renderTemplate renders the named template with the given variables.
+// Referencing a variable that was not provided is an error.
+func renderTemplate(name string, vars map[string]string) (string, error) {
+	path, err := resolveTemplatePath(name)
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return executeTemplate(filepath.Base(path), string(b), vars)
+}
+
+// executeTemplate renders the template text with the given variables.
+func executeTemplate(name, text string, vars map[string]string) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parse template: %w", err)
+	}
+	var builder strings.Builder
+	if err := tmpl.Execute(&builder, vars); err != nil {
+		return "", fmt.Errorf("render template: %w", err)
+	}
+	return builder.String(), nil
+}
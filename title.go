@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// renamedConvFile is set by /rename: the interactive session continues with this file.
+var renamedConvFile string
+
+// conversationTitle returns the title of a conversation, or its file name without extension.
+func conversationTitle(convFile string, cf *ConversationFile) string {
+	if cf.Title != "" {
+		return cf.Title
+	}
+	return strings.TrimSuffix(filepath.Base(convFile), filepath.Ext(convFile))
+}
+
+// generateTitle asks the model for a short title for the conversation.
+func generateTitle(cf *ConversationFile, cfg map[string]string) (string, error) {
+	sent, _ := splitPending(cf.Messages)
+	if len(sent) == 0 {
+		return "", fmt.Errorf("the conversation has no messages to name it after")
+	}
+	window := GetModelDefinition(cfg["MODEL"]).ContextWindow
+	answer, err := askAboutMessages(cfg, cf.SystemFor(cfg["MODEL"]), withSummary(cf, sent), "Write a title of at most eight words for the conversation above. Reply with the title only.", apiKeys.Current(), window/2, 512)
+	if err != nil {
+		return "", err
+	}
+	title := strings.TrimSpace(firstLine(filterThinkingBlock(answer)))
+	title = strings.TrimPrefix(title, "Title:")
+	title = strings.Trim(strings.TrimSpace(title), "\"'*#`")
+	if title == "" {
+		return "", fmt.Errorf("the model returned an empty title")
+	}
+	return title, nil
+}
+
+// setTitle implements /title [text|auto].
+func setTitle(args []string, convFile string, cfg map[string]string) {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed reading conversation: %v%s\n", red, err, normal)
+		return
+	}
+	text := strings.TrimSpace(strings.Join(args, " "))
+	switch text {
+	case "":
+		if cf.Title == "" {
+			fmt.Fprintln(os.Stderr, "The conversation has no title. Usage: /title <text>, /title auto")
+		} else {
+			fmt.Fprintf(os.Stderr, "Title: %s\n", cf.Title)
+		}
+		return
+	case "auto":
+		fmt.Fprintln(os.Stderr, "Asking the model for a title...")
+		if text, err = generateTitle(cf, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed to generate a title: %s%s\n", red, firstLine(describeError(err)), normal)
+			return
+		}
+		if cf, err = readConversation(convFile); err != nil {
+			fmt.Fprintf(os.Stderr, "%sFailed reading conversation: %v%s\n", red, err, normal)
+			return
+		}
+	}
+	cf.Title = text
+	if err := writeConversation(convFile, cf); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to save the title: %v%s\n", red, err, normal)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%sTitle: %s%s\n", green, text, normal)
+}
+
+// setTags implements /tag [name|-name]...: without arguments it lists the conversation's tags,
+// otherwise it adds each name and removes each -name.
+func setTags(args []string, convFile string) {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed reading conversation: %v%s\n", red, err, normal)
+		return
+	}
+	if len(args) == 0 {
+		if len(cf.Tags) == 0 {
+			fmt.Fprintln(os.Stderr, "The conversation has no tags. Usage: /tag <name>... to add, /tag -<name> to remove")
+		} else {
+			fmt.Fprintf(os.Stderr, "Tags: %s\n", strings.Join(cf.Tags, ", "))
+		}
+		return
+	}
+	for _, arg := range args {
+		name := strings.TrimPrefix(arg, "-")
+		if name == "" {
+			continue
+		}
+		kept := cf.Tags[:0]
+		for _, t := range cf.Tags {
+			if t != name {
+				kept = append(kept, t)
+			}
+		}
+		cf.Tags = kept
+		if !strings.HasPrefix(arg, "-") {
+			cf.Tags = append(cf.Tags, name)
+		}
+	}
+	if err := writeConversation(convFile, cf); err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed to save the tags: %v%s\n", red, err, normal)
+		return
+	}
+	if len(cf.Tags) == 0 {
+		fmt.Fprintf(os.Stderr, "%sThe conversation has no tags.%s\n", green, normal)
+	} else {
+		fmt.Fprintf(os.Stderr, "%sTags: %s%s\n", green, strings.Join(cf.Tags, ", "), normal)
+	}
+}
+
+// renameConversation implements /rename: it moves the conversation file to target, a file or an
+// existing directory, and returns the new path. An existing file is never replaced.
+func renameConversation(convFile, target string) (string, error) {
+	if ephemeralSession {
+		return "", fmt.Errorf("the conversation is in memory only; use /save to write it to a file")
+	}
+	if conversationsInMemory {
+		return "", fmt.Errorf("the conversation is read-only")
+	}
+	if strings.HasPrefix(target, "~") {
+		target = os.Getenv("HOME") + target[1:]
+	}
+	if fi, err := os.Stat(target); err == nil {
+		if !fi.IsDir() {
+			return "", fmt.Errorf("%s already exists", target)
+		}
+		target = filepath.Join(target, filepath.Base(convFile))
+		if fileExists(target) {
+			return "", fmt.Errorf("%s already exists", target)
+		}
+	}
+	if err := os.Rename(convFile, target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Token counts are estimated at four characters per token unless a tokenizer file is configured
+// for the model in the [tokenizers] section of the config file, keyed by a model ID pattern:
+//
+//	[tokenizers]
+//	meta/llama-3* = "~/.config/nvidia-chat/tokenizers/llama3-tokenizer.json"
+//	openai/* = "~/.config/nvidia-chat/tokenizers/o200k_base.tiktoken"
+//
+// Both tiktoken rank files and Hugging Face tokenizer.json files with a BPE model are read; the
+// latter covers the byte-level tokenizers (Llama 3, Qwen, DeepSeek) and the SentencePiece-derived
+// ones (Llama 2, Mistral, Gemma).
+
+// bpeTokenizer counts the tokens of a text by byte-pair encoding.
+type bpeTokenizer struct {
+	path       string
+	ranks      map[string]int // tiktoken: merged token to rank
+	merges     map[string]int // tokenizer.json: "left right" to merge priority
+	vocab      map[string]int // tokenizer.json: known tokens
+	byteLevel  bool           // bytes are mapped to printable characters (GPT-2 style)
+	metaspace  bool           // spaces are replaced by ▁ (SentencePiece style)
+	byteTokens bool           // unknown characters fall back to <0xNN> byte tokens
+	mu         sync.Mutex     // guards cache: /ab counts tokens from two goroutines
+	cache      map[string]int
+}
+
+// maxBPEChunk caps the bytes merged at once. Merging is quadratic in the chunk length, and text
+// without whitespace, such as minified code or base64, makes a single long chunk; longer chunks
+// are counted in pieces, which only matters at the pieces' edges.
+const maxBPEChunk = 256
+
+// preTokenizer splits text into the chunks merges never cross, as the GPT-4 family pattern does;
+// the trailing-space lookahead it relies on is handled by splitChunks.
+var preTokenizer = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// splitChunks pre-tokenizes text. A run of blanks followed by more text leaves its last blank to
+// the next chunk, as \s+(?!\S) does.
+func splitChunks(text string) []string {
+	var chunks []string
+	for pos := 0; pos < len(text); {
+		loc := preTokenizer.FindStringIndex(text[pos:])
+		if loc == nil || loc[1] == 0 {
+			chunks = append(chunks, text[pos:])
+			break
+		}
+		end := pos + loc[1]
+		chunk := text[pos:end]
+		if _, size := utf8.DecodeLastRuneInString(chunk); end < len(text) && len(chunk) > size && strings.TrimSpace(chunk) == "" && !strings.ContainsAny(chunk, "\r\n") {
+			end -= size
+		}
+		chunks = append(chunks, text[pos:end])
+		pos = end
+	}
+	return chunks
+}
+
+// gpt2ByteChars maps bytes to the printable characters byte-level vocabularies are written with.
+var gpt2ByteChars = func() [256]string {
+	var table [256]string
+	n := 0
+	for b := 0; b < 256; b++ {
+		if b >= '!' && b <= '~' || b >= 0xA1 && b <= 0xAC || b >= 0xAE {
+			table[b] = string(rune(b))
+		} else {
+			table[b] = string(rune(256 + n))
+			n++
+		}
+	}
+	return table
+}()
+
+// loadTokenizer reads a tiktoken rank file or a tokenizer.json file.
+func loadTokenizer(file string) (*bpeTokenizer, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	t := &bpeTokenizer{path: file, cache: map[string]int{}}
+	if !strings.HasSuffix(file, ".json") {
+		t.ranks = map[string]int{}
+		scanner := bufio.NewScanner(strings.NewReader(string(data)))
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 2 {
+				continue
+			}
+			token, err1 := base64.StdEncoding.DecodeString(fields[0])
+			rank, err2 := strconv.Atoi(fields[1])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("%s: not a tiktoken rank file", file)
+			}
+			t.ranks[string(token)] = rank
+		}
+		if len(t.ranks) == 0 {
+			return nil, fmt.Errorf("%s: no tokens", file)
+		}
+		return t, nil
+	}
+
+	var tj struct {
+		Model struct {
+			Type         string            `json:"type"`
+			Vocab        map[string]int    `json:"vocab"`
+			Merges       []json.RawMessage `json:"merges"`
+			ByteFallback bool              `json:"byte_fallback"`
+		} `json:"model"`
+		PreTokenizer json.RawMessage `json:"pre_tokenizer"`
+		Decoder      json.RawMessage `json:"decoder"`
+	}
+	if err := json.Unmarshal(data, &tj); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if tj.Model.Type != "BPE" || len(tj.Model.Vocab) == 0 {
+		return nil, fmt.Errorf("%s: only BPE tokenizers are supported, not %q", file, tj.Model.Type)
+	}
+	t.vocab, t.merges, t.byteTokens = tj.Model.Vocab, map[string]int{}, tj.Model.ByteFallback
+	for i, raw := range tj.Model.Merges {
+		var pair string
+		var parts []string
+		if json.Unmarshal(raw, &pair) == nil {
+			t.merges[pair] = i
+		} else if json.Unmarshal(raw, &parts) == nil && len(parts) == 2 {
+			t.merges[parts[0]+" "+parts[1]] = i
+		}
+	}
+	setup := string(tj.PreTokenizer) + string(tj.Decoder)
+	t.byteLevel = strings.Contains(setup, `"ByteLevel"`)
+	t.metaspace = !t.byteLevel
+	return t, nil
+}
+
+// count returns the number of tokens of text.
+func (t *bpeTokenizer) count(text string) int {
+	var chunks []string
+	if t.metaspace {
+		for i, word := range strings.SplitAfter(text, " ") {
+			word = strings.TrimSuffix(word, " ")
+			if i > 0 || word != "" {
+				chunks = append(chunks, "▁"+word)
+			}
+		}
+	} else {
+		chunks = splitChunks(text)
+	}
+	n := 0
+	for _, chunk := range chunks {
+		for chunk != "" {
+			piece := chunk
+			if len(piece) > maxBPEChunk {
+				cut := maxBPEChunk
+				for cut > 0 && !utf8.RuneStart(piece[cut]) {
+					cut--
+				}
+				if cut == 0 {
+					cut = maxBPEChunk
+				}
+				piece = piece[:cut]
+			}
+			chunk = chunk[len(piece):]
+			n += t.countPiece(piece)
+		}
+	}
+	return n
+}
+
+// countPiece counts the tokens of a chunk of at most maxBPEChunk bytes, through the cache.
+func (t *bpeTokenizer) countPiece(piece string) int {
+	t.mu.Lock()
+	c, ok := t.cache[piece]
+	t.mu.Unlock()
+	if ok {
+		return c
+	}
+	c = t.countChunk(piece)
+	t.mu.Lock()
+	if len(t.cache) < 100000 {
+		t.cache[piece] = c
+	}
+	t.mu.Unlock()
+	return c
+}
+
+// countChunk merges the symbols of a chunk, always the pair of best rank first, and counts what
+// is left.
+func (t *bpeTokenizer) countChunk(chunk string) int {
+	var symbols []string
+	switch {
+	case t.ranks != nil:
+		for i := 0; i < len(chunk); i++ {
+			symbols = append(symbols, chunk[i:i+1])
+		}
+	case t.byteLevel:
+		for i := 0; i < len(chunk); i++ {
+			symbols = append(symbols, gpt2ByteChars[chunk[i]])
+		}
+	default:
+		for _, r := range chunk {
+			symbols = append(symbols, string(r))
+		}
+	}
+	for len(symbols) > 1 {
+		best, bestRank := -1, 0
+		for i := 0; i+1 < len(symbols); i++ {
+			rank, ok := 0, false
+			if t.ranks != nil {
+				rank, ok = t.ranks[symbols[i]+symbols[i+1]]
+			} else {
+				rank, ok = t.merges[symbols[i]+" "+symbols[i+1]]
+			}
+			if ok && (best < 0 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		symbols[best] += symbols[best+1]
+		symbols = append(symbols[:best+1], symbols[best+2:]...)
+	}
+	n := len(symbols)
+	if t.vocab != nil && t.byteTokens {
+		// characters missing from the vocabulary are spelled as one token per UTF-8 byte
+		for _, s := range symbols {
+			if _, ok := t.vocab[s]; !ok && utf8.RuneCountInString(s) == 1 {
+				n += len(s) - 1
+			}
+		}
+	}
+	return n
+}
+
+var (
+	activeTokenizer *bpeTokenizer                // tokenizer of the session's model, if configured
+	tokenizers      = map[string]*bpeTokenizer{} // loaded files, by path
+)
+
+// tokenizerFile returns the tokenizer file configured for model: the one of the longest matching
+// pattern of the [tokenizers] section.
+func tokenizerFile(model string) string {
+	best, file := "", ""
+	for key, value := range userConfig {
+		if !strings.HasPrefix(key, "tokenizers.") {
+			continue
+		}
+		pattern := strings.Trim(strings.TrimPrefix(key, "tokenizers."), `"'`)
+		if ok, _ := path.Match(pattern, model); ok && len(pattern) > len(best) {
+			best, file = pattern, value
+		}
+	}
+	if strings.HasPrefix(file, "~/") {
+		file = filepath.Join(os.Getenv("HOME"), file[2:])
+	}
+	return file
+}
+
+// selectTokenizer makes the token counts use the tokenizer configured for model, if any. A file
+// that cannot be read is reported and the counts fall back to the estimate.
+func selectTokenizer(model string) {
+	activeTokenizer = nil
+	file := tokenizerFile(model)
+	if file == "" {
+		return
+	}
+	t, ok := tokenizers[file]
+	if !ok {
+		var err error
+		if t, err = loadTokenizer(file); err != nil {
+			fmt.Fprintf(os.Stderr, "%sCannot load the tokenizer for %s, estimating token counts: %v%s\n", red, model, err, normal)
+		}
+		tokenizers[file] = t
+	}
+	activeTokenizer = t
+}
+
+// printTokenCount implements /tokens: it counts the tokens of the conversation's next request.
+func printTokenCount(convFile string) {
+	cf, err := readConversation(convFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sFailed reading conversation: %v%s\n", red, err, normal)
+		return
+	}
+	how := "estimated at four characters per token"
+	if activeTokenizer != nil {
+		how = "counted with " + activeTokenizer.path
+	}
+	fmt.Fprintf(os.Stderr, "%d tokens in the %d message(s) of the next request, %s.\n", requestTokens(cf), len(cf.APIMessages()), how)
+}
+
+// countTextTokens counts the tokens of text with the session's tokenizer, or estimates them at
+// four characters per token.
+func countTextTokens(text string) int {
+	if activeTokenizer != nil {
+		return activeTokenizer.count(text)
+	}
+	return len(text) / 4
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CodeIter/nvidia-ai-chat/pkg/nvidiachat"
+)
+
+// maxToolRounds bounds how many consecutive tool-calling rounds a single user message may trigger.
+const maxToolRounds = 10
+
+// Tool calls requested by the model use the nvidiachat API types.
+type (
+	ToolCall         = nvidiachat.ToolCall
+	ToolFunctionCall = nvidiachat.ToolFunctionCall
+)
+
+// Tool is a function the model may call. Run receives the raw JSON arguments and returns the
+// text sent back to the model.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{} // JSON schema of the arguments
+	Source      string                 // where the tool comes from, shown by /tools
+	Run         func(arguments string) (string, error)
+}
+
+// registeredTools are offered to the model with every conversation request.
+var registeredTools []*Tool
+
+func registerTool(t *Tool) {
+	registeredTools = append(registeredTools, t)
+}
+
+func unregisterTool(name string) {
+	for i, t := range registeredTools {
+		if t.Name == name {
+			registeredTools = append(registeredTools[:i], registeredTools[i+1:]...)
+			return
+		}
+	}
+}
+
+func findTool(name string) *Tool {
+	for _, t := range registeredTools {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// toolChoicePayload returns the tool_choice request field for the TOOL_CHOICE setting: auto, none,
+// required, or the name of a tool the model must call.
+func toolChoicePayload(choice string) (interface{}, error) {
+	switch choice {
+	case "auto", "none", "required":
+		return choice, nil
+	}
+	if findTool(choice) == nil {
+		return nil, fmt.Errorf("Invalid tool choice %q: use auto, none, required or the name of a tool", choice)
+	}
+	return map[string]interface{}{"type": "function", "function": map[string]string{"name": choice}}, nil
+}
+
+// recordToolSettings records the tool settings of a request in the conversation's settings.
+func recordToolSettings(cf *ConversationFile, cfg map[string]string) {
+	cf.Settings.ToolChoice, cf.Settings.ParallelToolCalls = cfg["TOOL_CHOICE"], nil
+	if v, err := strconv.ParseBool(cfg["PARALLEL_TOOL_CALLS"]); err == nil {
+		cf.Settings.ParallelToolCalls = &v
+	}
+}
+
+// toolsPayload returns the "tools" request field for the registered tools.
+func toolsPayload() []interface{} {
+	var tools []interface{}
+	for _, t := range registeredTools {
+		params := t.Parameters
+		if params == nil {
+			params = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		tools = append(tools, map[string]interface{}{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        t.Name,
+				"description": t.Description,
+				"parameters":  params,
+			},
+		})
+	}
+	return tools
+}
+
+// printTools lists the registered tools for /tools.
+func printTools() {
+	if len(registeredTools) == 0 {
+		fmt.Fprintln(os.Stderr, "No tools available.")
+		return
+	}
+	names := make([]string, 0, len(registeredTools))
+	for _, t := range registeredTools {
+		names = append(names, t.Name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "%sAvailable tools:%s\n", bold, normal)
+	for _, name := range names {
+		t := findTool(name)
+		fmt.Fprintf(os.Stderr, "  %s (%s)\n      %s\n", t.Name, t.Source, firstLine(t.Description))
+	}
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// runToolCall executes one tool call and returns the tool message content. Failures are reported
+// to the model as text so it can react to them.
+func runToolCall(call ToolCall) string {
+	fmt.Fprintf(os.Stderr, "%s[tool] %s %s%s\n", blue, call.Function.Name, call.Function.Arguments, normal)
+	tool := findTool(call.Function.Name)
+	if tool == nil {
+		return fmt.Sprintf("Error: unknown tool %q", call.Function.Name)
+	}
+	result, err := tool.Run(call.Function.Arguments)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s[tool] %s failed: %v%s\n", red, call.Function.Name, err, normal)
+		return "Error: " + err.Error()
+	}
+	return result
+}
+
+// completeConversation sends the conversation in convFile and persists the assistant reply. When the
+// model calls tools, their results are appended and the conversation is sent again until the model
+// answers without tool calls. announce is called once the first successful response arrives.
+func completeConversation(convFile string, cfg map[string]string, sysPromptContent, accessToken string, out io.Writer, announce func()) (err error) {
+	turn := startSpan("chat.completion")
+	turn.set("model", cfg["MODEL"])
+	defer func() { turn.finish(err) }()
+	out = newStreamWriter(out, cfg)
+	defer flushOutput(out)
+	for round := 0; ; round++ {
+		build := startSpan("request.build")
+		cf, err := readConversation(convFile)
+		if err != nil {
+			return fmt.Errorf("read conversation: %w", err)
+		}
+		messages := buildMessages(cfg, sysPromptContent, cf)
+		if err := checkBudget(cf, messages); err != nil {
+			return err
+		}
+		payloadBytes, err := buildPayload(cfg, messages)
+		if err != nil {
+			return fmt.Errorf("build payload: %w", err)
+		}
+		req, err := newChatRequest(cfg, payloadBytes, accessToken)
+		if err != nil {
+			return fmt.Errorf("build request: %w", err)
+		}
+		build.set("messages", len(messages))
+		build.set("request_bytes", len(payloadBytes))
+		build.finish(nil)
+		api := startSpan("api.request").client()
+		resp, err := sendChatRequest(cfg, req)
+		if err != nil {
+			return fmt.Errorf("request failed: %w", err)
+		}
+		resp.Body = rawBody(resp.Body)
+		api.set("http.status_code", resp.StatusCode)
+		if resp.StatusCode >= 400 {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			return newAPIError(resp, body)
+		}
+		api.finish(nil)
+		if round == 0 && announce != nil {
+			announce()
+		}
+
+		stream := startSpan("response.stream")
+		resp.Body = traceBody(resp.Body, stream)
+
+		var assistantText string
+		var calls []ToolCall
+		var usage tokenUsage
+		if cfg["STREAM"] == "true" {
+			assistantText, calls, usage, err = handleStream(resp.Body, convFile, out)
+			resp.Body.Close()
+		} else {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			assistantText, calls, usage, _ = handleNonStream(body, out)
+		}
+		recordUsage(usage)
+		stream.set("prompt_tokens", usage.PromptTokens)
+		stream.set("completion_tokens", usage.CompletionTokens)
+		stream.set("tool_calls", len(calls))
+		stream.finish(err)
+
+		for i := range calls {
+			if calls[i].ID == "" {
+				calls[i].ID = "call_" + strconv.Itoa(i)
+			}
+		}
+		if strings.TrimSpace(assistantText) != "" || len(calls) > 0 {
+			persist := startSpan("conversation.persist")
+			cf, err2 := readConversation(convFile)
+			if err2 != nil {
+				return fmt.Errorf("append assistant message: %w", err2)
+			}
+			cf.Messages = dropIncomplete(cf.Messages)
+			msg := nvidiachat.Reply(cfg["MODEL"], assistantText, usage)
+			msg.ToolCalls, msg.Incomplete = calls, err != nil
+			cf.Messages = append(cf.Messages, msg)
+			cf.Settings.Model = cfg["MODEL"]
+			if len(registeredTools) > 0 {
+				recordToolSettings(cf, cfg)
+			}
+			cf.AddUsage(usage)
+			if err2 := writeConversation(convFile, cf); err2 != nil {
+				return fmt.Errorf("append assistant message: %w", err2)
+			}
+			persist.finish(nil)
+		}
+		flushOutput(out) // before hooks and tools write to stderr
+		if err != nil || len(calls) == 0 {
+			runResponseHook(cfg, convFile, assistantText)
+			return err
+		}
+		if round+1 >= maxToolRounds {
+			return fmt.Errorf("stopped after %d tool-calling rounds", maxToolRounds)
+		}
+
+		results := make([]Message, 0, len(calls))
+		for _, call := range calls {
+			span := startSpan("tool.call")
+			span.set("tool", call.Function.Name)
+			results = append(results, Message{Role: "tool", ToolCallID: call.ID, Content: runToolCall(call)})
+			span.finish(nil)
+		}
+		cf, err = readConversation(convFile)
+		if err != nil {
+			return fmt.Errorf("append tool results: %w", err)
+		}
+		cf.Messages = append(cf.Messages, results...)
+		if err := writeConversation(convFile, cf); err != nil {
+			return fmt.Errorf("append tool results: %w", err)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracing records the stages of each turn as OpenTelemetry spans and exports them to an OTLP/HTTP
+// collector in the JSON encoding when the trace of a turn ends. It is enabled by the standard
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT variables, or by endpoint in
+// the [tracing] section of the config file.
+
+// traceExportTimeout bounds the export of a trace, which happens before the turn returns.
+const traceExportTimeout = 5 * time.Second
+
+// traceSpan is a span being recorded. A nil *traceSpan, returned when tracing is off, ignores all
+// calls.
+type traceSpan struct {
+	traceID  string
+	spanID   string
+	parent   *traceSpan
+	name     string
+	kind     int // 1 internal, 3 client
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	events   []traceEvent
+	errorMsg string
+	children []*traceSpan
+}
+
+type traceEvent struct {
+	name string
+	time time.Time
+}
+
+// tracer holds the export settings; it is nil when tracing is off.
+type tracer struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	warned   bool
+}
+
+var (
+	tracing     *tracer
+	traceOnce   sync.Once
+	currentSpan *traceSpan // innermost open span; turns are serialized by sessionMu
+)
+
+// traceExporter returns the tracer configured by the environment or the config file, or nil.
+func traceExporter() *tracer {
+	traceOnce.Do(func() {
+		if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") || strings.EqualFold(os.Getenv("OTEL_TRACES_EXPORTER"), "none") {
+			return
+		}
+		endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+		if endpoint == "" {
+			base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+			if base == "" {
+				base = userConfig["tracing.endpoint"]
+			}
+			if base == "" {
+				return
+			}
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+		t := &tracer{endpoint: endpoint, headers: map[string]string{}, service: os.Getenv("OTEL_SERVICE_NAME")}
+		if t.service == "" {
+			t.service = "nvidia-chat"
+		}
+		headers := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS")
+		if headers == "" {
+			headers = os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")
+		}
+		for _, pair := range strings.Split(headers, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			k, _ := url.QueryUnescape(strings.TrimSpace(kv[0]))
+			v, _ := url.QueryUnescape(strings.TrimSpace(kv[1]))
+			t.headers[k] = v
+		}
+		tracing = t
+	})
+	return tracing
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// startSpan starts a span as a child of the innermost open span, or as the root of a new trace.
+func startSpan(name string) *traceSpan {
+	if traceExporter() == nil {
+		return nil
+	}
+	s := &traceSpan{spanID: randomHex(8), parent: currentSpan, name: name, kind: 1, start: time.Now(), attrs: map[string]interface{}{}}
+	if currentSpan != nil {
+		s.traceID = currentSpan.traceID
+		currentSpan.children = append(currentSpan.children, s)
+	} else {
+		s.traceID = randomHex(16)
+	}
+	currentSpan = s
+	return s
+}
+
+// client marks the span as a call to another service.
+func (s *traceSpan) client() *traceSpan {
+	if s != nil {
+		s.kind = 3
+	}
+	return s
+}
+
+func (s *traceSpan) set(key string, value interface{}) {
+	if s != nil {
+		s.attrs[key] = value
+	}
+}
+
+func (s *traceSpan) event(name string) {
+	if s != nil {
+		s.events = append(s.events, traceEvent{name, time.Now()})
+	}
+}
+
+// finish ends the span, with an error status if err is not nil. The spans still open inside it,
+// left by an early return, end with it and share its status. Ending a root span exports its trace.
+func (s *traceSpan) finish(err error) {
+	if s == nil || !s.end.IsZero() {
+		return
+	}
+	msg := ""
+	if err != nil {
+		msg = describeError(err)
+	}
+	for c := currentSpan; c != nil; c = c.parent {
+		if c == s {
+			for o := currentSpan; o != s; o = o.parent {
+				o.end, o.errorMsg = time.Now(), msg
+			}
+			currentSpan = s.parent
+			break
+		}
+	}
+	s.end, s.errorMsg = time.Now(), msg
+	if s.parent == nil {
+		tracing.export(s)
+	}
+}
+
+// tracedBody adds an event to span when the first bytes of a response body arrive.
+type tracedBody struct {
+	io.ReadCloser
+	span *traceSpan
+	seen bool
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.seen {
+		b.seen = true
+		b.span.event("first_byte")
+	}
+	return n, err
+}
+
+func traceBody(body io.ReadCloser, span *traceSpan) io.ReadCloser {
+	if span == nil {
+		return body
+	}
+	return &tracedBody{ReadCloser: body, span: span}
+}
+
+// otlpValue encodes an attribute value as an OTLP AnyValue.
+func otlpValue(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	}
+	return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+}
+
+func otlpAttributes(attrs map[string]interface{}) []map[string]interface{} {
+	list := []map[string]interface{}{}
+	for k, v := range attrs {
+		list = append(list, map[string]interface{}{"key": k, "value": otlpValue(v)})
+	}
+	return list
+}
+
+// appendOTLPSpans adds s and its descendants to spans in the OTLP JSON encoding.
+func appendOTLPSpans(spans []map[string]interface{}, s *traceSpan) []map[string]interface{} {
+	span := map[string]interface{}{
+		"traceId":           s.traceID,
+		"spanId":            s.spanID,
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attrs),
+	}
+	if s.parent != nil {
+		span["parentSpanId"] = s.parent.spanID
+	}
+	var events []map[string]interface{}
+	for _, e := range s.events {
+		events = append(events, map[string]interface{}{"name": e.name, "timeUnixNano": strconv.FormatInt(e.time.UnixNano(), 10)})
+	}
+	if events != nil {
+		span["events"] = events
+	}
+	if s.errorMsg != "" {
+		span["status"] = map[string]interface{}{"code": 2, "message": s.errorMsg}
+	}
+	spans = append(spans, span)
+	for _, c := range s.children {
+		spans = appendOTLPSpans(spans, c)
+	}
+	return spans
+}
+
+// export sends the trace of root to the collector. A failing export is reported once.
+func (t *tracer) export(root *traceSpan) {
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": otlpAttributes(map[string]interface{}{"service.name": t.service})},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "nvidia-chat"},
+				"spans": appendOTLPSpans(nil, root),
+			}},
+		}},
+	}
+	b, _ := json.Marshal(payload)
+	err := func() error {
+		req, err := http.NewRequest("POST", t.endpoint, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range t.headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := (&http.Client{Timeout: traceExportTimeout}).Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(ioutil.Discard, resp.Body)
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("%s", resp.Status)
+		}
+		return nil
+	}()
+	if err != nil && !t.warned {
+		t.warned = true
+		fmt.Fprintf(os.Stderr, "%sFailed to export the trace to %s: %v%s\n", red, t.endpoint, err, normal)
+	}
+}